@@ -0,0 +1,144 @@
+package promptvaultprocessor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// errAsyncQueueFull is the failure recorded for attributes whose write did
+// not fit in the storage.async queue.
+var errAsyncQueueFull = errors.New("async store queue full")
+
+// errAsyncClosed is the failure recorded for attributes arriving after
+// the storage.async writers were shut down.
+var errAsyncClosed = errors.New("async store writers closed")
+
+// asyncWrite is one store handed to the background writers, with the
+// reference already emitted for it and, for vaults whose references depend
+// on the time of the store, the time that reference was predicted for.
+type asyncWrite struct {
+	ref         string
+	key         string
+	content     []byte
+	contentType string
+	at          time.Time
+}
+
+// asyncWriter stores content on a pool of background goroutines so the
+// pipeline does not wait on the backend. Writes that do not fit in the
+// queue are refused.
+type asyncWriter struct {
+	store  func(w asyncWrite) (string, error)
+	logger *zap.Logger
+	writes chan asyncWrite
+	wg     sync.WaitGroup
+	failed func()
+
+	// mu guards closed: add holds it shared, so writes is never closed
+	// under a send.
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newAsyncWriter(workers, size int, store func(w asyncWrite) (string, error), logger *zap.Logger, failed func()) *asyncWriter {
+	a := &asyncWriter{
+		store:  store,
+		logger: logger,
+		writes: make(chan asyncWrite, size),
+		failed: failed,
+	}
+	a.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go a.run()
+	}
+	return a
+}
+
+func (a *asyncWriter) run() {
+	defer a.wg.Done()
+	for w := range a.writes {
+		ref, err := a.store(w)
+		if err == nil && ref != w.ref {
+			err = fmt.Errorf("stored as %s", ref)
+		}
+		if err != nil {
+			a.logger.Error("async vault store failed, reference does not resolve",
+				zap.String("key", w.key),
+				zap.String("ref", w.ref),
+				zap.Error(err),
+			)
+			a.failed()
+		}
+	}
+}
+
+// add enqueues w without blocking. It fails with errAsyncQueueFull when w
+// does not fit and errAsyncClosed after close. The content is copied,
+// since the caller's buffer may be reused once it returns.
+func (a *asyncWriter) add(w asyncWrite) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		return errAsyncClosed
+	}
+	w.content = bytes.Clone(w.content)
+	select {
+	case a.writes <- w:
+		return nil
+	default:
+		return errAsyncQueueFull
+	}
+}
+
+// close waits for queued and in-flight writes to finish. Later adds fail.
+func (a *asyncWriter) close() {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.writes)
+	}
+	a.mu.Unlock()
+	a.wg.Wait()
+}
+
+// startAsync starts the storage.async writers.
+func (p *vaultProcessor) startAsync() {
+	cfg := p.config.Storage.Async
+	p.async = newAsyncWriter(cfg.Workers, cfg.QueueSize, func(w asyncWrite) (string, error) {
+		return p.storeRetrying(context.Background(), w.key, w.content, w.contentType, w.at)
+	}, p.logger, func() {
+		p.stats.storeFailures.Add(1)
+		p.metrics.asyncFailures.Add(context.Background(), 1)
+	})
+}
+
+// storeAsync returns the reference content will be stored under and queues
+// the write, pinned to the time the reference was predicted for when the
+// vault supports it. When the queue is full nothing is stored and the
+// attribute is handled per on_store_failure.
+func (p *vaultProcessor) storeAsync(ctx context.Context, key string, content []byte, contentType string) (string, error) {
+	var ref string
+	var at time.Time
+	var err error
+	if pinning, ok := p.vault.(PinningVaultStorage); ok {
+		ref, at, err = pinning.PinRef(content, contentType)
+	} else {
+		ref, err = p.vault.(RefPredictor).PredictRef(content, contentType)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := p.async.add(asyncWrite{ref: ref, key: key, content: content, contentType: contentType, at: at}); err != nil {
+		if errors.Is(err, errAsyncQueueFull) {
+			p.metrics.asyncDropped.Add(ctx, 1)
+		}
+		return "", err
+	}
+	return ref, nil
+}
@@ -0,0 +1,208 @@
+package promptvaultprocessor
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.uber.org/zap"
+)
+
+func newAsyncBatch(attrs map[string]string) ptrace.Traces {
+	td := ptrace.NewTraces()
+	span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+	for k, v := range attrs {
+		span.Attributes().PutStr(k, v)
+	}
+	return td
+}
+
+func TestVaultAsyncStores(t *testing.T) {
+	fsVault, _ := NewFilesystemVault(t.TempDir())
+	vault := &blockingVault{FilesystemVault: fsVault, started: make(chan struct{}, 1), release: make(chan struct{})}
+	cfg := createDefaultConfig()
+	cfg.Storage.Async.Enabled = true
+	sink := new(consumertest.TracesSink)
+	proc := newTestProcessor(t, cfg, vault, sink)
+	if err := proc.Start(context.Background(), componenttest.NewNopHost()); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+
+	td := newAsyncBatch(map[string]string{
+		"gen_ai.prompt":     "Tell me about quantum computing",
+		"gen_ai.completion": "Quantum computing uses qubits...",
+	})
+	done := make(chan error)
+	go func() { done <- proc.ConsumeTraces(context.Background(), td) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the batch forwarded while its writes are blocked")
+	}
+
+	attrs := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	var refs []string
+	for _, key := range []string{"gen_ai.prompt", "gen_ai.completion"} {
+		v, _ := attrs.Get(proc.refKey(key))
+		if !strings.HasPrefix(v.Str(), "vault://") {
+			t.Fatalf("expected a reference for %s, got %q", key, v.Str())
+		}
+		refs = append(refs, v.Str())
+	}
+
+	close(vault.release)
+	if err := proc.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	for _, ref := range refs {
+		if _, err := fsVault.Retrieve(ref); err != nil {
+			t.Errorf("expected %s written by shutdown: %v", ref, err)
+		}
+	}
+}
+
+func TestVaultAsyncQueueFull(t *testing.T) {
+	fsVault, _ := NewFilesystemVault(t.TempDir())
+	vault := &blockingVault{FilesystemVault: fsVault, started: make(chan struct{}, 1), release: make(chan struct{})}
+	cfg := createDefaultConfig()
+	cfg.Vault.Keys = []string{"a", "b", "c", "d"}
+	cfg.Storage.Async = AsyncConfig{Enabled: true, QueueSize: 1, Workers: 1}
+	set, reader := newTestTelemetry()
+	sink := new(consumertest.TracesSink)
+	proc, err := newVaultProcessor(set, cfg, vault, sink)
+	if err != nil {
+		t.Fatalf("failed to create processor: %v", err)
+	}
+	if err := proc.Start(context.Background(), componenttest.NewNopHost()); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer func() {
+		close(vault.release)
+		if err := proc.Shutdown(context.Background()); err != nil {
+			t.Errorf("shutdown: %v", err)
+		}
+	}()
+
+	// Occupy the only worker, then send more writes than the queue holds.
+	if err := proc.ConsumeTraces(context.Background(), newAsyncBatch(map[string]string{"a": "first value"})); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	<-vault.started
+	td := newAsyncBatch(map[string]string{"b": "second value", "c": "third value", "d": "fourth value"})
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	attrs := sink.AllTraces()[1].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	var inline int
+	for _, key := range []string{"b", "c", "d"} {
+		if v, _ := attrs.Get(key); !strings.HasPrefix(v.Str(), "vault://") {
+			inline++
+		}
+	}
+	if inline != 2 {
+		t.Errorf("expected 2 attributes kept inline, got %d", inline)
+	}
+	if n := counterValue(t, reader, "processor_promptvault_async_dropped"); n != 2 {
+		t.Errorf("expected 2 dropped writes, got %d", n)
+	}
+}
+
+func TestVaultAsyncValidation(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	set, _ := newTestTelemetry()
+
+	cfg := createDefaultConfig()
+	cfg.Storage.Async.Enabled = true
+	if _, err := newVaultProcessor(set, cfg, vault, consumertest.NewNop()); err != nil {
+		t.Fatalf("unexpected error for async on the filesystem vault: %v", err)
+	}
+
+	if _, err := newVaultProcessor(set, createDefaultConfig(), failingVault{}, consumertest.NewNop()); err != nil {
+		t.Fatalf("unexpected error without async: %v", err)
+	}
+	cfg = createDefaultConfig()
+	cfg.Storage.Async.Enabled = true
+	if _, err := newVaultProcessor(set, cfg, failingVault{}, consumertest.NewNop()); err == nil {
+		t.Error("expected async to be rejected for a vault that cannot predict references")
+	}
+}
+
+func TestVaultAsyncPinsPartition(t *testing.T) {
+	dir := t.TempDir()
+	var mu sync.Mutex
+	now := time.Date(2026, 3, 1, 23, 59, 59, 0, time.UTC)
+	fsVault, _ := NewFilesystemVault(dir, WithClock(func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}))
+	vault := &blockingVault{FilesystemVault: fsVault, started: make(chan struct{}, 1), release: make(chan struct{})}
+	cfg := createDefaultConfig()
+	cfg.Storage.Async.Enabled = true
+	set, reader := newTestTelemetry()
+	sink := new(consumertest.TracesSink)
+	proc, err := newVaultProcessor(set, cfg, vault, sink)
+	if err != nil {
+		t.Fatalf("failed to create processor: %v", err)
+	}
+	if err := proc.Start(context.Background(), componenttest.NewNopHost()); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+
+	td := newAsyncBatch(map[string]string{"gen_ai.prompt": "Tell me about quantum computing"})
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ref, _ := td.ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes().Get(proc.refKey("gen_ai.prompt"))
+	<-vault.started
+
+	// The write runs after midnight, but lands where its reference points.
+	mu.Lock()
+	now = now.Add(time.Minute)
+	mu.Unlock()
+	close(vault.release)
+	if err := proc.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if !strings.Contains(ref.Str(), "/2026/03/01/") {
+		t.Fatalf("expected a reference into the day it was queued, got %s", ref.Str())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "2026", "03", "01", strings.TrimPrefix(ref.Str(), "vault://2026/03/01/"))); err != nil {
+		t.Errorf("expected the object in the queued day's partition: %v", err)
+	}
+	if n := counterValue(t, reader, "processor_promptvault_async_failures"); n != 0 {
+		t.Errorf("expected no async failures, got %d", n)
+	}
+}
+
+func TestAsyncWriterAddAfterClose(t *testing.T) {
+	a := newAsyncWriter(2, 4, func(w asyncWrite) (string, error) { return w.ref, nil }, zap.NewNop(), func() {})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				a.add(asyncWrite{ref: "vault://x"})
+			}
+		}()
+	}
+	a.close()
+	wg.Wait()
+	if err := a.add(asyncWrite{ref: "vault://x"}); !errors.Is(err, errAsyncClosed) {
+		t.Errorf("expected adds after close to fail with %v, got %v", errAsyncClosed, err)
+	}
+	a.close()
+}
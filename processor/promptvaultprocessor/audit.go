@@ -0,0 +1,132 @@
+package promptvaultprocessor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.uber.org/zap"
+)
+
+// AuditRecord states that content, identified by checksum, from a span was
+// offloaded at a point in time under a mode. It never holds the content.
+type AuditRecord struct {
+	Time     time.Time `json:"time"`
+	TraceID  string    `json:"trace_id,omitempty"`
+	SpanID   string    `json:"span_id,omitempty"`
+	Key      string    `json:"key"`
+	Ref      string    `json:"ref"`
+	Checksum string    `json:"checksum"`
+	Mode     string    `json:"mode"`
+}
+
+// AuditSink is an append-only destination for audit records.
+type AuditSink interface {
+	Write(AuditRecord) error
+	Close() error
+}
+
+// fileAuditSink appends records as NDJSON.
+type fileAuditSink struct {
+	f   *os.File
+	enc *json.Encoder
+}
+
+// NewFileAuditSink appends audit records to path, one JSON object per line.
+func NewFileAuditSink(path string) (AuditSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open audit file: %w", err)
+	}
+	return &fileAuditSink{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (s *fileAuditSink) Write(r AuditRecord) error {
+	return s.enc.Encode(r)
+}
+
+func (s *fileAuditSink) Close() error {
+	if err := s.f.Sync(); err != nil {
+		s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
+
+// auditQueue hands records to a sink on a background goroutine so a slow
+// sink never blocks the pipeline. Records that do not fit in the queue are
+// dropped and counted.
+type auditQueue struct {
+	sink    AuditSink
+	logger  *zap.Logger
+	records chan AuditRecord
+	done    chan struct{}
+	once    sync.Once
+	dropped func()
+}
+
+func newAuditQueue(sink AuditSink, size int, logger *zap.Logger, dropped func()) *auditQueue {
+	q := &auditQueue{
+		sink:    sink,
+		logger:  logger,
+		records: make(chan AuditRecord, size),
+		done:    make(chan struct{}),
+		dropped: dropped,
+	}
+	go q.run()
+	return q
+}
+
+func (q *auditQueue) run() {
+	defer close(q.done)
+	for r := range q.records {
+		if err := q.sink.Write(r); err != nil {
+			q.logger.Warn("audit write failed", zap.String("ref", r.Ref), zap.Error(err))
+		}
+	}
+}
+
+// add enqueues r without blocking.
+func (q *auditQueue) add(r AuditRecord) {
+	select {
+	case q.records <- r:
+	default:
+		q.dropped()
+	}
+}
+
+// close flushes queued records and closes the sink.
+func (q *auditQueue) close() error {
+	var err error
+	q.once.Do(func() {
+		close(q.records)
+		<-q.done
+		err = q.sink.Close()
+	})
+	return err
+}
+
+// auditRecords builds one record per vaulted attribute. Trace and span IDs
+// are empty for resource and scope attributes.
+func auditRecords(now time.Time, traceID pcommon.TraceID, spanID pcommon.SpanID, mode string, vaulted []vaultedAttr) []AuditRecord {
+	records := make([]AuditRecord, len(vaulted))
+	for i, v := range vaulted {
+		records[i] = AuditRecord{
+			Time:     now.UTC(),
+			Key:      v.key,
+			Ref:      v.ref,
+			Checksum: CanonicalRef(v.ref),
+			Mode:     mode,
+		}
+		if !traceID.IsEmpty() {
+			records[i].TraceID = traceID.String()
+		}
+		if !spanID.IsEmpty() {
+			records[i].SpanID = spanID.String()
+		}
+	}
+	return records
+}
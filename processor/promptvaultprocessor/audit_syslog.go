@@ -0,0 +1,36 @@
+//go:build !windows && !plan9
+
+package promptvaultprocessor
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+)
+
+// syslogAuditSink sends each record as a JSON message to syslog.
+type syslogAuditSink struct {
+	w *syslog.Writer
+}
+
+// NewSyslogAuditSink sends audit records to syslog at network/address, or
+// to the local syslog daemon when both are empty.
+func NewSyslogAuditSink(network, address, tag string) (AuditSink, error) {
+	w, err := syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_AUTH, tag)
+	if err != nil {
+		return nil, fmt.Errorf("connect to syslog: %w", err)
+	}
+	return &syslogAuditSink{w: w}, nil
+}
+
+func (s *syslogAuditSink) Write(r AuditRecord) error {
+	msg, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return s.w.Info(string(msg))
+}
+
+func (s *syslogAuditSink) Close() error {
+	return s.w.Close()
+}
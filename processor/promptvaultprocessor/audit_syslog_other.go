@@ -0,0 +1,10 @@
+//go:build windows || plan9
+
+package promptvaultprocessor
+
+import "errors"
+
+// NewSyslogAuditSink is not available on this platform.
+func NewSyslogAuditSink(network, address, tag string) (AuditSink, error) {
+	return nil, errors.New("syslog audit sink is not supported on this platform")
+}
@@ -0,0 +1,125 @@
+package promptvaultprocessor
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+func TestAuditFileSink(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	path := filepath.Join(t.TempDir(), "audit.ndjson")
+	cfg := createDefaultConfig()
+	cfg.Audit.Sink = "file"
+	cfg.Audit.Path = path
+	proc := newTestProcessor(t, cfg, vault, new(consumertest.TracesSink))
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	proc.now = func() time.Time { return now }
+	if err := proc.Start(context.Background(), nil); err != nil {
+		t.Fatalf("unexpected start error: %v", err)
+	}
+
+	traceID := pcommon.TraceID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	td := ptrace.NewTraces()
+	spans := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans()
+	first := spans.AppendEmpty()
+	first.SetTraceID(traceID)
+	first.SetSpanID(pcommon.SpanID{1, 1, 1, 1, 1, 1, 1, 1})
+	first.Attributes().PutStr("gen_ai.prompt", "Tell me about quantum computing")
+	second := spans.AppendEmpty()
+	second.SetTraceID(traceID)
+	second.SetSpanID(pcommon.SpanID{2, 2, 2, 2, 2, 2, 2, 2})
+	second.Attributes().PutStr("gen_ai.completion", "Quantum computers use qubits")
+	spans.AppendEmpty().Attributes().PutStr("http.route", "/chat")
+
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := proc.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open audit file: %v", err)
+	}
+	defer f.Close()
+	var records []AuditRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("invalid audit line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, r)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected one record per offload, got %d", len(records))
+	}
+
+	ref, _ := first.Attributes().Get("gen_ai.prompt.vault_ref")
+	want := AuditRecord{
+		Time:     now,
+		TraceID:  traceID.String(),
+		SpanID:   "0101010101010101",
+		Key:      "gen_ai.prompt",
+		Ref:      ref.Str(),
+		Checksum: CanonicalRef(ref.Str()),
+		Mode:     "replace_with_ref",
+	}
+	if records[0] != want {
+		t.Errorf("unexpected audit record\n got: %+v\nwant: %+v", records[0], want)
+	}
+	if records[1].Key != "gen_ai.completion" || records[1].SpanID != "0202020202020202" {
+		t.Errorf("unexpected second record: %+v", records[1])
+	}
+}
+
+// blockedSink blocks every write until release is closed.
+type blockedSink struct {
+	release chan struct{}
+	writes  int
+}
+
+func (s *blockedSink) Write(AuditRecord) error {
+	<-s.release
+	s.writes++
+	return nil
+}
+
+func (s *blockedSink) Close() error { return nil }
+
+func TestAuditQueueDoesNotBlock(t *testing.T) {
+	sink := &blockedSink{release: make(chan struct{})}
+	dropped := 0
+	q := newAuditQueue(sink, 2, nil, func() { dropped++ })
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			q.add(AuditRecord{Key: "gen_ai.prompt"})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("add blocked on a stalled sink")
+	}
+
+	close(sink.release)
+	if err := q.close(); err != nil {
+		t.Fatalf("unexpected close error: %v", err)
+	}
+	if sink.writes+dropped != 10 || dropped == 0 {
+		t.Errorf("expected writes (%d) + dropped (%d) = 10 with some dropped", sink.writes, dropped)
+	}
+}
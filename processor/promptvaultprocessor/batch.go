@@ -0,0 +1,142 @@
+package promptvaultprocessor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"go.opentelemetry.io/collector/consumer/consumererror"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.uber.org/zap"
+)
+
+// errStoreFailed is returned (wrapped in a retryable consumererror) for a
+// batch with content that could not be stored under on_store_failure fail.
+var errStoreFailed = errors.New("promptvault processor could not store some content")
+
+// errEncodeFailed is returned (wrapped in a permanent consumererror) for a
+// batch with map or slice values that could not be encoded under
+// on_encode_failure fail; retrying would fail the same way.
+var errEncodeFailed = errors.New("promptvault processor could not encode some content")
+
+// errSaturated is returned (wrapped in a retryable consumererror) when a
+// batch arrives while max_in_flight_batches are already being offloaded.
+var errSaturated = errors.New("promptvault processor saturated: too many batches in flight")
+
+// batchFailuresKey carries a batch's *batchFailures through its context.
+type batchFailuresKey struct{}
+
+// batchFailures counts the attributes of a batch failed under
+// on_store_failure fail and on_encode_failure fail.
+type batchFailures struct {
+	store, encode atomic.Int64
+}
+
+// countFailure records a failed attribute in the batch offloaded under ctx.
+func countFailure(ctx context.Context, encode bool) {
+	failures, ok := ctx.Value(batchFailuresKey{}).(*batchFailures)
+	switch {
+	case !ok:
+	case encode:
+		failures.encode.Add(1)
+	default:
+		failures.store.Add(1)
+	}
+}
+
+// batchContext returns the context a batch is offloaded under, cut short
+// by max_batch_processing_time. The batch itself is forwarded with ctx.
+func (p *vaultProcessor) batchContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.config.Vault.OnStoreFailure == "fail" || p.config.Vault.OnEncodeFailure == "fail" {
+		ctx = context.WithValue(ctx, batchFailuresKey{}, new(batchFailures))
+	}
+	if limit := p.config.Vault.MaxBatchProcessingTime; limit > 0 {
+		return context.WithTimeout(ctx, limit)
+	}
+	return ctx, func() {}
+}
+
+// checkBatchTimeout logs when offloading a batch was cut short by
+// max_batch_processing_time rather than by the caller.
+func (p *vaultProcessor) checkBatchTimeout(ctx, batchCtx context.Context) {
+	if batchCtx.Err() != nil && ctx.Err() == nil {
+		p.logger.Warn("max_batch_processing_time exceeded, forwarding batch with remaining attributes inline",
+			zap.Duration("max_batch_processing_time", p.config.Vault.MaxBatchProcessingTime),
+		)
+	}
+}
+
+// batchFailure returns errEncodeFailed or errStoreFailed when an attribute
+// of the batch offloaded under batchCtx failed under on_encode_failure or
+// on_store_failure fail. Encode failures take precedence: the batch would
+// never succeed on retry.
+func batchFailure(batchCtx context.Context) error {
+	failures, ok := batchCtx.Value(batchFailuresKey{}).(*batchFailures)
+	if !ok {
+		return nil
+	}
+	if n := failures.encode.Load(); n > 0 {
+		return consumererror.NewPermanent(fmt.Errorf("%w: %d attributes", errEncodeFailed, n))
+	}
+	if n := failures.store.Load(); n > 0 {
+		return fmt.Errorf("%w: %d attributes", errStoreFailed, n)
+	}
+	return nil
+}
+
+// memoryPressure reports whether the heap is above memory.bypass_heap_mib,
+// logging when that changes. Batches are passed through while it is.
+func (p *vaultProcessor) memoryPressure() bool {
+	if p.memory == nil {
+		return false
+	}
+	pressure, changed := p.memory.underPressure()
+	switch {
+	case changed && pressure:
+		p.logger.Warn("heap above bypass threshold, passing batches through without offloading",
+			zap.Uint64("bypass_heap_mib", p.config.Memory.BypassHeapMiB),
+		)
+	case changed:
+		p.logger.Info("heap back below bypass threshold, offloading resumed")
+	}
+	return pressure
+}
+
+// acquireBatch takes one of the max_in_flight_batches slots, reporting false
+// when none is free. A successful call is paired with releaseBatch.
+func (p *vaultProcessor) acquireBatch() bool {
+	if p.inFlight == nil {
+		return true
+	}
+	select {
+	case p.inFlight <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *vaultProcessor) releaseBatch() {
+	if p.inFlight != nil {
+		<-p.inFlight
+	}
+}
+
+// dropOnFailure applies the store-failure policy to an attribute that
+// could not be offloaded and reports whether its content was dropped. The
+// "drop" and "fail" policies only apply in modes that would have removed
+// the content from the span anyway; "fail" keeps it and fails the batch.
+func (p *vaultProcessor) dropOnFailure(ctx context.Context, attrs pcommon.Map, mode, key string) bool {
+	if mode == "keep_and_ref" {
+		return false
+	}
+	switch p.config.Vault.OnStoreFailure {
+	case "drop":
+		attrs.Remove(key)
+		return true
+	case "fail":
+		countFailure(ctx, false)
+	}
+	return false
+}
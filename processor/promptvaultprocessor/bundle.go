@@ -0,0 +1,69 @@
+package promptvaultprocessor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// refFieldSep selects one field of a bundle object in a reference:
+// vault://<sha256>.json#field=<key>.
+const refFieldSep = "#field="
+
+// storeBundle stores fields as one JSON object and returns each key's
+// reference into it.
+func (p *vaultProcessor) storeBundle(ctx context.Context, fields map[string]string) (map[string]string, error) {
+	if err := ctx.Err(); err != nil {
+		p.metrics.skippedAttributes.Add(context.WithoutCancel(ctx), int64(len(fields)))
+		return nil, err
+	}
+	bundle, err := json.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("encode bundle: %w", err)
+	}
+	if p.limiter != nil && !p.limiter.allow(len(bundle)) {
+		p.metrics.throttledAttributes.Add(ctx, int64(len(fields)))
+		return nil, errThrottled
+	}
+	ref, err := p.store(ctx, "", bundle, contentTypeJSON)
+	if err == nil && p.config.Storage.VerifyAfterWrite {
+		err = p.verify(ctx, ref, bundle, false)
+	}
+	if err != nil {
+		p.stats.storeFailures.Add(1)
+		p.logger.Warn("vault bundle store failed", zap.Int("keys", len(fields)), zap.Error(err))
+		return nil, err
+	}
+
+	refs := make(map[string]string, len(fields))
+	for key := range fields {
+		refs[key] = ref + refFieldSep + key
+	}
+	return refs, nil
+}
+
+// ResolveBundleField retrieves the content a reference points to. For a
+// reference into a bundle it returns the selected field; other references
+// are retrieved as-is.
+func ResolveBundleField(vault VaultRetriever, ref string) ([]byte, error) {
+	base, field, ok := strings.Cut(ref, refFieldSep)
+	if !ok {
+		return vault.Retrieve(ref)
+	}
+	data, err := vault.Retrieve(base)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]string
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("vault ref %s is not a bundle: %w", base, err)
+	}
+	value, ok := fields[field]
+	if !ok {
+		return nil, fmt.Errorf("bundle %s has no field %q", base, field)
+	}
+	return []byte(value), nil
+}
@@ -0,0 +1,66 @@
+package promptvaultprocessor
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+func TestVaultBundle(t *testing.T) {
+	dir := t.TempDir()
+	vault, _ := NewFilesystemVault(dir)
+	sink := new(consumertest.TracesSink)
+	cfg := createDefaultConfig()
+	cfg.Vault.Bundle = true
+	proc := newTestProcessor(t, cfg, vault, sink)
+
+	want := map[string]string{
+		"gen_ai.prompt":              "Tell me about quantum computing",
+		"gen_ai.completion":          "Quantum computers use qubits",
+		"gen_ai.system_instructions": `{"role":"system","content":"Be brief"}`,
+	}
+	td := ptrace.NewTraces()
+	attrs := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty().Attributes()
+	for key, value := range want {
+		attrs.PutStr(key, value)
+	}
+
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if files := vaultFiles(t, dir); len(files) != 1 {
+		t.Fatalf("expected one bundle object, found %d", len(files))
+	}
+
+	got := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	var bundle string
+	for key, value := range want {
+		ref, ok := got.Get(key + ".vault_ref")
+		if !ok {
+			t.Fatalf("expected a reference for %s", key)
+		}
+		base, field, ok := strings.Cut(ref.Str(), refFieldSep)
+		if !ok || field != key {
+			t.Errorf("expected %s to reference its field in the bundle, got %s", key, ref.Str())
+		}
+		if bundle == "" {
+			bundle = base
+		} else if base != bundle {
+			t.Errorf("expected every key in one bundle, got %s and %s", bundle, base)
+		}
+		content, err := ResolveBundleField(vault, ref.Str())
+		if err != nil {
+			t.Fatalf("resolve %s: %v", ref.Str(), err)
+		}
+		if string(content) != value {
+			t.Errorf("%s: expected %q, got %q", key, value, content)
+		}
+	}
+
+	if _, err := ResolveBundleField(vault, bundle+refFieldSep+"gen_ai.missing"); err == nil {
+		t.Error("expected an error for a field not in the bundle")
+	}
+}
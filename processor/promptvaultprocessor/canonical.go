@@ -0,0 +1,88 @@
+package promptvaultprocessor
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// parseJSONPaths splits dotted exclusion paths such as "metadata.request_id"
+// into their segments. A leading "$." is accepted and ignored.
+func parseJSONPaths(paths []string) [][]string {
+	parsed := make([][]string, 0, len(paths))
+	for _, path := range paths {
+		path = strings.TrimPrefix(path, "$.")
+		if path == "" {
+			continue
+		}
+		parsed = append(parsed, strings.Split(path, "."))
+	}
+	return parsed
+}
+
+// canonicalizeJSON removes the fields at paths from a JSON document and
+// re-encodes it with sorted keys, so documents differing only in excluded
+// (volatile) fields produce identical bytes. Arrays are traversed
+// transparently: a path applies to every element. Content that is not JSON
+// is returned unchanged.
+func canonicalizeJSON(content []byte, paths [][]string) []byte {
+	if len(paths) == 0 || detectContentType(content) != contentTypeJSON {
+		return content
+	}
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return content
+	}
+	for _, path := range paths {
+		removeJSONPath(doc, path)
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return content
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
+
+func removeJSONPath(node any, path []string) {
+	switch n := node.(type) {
+	case []any:
+		for _, elem := range n {
+			removeJSONPath(elem, path)
+		}
+	case map[string]any:
+		if len(path) == 1 {
+			delete(n, path[0])
+			return
+		}
+		if child, ok := n[path[0]]; ok {
+			removeJSONPath(child, path[1:])
+		}
+	}
+}
+
+// finiteJSON replaces the NaN and infinite floats in v, which JSON cannot
+// represent, with their string form.
+func finiteJSON(v any) any {
+	switch v := v.(type) {
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return strconv.FormatFloat(v, 'g', -1, 64)
+		}
+	case map[string]any:
+		for k, e := range v {
+			v[k] = finiteJSON(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = finiteJSON(e)
+		}
+	}
+	return v
+}
@@ -0,0 +1,68 @@
+package promptvaultprocessor
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+func TestCanonicalizeJSON(t *testing.T) {
+	paths := parseJSONPaths([]string{"timestamp", "$.metadata.request_id"})
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"top-level field", `{"role":"user","timestamp":"2026-01-01T00:00:00Z"}`, `{"role":"user"}`},
+		{"nested field", `{"metadata":{"request_id":"r-1","tenant":"a"}}`, `{"metadata":{"tenant":"a"}}`},
+		{"array elements", `[{"content":"hi","timestamp":1},{"content":"yo","timestamp":2}]`, `[{"content":"hi"},{"content":"yo"}]`},
+		{"keys sorted", `{"b":1,"a":"<x>"}`, `{"a":"<x>","b":1}`},
+		{"not json", `timestamp: now`, `timestamp: now`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(canonicalizeJSON([]byte(tt.in), paths)); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVaultJSONExclusionsDedup(t *testing.T) {
+	dir := t.TempDir()
+	vault, _ := NewFilesystemVault(dir, WithGzip(1<<20))
+	sink := new(consumertest.TracesSink)
+	cfg := createDefaultConfig()
+	cfg.Vault.JSONExclusions = []string{"timestamp"}
+	proc := newTestProcessor(t, cfg, vault, sink)
+
+	td := ptrace.NewTraces()
+	spans := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans()
+	spans.AppendEmpty().Attributes().PutStr("gen_ai.input.messages",
+		`{"role":"user","content":"Tell me about quantum computing","timestamp":"2026-03-01T10:00:00Z"}`)
+	spans.AppendEmpty().Attributes().PutStr("gen_ai.input.messages",
+		`{"timestamp":"2026-03-01T10:05:00Z","role":"user","content":"Tell me about quantum computing"}`)
+
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans()
+	first, _ := got.At(0).Attributes().Get("gen_ai.input.messages")
+	second, _ := got.At(1).Attributes().Get("gen_ai.input.messages")
+	if first.Str() != second.Str() {
+		t.Errorf("expected both messages to share a reference, got %s and %s", first.Str(), second.Str())
+	}
+	if files := vaultFiles(t, dir); len(files) != 1 {
+		t.Errorf("expected one stored object, got %d", len(files))
+	}
+	data, err := vault.Retrieve(first.Str())
+	if err != nil {
+		t.Fatalf("retrieve failed: %v", err)
+	}
+	if want := `{"content":"Tell me about quantum computing","role":"user"}`; string(data) != want {
+		t.Errorf("expected the canonicalized form %s, got %s", want, data)
+	}
+}
@@ -1,38 +1,527 @@
 package promptvaultprocessor
 
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"time"
+
+	"go.opentelemetry.io/collector/component"
+)
+
 // Config for the prompt vault processor.
 type Config struct {
 	Storage StorageConfig `mapstructure:"storage"`
 	Vault   VaultConfig   `mapstructure:"vault"`
+	Memory  MemoryConfig  `mapstructure:"memory"`
+	// Resolver exposes an optional HTTP endpoint for resolving references.
+	Resolver ResolverConfig `mapstructure:"resolver"`
+	// Audit writes an append-only record of every offload.
+	Audit AuditConfig `mapstructure:"audit"`
+	// DryRun reports what would be offloaded without touching any span.
+	DryRun DryRunConfig `mapstructure:"dry_run"`
+	// Crypto encrypts vaulted objects at rest.
+	Crypto CryptoConfig `mapstructure:"crypto"`
+	// SummaryInterval logs the lifetime summary periodically as well as on
+	// shutdown, for short-lived collectors that may exit before their
+	// metrics are scraped. 0 = on shutdown only.
+	SummaryInterval time.Duration `mapstructure:"summary_interval"`
+}
+
+// CryptoConfig enables AES-256-GCM encryption of filesystem objects. The
+// key is 32 bytes, base64-encoded, given inline in Key or read from KeyEnv
+// or KeyFile.
+type CryptoConfig struct {
+	Enable bool `mapstructure:"enable"`
+	// Key is the key itself, typically substituted from the environment
+	// with ${env:NAME} so it stays out of the config file.
+	Key string `mapstructure:"key"`
+	// KeyEnv names an environment variable holding the key.
+	KeyEnv string `mapstructure:"key_env"`
+	// KeyFile is a file holding the key, e.g. a mounted secret.
+	KeyFile string `mapstructure:"key_file"`
+	// Keys limits encryption to the objects of these attribute keys, for
+	// values that are sensitive rather than merely large; other objects are
+	// stored in plaintext. Empty encrypts every object.
+	Keys []string `mapstructure:"keys"`
+}
+
+// AuditConfig selects where audit records go.
+type AuditConfig struct {
+	// Sink: "" (off), "file" (NDJSON appended to Path) or "syslog".
+	Sink string `mapstructure:"sink"`
+	// Path of the NDJSON audit file.
+	Path string `mapstructure:"path"`
+	// Network and Address of a remote syslog server; empty for local syslog.
+	Network string `mapstructure:"network"`
+	Address string `mapstructure:"address"`
+	// Tag identifies syslog messages.
+	Tag string `mapstructure:"tag"`
+	// QueueSize bounds records waiting for the sink; beyond it records are
+	// dropped rather than blocking the pipeline.
+	QueueSize int `mapstructure:"queue_size"`
+}
+
+// DryRunConfig turns the processor into a read-only tuning tool.
+type DryRunConfig struct {
+	// Enabled leaves spans and the vault untouched and accumulates a report
+	// of matched keys, would-be offloaded bytes and large unmatched keys.
+	Enabled bool `mapstructure:"enabled"`
+	// ReportInterval: how often the report is logged.
+	ReportInterval time.Duration `mapstructure:"report_interval"`
+	// LargeValueBytes: unconfigured string/bytes attributes at least this
+	// large are listed in the report as candidates to vault.
+	LargeValueBytes int `mapstructure:"large_value_bytes"`
+}
+
+// ResolverConfig configures the embedded read-only reference resolver.
+type ResolverConfig struct {
+	// Enabled starts an HTTP server exposing GET /resolve?ref=<ref>.
+	Enabled bool `mapstructure:"enabled"`
+	// Endpoint is the host:port to bind. Defaults to localhost only.
+	Endpoint string `mapstructure:"endpoint"`
+	// AuthToken must be sent as "Authorization: Bearer <token>". Required.
+	// References are looked up only if Vault.AllowedSchemes allows them.
+	AuthToken string `mapstructure:"auth_token"`
+}
+
+// MemoryConfig lets the processor stop offloading under memory pressure.
+type MemoryConfig struct {
+	// BypassHeapMiB: while the heap is above this many MiB, spans pass
+	// through without offloading. 0 disables the bypass.
+	BypassHeapMiB uint64 `mapstructure:"bypass_heap_mib"`
+	// CheckInterval: how often the heap size is sampled.
+	CheckInterval time.Duration `mapstructure:"check_interval"`
 }
 
 // StorageConfig defines where vaulted content is stored.
 type StorageConfig struct {
-	Backend    string           `mapstructure:"backend"` // "filesystem" or "s3"
+	Backend    string           `mapstructure:"backend"` // "filesystem", "kafka", "s3", "gcs" or "memory"
 	Filesystem FilesystemConfig `mapstructure:"filesystem"`
+	Kafka      KafkaConfig      `mapstructure:"kafka"`
+	S3         S3Config         `mapstructure:"s3"`
+	GCS        GCSConfig        `mapstructure:"gcs"`
+	// VerifyAfterWrite reads every stored object back and compares it with
+	// the original before trusting the reference.
+	VerifyAfterWrite bool `mapstructure:"verify_after_write"`
+	// MaxBytesPerSecond rate-limits offloaded bytes across all batches.
+	// Values over the limit stay inline. 0 = unlimited.
+	MaxBytesPerSecond int `mapstructure:"max_bytes_per_second"`
+	// CollapseConcurrentStores makes concurrent stores of identical content
+	// share one backend call and its reference, saving bandwidth on
+	// networked backends.
+	CollapseConcurrentStores bool `mapstructure:"collapse_concurrent_stores"`
+	// RetentionDays deletes vaulted objects this many days after they were
+	// last stored, in a background sweep every SweepInterval. Keys listed
+	// in vault.retention_days keep their own window. 0 = keep forever.
+	RetentionDays int `mapstructure:"retention_days"`
+	// SweepInterval between retention sweeps.
+	SweepInterval time.Duration `mapstructure:"sweep_interval"`
+	// Async writes vaulted content in the background.
+	Async AsyncConfig `mapstructure:"async"`
+	// Retry retries failed stores before on_store_failure applies.
+	Retry RetryConfig `mapstructure:"retry"`
+}
+
+// RetryConfig retries transient store failures, such as throttling or a
+// briefly unavailable disk, with exponential backoff.
+type RetryConfig struct {
+	// MaxAttempts per store, including the first. 0 or 1 = no retries.
+	MaxAttempts int `mapstructure:"max_attempts"`
+	// InitialBackoff before the first retry; each retry waits twice as
+	// long as the one before, up to MaxBackoff.
+	InitialBackoff time.Duration `mapstructure:"initial_backoff"`
+	MaxBackoff     time.Duration `mapstructure:"max_backoff"`
+}
+
+// ValueFilterConfig gates offload on the value itself, e.g. to keep small
+// JSON stubs inline while vaulting full message arrays. An empty filter
+// lets every value through.
+type ValueFilterConfig struct {
+	// Pattern is a regular expression the value must match.
+	Pattern string `mapstructure:"pattern"`
+	// JSONField is a dotted path into values that are JSON documents:
+	// those are only vaulted when the field is present, and Pattern is
+	// matched against the field's value instead of the whole document.
+	JSONField string `mapstructure:"json_field"`
+}
+
+// AsyncConfig moves vault writes off the pipeline. References are computed
+// from the content before it is written, so spans are forwarded without
+// waiting on the backend.
+type AsyncConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// QueueSize bounds the writes waiting for a worker. Writes that do not
+	// fit are refused and handled per on_store_failure.
+	QueueSize int `mapstructure:"queue_size"`
+	// Workers writing concurrently.
+	Workers int `mapstructure:"workers"`
 }
 
 // FilesystemConfig for local file-based vault storage.
 type FilesystemConfig struct {
 	BasePath string `mapstructure:"base_path"`
+	// BasePaths spreads objects across several roots (e.g. one per disk).
+	// When set it replaces BasePath.
+	BasePaths []string `mapstructure:"base_paths"`
+	// Compression: "gzip" or "zstd" compresses objects on disk, "none"
+	// stores them raw. zstd objects are always enveloped.
+	Compression string `mapstructure:"compression"`
+	// CompressMinSize: only compress objects at least this large (bytes).
+	CompressMinSize int `mapstructure:"compress_min_size"`
+	// Envelope writes objects in a self-describing envelope format that
+	// records size, content type and compression.
+	Envelope bool `mapstructure:"envelope"`
+	// EnvelopeVersion pins the envelope version written, for consumers
+	// whose tools only decode older versions. 0 = latest.
+	EnvelopeVersion int `mapstructure:"envelope_version"`
+	// SecondaryChecksum adds a BLAKE2b-256 checksum to references, verified
+	// together with the SHA-256 on retrieval.
+	SecondaryChecksum bool `mapstructure:"secondary_checksum"`
+	// CollisionCheckMaxSize compares content of up to this many bytes with
+	// the object already stored under its name before deduplicating, and
+	// stores it under a disambiguated name if they differ. 0 = off.
+	CollisionCheckMaxSize int `mapstructure:"collision_check_max_size"`
+	// Compaction periodically packs small objects together.
+	Compaction CompactionConfig `mapstructure:"compaction"`
+}
+
+// CompactionConfig controls background packing of small objects into pack
+// files, which saves inodes on vaults holding millions of short prompts.
+type CompactionConfig struct {
+	// Interval between compactions. 0 disables compaction.
+	Interval time.Duration `mapstructure:"interval"`
+	// MinAge: only objects not stored or deduplicated for this long are
+	// packed, so hot objects stay standalone.
+	MinAge time.Duration `mapstructure:"min_age"`
+	// MaxObjectSize: only objects up to this many bytes (as stored) are
+	// packed.
+	MaxObjectSize int64 `mapstructure:"max_object_size"`
+}
+
+// KafkaConfig for producing vaulted content to a Kafka topic.
+type KafkaConfig struct {
+	Brokers []string `mapstructure:"brokers"`
+	// Topic receives one message per object, keyed by its SHA-256. It
+	// should be compacted.
+	Topic string `mapstructure:"topic"`
+	// Timeout bounds each produce or lookup.
+	Timeout time.Duration `mapstructure:"timeout"`
+	// MaxAttempts: how many times a failed produce is attempted before the
+	// content is left inline.
+	MaxAttempts int `mapstructure:"max_attempts"`
+	// LookupIndexSize caps how many keys per partition Retrieve remembers
+	// the offset of, so lookups do not rescan the topic. When a partition
+	// exceeds it, its index starts over. 0 = no cap.
+	LookupIndexSize int `mapstructure:"lookup_index_size"`
+}
+
+// S3Config for storing vaulted content in an S3 or S3-compatible bucket.
+type S3Config struct {
+	Bucket string `mapstructure:"bucket"`
+	Region string `mapstructure:"region"`
+	// Prefix is prepended to every object key, e.g. "prompts/".
+	Prefix string `mapstructure:"prefix"`
+	// Endpoint overrides the S3 endpoint, e.g. for MinIO. Path-style
+	// addressing is used when set.
+	Endpoint string `mapstructure:"endpoint"`
+	// Timeout bounds each put or get.
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// GCSConfig for storing vaulted content in a Google Cloud Storage bucket.
+type GCSConfig struct {
+	Bucket string `mapstructure:"bucket"`
+	// Prefix is prepended to every object name, e.g. "prompts/".
+	Prefix string `mapstructure:"prefix"`
+	// CredentialsFile is a service account JSON key. Empty uses Application
+	// Default Credentials, e.g. GKE workload identity.
+	CredentialsFile string `mapstructure:"credentials_file"`
+	// Timeout bounds each write or read.
+	Timeout time.Duration `mapstructure:"timeout"`
 }
 
 // VaultConfig controls which attributes get vaulted.
 type VaultConfig struct {
-	// Keys lists the attribute keys whose values should be vaulted.
+	// Keys lists the attribute keys whose values should be vaulted. Keys
+	// containing *, ? or [ are glob patterns (path.Match syntax), e.g.
+	// gen_ai.completion.*.content; other keys match exactly.
 	Keys []string `mapstructure:"keys"`
+	// KeyPrefixes vaults span attributes whose key starts with any of these
+	// prefixes, e.g. "baggage." for baggage materialized onto spans.
+	KeyPrefixes []string `mapstructure:"key_prefixes"`
+	// KeyPatterns vaults span attributes whose key matches any of these
+	// regular expressions, e.g. ^gen_ai\.messages\.\d+\.content$.
+	// Patterns are unanchored unless they say otherwise.
+	KeyPatterns []string `mapstructure:"key_patterns"`
+	// SensitiveMarkerSuffix offloads any attribute K whose companion
+	// attribute K+suffix (e.g. "gen_ai.input.sensitive") is true, whether
+	// or not K is listed in Keys. The marker itself is left in place.
+	SensitiveMarkerSuffix string `mapstructure:"sensitive_marker_suffix"`
+	// ProviderProfiles selects the key set per span from the provider named
+	// in ProviderAttribute, falling back to Keys for unknown providers.
+	ProviderProfiles bool `mapstructure:"provider_profiles"`
+	// ProviderAttribute names the span attribute identifying the provider.
+	ProviderAttribute string `mapstructure:"provider_attribute"`
+	// Profiles adds or overrides per-provider key sets.
+	Profiles map[string][]string `mapstructure:"profiles"`
+	// ResourceKeys lists resource attribute keys to vault. Empty = don't touch.
+	ResourceKeys []string `mapstructure:"resource_keys"`
+	// ScopeKeys lists instrumentation scope attribute keys to vault. Empty = don't touch.
+	ScopeKeys []string `mapstructure:"scope_keys"`
+	// ScanResourceAttributes also vaults resource attributes listed in
+	// Keys, for SDKs that attach e.g. gen_ai.system_instructions to the
+	// resource.
+	ScanResourceAttributes bool `mapstructure:"scan_resource_attributes"`
+	// ScanScopeAttributes also vaults instrumentation scope attributes
+	// listed in Keys.
+	ScanScopeAttributes bool `mapstructure:"scan_scope_attributes"`
+	// LogBody also vaults the body of log records when it is a string,
+	// under the same mode and thresholds as attributes. Its reference and
+	// sidecar attributes are named after "log.body".
+	LogBody bool `mapstructure:"log_body"`
 	// SizeThreshold: only vault values larger than this (bytes). 0 = vault everything.
 	SizeThreshold int `mapstructure:"size_threshold"`
-	// Mode: "replace_with_ref" replaces value with vault://ref, "remove" deletes the attr.
+	// KeyThresholds overrides SizeThreshold for individual attribute keys,
+	// e.g. 0 for short but sensitive system instructions. Grouped keys are
+	// compared as a group against SizeThreshold.
+	KeyThresholds map[string]int `mapstructure:"key_thresholds"`
+	// ValueFilter only vaults values that pass it, beyond SizeThreshold.
+	ValueFilter ValueFilterConfig `mapstructure:"value_filter"`
+	// ValueFilters overrides ValueFilter for individual attribute keys.
+	ValueFilters map[string]ValueFilterConfig `mapstructure:"value_filters"`
+	// MinEntropy leaves values inline whose byte entropy (bits per byte,
+	// 0-8; see byteEntropy) is below this, such as long runs of one
+	// character. 0 disables the check.
+	MinEntropy float64 `mapstructure:"min_entropy"`
+	// KeyPriority orders keys from most to least sensitive. Candidates are
+	// offloaded in this order (unlisted keys last), so when a cap stops
+	// offloading early the most sensitive content is already vaulted.
+	KeyPriority []string `mapstructure:"key_priority"`
+	// MaxOffloadsPerSpan caps how many attributes are offloaded from one
+	// span (or resource or scope); the rest stay inline. 0 = no cap.
+	MaxOffloadsPerSpan int `mapstructure:"max_offloads_per_span"`
+	// Groups lists sets of keys (e.g. prompt and completion of one turn)
+	// whose combined size is compared against the smallest threshold among
+	// the group's keys (KeyThresholds, else SizeThreshold): either all
+	// present keys of a group are vaulted or none are.
+	Groups [][]string `mapstructure:"groups"`
+	// JSONExclusions lists dotted paths (e.g. "timestamp",
+	// "metadata.request_id") removed from JSON values before they are
+	// hashed and stored, so volatile fields do not defeat dedup. The vault
+	// holds, and retrieval returns, the canonicalized document.
+	JSONExclusions []string `mapstructure:"json_exclusions"`
+	// Mode: "replace_with_ref" replaces value with vault://ref, "remove" deletes the attr,
+	// "keep_and_ref" keeps the value and only adds the reference attribute,
+	// "sidecar" replaces the value with the reference and keeps a compressed
+	// copy of the original in a sidecar attribute (see Sidecar).
 	Mode string `mapstructure:"mode"`
+	// Sidecar configures the sidecar attribute written in "sidecar" mode.
+	Sidecar SidecarConfig `mapstructure:"sidecar"`
+	// DestructiveAfter delays Mode: until then the processor behaves as
+	// keep_and_ref. Either an RFC 3339 timestamp or a duration measured from
+	// processor start (e.g. "168h"). Empty applies Mode immediately.
+	DestructiveAfter string `mapstructure:"destructive_after"`
+	// RefNamespace is prepended to reference attribute names, e.g. "vault."
+	// writes "vault.gen_ai.prompt.vault_ref". Empty keeps refs beside the key.
+	RefNamespace string `mapstructure:"ref_namespace"`
+	// RefSuffix is appended to reference attribute names. RefNamespace and
+	// RefSuffix cannot both be empty.
+	RefSuffix string `mapstructure:"ref_suffix"`
+	// MaxRefValueLength caps the reference written into the original
+	// attribute. Longer references are shortened to their essential
+	// vault://<hash> form there; the reference attribute always holds the
+	// full reference. 0 = no cap.
+	MaxRefValueLength int `mapstructure:"max_ref_value_length"`
+	// KeyedAddressing folds the attribute key into the content address so
+	// identical content under different keys is stored separately.
+	KeyedAddressing bool `mapstructure:"keyed_addressing"`
+	// OffloadOnlyNovel keeps content inline when it is already in the vault
+	// and only offloads content the vault has not seen yet.
+	OffloadOnlyNovel bool `mapstructure:"offload_only_novel"`
+	// NovelCache caches the vault's answers for OffloadOnlyNovel.
+	NovelCache NovelCacheConfig `mapstructure:"novel_cache"`
+	// OnStoreFailure: "keep" leaves content inline when it cannot be
+	// stored (including throttled and timed-out attributes); "drop" removes
+	// it in modes that take content off the span; "fail" returns the batch
+	// to the caller with a retryable error instead of forwarding content
+	// those modes would have taken off.
+	OnStoreFailure string `mapstructure:"on_store_failure"`
+	// ErrorStatusOnDrop sets the span status to Error, naming the attribute
+	// and the failure, when OnStoreFailure drops content.
+	ErrorStatusOnDrop bool `mapstructure:"error_status_on_drop"`
+	// OnEncodeFailure controls map and slice values that cannot be encoded
+	// as JSON, such as those holding NaN or infinite doubles: "keep" leaves
+	// the value inline; "string" vaults its string form, with non-finite
+	// numbers written as strings, which restores as a string value; "fail"
+	// leaves the value inline and returns the batch to the caller with a
+	// permanent error.
+	OnEncodeFailure string `mapstructure:"on_encode_failure"`
+	// EventDuplicates controls span event attributes repeating the value of
+	// a span attribute: "store" vaults both locations independently;
+	// "reference" stores the span attribute and gives the event attribute
+	// the same reference; "prefer_attribute" and "prefer_event" vault the
+	// preferred location and remove the repeated value from the other.
+	EventDuplicates string `mapstructure:"event_duplicates"`
+	// OnReference controls matched attributes whose value is already a vault
+	// reference: "skip" leaves them as they are, "validate" also checks the
+	// reference resolves, "rewrite" lays them out as if offloaded in Mode.
+	OnReference string `mapstructure:"on_reference"`
+	// RetentionDays keeps the objects of the listed keys for their own
+	// number of days when the vault is swept, e.g. long for system prompts
+	// and short for user input. Other keys follow the sweep's max age.
+	RetentionDays map[string]int `mapstructure:"retention_days"`
+	// Bundle stores a span's matched text values together as one JSON
+	// object of {key: value} instead of one object per key. Each key's
+	// reference points at the bundle plus its field. Mode applies as usual.
+	Bundle bool `mapstructure:"bundle"`
+	// MarkOffloaded sets vault.offloaded=true on every span that had an
+	// attribute offloaded, as a signal for tail sampling.
+	MarkOffloaded bool `mapstructure:"mark_offloaded"`
+	// Rehydrate reverses offloading: instead of vaulting, the processor
+	// replaces vault references in incoming spans with their content (see
+	// RestoreContent), for a downstream collector feeding a trusted sink.
+	// Requires a vault that supports Retrieve.
+	Rehydrate bool `mapstructure:"rehydrate"`
+	// AllowedSchemes lists the reference schemes (e.g. "vault") followed
+	// when restoring (Rehydrate), validating (OnReference "validate") or
+	// resolving (Resolver) a reference. References with any other scheme
+	// are never looked up. Empty allows only the scheme of the configured
+	// backend's own references.
+	AllowedSchemes []string `mapstructure:"allowed_schemes"`
+	// RehydrateMaxBytes caps how many bytes of content are restored into
+	// one span (with its events), log record, data point or exemplar;
+	// references whose content would exceed it stay in place, so rehydrated
+	// data stays within downstream size limits. 0 restores everything.
+	RehydrateMaxBytes int `mapstructure:"rehydrate_max_bytes"`
+	// DisableForEnvironments turns the processor into a pure pass-through,
+	// neither reading nor mutating data, when the collector's own
+	// EnvironmentAttribute resource attribute (service::telemetry::resource)
+	// is one of these, e.g. ["dev"] to share one pipeline config with local
+	// development.
+	DisableForEnvironments []string `mapstructure:"disable_for_environments"`
+	// EnvironmentAttribute names the collector resource attribute holding
+	// the environment.
+	EnvironmentAttribute string `mapstructure:"environment_attribute"`
+	// CanaryAttribute, when set, is stamped as true on every span the
+	// processor sees, matched or not, so its absence downstream shows the
+	// processor is not in the pipeline.
+	CanaryAttribute string `mapstructure:"canary_attribute"`
+	// TraceDigest writes a digest of every reference produced for a trace
+	// within a batch to that trace's root span as gen_ai.vault.trace_digest.
+	TraceDigest bool `mapstructure:"trace_digest"`
+	// MaxInFlightBatches caps how many batches are offloaded concurrently.
+	// Batches beyond the cap are rejected untouched with a retryable error so
+	// an upstream queue/retry sender backs off. 0 = unlimited.
+	MaxInFlightBatches int `mapstructure:"max_in_flight_batches"`
+	// MaxBatchProcessingTime caps how long one batch is spent offloading.
+	// Once exceeded, remaining attributes stay inline and the batch is
+	// forwarded. 0 = no cap.
+	MaxBatchProcessingTime time.Duration `mapstructure:"max_batch_processing_time"`
+	// Concurrency is how many of one span's attributes are stored at once,
+	// which hides backend latency when a span carries several. 0 =
+	// GOMAXPROCS, 1 = one at a time.
+	Concurrency int `mapstructure:"concurrency"`
+	// Conversation stores growing chat histories as deltas between turns.
+	Conversation ConversationConfig `mapstructure:"conversation"`
+	// Consent restricts offloading to spans an upstream component has
+	// approved. Unapproved spans pass through untouched.
+	Consent ConsentConfig `mapstructure:"consent"`
+}
+
+// ConsentConfig names where an upstream consent signal is read from. With
+// neither set, every span is eligible; with both, either signal suffices.
+type ConsentConfig struct {
+	// Attribute is a span or resource attribute that approves a span when
+	// it is true (a bool, or the string "true").
+	Attribute string `mapstructure:"attribute"`
+	// Metadata is a client metadata key in the request context, set by the
+	// receiver (include_metadata) or an upstream processor, that approves
+	// every span in the batch when its value is "true".
+	Metadata string `mapstructure:"metadata"`
+}
+
+// enabled reports whether offloading is gated on consent.
+func (c ConsentConfig) enabled() bool {
+	return c.Attribute != "" || c.Metadata != ""
+}
+
+// SidecarConfig controls the span-local copy kept in "sidecar" mode. A
+// downstream processor is expected to strip the sidecar before export.
+type SidecarConfig struct {
+	// Suffix is appended to the attribute key to name the sidecar.
+	Suffix string `mapstructure:"suffix"`
+	// Compression: "gzip" or "none".
+	Compression string `mapstructure:"compression"`
+}
+
+// NovelCacheConfig for remembering content the vault already holds, so
+// offload_only_novel does not ask the vault about every repeated value.
+type NovelCacheConfig struct {
+	// Size caps how many checksums are remembered. 0 = no cache.
+	Size int `mapstructure:"size"`
+	// TTL is how long the vault's answer is trusted. It is capped at
+	// storage.retention_days, so content swept from the vault is offloaded
+	// again. 0 = the retention window, or until evicted without retention.
+	TTL time.Duration `mapstructure:"ttl"`
+	// Warm fills the cache at start with the checksums of objects the
+	// vault stored within TTL, newest first and at most Size of them, so
+	// the first batches do not ask the vault about content it holds.
+	Warm bool `mapstructure:"warm"`
+}
+
+// ConversationConfig controls append-only storage of conversation keys.
+type ConversationConfig struct {
+	// Keys lists attribute keys holding cumulative conversation history.
+	// They must also appear in VaultConfig.Keys to be vaulted at all.
+	Keys []string `mapstructure:"keys"`
+	// IDAttribute is the span attribute identifying the conversation.
+	IDAttribute string `mapstructure:"id_attribute"`
+	// MaxConversations bounds how many conversations are tracked in memory.
+	MaxConversations int `mapstructure:"max_conversations"`
+	// TraceScoped tracks spans without IDAttribute by their trace instead,
+	// so cumulative snapshots of a streaming completion emitted as several
+	// spans of one trace are stored as deltas of each other.
+	TraceScoped bool `mapstructure:"trace_scoped"`
+	// IdleTimeout forgets conversations (or traces) not extended for this
+	// long, treating them as complete. 0 = kept until evicted.
+	IdleTimeout time.Duration `mapstructure:"idle_timeout"`
 }
 
 func createDefaultConfig() *Config {
 	return &Config{
 		Storage: StorageConfig{
-			Backend: "filesystem",
+			Backend:       "filesystem",
+			SweepInterval: time.Hour,
+			Async: AsyncConfig{
+				QueueSize: 1000,
+				Workers:   4,
+			},
+			Retry: RetryConfig{
+				MaxAttempts:    1,
+				InitialBackoff: 100 * time.Millisecond,
+				MaxBackoff:     5 * time.Second,
+			},
 			Filesystem: FilesystemConfig{
-				BasePath: "/data/vault",
+				BasePath:        "/data/vault",
+				Compression:     "gzip",
+				CompressMinSize: 1024,
+				Compaction: CompactionConfig{
+					MinAge:        24 * time.Hour,
+					MaxObjectSize: 4096,
+				},
+			},
+			Kafka: KafkaConfig{
+				Timeout:         10 * time.Second,
+				MaxAttempts:     3,
+				LookupIndexSize: 100000,
+			},
+			S3: S3Config{
+				Timeout: 10 * time.Second,
+			},
+			GCS: GCSConfig{
+				Timeout: 10 * time.Second,
 			},
 		},
 		Vault: VaultConfig{
@@ -43,8 +532,183 @@ func createDefaultConfig() *Config {
 				"gen_ai.input.messages",
 				"gen_ai.output.messages",
 			},
-			SizeThreshold: 0,
-			Mode:          "replace_with_ref",
+			ProviderAttribute:    "gen_ai.system",
+			EnvironmentAttribute: "deployment.environment",
+			SizeThreshold:        0,
+			Mode:                 "replace_with_ref",
+			RefSuffix:            ".vault_ref",
+			OnStoreFailure:       "keep",
+			OnEncodeFailure:      "keep",
+			EventDuplicates:      "store",
+			OnReference:          "skip",
+			Sidecar: SidecarConfig{
+				Suffix:      ".vault_sidecar",
+				Compression: "gzip",
+			},
+			Conversation: ConversationConfig{
+				IDAttribute:      "gen_ai.conversation.id",
+				MaxConversations: 10000,
+			},
+		},
+		Memory: MemoryConfig{
+			CheckInterval: time.Second,
+		},
+		Audit: AuditConfig{
+			Tag:       "promptvault",
+			QueueSize: 1000,
+		},
+		DryRun: DryRunConfig{
+			ReportInterval:  time.Minute,
+			LargeValueBytes: 4096,
 		},
+		Resolver: ResolverConfig{
+			Endpoint: "localhost:8790",
+		},
+	}
+}
+
+var _ component.ConfigValidator = (*Config)(nil)
+
+// Validate rejects configurations that would otherwise pass prompts
+// through silently, so the collector fails at startup instead. Checks that
+// depend on the vault's capabilities happen when the processor is created.
+func (cfg *Config) Validate() error {
+	var errs error
+	switch cfg.Vault.Mode {
+	case "replace_with_ref", "remove", "keep_and_ref", "sidecar":
+	default:
+		errs = errors.Join(errs, fmt.Errorf("unsupported vault.mode %q: use replace_with_ref, remove, keep_and_ref or sidecar", cfg.Vault.Mode))
+	}
+	v := cfg.Vault
+	if len(v.Keys) == 0 && len(v.KeyPrefixes) == 0 && len(v.KeyPatterns) == 0 && len(v.ResourceKeys) == 0 && len(v.ScopeKeys) == 0 &&
+		v.SensitiveMarkerSuffix == "" && !v.ProviderProfiles && !v.LogBody {
+		errs = errors.Join(errs, errors.New("vault.keys is empty and nothing else selects attributes to vault"))
+	}
+	if v.RefNamespace == "" && v.RefSuffix == "" {
+		// The reference attribute would be the original attribute itself.
+		errs = errors.Join(errs, errors.New("vault.ref_namespace and vault.ref_suffix must not both be empty"))
+	}
+	if v.SizeThreshold < 0 {
+		errs = errors.Join(errs, fmt.Errorf("vault.size_threshold must not be negative, got %d", v.SizeThreshold))
+	}
+	for key, threshold := range v.KeyThresholds {
+		if threshold < 0 {
+			errs = errors.Join(errs, fmt.Errorf("vault.key_thresholds for %s must not be negative, got %d", key, threshold))
+		}
+	}
+	if v.RehydrateMaxBytes < 0 {
+		errs = errors.Join(errs, fmt.Errorf("vault.rehydrate_max_bytes must not be negative, got %d", v.RehydrateMaxBytes))
+	}
+	if c := v.NovelCache; c.Size < 0 || c.TTL < 0 {
+		errs = errors.Join(errs, errors.New("vault.novel_cache size and ttl must not be negative"))
+	}
+	if c := v.NovelCache; c.Warm && c.Size == 0 {
+		errs = errors.Join(errs, errors.New("vault.novel_cache.warm requires a size"))
+	}
+	if v.OffloadOnlyNovel && v.KeyedAddressing {
+		errs = errors.Join(errs, errors.New("vault.offload_only_novel cannot be combined with keyed_addressing"))
+	}
+	if len(v.RetentionDays) > 0 && v.KeyedAddressing {
+		errs = errors.Join(errs, errors.New("vault.retention_days cannot be combined with keyed_addressing"))
+	}
+	for key, days := range v.RetentionDays {
+		if days < 1 {
+			errs = errors.Join(errs, fmt.Errorf("vault.retention_days for %s must be at least 1, got %d", key, days))
+		}
 	}
-}
\ No newline at end of file
+	if v.Mode == "sidecar" {
+		switch v.Sidecar.Compression {
+		case "gzip", "none":
+		default:
+			errs = errors.Join(errs, fmt.Errorf("unsupported vault.sidecar.compression %q: use gzip or none", v.Sidecar.Compression))
+		}
+	}
+	switch v.OnStoreFailure {
+	case "keep", "drop", "fail":
+	default:
+		errs = errors.Join(errs, fmt.Errorf("unsupported vault.on_store_failure %q: use keep, drop or fail", v.OnStoreFailure))
+	}
+	switch v.OnEncodeFailure {
+	case "keep", "string", "fail":
+	default:
+		errs = errors.Join(errs, fmt.Errorf("unsupported vault.on_encode_failure %q: use keep, string or fail", v.OnEncodeFailure))
+	}
+	switch v.EventDuplicates {
+	case "store", "reference", "prefer_attribute", "prefer_event":
+	default:
+		errs = errors.Join(errs, fmt.Errorf("unsupported vault.event_duplicates %q: use store, reference, prefer_attribute or prefer_event", v.EventDuplicates))
+	}
+	switch v.OnReference {
+	case "skip", "rewrite", "validate":
+	default:
+		errs = errors.Join(errs, fmt.Errorf("unsupported vault.on_reference %q: use skip, rewrite or validate", v.OnReference))
+	}
+	if _, _, err := parseDestructiveAfter(v.DestructiveAfter); err != nil {
+		errs = errors.Join(errs, err)
+	}
+	if _, _, err := compileValueFilters(v); err != nil {
+		errs = errors.Join(errs, err)
+	}
+	for _, pattern := range v.KeyPatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("invalid vault.key_patterns entry %q: %w", pattern, err))
+		}
+	}
+	for _, key := range globPatterns(v) {
+		if _, err := path.Match(key, ""); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("invalid key pattern %q: %w", key, err))
+		}
+	}
+	switch cfg.Storage.Backend {
+	case "", "filesystem", "kafka", "s3", "gcs", "memory":
+	default:
+		errs = errors.Join(errs, fmt.Errorf("unsupported storage.backend %q: use filesystem, kafka, s3, gcs or memory", cfg.Storage.Backend))
+	}
+	if fs := cfg.Storage.Filesystem; (cfg.Storage.Backend == "" || cfg.Storage.Backend == "filesystem") && fs.BasePath == "" && len(fs.BasePaths) == 0 {
+		errs = errors.Join(errs, errors.New("storage.filesystem.base_path is required for the filesystem backend"))
+	}
+	if r := cfg.Storage.Retry; r.MaxAttempts < 0 || r.InitialBackoff < 0 || r.MaxBackoff < 0 {
+		errs = errors.Join(errs, errors.New("storage.retry max_attempts, initial_backoff and max_backoff must not be negative"))
+	}
+	if cfg.Storage.RetentionDays < 0 {
+		errs = errors.Join(errs, fmt.Errorf("storage.retention_days must not be negative, got %d", cfg.Storage.RetentionDays))
+	}
+	if async := cfg.Storage.Async; async.Enabled {
+		if cfg.Storage.VerifyAfterWrite {
+			errs = errors.Join(errs, errors.New("storage.async cannot be combined with verify_after_write"))
+		}
+		if cfg.Storage.Filesystem.CollisionCheckMaxSize > 0 {
+			errs = errors.Join(errs, errors.New("storage.async cannot be combined with collision_check_max_size"))
+		}
+		if async.QueueSize < 1 || async.Workers < 1 {
+			errs = errors.Join(errs, errors.New("storage.async queue_size and workers must be at least 1"))
+		}
+	}
+	if cfg.Storage.Kafka.LookupIndexSize < 0 {
+		errs = errors.Join(errs, errors.New("storage.kafka.lookup_index_size must not be negative"))
+	}
+	switch cfg.Storage.Filesystem.Compression {
+	case "", "none", "gzip", "zstd":
+	default:
+		errs = errors.Join(errs, fmt.Errorf("unsupported storage.filesystem.compression %q: use none, gzip or zstd", cfg.Storage.Filesystem.Compression))
+	}
+	if cfg.Crypto.Enable {
+		if cfg.Storage.Backend != "" && cfg.Storage.Backend != "filesystem" {
+			errs = errors.Join(errs, fmt.Errorf("crypto is only supported by the filesystem backend, not %q", cfg.Storage.Backend))
+		}
+		if _, err := LoadEncryptionKey(cfg.Crypto); err != nil {
+			errs = errors.Join(errs, err)
+		}
+	} else if len(cfg.Crypto.Keys) > 0 {
+		errs = errors.Join(errs, errors.New("crypto.keys requires crypto.enable"))
+	}
+	if len(cfg.Crypto.Keys) > 0 && v.KeyedAddressing {
+		errs = errors.Join(errs, errors.New("crypto.keys cannot be combined with keyed_addressing"))
+	}
+	for _, key := range cfg.Crypto.Keys {
+		if v.RetentionDays[key] > 0 {
+			errs = errors.Join(errs, fmt.Errorf("crypto.keys cannot be combined with retention_days for %s", key))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,124 @@
+package promptvaultprocessor
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		err    string
+	}{
+		{name: "default", modify: func(*Config) {}},
+		{name: "sidecar mode", modify: func(c *Config) { c.Vault.Mode = "sidecar" }},
+		{name: "prefixes only", modify: func(c *Config) {
+			c.Vault.Keys = nil
+			c.Vault.KeyPrefixes = []string{"baggage."}
+		}},
+		{name: "mode typo", modify: func(c *Config) { c.Vault.Mode = "replace" }, err: `unsupported vault.mode "replace"`},
+		{name: "empty mode", modify: func(c *Config) { c.Vault.Mode = "" }, err: "unsupported vault.mode"},
+		{name: "no keys", modify: func(c *Config) { c.Vault.Keys = nil }, err: "vault.keys is empty"},
+		{name: "crypto", modify: func(c *Config) {
+			c.Crypto = CryptoConfig{Enable: true, Key: base64.StdEncoding.EncodeToString(make([]byte, 32))}
+		}},
+		{name: "crypto on kafka", modify: func(c *Config) {
+			c.Storage.Backend = "kafka"
+			c.Crypto = CryptoConfig{Enable: true, Key: base64.StdEncoding.EncodeToString(make([]byte, 32))}
+		}, err: "crypto is only supported by the filesystem backend"},
+		{name: "crypto without key", modify: func(c *Config) { c.Crypto.Enable = true }, err: "enable requires key"},
+		{name: "crypto short key", modify: func(c *Config) {
+			c.Crypto = CryptoConfig{Enable: true, Key: base64.StdEncoding.EncodeToString(make([]byte, 16))}
+		}, err: "key must be 32 bytes"},
+		{name: "crypto keys without crypto", modify: func(c *Config) { c.Crypto.Keys = []string{"gen_ai.prompt"} }, err: "crypto.keys requires crypto.enable"},
+		{name: "unknown backend", modify: func(c *Config) { c.Storage.Backend = "azure" }, err: `unsupported storage.backend "azure"`},
+		{name: "unknown compression", modify: func(c *Config) { c.Storage.Filesystem.Compression = "lz4" }, err: `unsupported storage.filesystem.compression "lz4"`},
+		{name: "malformed value filter", modify: func(c *Config) {
+			c.Vault.ValueFilters = map[string]ValueFilterConfig{"gen_ai.prompt": {Pattern: "(unclosed"}}
+		}, err: "value_filters for gen_ai.prompt"},
+		{name: "ref attribute is the original", modify: func(c *Config) { c.Vault.RefSuffix = "" }, err: "vault.ref_namespace and vault.ref_suffix must not both be empty"},
+		{name: "ref namespace only", modify: func(c *Config) { c.Vault.RefNamespace, c.Vault.RefSuffix = "vault.", "" }},
+		{name: "negative size threshold", modify: func(c *Config) { c.Vault.SizeThreshold = -1 }, err: "vault.size_threshold must not be negative"},
+		{name: "negative key threshold", modify: func(c *Config) { c.Vault.KeyThresholds = map[string]int{"gen_ai.prompt": -5} }, err: "vault.key_thresholds for gen_ai.prompt"},
+		{name: "negative rehydrate budget", modify: func(c *Config) { c.Vault.RehydrateMaxBytes = -1 }, err: "vault.rehydrate_max_bytes must not be negative"},
+		{name: "missing base path", modify: func(c *Config) { c.Storage.Filesystem.BasePath = "" }, err: "storage.filesystem.base_path is required"},
+		{name: "base paths without base path", modify: func(c *Config) {
+			c.Storage.Filesystem.BasePath = ""
+			c.Storage.Filesystem.BasePaths = []string{"/data/a", "/data/b"}
+		}},
+		{name: "memory backend needs no base path", modify: func(c *Config) {
+			c.Storage.Backend = "memory"
+			c.Storage.Filesystem.BasePath = ""
+		}},
+		{name: "negative retry", modify: func(c *Config) { c.Storage.Retry.MaxAttempts = -1 }, err: "storage.retry"},
+		{name: "negative kafka lookup index", modify: func(c *Config) { c.Storage.Kafka.LookupIndexSize = -1 }, err: "storage.kafka.lookup_index_size"},
+		{name: "negative storage retention", modify: func(c *Config) { c.Storage.RetentionDays = -1 }, err: "storage.retention_days must not be negative"},
+		{name: "async", modify: func(c *Config) { c.Storage.Async.Enabled = true }},
+		{name: "async with verify after write", modify: func(c *Config) {
+			c.Storage.Async.Enabled = true
+			c.Storage.VerifyAfterWrite = true
+		}, err: "storage.async cannot be combined with verify_after_write"},
+		{name: "async with collision check", modify: func(c *Config) {
+			c.Storage.Async.Enabled = true
+			c.Storage.Filesystem.CollisionCheckMaxSize = 1024
+		}, err: "storage.async cannot be combined with collision_check_max_size"},
+		{name: "async without queue", modify: func(c *Config) { c.Storage.Async = AsyncConfig{Enabled: true, Workers: 1} }, err: "storage.async queue_size and workers"},
+		{name: "negative novel cache", modify: func(c *Config) { c.Vault.NovelCache.TTL = -time.Second }, err: "vault.novel_cache"},
+		{name: "novel cache warm without size", modify: func(c *Config) { c.Vault.NovelCache.Warm = true }, err: "vault.novel_cache.warm requires a size"},
+		{name: "novel with keyed addressing", modify: func(c *Config) {
+			c.Vault.OffloadOnlyNovel = true
+			c.Vault.KeyedAddressing = true
+		}, err: "vault.offload_only_novel cannot be combined with keyed_addressing"},
+		{name: "retention days", modify: func(c *Config) { c.Vault.RetentionDays = map[string]int{"gen_ai.prompt": 30} }},
+		{name: "retention days with keyed addressing", modify: func(c *Config) {
+			c.Vault.RetentionDays = map[string]int{"gen_ai.prompt": 30}
+			c.Vault.KeyedAddressing = true
+		}, err: "vault.retention_days cannot be combined with keyed_addressing"},
+		{name: "zero retention days", modify: func(c *Config) { c.Vault.RetentionDays = map[string]int{"gen_ai.prompt": 0} }, err: "vault.retention_days for gen_ai.prompt must be at least 1"},
+		{name: "crypto keys with keyed addressing", modify: func(c *Config) {
+			c.Crypto = CryptoConfig{Enable: true, Key: base64.StdEncoding.EncodeToString(make([]byte, 32)), Keys: []string{"gen_ai.prompt"}}
+			c.Vault.KeyedAddressing = true
+		}, err: "crypto.keys cannot be combined with keyed_addressing"},
+		{name: "crypto keys with retention days", modify: func(c *Config) {
+			c.Crypto = CryptoConfig{Enable: true, Key: base64.StdEncoding.EncodeToString(make([]byte, 32)), Keys: []string{"gen_ai.prompt"}}
+			c.Vault.RetentionDays = map[string]int{"gen_ai.prompt": 30}
+		}, err: "crypto.keys cannot be combined with retention_days for gen_ai.prompt"},
+		{name: "unknown sidecar compression", modify: func(c *Config) {
+			c.Vault.Mode = "sidecar"
+			c.Vault.Sidecar.Compression = "zstd"
+		}, err: `unsupported vault.sidecar.compression "zstd"`},
+		{name: "sidecar compression outside sidecar mode", modify: func(c *Config) { c.Vault.Sidecar.Compression = "zstd" }},
+		{name: "unknown on store failure", modify: func(c *Config) { c.Vault.OnStoreFailure = "retry" }, err: `unsupported vault.on_store_failure "retry"`},
+		{name: "on encode failure fail", modify: func(c *Config) { c.Vault.OnEncodeFailure = "fail" }},
+		{name: "unknown on encode failure", modify: func(c *Config) { c.Vault.OnEncodeFailure = "drop" }, err: `unsupported vault.on_encode_failure "drop"`},
+		{name: "unknown event duplicates", modify: func(c *Config) { c.Vault.EventDuplicates = "merge" }, err: `unsupported vault.event_duplicates "merge"`},
+		{name: "on reference validate", modify: func(c *Config) { c.Vault.OnReference = "validate" }},
+		{name: "unknown on reference", modify: func(c *Config) { c.Vault.OnReference = "follow" }, err: `unsupported vault.on_reference "follow"`},
+		{name: "destructive after timestamp", modify: func(c *Config) { c.Vault.DestructiveAfter = "2026-04-01T00:00:00Z" }},
+		{name: "destructive after delay", modify: func(c *Config) { c.Vault.DestructiveAfter = "72h" }},
+		{name: "unparseable destructive after", modify: func(c *Config) { c.Vault.DestructiveAfter = "next tuesday" }, err: `vault.destructive_after "next tuesday"`},
+		{name: "key pattern", modify: func(c *Config) { c.Vault.Keys = []string{"gen_ai.completion.*.content"} }},
+		{name: "key regexp only", modify: func(c *Config) {
+			c.Vault.Keys = nil
+			c.Vault.KeyPatterns = []string{`^gen_ai\.messages\.\d+\.content$`}
+		}},
+		{name: "malformed key regexp", modify: func(c *Config) { c.Vault.KeyPatterns = []string{`gen_ai\.messages\.(\d+`} }, err: "invalid vault.key_patterns entry"},
+		{name: "malformed key pattern", modify: func(c *Config) { c.Vault.Keys = []string{"gen_ai.completion.[0"} }, err: `invalid key pattern "gen_ai.completion.[0"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := createDefaultConfig()
+			tt.modify(cfg)
+			err := cfg.Validate()
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("expected an error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
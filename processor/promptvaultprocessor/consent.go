@@ -0,0 +1,30 @@
+package promptvaultprocessor
+
+import (
+	"context"
+
+	"go.opentelemetry.io/collector/client"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+)
+
+// metadataConsent reports whether the request context approves the whole
+// batch through the configured client metadata key.
+func (p *vaultProcessor) metadataConsent(ctx context.Context) bool {
+	key := p.config.Vault.Consent.Metadata
+	if key == "" {
+		return false
+	}
+	for _, v := range client.FromContext(ctx).Metadata.Get(key) {
+		if v == "true" {
+			return true
+		}
+	}
+	return false
+}
+
+// attributeConsent reports whether attrs carry the configured consent
+// attribute set to true.
+func (p *vaultProcessor) attributeConsent(attrs pcommon.Map) bool {
+	key := p.config.Vault.Consent.Attribute
+	return key != "" && attrIsTrue(attrs, key)
+}
@@ -0,0 +1,81 @@
+package promptvaultprocessor
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/collector/client"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+func TestConsentAttribute(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	sink := new(consumertest.TracesSink)
+	cfg := createDefaultConfig()
+	cfg.Vault.Consent.Attribute = "consent.vault"
+	proc := newTestProcessor(t, cfg, vault, sink)
+
+	td := ptrace.NewTraces()
+	spans := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans()
+	consented := spans.AppendEmpty().Attributes()
+	consented.PutBool("consent.vault", true)
+	consented.PutStr("gen_ai.prompt", "Tell me about quantum computing")
+	declined := spans.AppendEmpty().Attributes()
+	declined.PutStr("consent.vault", "false")
+	declined.PutStr("gen_ai.prompt", "Tell me about quantum computing")
+	spans.AppendEmpty().Attributes().PutStr("gen_ai.prompt", "Tell me about quantum computing")
+
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans()
+	if _, ok := got.At(0).Attributes().Get("gen_ai.prompt.vault_ref"); !ok {
+		t.Error("expected the consented span to be offloaded")
+	}
+	for i := 1; i < 3; i++ {
+		attrs := got.At(i).Attributes()
+		if v, _ := attrs.Get("gen_ai.prompt"); v.Str() != "Tell me about quantum computing" {
+			t.Errorf("span %d: expected content to pass through without consent, got %q", i, v.Str())
+		}
+		if _, ok := attrs.Get("gen_ai.prompt.vault_ref"); ok {
+			t.Errorf("span %d: expected no reference without consent", i)
+		}
+	}
+}
+
+func TestConsentMetadata(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	sink := new(consumertest.TracesSink)
+	cfg := createDefaultConfig()
+	cfg.Vault.Consent.Metadata = "x-vault-consent"
+	proc := newTestProcessor(t, cfg, vault, sink)
+
+	newTraces := func() ptrace.Traces {
+		td := ptrace.NewTraces()
+		spans := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans()
+		spans.AppendEmpty().Attributes().PutStr("gen_ai.prompt", "Tell me about quantum computing")
+		return td
+	}
+
+	consented := client.NewContext(context.Background(), client.Info{
+		Metadata: client.NewMetadata(map[string][]string{"x-vault-consent": {"true"}}),
+	})
+	if err := proc.ConsumeTraces(consented, newTraces()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := proc.ConsumeTraces(context.Background(), newTraces()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	traces := sink.AllTraces()
+	first := traces[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	if _, ok := first.Get("gen_ai.prompt.vault_ref"); !ok {
+		t.Error("expected the batch with consent metadata to be offloaded")
+	}
+	second := traces[1].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	if _, ok := second.Get("gen_ai.prompt.vault_ref"); ok {
+		t.Error("expected the batch without consent metadata to pass through")
+	}
+}
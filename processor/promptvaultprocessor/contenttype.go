@@ -0,0 +1,71 @@
+package promptvaultprocessor
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+)
+
+// Content types detected for vaulted objects.
+const (
+	contentTypeText   = "text"
+	contentTypeJSON   = "json"
+	contentTypeBinary = "binary"
+	contentTypeGzip   = "gzip"
+	// contentTypeMap and contentTypeSlice tag JSON-encoded map and slice
+	// attribute values, so they are restored as the same value type.
+	contentTypeMap   = "map"
+	contentTypeSlice = "slice"
+)
+
+// contentTypeExt maps a detected content type to its object file extension.
+var contentTypeExt = map[string]string{
+	contentTypeText:   "txt",
+	contentTypeJSON:   "json",
+	contentTypeBinary: "bin",
+	contentTypeGzip:   "gz",
+	contentTypeMap:    "map",
+	contentTypeSlice:  "slice",
+}
+
+// refContentType returns the content type recorded in ref's extension, or
+// "" when it has none.
+func refContentType(ref string) string {
+	base, _, _ := strings.Cut(ref, refFragmentSep)
+	dot := strings.LastIndex(base, ".")
+	if dot < 0 || strings.Contains(base[dot:], "/") {
+		return ""
+	}
+	for contentType, ext := range contentTypeExt {
+		if base[dot+1:] == ext {
+			return contentType
+		}
+	}
+	return ""
+}
+
+// detectContentType makes a cheap guess at what content holds so operators
+// browsing the vault can tell objects apart.
+func detectContentType(content []byte) string {
+	if len(content) >= 2 && content[0] == 0x1f && content[1] == 0x8b {
+		return contentTypeGzip
+	}
+	trimmed := bytes.TrimSpace(content)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid(trimmed) {
+		return contentTypeJSON
+	}
+	if utf8.Valid(content) && !hasControlBytes(content) {
+		return contentTypeText
+	}
+	return contentTypeBinary
+}
+
+func hasControlBytes(content []byte) bool {
+	for _, b := range content {
+		if b < 0x20 && b != '\t' && b != '\n' && b != '\r' {
+			return true
+		}
+	}
+	return false
+}
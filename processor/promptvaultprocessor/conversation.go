@@ -0,0 +1,149 @@
+package promptvaultprocessor
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// deltaMagic prefixes objects that only hold the tail of a conversation.
+// The header line after it names the reference of the previous turn.
+const deltaMagic = "PVDELTA1\n"
+
+// maxChainDepth guards reconstruction against corrupt or cyclic chains.
+const maxChainDepth = 100000
+
+// conversationLog tracks the last stored turn of each conversation so that
+// a growing history can be stored as a delta chained to the previous turn.
+type conversationLog struct {
+	mu    sync.Mutex
+	max   int
+	idle  time.Duration
+	now   func() time.Time
+	turns map[string]conversationTurn
+}
+
+type conversationTurn struct {
+	ref  string
+	size int
+	hash [sha256.Size]byte
+	seen time.Time
+	// chain lists the objects the turn resolves through, from the first
+	// turn to ref itself.
+	chain []string
+}
+
+// newConversationLog tracks up to maxConversations conversations (0 =
+// unbounded). Conversations not extended within idle are forgotten (0 =
+// never), so their next turn is stored in full.
+func newConversationLog(maxConversations int, idle time.Duration) *conversationLog {
+	return &conversationLog{
+		max:   maxConversations,
+		idle:  idle,
+		now:   time.Now,
+		turns: make(map[string]conversationTurn),
+	}
+}
+
+// expired reports whether turn has been idle for longer than the log keeps
+// conversations.
+func (c *conversationLog) expired(turn conversationTurn, now time.Time) bool {
+	return c.idle > 0 && now.Sub(turn.seen) > c.idle
+}
+
+// store vaults content for the given conversation and key through write.
+// When content extends the previously stored turn, only the appended bytes
+// are written together with a pointer to the previous turn's reference.
+// The earlier objects of the chain are touched first, when touch is set, so
+// a retention sweep does not remove them while later turns still resolve
+// through them; if one is already gone the turn is stored in full.
+func (c *conversationLog) store(write func(object []byte) (string, error), touch func(ref string) error, conversationID, key string, content []byte) (string, error) {
+	id := conversationID + "\x00" + key
+	now := c.now()
+
+	c.mu.Lock()
+	prev, ok := c.turns[id]
+	c.mu.Unlock()
+	extends := ok && !c.expired(prev, now) &&
+		len(content) >= prev.size && sha256.Sum256(content[:prev.size]) == prev.hash
+	for i := 0; extends && touch != nil && i < len(prev.chain); i++ {
+		extends = touch(prev.chain[i]) == nil
+	}
+
+	object := content
+	var chain []string
+	if extends {
+		if len(content) == prev.size {
+			c.mu.Lock()
+			prev.seen = now
+			c.turns[id] = prev
+			c.mu.Unlock()
+			return prev.ref, nil
+		}
+		object = encodeDelta(prev.ref, content[prev.size:])
+		chain = slices.Clip(prev.chain)
+	}
+
+	ref, err := write(object)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, tracked := c.turns[id]; !tracked && c.max > 0 && len(c.turns) >= c.max {
+		// Forget idle conversations first; failing that, evict an
+		// arbitrary one. Its next turn is stored in full.
+		for k, turn := range c.turns {
+			if c.expired(turn, now) {
+				delete(c.turns, k)
+			}
+		}
+		if len(c.turns) >= c.max {
+			for k := range c.turns {
+				delete(c.turns, k)
+				break
+			}
+		}
+	}
+	c.turns[id] = conversationTurn{ref: ref, size: len(content), hash: sha256.Sum256(content), seen: now, chain: append(chain, ref)}
+	return ref, nil
+}
+
+func encodeDelta(prevRef string, tail []byte) []byte {
+	buf := make([]byte, 0, len(deltaMagic)+len(prevRef)+1+len(tail))
+	buf = append(buf, deltaMagic...)
+	buf = append(buf, prevRef...)
+	buf = append(buf, '\n')
+	return append(buf, tail...)
+}
+
+// ResolveConversation returns the full conversation history stored under ref,
+// following the chain of delta objects back to the first turn.
+func ResolveConversation(vault VaultRetriever, ref string) ([]byte, error) {
+	var tails [][]byte
+	for depth := 0; depth < maxChainDepth; depth++ {
+		data, err := vault.Retrieve(ref)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.HasPrefix(data, []byte(deltaMagic)) {
+			for i := len(tails) - 1; i >= 0; i-- {
+				data = append(data, tails[i]...)
+			}
+			return data, nil
+		}
+
+		header := data[len(deltaMagic):]
+		nl := bytes.IndexByte(header, '\n')
+		if nl < 0 {
+			return nil, fmt.Errorf("malformed conversation delta: %s", ref)
+		}
+		tails = append(tails, header[nl+1:])
+		ref = string(header[:nl])
+	}
+	return nil, fmt.Errorf("conversation chain too deep: %s", ref)
+}
@@ -0,0 +1,228 @@
+package promptvaultprocessor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+func TestConversationStoresDeltas(t *testing.T) {
+	tmpDir := t.TempDir()
+	vault, _ := NewFilesystemVault(tmpDir)
+	cfg := createDefaultConfig()
+	cfg.Vault.Conversation.Keys = []string{"gen_ai.input.messages"}
+	sink := new(consumertest.TracesSink)
+	proc := newTestProcessor(t, cfg, vault, sink)
+
+	var history string
+	var turns []string
+	for turn := 0; turn < 3; turn++ {
+		history += fmt.Sprintf(`{"role":"user","content":%q}`, strings.Repeat(fmt.Sprintf("turn %d ", turn), 200))
+		turns = append(turns, history)
+
+		td := ptrace.NewTraces()
+		span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+		span.Attributes().PutStr("gen_ai.conversation.id", "conv-1")
+		span.Attributes().PutStr("gen_ai.input.messages", history)
+		if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	var stored, full int64
+	for _, f := range vaultFiles(t, tmpDir) {
+		info, _ := os.Stat(f)
+		stored += info.Size()
+	}
+	for _, h := range turns {
+		full += int64(len(h))
+	}
+	// Storing every turn in full costs the sum of all histories; deltas
+	// should cost roughly the final history plus small headers.
+	if stored >= int64(len(turns[2]))+1024 {
+		t.Errorf("expected deltas to store about %d bytes, stored %d (full copies: %d)", len(turns[2]), stored, full)
+	}
+
+	for i, td := range sink.AllTraces() {
+		attrs := td.ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+		ref, ok := attrs.Get("gen_ai.input.messages.vault_ref")
+		if !ok {
+			t.Fatalf("turn %d: expected vault_ref attribute", i)
+		}
+		data, err := ResolveConversation(vault, ref.Str())
+		if err != nil {
+			t.Fatalf("turn %d: resolve failed: %v", i, err)
+		}
+		if string(data) != turns[i] {
+			t.Errorf("turn %d: reconstructed history does not match original", i)
+		}
+	}
+}
+
+func TestConversationRestartsOnDivergence(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	log := newConversationLog(10, 0)
+
+	ref1, _ := log.store(vault.Store, vault.Touch, "conv", "k", []byte("hello"))
+	ref2, _ := log.store(vault.Store, vault.Touch, "conv", "k", []byte("goodbye, world"))
+
+	data, err := ResolveConversation(vault, ref2)
+	if err != nil {
+		t.Fatalf("resolve failed: %v", err)
+	}
+	if string(data) != "goodbye, world" {
+		t.Errorf("expected full content after divergence, got %q", string(data))
+	}
+	if ref1 == ref2 {
+		t.Error("expected distinct refs for diverging turns")
+	}
+}
+
+func TestConversationTraceScopedSnapshots(t *testing.T) {
+	tmpDir := t.TempDir()
+	vault, _ := NewFilesystemVault(tmpDir)
+	cfg := createDefaultConfig()
+	cfg.Vault.Conversation.Keys = []string{"gen_ai.completion"}
+	cfg.Vault.Conversation.TraceScoped = true
+	cfg.Vault.Conversation.IdleTimeout = time.Minute
+	sink := new(consumertest.TracesSink)
+	proc := newTestProcessor(t, cfg, vault, sink)
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	proc.conversations.now = func() time.Time { return now }
+
+	// A streaming completion emitted as three spans, each carrying the
+	// text so far, arriving in separate batches.
+	traceID := pcommon.TraceID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	snapshots := []string{
+		strings.Repeat("Quantum computers use qubits. ", 20),
+		strings.Repeat("Quantum computers use qubits. ", 40),
+		strings.Repeat("Quantum computers use qubits. ", 60),
+	}
+	send := func(id pcommon.TraceID, completion string) {
+		td := ptrace.NewTraces()
+		span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+		span.SetTraceID(id)
+		span.Attributes().PutStr("gen_ai.completion", completion)
+		if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	for _, snapshot := range snapshots {
+		send(traceID, snapshot)
+	}
+
+	var full, deltas int
+	for _, f := range vaultFiles(t, tmpDir) {
+		data, _ := os.ReadFile(f)
+		if strings.HasPrefix(string(data), deltaMagic) {
+			deltas++
+		} else {
+			full++
+		}
+	}
+	if full != 1 || deltas != 2 {
+		t.Errorf("expected the first snapshot in full and two deltas, got %d full and %d deltas", full, deltas)
+	}
+	for i, td := range sink.AllTraces() {
+		ref, _ := td.ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes().Get("gen_ai.completion.vault_ref")
+		data, err := ResolveConversation(vault, ref.Str())
+		if err != nil || string(data) != snapshots[i] {
+			t.Errorf("snapshot %d: expected the full text back, got %d bytes (%v)", i, len(data), err)
+		}
+	}
+
+	// Once the trace has gone quiet it is treated as complete: a later
+	// span continuing the text is stored in full.
+	now = now.Add(2 * time.Minute)
+	send(traceID, snapshots[2]+"Done.")
+	if files := len(vaultFiles(t, tmpDir)); files != 4 {
+		t.Fatalf("expected a fourth object, got %d", files)
+	}
+	last := sink.AllTraces()[3].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	ref, _ := last.Get("gen_ai.completion.vault_ref")
+	if data, _ := vault.Retrieve(ref.Str()); strings.HasPrefix(string(data), deltaMagic) {
+		t.Error("expected an expired trace to start over with a full object")
+	}
+}
+
+func TestConversationStoresRetry(t *testing.T) {
+	fsVault, _ := NewFilesystemVault(t.TempDir())
+	vault := &flakyVault{FilesystemVault: fsVault, failures: 1}
+	cfg := createDefaultConfig()
+	cfg.Vault.Conversation.Keys = []string{"gen_ai.input.messages"}
+	cfg.Storage.Retry = RetryConfig{MaxAttempts: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	sink := new(consumertest.TracesSink)
+	proc := newTestProcessor(t, cfg, vault, sink)
+
+	td := ptrace.NewTraces()
+	span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+	span.Attributes().PutStr("gen_ai.conversation.id", "conv-1")
+	span.Attributes().PutStr("gen_ai.input.messages", `{"role":"user","content":"hello"}`)
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ref, ok := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes().Get("gen_ai.input.messages.vault_ref")
+	if !ok {
+		t.Fatal("expected the turn stored on retry")
+	}
+	if data, err := ResolveConversation(fsVault, ref.Str()); err != nil || string(data) != `{"role":"user","content":"hello"}` {
+		t.Errorf("unexpected content %q (%v)", data, err)
+	}
+	if n := vault.attempts.Load(); n != 2 {
+		t.Errorf("expected 2 attempts, got %d", n)
+	}
+}
+
+func TestConversationSurvivesSweep(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	vault, _ := NewFilesystemVault(t.TempDir(), WithClock(func() time.Time { return now }))
+	log := newConversationLog(10, 0)
+
+	history := `{"role":"user","content":"hello"}`
+	log.store(vault.Store, vault.Touch, "conv", "k", []byte(history))
+	var ref string
+	for day := 0; day < 3; day++ {
+		now = now.Add(5 * 24 * time.Hour)
+		history += fmt.Sprintf(`{"role":"assistant","content":"day %d"}`, day)
+		ref, _ = log.store(vault.Store, vault.Touch, "conv", "k", []byte(history))
+	}
+
+	// The first turn is 15 days old, but every later turn still resolves
+	// through it.
+	if removed, _, err := vault.Sweep(7 * 24 * time.Hour); err != nil || removed != 0 {
+		t.Fatalf("expected nothing swept, got %d (%v)", removed, err)
+	}
+	data, err := ResolveConversation(vault, ref)
+	if err != nil || string(data) != history {
+		t.Errorf("expected the full history, got %q (%v)", data, err)
+	}
+}
+
+func TestConversationRestartsWhenChainSwept(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	vault, _ := NewFilesystemVault(t.TempDir(), WithClock(func() time.Time { return now }))
+	log := newConversationLog(10, 0)
+
+	log.store(vault.Store, vault.Touch, "conv", "k", []byte("hello"))
+	now = now.Add(10 * 24 * time.Hour)
+	if removed, _, _ := vault.Sweep(7 * 24 * time.Hour); removed != 1 {
+		t.Fatalf("expected the first turn swept, got %d", removed)
+	}
+
+	ref, err := log.store(vault.Store, vault.Touch, "conv", "k", []byte("hello, world"))
+	if err != nil {
+		t.Fatalf("store failed: %v", err)
+	}
+	data, err := ResolveConversation(vault, ref)
+	if err != nil || string(data) != "hello, world" {
+		t.Errorf("expected the turn stored in full, got %q (%v)", data, err)
+	}
+}
@@ -0,0 +1,90 @@
+package promptvaultprocessor
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// encryptionKeySize is the AES-256 key length in bytes.
+const encryptionKeySize = 32
+
+// newAEAD returns AES-256-GCM for key.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != encryptionKeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", encryptionKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptionCanary is the content round-tripped by CheckEncryption.
+var encryptionCanary = []byte("promptvault encryption self-test")
+
+// CheckEncryption encrypts a canary into an envelope and decrypts it again
+// through the same path as stored objects, without writing anything. It
+// returns nil when the vault does not encrypt.
+func (v *FilesystemVault) CheckEncryption() error {
+	if v.aead == nil {
+		return nil
+	}
+	data, err := wrapEnvelope(encryptionCanary, len(encryptionCanary), contentTypeText, compressionNone, v.envelopeVersion, v.aead)
+	if err != nil {
+		return err
+	}
+	got, h, err := decodeEnvelope(data, v.aead)
+	if err != nil {
+		return err
+	}
+	if !h.Encrypted || !bytes.Equal(got, encryptionCanary) {
+		return errors.New("canary did not round-trip")
+	}
+	return nil
+}
+
+// LoadEncryptionKey returns the base64-encoded AES-256 key cfg holds in
+// Key or points at in the environment variable KeyEnv or the file KeyFile.
+func LoadEncryptionKey(cfg CryptoConfig) ([]byte, error) {
+	var encoded string
+	sources := 0
+	for _, source := range []string{cfg.Key, cfg.KeyEnv, cfg.KeyFile} {
+		if source != "" {
+			sources++
+		}
+	}
+	switch {
+	case sources > 1:
+		return nil, errors.New("crypto: set only one of key, key_env and key_file")
+	case cfg.Key != "":
+		encoded = cfg.Key
+	case cfg.KeyEnv != "":
+		var ok bool
+		if encoded, ok = os.LookupEnv(cfg.KeyEnv); !ok {
+			return nil, fmt.Errorf("crypto: environment variable %s is not set", cfg.KeyEnv)
+		}
+	case cfg.KeyFile != "":
+		data, err := os.ReadFile(cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("crypto: read key file: %w", err)
+		}
+		encoded = string(data)
+	default:
+		return nil, errors.New("crypto: enable requires key, key_env or key_file")
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("crypto: key is not base64: %w", err)
+	}
+	if len(key) != encryptionKeySize {
+		return nil, fmt.Errorf("crypto: key must be %d bytes, got %d", encryptionKeySize, len(key))
+	}
+	return key, nil
+}
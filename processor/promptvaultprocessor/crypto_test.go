@@ -0,0 +1,242 @@
+package promptvaultprocessor
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/collector/processor"
+	"go.opentelemetry.io/collector/processor/processortest"
+)
+
+var testEncryptionKey = bytes.Repeat([]byte{0x42}, encryptionKeySize)
+
+func TestFilesystemVaultEncryption(t *testing.T) {
+	dir := t.TempDir()
+	vault, err := NewFilesystemVault(dir, WithGzip(64), WithEncryption(testEncryptionKey))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	content := []byte(strings.Repeat("Tell me about quantum computing. ", 10))
+	ref, err := vault.Store(content)
+	if err != nil {
+		t.Fatalf("store failed: %v", err)
+	}
+	if got, err := vault.Retrieve(ref); err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("expected the encrypted object to round-trip, got %v", err)
+	}
+	if part, err := vault.RetrieveRange(ref, 5, 2); err != nil || string(part) != "me" {
+		t.Errorf("expected range %q, got %q (%v)", "me", part, err)
+	}
+
+	files := vaultFiles(t, dir)
+	if len(files) != 1 || !strings.HasSuffix(files[0], ".txt.pv") {
+		t.Fatalf("expected one .txt.pv object, got %v", files)
+	}
+	raw, _ := os.ReadFile(files[0])
+	if bytes.Contains(raw, []byte("quantum")) {
+		t.Error("expected the object not to contain plaintext")
+	}
+	if _, _, err := DecodeEnvelope(raw); err == nil {
+		t.Error("expected decoding without a key to fail")
+	}
+	got, h, err := DecodeEncryptedEnvelope(raw, testEncryptionKey)
+	if err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("expected the envelope to decrypt, got %v", err)
+	}
+	if !h.Encrypted || h.Encryption != encryptionAES256GCM || !h.Compressed {
+		t.Errorf("unexpected header %+v", h)
+	}
+
+	// Each object gets its own nonce: the same content encrypts differently.
+	other := t.TempDir()
+	otherVault, _ := NewFilesystemVault(other, WithGzip(64), WithEncryption(testEncryptionKey))
+	otherVault.Store(content)
+	otherRaw, _ := os.ReadFile(vaultFiles(t, other)[0])
+	if bytes.Equal(raw, otherRaw) {
+		t.Error("expected different ciphertexts for separately written objects")
+	}
+
+	// The header is authenticated with the ciphertext.
+	tampered := bytes.Clone(raw)
+	tampered[envelopeHeaderSize] = 'j' // first byte of the content type
+	if _, _, err := DecodeEncryptedEnvelope(tampered, testEncryptionKey); err == nil {
+		t.Error("expected a tampered header to fail authentication")
+	}
+	wrongKey := bytes.Repeat([]byte{0x24}, encryptionKeySize)
+	if _, _, err := DecodeEncryptedEnvelope(raw, wrongKey); err == nil {
+		t.Error("expected decryption with the wrong key to fail")
+	}
+}
+
+func TestFilesystemVaultEncryptionReadsPlainObjects(t *testing.T) {
+	dir := t.TempDir()
+	plain, _ := NewFilesystemVault(dir, WithEnvelope())
+	content := []byte("What is the capital of France?")
+	ref, _ := plain.Store(content)
+
+	vault, _ := NewFilesystemVault(dir, WithEncryption(testEncryptionKey))
+	if got, err := vault.Retrieve(ref); err != nil || !bytes.Equal(got, content) {
+		t.Errorf("expected the unencrypted object to resolve, got %v", err)
+	}
+}
+
+func TestFilesystemVaultEncryptionOptions(t *testing.T) {
+	if _, err := NewFilesystemVault(t.TempDir(), WithEncryption([]byte("short"))); err == nil {
+		t.Error("expected an error for a key that is not 32 bytes")
+	}
+	if _, err := NewFilesystemVault(t.TempDir(), WithEncryption(testEncryptionKey), WithEnvelopeVersion(1)); err == nil {
+		t.Error("expected an error encrypting with a version 1 envelope")
+	}
+}
+
+func TestLoadEncryptionKey(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString(testEncryptionKey)
+	t.Setenv("PROMPTVAULT_TEST_KEY", encoded)
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyFile, []byte(encoded+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	shortFile := filepath.Join(t.TempDir(), "short")
+	if err := os.WriteFile(shortFile, []byte(base64.StdEncoding.EncodeToString([]byte("short"))), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		cfg  CryptoConfig
+		ok   bool
+	}{
+		{name: "inline", cfg: CryptoConfig{Key: encoded}, ok: true},
+		{name: "env", cfg: CryptoConfig{KeyEnv: "PROMPTVAULT_TEST_KEY"}, ok: true},
+		{name: "file", cfg: CryptoConfig{KeyFile: keyFile}, ok: true},
+		{name: "unset env", cfg: CryptoConfig{KeyEnv: "PROMPTVAULT_UNSET_KEY"}},
+		{name: "missing file", cfg: CryptoConfig{KeyFile: filepath.Join(t.TempDir(), "missing")}},
+		{name: "short key", cfg: CryptoConfig{KeyFile: shortFile}},
+		{name: "not base64", cfg: CryptoConfig{Key: "not a key!"}},
+		{name: "env and file", cfg: CryptoConfig{KeyEnv: "PROMPTVAULT_TEST_KEY", KeyFile: keyFile}},
+		{name: "inline and env", cfg: CryptoConfig{Key: encoded, KeyEnv: "PROMPTVAULT_TEST_KEY"}},
+		{name: "neither", cfg: CryptoConfig{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := LoadEncryptionKey(tt.cfg)
+			if tt.ok && (err != nil || !bytes.Equal(key, testEncryptionKey)) {
+				t.Errorf("expected the key, got %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+// openFailingAEAD encrypts normally but fails every decryption.
+type openFailingAEAD struct{ cipher.AEAD }
+
+func (openFailingAEAD) Open([]byte, []byte, []byte, []byte) ([]byte, error) {
+	return nil, errors.New("message authentication failed")
+}
+
+func TestCryptoStartupSelfTest(t *testing.T) {
+	factory := NewFactory()
+	newProcessor := func(key []byte) (processor.Traces, error) {
+		cfg := factory.CreateDefaultConfig().(*Config)
+		cfg.Storage.Filesystem.BasePath = t.TempDir()
+		cfg.Crypto = CryptoConfig{Enable: true, Key: base64.StdEncoding.EncodeToString(key)}
+		return factory.CreateTracesProcessor(context.Background(), processortest.NewNopSettings(), cfg, consumertest.NewNop())
+	}
+
+	proc, err := newProcessor(testEncryptionKey)
+	if err != nil {
+		t.Fatalf("failed to create processor: %v", err)
+	}
+	if err := proc.Start(context.Background(), componenttest.NewNopHost()); err != nil {
+		t.Fatalf("expected startup to succeed with a valid key: %v", err)
+	}
+	proc.Shutdown(context.Background())
+
+	if _, err := newProcessor([]byte("sixteen byte key")); err == nil || !strings.Contains(err.Error(), "key must be 32 bytes, got 16") {
+		t.Errorf("expected a descriptive error for a 16-byte key, got %v", err)
+	}
+
+	vault, _ := NewFilesystemVault(t.TempDir(), WithEncryption(testEncryptionKey))
+	vault.aead = openFailingAEAD{vault.aead}
+	broken := newTestProcessor(t, createDefaultConfig(), vault, consumertest.NewNop())
+	if err := broken.Start(context.Background(), componenttest.NewNopHost()); err == nil || !strings.Contains(err.Error(), "crypto self-test failed") {
+		t.Errorf("expected startup to fail the self-test, got %v", err)
+	}
+}
+
+func TestCryptoPerKey(t *testing.T) {
+	dir := t.TempDir()
+	vault, _ := NewFilesystemVault(dir, WithEncryptionKey(testEncryptionKey))
+	cfg := createDefaultConfig()
+	cfg.Crypto = CryptoConfig{Enable: true, Keys: []string{"gen_ai.prompt"}}
+	sink := new(consumertest.TracesSink)
+	proc := newTestProcessor(t, cfg, vault, sink)
+
+	td := ptrace.NewTraces()
+	span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+	values := map[string]string{
+		"gen_ai.prompt":     "My account number is 12345678",
+		"gen_ai.completion": "Quantum computing uses qubits...",
+	}
+	for key, value := range values {
+		span.Attributes().PutStr(key, value)
+	}
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	attrs := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	for key, wantEncrypted := range map[string]bool{"gen_ai.prompt": true, "gen_ai.completion": false} {
+		ref, _ := attrs.Get(key + ".vault_ref")
+		path, err := vault.find(ref.Str())
+		if err != nil {
+			t.Fatalf("%s: %v", key, err)
+		}
+		if got := encryptedObject(path); got != wantEncrypted {
+			t.Errorf("%s: expected encrypted=%v, got %v", key, wantEncrypted, got)
+		}
+		if got, err := vault.Retrieve(ref.Str()); err != nil || string(got) != values[key] {
+			t.Errorf("%s: expected the content back, got %q (%v)", key, got, err)
+		}
+	}
+}
+
+func TestStoreEncryptedUpgradesPlaintext(t *testing.T) {
+	dir := t.TempDir()
+	vault, _ := NewFilesystemVault(dir, WithEncryptionKey(testEncryptionKey))
+	content := []byte("My account number is 12345678")
+
+	plainRef, _ := vault.Store(content)
+	ref, err := vault.StoreEncrypted(content, "")
+	if err != nil {
+		t.Fatalf("store failed: %v", err)
+	}
+	files := vaultFiles(t, dir)
+	if len(files) != 1 || !encryptedObject(files[0]) {
+		t.Fatalf("expected the plaintext object replaced by one encrypted object, got %v", files)
+	}
+	for _, r := range []string{plainRef, ref} {
+		if got, err := vault.Retrieve(r); err != nil || !bytes.Equal(got, content) {
+			t.Errorf("expected %s to resolve, got %q (%v)", r, got, err)
+		}
+	}
+
+	// A later plaintext store deduplicates to the encrypted object.
+	vault.Store(content)
+	if files := vaultFiles(t, dir); len(files) != 1 || !encryptedObject(files[0]) {
+		t.Errorf("expected the object to stay encrypted, got %v", files)
+	}
+}
@@ -0,0 +1,58 @@
+package promptvaultprocessor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+// traceDigestKey is the root span attribute holding a trace's digest.
+const traceDigestKey = "gen_ai.vault.trace_digest"
+
+// traceDigests collects the references produced for each trace in a batch.
+type traceDigests map[pcommon.TraceID]*traceDigest
+
+type traceDigest struct {
+	entries []string
+	target  ptrace.Span
+	hasRoot bool
+}
+
+// add records the references vaulted on span. The digest is written to the
+// trace's root span, or to its first span when the root is not in the batch.
+func (d traceDigests) add(span ptrace.Span, vaulted []vaultedAttr) {
+	td, ok := d[span.TraceID()]
+	if !ok {
+		td = &traceDigest{target: span}
+		d[span.TraceID()] = td
+	}
+	if !td.hasRoot && span.ParentSpanID().IsEmpty() {
+		td.target = span
+		td.hasRoot = true
+	}
+	spanID := span.SpanID()
+	for _, v := range vaulted {
+		td.entries = append(td.entries, hex.EncodeToString(spanID[:])+" "+v.key+" "+CanonicalRef(v.ref))
+	}
+}
+
+// stamp writes each trace's digest to its target span. The digest is the
+// SHA-256 of the sorted "<span id> <key> <ref>" entries, so it does not
+// depend on span order and changes whenever any vaulted content changes.
+func (d traceDigests) stamp() {
+	for _, td := range d {
+		if len(td.entries) == 0 {
+			continue
+		}
+		sort.Strings(td.entries)
+		h := sha256.New()
+		for _, e := range td.entries {
+			h.Write([]byte(e))
+			h.Write([]byte{'\n'})
+		}
+		td.target.Attributes().PutStr(traceDigestKey, "sha256:"+hex.EncodeToString(h.Sum(nil)))
+	}
+}
@@ -0,0 +1,88 @@
+package promptvaultprocessor
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+func TestVaultTraceDigest(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	cfg := createDefaultConfig()
+	cfg.Vault.TraceDigest = true
+	sink := new(consumertest.TracesSink)
+	proc := newTestProcessor(t, cfg, vault, sink)
+
+	traceID := pcommon.TraceID{1, 2, 3}
+	consume := func(childCompletion string) ptrace.SpanSlice {
+		td := ptrace.NewTraces()
+		spans := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans()
+
+		// Children first, so the root is not simply the first span.
+		for i, completion := range []string{"first answer", childCompletion} {
+			child := spans.AppendEmpty()
+			child.SetTraceID(traceID)
+			child.SetSpanID(pcommon.SpanID{byte(i + 2)})
+			child.SetParentSpanID(pcommon.SpanID{1})
+			child.Attributes().PutStr("gen_ai.completion", completion)
+		}
+		root := spans.AppendEmpty()
+		root.SetTraceID(traceID)
+		root.SetSpanID(pcommon.SpanID{1})
+		root.Attributes().PutStr("gen_ai.prompt", "the user question")
+
+		proc.ConsumeTraces(context.Background(), td)
+		traces := sink.AllTraces()
+		return traces[len(traces)-1].ResourceSpans().At(0).ScopeSpans().At(0).Spans()
+	}
+
+	digestOf := func(spans ptrace.SpanSlice) string {
+		for i := 0; i < 2; i++ {
+			if _, ok := spans.At(i).Attributes().Get(traceDigestKey); ok {
+				t.Errorf("expected no digest on child span %d", i)
+			}
+		}
+		v, ok := spans.At(2).Attributes().Get(traceDigestKey)
+		if !ok {
+			t.Fatal("expected digest on the root span")
+		}
+		return v.Str()
+	}
+
+	first := digestOf(consume("second answer"))
+	if !strings.HasPrefix(first, "sha256:") {
+		t.Errorf("unexpected digest format: %s", first)
+	}
+	if again := digestOf(consume("second answer")); again != first {
+		t.Errorf("expected deterministic digest, got %s and %s", first, again)
+	}
+	if changed := digestOf(consume("a different answer")); changed == first {
+		t.Error("expected digest to change when vaulted content changes")
+	}
+}
+
+func TestVaultTraceDigestBackendRefs(t *testing.T) {
+	cfg := createDefaultConfig()
+	cfg.Vault.TraceDigest = true
+	sink := new(consumertest.TracesSink)
+	proc := newTestProcessor(t, cfg, NewMemoryVault(), sink)
+
+	digest := func(prompt string) string {
+		td := ptrace.NewTraces()
+		span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+		span.SetTraceID(pcommon.TraceID{1})
+		span.SetSpanID(pcommon.SpanID{1})
+		span.Attributes().PutStr("gen_ai.prompt", prompt)
+		proc.ConsumeTraces(context.Background(), td)
+		traces := sink.AllTraces()
+		v, _ := traces[len(traces)-1].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes().Get(traceDigestKey)
+		return v.Str()
+	}
+	if digest("the user question") == digest("a different question") {
+		t.Error("expected digests over promptvault:// references to change with the content")
+	}
+}
@@ -0,0 +1,117 @@
+package promptvaultprocessor
+
+import (
+	"sort"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// attrStats aggregates the sizes of one attribute key.
+type attrStats struct {
+	count int64
+	bytes int64
+	max   int
+}
+
+func (s *attrStats) add(size int) {
+	s.count++
+	s.bytes += int64(size)
+	if size > s.max {
+		s.max = size
+	}
+}
+
+// dryRunReport accumulates what the processor would have done in dry-run
+// mode: which configured keys appeared, how much would have been offloaded,
+// and which large attributes are not configured at all.
+type dryRunReport struct {
+	largeValueBytes int
+
+	mu        sync.Mutex
+	matched   map[string]*attrStats
+	unmatched map[string]*attrStats
+	offload   attrStats
+}
+
+// newDryRunReport returns nil when dry-run is disabled.
+func newDryRunReport(cfg DryRunConfig) *dryRunReport {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &dryRunReport{
+		largeValueBytes: cfg.LargeValueBytes,
+		matched:         map[string]*attrStats{},
+		unmatched:       map[string]*attrStats{},
+	}
+}
+
+// observeMatched records a configured key seen in traffic.
+func (r *dryRunReport) observeMatched(key string, size int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	statsFor(r.matched, key).add(size)
+}
+
+// observeUnmatched records an unconfigured key if its value is large
+// enough to be worth adding.
+func (r *dryRunReport) observeUnmatched(key string, size int) {
+	if size < r.largeValueBytes {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	statsFor(r.unmatched, key).add(size)
+}
+
+// observeOffload records a value that would have been offloaded.
+func (r *dryRunReport) observeOffload(size int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.offload.add(size)
+}
+
+func statsFor(m map[string]*attrStats, key string) *attrStats {
+	s, ok := m[key]
+	if !ok {
+		s = &attrStats{}
+		m[key] = s
+	}
+	return s
+}
+
+// log writes the report accumulated so far as one summary line.
+func (r *dryRunReport) log(logger *zap.Logger) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	logger.Info("promptvault dry-run report",
+		zap.Int64("would_offload_values", r.offload.count),
+		zap.Int64("would_offload_bytes", r.offload.bytes),
+		zap.Objects("matched_keys", keyStatsList(r.matched)),
+		zap.Objects("unmatched_large_keys", keyStatsList(r.unmatched)),
+	)
+}
+
+// keyStats is the loggable form of one key's attrStats.
+type keyStats struct {
+	key string
+	attrStats
+}
+
+func (k keyStats) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("key", k.key)
+	enc.AddInt64("count", k.count)
+	enc.AddInt64("bytes", k.bytes)
+	enc.AddInt("max_bytes", k.max)
+	return nil
+}
+
+func keyStatsList(m map[string]*attrStats) []keyStats {
+	list := make([]keyStats, 0, len(m))
+	for key, s := range m {
+		list = append(list, keyStats{key: key, attrStats: *s})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].key < list[j].key })
+	return list
+}
@@ -0,0 +1,87 @@
+package promptvaultprocessor
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/otel/metric/noop"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestDryRunReport(t *testing.T) {
+	dir := t.TempDir()
+	vault, _ := NewFilesystemVault(dir)
+	sink := new(consumertest.TracesSink)
+	cfg := createDefaultConfig()
+	cfg.Vault.SizeThreshold = 64
+	cfg.DryRun.Enabled = true
+	cfg.DryRun.ReportInterval = time.Hour
+	cfg.DryRun.LargeValueBytes = 1024
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	set := component.TelemetrySettings{Logger: zap.New(core), MeterProvider: noop.NewMeterProvider()}
+	proc, err := newVaultProcessor(set, cfg, vault, sink)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := proc.Start(context.Background(), nil); err != nil {
+		t.Fatalf("unexpected start error: %v", err)
+	}
+
+	td := ptrace.NewTraces()
+	spans := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans()
+	spans.AppendEmpty().Attributes().PutStr("gen_ai.prompt", strings.Repeat("a", 100))
+	second := spans.AppendEmpty().Attributes()
+	second.PutStr("gen_ai.prompt", strings.Repeat("b", 2000))
+	second.PutStr("gen_ai.completion", strings.Repeat("c", 50))
+	third := spans.AppendEmpty().Attributes()
+	third.PutStr("llm.raw_request", strings.Repeat("d", 5000))
+	third.PutStr("http.method", "POST")
+
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans()
+	if v, _ := got.At(1).Attributes().Get("gen_ai.prompt"); v.Str() != strings.Repeat("b", 2000) {
+		t.Error("expected dry-run to leave attributes untouched")
+	}
+	if _, ok := got.At(1).Attributes().Get("gen_ai.prompt.vault_ref"); ok {
+		t.Error("expected dry-run not to add reference attributes")
+	}
+	if files := vaultFiles(t, dir); len(files) != 0 {
+		t.Errorf("expected dry-run not to write to the vault, found %d objects", len(files))
+	}
+
+	r := proc.dryRun
+	if s := r.matched["gen_ai.prompt"]; s == nil || s.count != 2 || s.bytes != 2100 || s.max != 2000 {
+		t.Errorf("unexpected gen_ai.prompt stats: %+v", s)
+	}
+	if s := r.matched["gen_ai.completion"]; s == nil || s.count != 1 || s.bytes != 50 {
+		t.Errorf("unexpected gen_ai.completion stats: %+v", s)
+	}
+	if r.offload.count != 2 || r.offload.bytes != 2100 {
+		t.Errorf("expected 2 values / 2100 bytes to be offloadable, got %d / %d", r.offload.count, r.offload.bytes)
+	}
+	if len(r.unmatched) != 1 || r.unmatched["llm.raw_request"] == nil {
+		t.Errorf("expected only llm.raw_request as an unmatched large key, got %v", r.unmatched)
+	}
+
+	if err := proc.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
+	entries := logs.FilterMessage("promptvault dry-run report").All()
+	if len(entries) != 1 {
+		t.Fatalf("expected a final report on shutdown, got %d", len(entries))
+	}
+	if bytes := entries[0].ContextMap()["would_offload_bytes"]; bytes != int64(2100) {
+		t.Errorf("expected would_offload_bytes 2100 in the report, got %v", bytes)
+	}
+}
@@ -0,0 +1,28 @@
+package promptvaultprocessor
+
+import "math"
+
+// byteEntropy estimates the information content of b as the Shannon entropy
+// of its byte distribution, in bits per byte: 0 for a run of one repeated
+// byte, about 4-5 for English prose and up to 8 for random data. It ignores
+// byte order, so it is a cheap lower bar for "trivial" values rather than a
+// measure of compressibility.
+func byteEntropy(b []byte) float64 {
+	if len(b) == 0 {
+		return 0
+	}
+	var counts [256]int
+	for _, c := range b {
+		counts[c]++
+	}
+	n := float64(len(b))
+	var h float64
+	for _, c := range counts {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / n
+		h -= p * math.Log2(p)
+	}
+	return h
+}
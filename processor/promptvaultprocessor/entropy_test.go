@@ -0,0 +1,57 @@
+package promptvaultprocessor
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+func TestByteEntropy(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+	}{
+		{"", 0},
+		{strings.Repeat("a", 100), 0},
+		{strings.Repeat("ab", 50), 1},
+		{"abcdefgh", 3},
+	}
+	for _, tt := range tests {
+		if got := byteEntropy([]byte(tt.in)); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("byteEntropy(%.10q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestVaultMinEntropy(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	sink := new(consumertest.TracesSink)
+	cfg := createDefaultConfig()
+	cfg.Vault.SizeThreshold = 100
+	cfg.Vault.MinEntropy = 2
+	proc := newTestProcessor(t, cfg, vault, sink)
+
+	prose := "The quick brown fox jumps over the lazy dog while seven wizards quietly judge the boxing match at dawn."
+	repeated := strings.Repeat("z", len(prose))
+
+	td := ptrace.NewTraces()
+	attrs := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty().Attributes()
+	attrs.PutStr("gen_ai.prompt", prose)
+	attrs.PutStr("gen_ai.completion", repeated)
+
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	if v, _ := got.Get("gen_ai.prompt"); !strings.HasPrefix(v.Str(), "vault://") {
+		t.Errorf("expected high-entropy text to be offloaded, got %.30s", v.Str())
+	}
+	if v, _ := got.Get("gen_ai.completion"); v.Str() != repeated {
+		t.Errorf("expected the repeated run to stay inline, got %.30s", v.Str())
+	}
+}
@@ -0,0 +1,257 @@
+package promptvaultprocessor
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Envelope layout, all integers big-endian:
+//
+//	magic        4 bytes  "PVOB"
+//	version      1 byte   envelope version (1 or 2)
+//	flags        1 byte   envelopeFlag*
+//	size         8 bytes  length of the original content
+//	type length  1 byte
+//	type         n bytes  original content type (text, json, binary, gzip)
+//
+// Version 2 adds provenance so objects can be interpreted long after the
+// processor that wrote them has been upgraded:
+//
+//	ref schema   1 byte   refSchemaVersion of the writer
+//	compression  1 byte   compression* algorithm ID
+//	encryption   1 byte   encryption* algorithm ID
+//	writer len   1 byte
+//	writer       n bytes  "promptvault/<version>"
+//
+// followed in both versions by the payload: the content, transformed as the
+// header says. Version 1 records the transformation in flags only; version
+// 2 in its algorithm IDs, mirrored in flags for simple tools. Encrypted
+// payloads (version 2 only) are a random nonce followed by the AES-256-GCM
+// ciphertext of the compressed content, authenticated together with the
+// header.
+var envelopeMagic = []byte("PVOB")
+
+const (
+	envelopeVersion    = 2
+	envelopeHeaderSize = 4 + 1 + 1 + 8 + 1
+)
+
+// refSchemaVersion identifies the reference format written by this
+// processor: vault://<sha256>.<ext>, optionally with #<scheme>=<digest>
+// integrity fragments such as #b2=<blake2b-256>.
+const refSchemaVersion = 1
+
+// ErrUnsupportedVersion is returned when decoding an envelope written in a
+// version newer (or older) than this processor understands.
+var ErrUnsupportedVersion = errors.New("unsupported envelope version")
+
+// Envelope flags.
+const (
+	envelopeFlagGzip      = 1 << 0
+	envelopeFlagEncrypted = 1 << 1
+)
+
+// Compression and encryption algorithm IDs recorded in version 2 envelopes.
+const (
+	compressionNone = 0
+	compressionGzip = 1
+	compressionZstd = 2
+
+	encryptionNone      = 0
+	encryptionAES256GCM = 1
+)
+
+// EnvelopeHeader describes an enveloped object.
+type EnvelopeHeader struct {
+	Version     uint8
+	Compressed  bool
+	Encrypted   bool
+	Size        uint64
+	ContentType string
+
+	// Version 2 and later.
+	RefSchema   uint8
+	Compression uint8
+	Encryption  uint8
+	Writer      string
+}
+
+// EncodeEnvelope wraps content in a self-describing envelope, gzipping the
+// payload when compress is set.
+func EncodeEnvelope(content []byte, contentType string, compress bool) ([]byte, error) {
+	payload := content
+	compression := byte(compressionNone)
+	if compress {
+		compressed, err := gzipBytes(content)
+		if err != nil {
+			return nil, fmt.Errorf("compress vault content: %w", err)
+		}
+		payload = compressed
+		compression = compressionGzip
+	}
+	return wrapEnvelope(payload, len(content), contentType, compression, envelopeVersion, nil)
+}
+
+// wrapEnvelope prefixes an already compressed payload with a header in the
+// given envelope version (1 to envelopeVersion), encrypting the payload
+// with aead unless it is nil.
+func wrapEnvelope(payload []byte, size int, contentType string, compression, envVersion byte, aead cipher.AEAD) ([]byte, error) {
+	if envVersion < 1 || envVersion > envelopeVersion {
+		return nil, fmt.Errorf("%w %d", ErrUnsupportedVersion, envVersion)
+	}
+	if aead != nil && envVersion < 2 {
+		return nil, fmt.Errorf("envelope version %d cannot record encryption", envVersion)
+	}
+	if compression == compressionZstd && envVersion < 2 {
+		return nil, fmt.Errorf("envelope version %d cannot record zstd compression", envVersion)
+	}
+	writer := "promptvault/" + version
+	if len(contentType) > 255 {
+		return nil, fmt.Errorf("content type %q too long for envelope", contentType)
+	}
+	if len(writer) > 255 {
+		return nil, fmt.Errorf("writer %q too long for envelope", writer)
+	}
+	var flags byte
+	if compression != compressionNone {
+		flags |= envelopeFlagGzip
+	}
+	encryption := byte(encryptionNone)
+	if aead != nil {
+		flags |= envelopeFlagEncrypted
+		encryption = encryptionAES256GCM
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, envelopeHeaderSize+len(contentType)+4+len(writer)+len(payload)))
+	buf.Write(envelopeMagic)
+	buf.WriteByte(envVersion)
+	buf.WriteByte(flags)
+	_ = binary.Write(buf, binary.BigEndian, uint64(size))
+	buf.WriteByte(byte(len(contentType)))
+	buf.WriteString(contentType)
+	if envVersion >= 2 {
+		buf.WriteByte(refSchemaVersion)
+		buf.WriteByte(compression)
+		buf.WriteByte(encryption)
+		buf.WriteByte(byte(len(writer)))
+		buf.WriteString(writer)
+	}
+	if aead == nil {
+		buf.Write(payload)
+		return buf.Bytes(), nil
+	}
+	header := buf.Bytes()
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(payload)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	return append(header, aead.Seal(nonce, nonce, payload, header)...), nil
+}
+
+// DecodeEnvelope parses an envelope and returns the original content. It
+// needs nothing but the object bytes, so tools can decode vault objects
+// without their reference. Envelopes of every earlier version decode;
+// encrypted ones need DecodeEncryptedEnvelope.
+func DecodeEnvelope(data []byte) ([]byte, EnvelopeHeader, error) {
+	return decodeEnvelope(data, nil)
+}
+
+// DecodeEncryptedEnvelope decodes an envelope like DecodeEnvelope,
+// decrypting it with the AES-256 key it was written with. Unencrypted
+// envelopes decode as well.
+func DecodeEncryptedEnvelope(data, key []byte) ([]byte, EnvelopeHeader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, EnvelopeHeader{}, err
+	}
+	return decodeEnvelope(data, aead)
+}
+
+func decodeEnvelope(data []byte, aead cipher.AEAD) ([]byte, EnvelopeHeader, error) {
+	var h EnvelopeHeader
+	if len(data) < envelopeHeaderSize || !bytes.Equal(data[:4], envelopeMagic) {
+		return nil, h, errors.New("not a vault envelope")
+	}
+	h.Version = data[4]
+	if h.Version < 1 || h.Version > envelopeVersion {
+		return nil, h, fmt.Errorf("%w %d", ErrUnsupportedVersion, h.Version)
+	}
+	flags := data[5]
+	h.Size = binary.BigEndian.Uint64(data[6:14])
+	rest := data[envelopeHeaderSize-1:]
+	var ok bool
+	if h.ContentType, rest, ok = readShortString(rest); !ok {
+		return nil, h, errors.New("truncated vault envelope")
+	}
+
+	switch h.Version {
+	case 1:
+		if flags&envelopeFlagGzip != 0 {
+			h.Compression = compressionGzip
+		}
+		if flags&envelopeFlagEncrypted != 0 {
+			h.Encryption = 1
+		}
+	default:
+		if len(rest) < 3 {
+			return nil, h, errors.New("truncated vault envelope")
+		}
+		h.RefSchema, h.Compression, h.Encryption = rest[0], rest[1], rest[2]
+		if h.Writer, rest, ok = readShortString(rest[3:]); !ok {
+			return nil, h, errors.New("truncated vault envelope")
+		}
+	}
+	h.Compressed = h.Compression != compressionNone
+	h.Encrypted = h.Encryption != encryptionNone
+
+	content := rest
+	switch h.Encryption {
+	case encryptionNone:
+	case encryptionAES256GCM:
+		if aead == nil {
+			return nil, h, errors.New("vault envelope is encrypted and no key is configured")
+		}
+		if len(rest) < aead.NonceSize() {
+			return nil, h, errors.New("truncated vault envelope")
+		}
+		header := data[:len(data)-len(rest)]
+		var err error
+		if content, err = aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], header); err != nil {
+			return nil, h, fmt.Errorf("decrypt vault envelope: %w", err)
+		}
+	default:
+		return nil, h, fmt.Errorf("unsupported envelope encryption %d", h.Encryption)
+	}
+	switch h.Compression {
+	case compressionNone:
+	case compressionGzip:
+		var err error
+		if content, err = gunzipBytes(content); err != nil {
+			return nil, h, err
+		}
+	case compressionZstd:
+		var err error
+		if content, err = unzstdBytes(content); err != nil {
+			return nil, h, err
+		}
+	default:
+		return nil, h, fmt.Errorf("unsupported envelope compression %d", h.Compression)
+	}
+	if uint64(len(content)) != h.Size {
+		return nil, h, fmt.Errorf("vault envelope size mismatch: header says %d bytes, got %d", h.Size, len(content))
+	}
+	return content, h, nil
+}
+
+// readShortString reads a one-byte length followed by that many bytes.
+func readShortString(b []byte) (s string, rest []byte, ok bool) {
+	if len(b) < 1 || len(b) < 1+int(b[0]) {
+		return "", nil, false
+	}
+	n := int(b[0])
+	return string(b[1 : 1+n]), b[1+n:], true
+}
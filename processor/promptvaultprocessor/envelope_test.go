@@ -0,0 +1,237 @@
+package promptvaultprocessor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	content := []byte(strings.Repeat(`{"role":"user","content":"hello"}`, 50))
+	for _, compress := range []bool{false, true} {
+		data, err := EncodeEnvelope(content, contentTypeJSON, compress)
+		if err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+		got, h, err := DecodeEnvelope(data)
+		if err != nil {
+			t.Fatalf("decode failed: %v", err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Errorf("compress=%v: round trip changed the content", compress)
+		}
+		if h.Compressed != compress || h.Size != uint64(len(content)) || h.ContentType != contentTypeJSON ||
+			h.Version != envelopeVersion || h.RefSchema != refSchemaVersion || h.Writer != "promptvault/"+version {
+			t.Errorf("compress=%v: unexpected header %+v", compress, h)
+		}
+	}
+
+	if _, _, err := DecodeEnvelope([]byte("plain text")); err == nil {
+		t.Error("expected an error decoding a non-envelope")
+	}
+	data, _ := EncodeEnvelope(content, contentTypeJSON, false)
+	if _, _, err := DecodeEnvelope(data[:len(data)-1]); err == nil {
+		t.Error("expected an error decoding a truncated envelope")
+	}
+}
+
+func TestFilesystemVaultEnvelope(t *testing.T) {
+	dir := t.TempDir()
+	vault, _ := NewFilesystemVault(dir, WithEnvelope(), WithGzip(64))
+	content := []byte(strings.Repeat("Tell me about quantum computing. ", 20))
+
+	ref, err := vault.Store(content)
+	if err != nil {
+		t.Fatalf("store failed: %v", err)
+	}
+	got, err := vault.Retrieve(ref)
+	if err != nil {
+		t.Fatalf("retrieve failed: %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("retrieved content does not match")
+	}
+	part, err := vault.RetrieveRange(ref, 5, 2)
+	if err != nil || string(part) != "me" {
+		t.Errorf("expected range %q, got %q (%v)", "me", part, err)
+	}
+
+	// The object decodes from its bytes alone, without the reference.
+	files := vaultFiles(t, dir)
+	if len(files) != 1 || !strings.HasSuffix(files[0], ".txt.pv") {
+		t.Fatalf("expected one .txt.pv object, got %v", files)
+	}
+	data, _ := os.ReadFile(files[0])
+	decoded, h, err := DecodeEnvelope(data)
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if !bytes.Equal(decoded, content) || !h.Compressed || h.ContentType != contentTypeText {
+		t.Errorf("unexpected decode result, header %+v", h)
+	}
+
+	if _, err := vault.Store(content); err != nil {
+		t.Fatalf("second store failed: %v", err)
+	}
+	if files := vaultFiles(t, dir); len(files) != 1 {
+		t.Errorf("expected the enveloped object to deduplicate, got %d objects", len(files))
+	}
+}
+
+// encodeEnvelopeV1 writes the version 1 layout, which recorded compression
+// in flags only and carried no provenance.
+func encodeEnvelopeV1(content []byte, contentType string) []byte {
+	payload, _ := gzipBytes(content)
+	var buf bytes.Buffer
+	buf.WriteString("PVOB")
+	buf.WriteByte(1)
+	buf.WriteByte(envelopeFlagGzip)
+	_ = binary.Write(&buf, binary.BigEndian, uint64(len(content)))
+	buf.WriteByte(byte(len(contentType)))
+	buf.WriteString(contentType)
+	buf.Write(payload)
+	return buf.Bytes()
+}
+
+func TestDecodeEnvelopeVersion1(t *testing.T) {
+	content := []byte("Tell me about quantum computing")
+	got, h, err := DecodeEnvelope(encodeEnvelopeV1(content, contentTypeText))
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if !bytes.Equal(got, content) || h.Version != 1 || !h.Compressed || h.Writer != "" {
+		t.Errorf("unexpected v1 decode: %q, header %+v", got, h)
+	}
+
+	// A vault upgraded to version 2 envelopes still reads v1 objects.
+	dir := t.TempDir()
+	vault, _ := NewFilesystemVault(dir, WithEnvelope())
+	ref, _ := vault.Store(content)
+	files := vaultFiles(t, dir)
+	if err := os.WriteFile(files[0], encodeEnvelopeV1(content, contentTypeText), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	data, err := vault.Retrieve(ref)
+	if err != nil || !bytes.Equal(data, content) {
+		t.Errorf("expected the v1 object to resolve, got %q (%v)", data, err)
+	}
+}
+
+func TestDecodeEnvelopeRejectsUnknownAlgorithms(t *testing.T) {
+	data, _ := EncodeEnvelope([]byte("hello"), contentTypeText, false)
+	// The compression ID follows the fixed header, the type and the schema.
+	data[envelopeHeaderSize+len(contentTypeText)+1] = 9
+	if _, _, err := DecodeEnvelope(data); err == nil {
+		t.Error("expected an error for an unknown compression algorithm")
+	}
+}
+
+func TestFilesystemVaultPinnedEnvelopeVersion(t *testing.T) {
+	dir := t.TempDir()
+	vault, err := NewFilesystemVault(dir, WithGzip(1), WithEnvelopeVersion(1))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	content := []byte(strings.Repeat("Tell me about quantum computing. ", 10))
+	ref, err := vault.Store(content)
+	if err != nil {
+		t.Fatalf("store failed: %v", err)
+	}
+
+	files := vaultFiles(t, dir)
+	raw, _ := os.ReadFile(files[0])
+	got, h, err := DecodeEnvelope(raw)
+	if err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("expected a decodable envelope, got %v", err)
+	}
+	if h.Version != 1 || !h.Compressed || h.Writer != "" {
+		t.Errorf("expected a compressed version 1 envelope without provenance, got %+v", h)
+	}
+	if data, err := vault.Retrieve(ref); err != nil || !bytes.Equal(data, content) {
+		t.Errorf("expected the pinned object to resolve, got %v", err)
+	}
+
+	if _, err := NewFilesystemVault(t.TempDir(), WithEnvelopeVersion(envelopeVersion+1)); err == nil {
+		t.Error("expected an error pinning a version this processor cannot write")
+	}
+}
+
+func TestDecodeEnvelopeRejectsNewerVersion(t *testing.T) {
+	dir := t.TempDir()
+	vault, _ := NewFilesystemVault(dir, WithEnvelope())
+	content := []byte("Tell me about quantum computing")
+	ref, _ := vault.Store(content)
+
+	files := vaultFiles(t, dir)
+	raw, _ := os.ReadFile(files[0])
+	raw[4] = envelopeVersion + 1
+	if _, _, err := DecodeEnvelope(raw); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("expected ErrUnsupportedVersion decoding a newer envelope, got %v", err)
+	}
+	if err := os.WriteFile(files[0], raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vault.Retrieve(ref); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("expected ErrUnsupportedVersion retrieving a newer envelope, got %v", err)
+	}
+}
+
+func TestEnvelopeVersionsAndFlags(t *testing.T) {
+	content := []byte(strings.Repeat("Tell me about quantum computing. ", 10))
+	gz, _ := gzipBytes(content)
+	for _, envVersion := range []byte{1, 2} {
+		for _, compression := range []byte{compressionNone, compressionGzip} {
+			payload := content
+			if compression == compressionGzip {
+				payload = gz
+			}
+			data, err := wrapEnvelope(payload, len(content), contentTypeText, compression, envVersion, nil)
+			if err != nil {
+				t.Fatalf("v%d compression %d: wrap failed: %v", envVersion, compression, err)
+			}
+			if got := data[5]&envelopeFlagGzip != 0; got != (compression == compressionGzip) {
+				t.Errorf("v%d compression %d: gzip flag %v", envVersion, compression, got)
+			}
+			got, h, err := DecodeEnvelope(data)
+			if err != nil || !bytes.Equal(got, content) {
+				t.Fatalf("v%d compression %d: decode failed: %v", envVersion, compression, err)
+			}
+			if h.Version != envVersion || h.Compression != compression || h.Encrypted {
+				t.Errorf("v%d compression %d: unexpected header %+v", envVersion, compression, h)
+			}
+		}
+	}
+
+	// The encrypted flag is reserved: such objects are refused, not
+	// returned as if they were plaintext.
+	data, _ := wrapEnvelope(content, len(content), contentTypeText, compressionNone, 1, nil)
+	data[5] |= envelopeFlagEncrypted
+	if _, _, err := DecodeEnvelope(data); err == nil {
+		t.Error("expected an error decoding an encrypted envelope")
+	}
+}
+
+func TestFilesystemVaultEnvelopeReadsLegacyObjects(t *testing.T) {
+	dir := t.TempDir()
+	legacy, _ := NewFilesystemVault(dir, WithGzip(64))
+	small := []byte("What is the capital of France?")
+	large := []byte(strings.Repeat("Tell me about quantum computing. ", 10))
+	smallRef, _ := legacy.Store(small)
+	largeRef, _ := legacy.Store(large)
+
+	vault, _ := NewFilesystemVault(dir, WithEnvelope(), WithGzip(64))
+	for ref, want := range map[string][]byte{smallRef: small, largeRef: large} {
+		if got, err := vault.Retrieve(ref); err != nil || !bytes.Equal(got, want) {
+			t.Errorf("expected legacy object %s to resolve, got %v", ref, err)
+		}
+	}
+	if _, err := vault.Store(small); err != nil {
+		t.Fatalf("store failed: %v", err)
+	}
+	if files := vaultFiles(t, dir); len(files) != 2 {
+		t.Errorf("expected the raw object to deduplicate, got %v", files)
+	}
+}
@@ -2,12 +2,17 @@ package promptvaultprocessor
 
 import (
 	"context"
+	"fmt"
 
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/consumer"
 	"go.opentelemetry.io/collector/processor"
 )
 
+// version of the processor, recorded in enveloped objects. Release builds
+// set it with -ldflags "-X <package>.version=<version>".
+var version = "0.1.0"
+
 const (
 	typeStr   = "promptvault"
 	stability = component.StabilityLevelAlpha
@@ -19,21 +24,102 @@ func NewFactory() processor.Factory {
 		component.MustNewType(typeStr),
 		func() component.Config { return createDefaultConfig() },
 		processor.WithTraces(createTracesProcessor, stability),
+		processor.WithLogs(createLogsProcessor, stability),
+		processor.WithMetrics(createMetricsProcessor, stability),
 	)
 }
 
 func createTracesProcessor(
-	ctx context.Context,
+	_ context.Context,
 	set processor.Settings,
 	cfg component.Config,
 	nextConsumer consumer.Traces,
 ) (processor.Traces, error) {
 	pCfg := cfg.(*Config)
+	vault, err := createVault(pCfg)
+	if err != nil {
+		return nil, err
+	}
+	return newVaultProcessor(set.TelemetrySettings, pCfg, vault, nextConsumer)
+}
+
+func createLogsProcessor(
+	_ context.Context,
+	set processor.Settings,
+	cfg component.Config,
+	nextConsumer consumer.Logs,
+) (processor.Logs, error) {
+	pCfg := cfg.(*Config)
+	vault, err := createVault(pCfg)
+	if err != nil {
+		return nil, err
+	}
+	return newLogsProcessor(set.TelemetrySettings, pCfg, vault, nextConsumer)
+}
 
-	vault, err := NewFilesystemVault(pCfg.Storage.Filesystem.BasePath)
+func createMetricsProcessor(
+	_ context.Context,
+	set processor.Settings,
+	cfg component.Config,
+	nextConsumer consumer.Metrics,
+) (processor.Metrics, error) {
+	pCfg := cfg.(*Config)
+	vault, err := createVault(pCfg)
 	if err != nil {
 		return nil, err
 	}
+	return newMetricsProcessor(set.TelemetrySettings, pCfg, vault, nextConsumer)
+}
+
+// createVault builds the storage backend cfg selects.
+func createVault(pCfg *Config) (VaultStorage, error) {
+	switch pCfg.Storage.Backend {
+	case "kafka":
+		return NewKafkaVault(pCfg.Storage.Kafka)
+	case "s3":
+		return NewS3Vault(pCfg.Storage.S3)
+	case "gcs":
+		return NewGCSVault(pCfg.Storage.GCS)
+	case "memory":
+		return NewMemoryVault(), nil
+	case "", "filesystem":
+	default:
+		return nil, fmt.Errorf("unsupported storage backend %q", pCfg.Storage.Backend)
+	}
+
+	var opts []FilesystemOption
+	if len(pCfg.Storage.Filesystem.BasePaths) > 0 {
+		opts = append(opts, WithBasePaths(pCfg.Storage.Filesystem.BasePaths...))
+	}
+	switch pCfg.Storage.Filesystem.Compression {
+	case "gzip":
+		opts = append(opts, WithGzip(pCfg.Storage.Filesystem.CompressMinSize))
+	case "zstd":
+		opts = append(opts, WithZstd(pCfg.Storage.Filesystem.CompressMinSize))
+	}
+	if pCfg.Storage.Filesystem.Envelope {
+		opts = append(opts, WithEnvelope())
+	}
+	if v := pCfg.Storage.Filesystem.EnvelopeVersion; v != 0 {
+		opts = append(opts, WithEnvelopeVersion(v))
+	}
+	if pCfg.Storage.Filesystem.SecondaryChecksum {
+		opts = append(opts, WithSecondaryChecksum())
+	}
+	if pCfg.Crypto.Enable {
+		key, err := LoadEncryptionKey(pCfg.Crypto)
+		if err != nil {
+			return nil, err
+		}
+		if len(pCfg.Crypto.Keys) > 0 {
+			opts = append(opts, WithEncryptionKey(key))
+		} else {
+			opts = append(opts, WithEncryption(key))
+		}
+	}
+	if n := pCfg.Storage.Filesystem.CollisionCheckMaxSize; n > 0 {
+		opts = append(opts, WithCollisionCheck(n))
+	}
 
-	return newVaultProcessor(set.Logger, pCfg, vault, nextConsumer), nil
-}
\ No newline at end of file
+	return NewFilesystemVault(pCfg.Storage.Filesystem.BasePath, opts...)
+}
@@ -0,0 +1,186 @@
+package promptvaultprocessor
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// gcsRefPrefix starts every reference produced by a GCSVault; the bucket
+// and object name follow.
+const gcsRefPrefix = backendRefScheme + "gcs/"
+
+// errGCSNotFound is returned by a GCSClient Get that finds no object.
+var errGCSNotFound = errors.New("no such object")
+
+// GCSClient writes and reads objects in a single bucket.
+type GCSClient interface {
+	Put(ctx context.Context, name string, body []byte, contentType string) error
+	Get(ctx context.Context, name string) ([]byte, error)
+	Close() error
+}
+
+// GCSVault stores content as objects in a Google Cloud Storage bucket,
+// named <prefix><sha256>.<ext> like filesystem objects, so identical
+// content is written to the same object.
+type GCSVault struct {
+	client  GCSClient
+	bucket  string
+	prefix  string
+	timeout time.Duration
+}
+
+// NewGCSVault creates a GCSVault for the bucket in cfg, authenticating with
+// Application Default Credentials unless cfg names a credentials file.
+// Access tokens, including workload identity ones, are refreshed by the
+// client before they expire.
+func NewGCSVault(cfg GCSConfig) (*GCSVault, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("gcs backend requires a bucket")
+	}
+	client, err := newCloudGCSClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newGCSVault(client, cfg.Bucket, cfg.Prefix, cfg.Timeout), nil
+}
+
+func newGCSVault(client GCSClient, bucket, prefix string, timeout time.Duration) *GCSVault {
+	return &GCSVault{client: client, bucket: bucket, prefix: prefix, timeout: timeout}
+}
+
+// Store writes content under its checksum and returns a reference of the
+// form promptvault://gcs/<bucket>/<prefix><sha256>.<ext>.
+func (v *GCSVault) Store(content []byte) (string, error) {
+	return v.StoreContext(context.Background(), content)
+}
+
+// StoreContext is Store bounded by ctx as well as the configured timeout.
+func (v *GCSVault) StoreContext(ctx context.Context, content []byte) (string, error) {
+	name := v.objectName(content)
+	ctx, cancel := v.context(ctx)
+	defer cancel()
+	if err := v.client.Put(ctx, name, content, mimeTypes[detectContentType(content)]); err != nil {
+		return "", fmt.Errorf("write gcs object %s/%s: %w", v.bucket, name, err)
+	}
+	return gcsRefPrefix + v.bucket + "/" + name, nil
+}
+
+// PredictRef returns the reference Store will return for content. Objects
+// are named by their detected type, so contentType is not used.
+func (v *GCSVault) PredictRef(content []byte, _ string) (string, error) {
+	return gcsRefPrefix + v.bucket + "/" + v.objectName(content), nil
+}
+
+// objectName returns the name content is stored under.
+func (v *GCSVault) objectName(content []byte) string {
+	return fmt.Sprintf("%s%x.%s", v.prefix, sha256.Sum256(content), contentTypeExt[detectContentType(content)])
+}
+
+// RefScheme reports "promptvault", the scheme of backend references.
+func (v *GCSVault) RefScheme() string {
+	return strings.TrimSuffix(backendRefScheme, "://")
+}
+
+// Retrieve reads the object named in ref and verifies it against the
+// checksum in its name.
+func (v *GCSVault) Retrieve(ref string) ([]byte, error) {
+	bucket, name, ok := strings.Cut(strings.TrimPrefix(ref, gcsRefPrefix), "/")
+	if !strings.HasPrefix(ref, gcsRefPrefix) || !ok {
+		return nil, fmt.Errorf("not a gcs vault ref: %s", ref)
+	}
+	if bucket != v.bucket {
+		return nil, fmt.Errorf("vault ref %s is for bucket %s, not %s", ref, bucket, v.bucket)
+	}
+
+	ctx, cancel := v.context(context.Background())
+	defer cancel()
+	content, err := v.client.Get(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("vault ref not found: %s: %w", ref, err)
+	}
+	if !matchesKeyChecksum(name, content) {
+		return nil, fmt.Errorf("vault ref %s: object does not match its checksum", ref)
+	}
+	return content, nil
+}
+
+// Close releases the client.
+func (v *GCSVault) Close() error {
+	return v.client.Close()
+}
+
+func (v *GCSVault) context(parent context.Context) (context.Context, context.CancelFunc) {
+	if v.timeout > 0 {
+		return context.WithTimeout(parent, v.timeout)
+	}
+	return context.WithCancel(parent)
+}
+
+// cloudGCSClient implements GCSClient with the Cloud Storage client.
+type cloudGCSClient struct {
+	client *storage.Client
+	bucket *storage.BucketHandle
+}
+
+func newCloudGCSClient(cfg GCSConfig) (*cloudGCSClient, error) {
+	var opts []option.ClientOption
+	if cfg.CredentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(cfg.CredentialsFile))
+	}
+	return newCloudGCSClientWithOptions(cfg, opts...)
+}
+
+func newCloudGCSClientWithOptions(cfg GCSConfig, opts ...option.ClientOption) (*cloudGCSClient, error) {
+	client, err := storage.NewClient(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("create gcs client: %w", err)
+	}
+	return &cloudGCSClient{client: client, bucket: client.Bucket(cfg.Bucket)}, nil
+}
+
+func (c *cloudGCSClient) Put(ctx context.Context, name string, body []byte, contentType string) error {
+	w := c.bucket.Object(name).NewWriter(ctx)
+	w.ContentType = contentType
+	if _, err := w.Write(body); err != nil {
+		_ = w.Close()
+		return checkGCSCredentials(err)
+	}
+	return checkGCSCredentials(w.Close())
+}
+
+func (c *cloudGCSClient) Get(ctx context.Context, name string) ([]byte, error) {
+	r, err := c.bucket.Object(name).NewReader(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return nil, errGCSNotFound
+		}
+		return nil, checkGCSCredentials(err)
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// checkGCSCredentials marks err as ErrCredentialsExpired when Cloud Storage
+// rejected the request's access token; the client fetches a new one for
+// the next request.
+func checkGCSCredentials(err error) error {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusUnauthorized {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrCredentialsExpired, err)
+}
+
+func (c *cloudGCSClient) Close() error {
+	return c.client.Close()
+}
@@ -0,0 +1,172 @@
+package promptvaultprocessor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// fakeGCS is an in-memory GCSClient.
+type fakeGCS struct {
+	mu      sync.Mutex
+	objects map[string]string
+	closed  bool
+}
+
+func (g *fakeGCS) Put(_ context.Context, name string, body []byte, _ string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.objects == nil {
+		g.objects = map[string]string{}
+	}
+	g.objects[name] = string(body)
+	return nil
+}
+
+func (g *fakeGCS) Get(_ context.Context, name string) ([]byte, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	body, ok := g.objects[name]
+	if !ok {
+		return nil, errGCSNotFound
+	}
+	return []byte(body), nil
+}
+
+func (g *fakeGCS) Close() error {
+	g.closed = true
+	return nil
+}
+
+func TestGCSVaultStoreAndRetrieve(t *testing.T) {
+	client := &fakeGCS{}
+	vault := newGCSVault(client, "vault-bucket", "prompts/", 0)
+
+	content := []byte("Tell me about quantum computing")
+	ref, err := vault.Store(content)
+	if err != nil {
+		t.Fatalf("store failed: %v", err)
+	}
+	if !strings.HasPrefix(ref, "promptvault://gcs/vault-bucket/prompts/") || !strings.HasSuffix(ref, ".txt") {
+		t.Errorf("unexpected ref format: %s", ref)
+	}
+	name := strings.TrimPrefix(ref, "promptvault://gcs/vault-bucket/")
+	if client.objects[name] != string(content) {
+		t.Errorf("expected the object under %s, got %v", name, client.objects)
+	}
+
+	got, err := vault.Retrieve(ref)
+	if err != nil || string(got) != string(content) {
+		t.Fatalf("expected %q, got %q (%v)", content, got, err)
+	}
+	if _, err := vault.Retrieve("promptvault://gcs/other-bucket/" + name); err == nil {
+		t.Error("expected an error for a ref in another bucket")
+	}
+	if _, err := vault.Retrieve("promptvault://s3/vault-bucket/" + name); err == nil {
+		t.Error("expected an error for a ref of another backend")
+	}
+
+	client.objects[name] = "tampered"
+	if _, err := vault.Retrieve(ref); err == nil {
+		t.Error("expected an error for an object not matching its checksum")
+	}
+}
+
+func TestGCSVaultOffloadsAndCloses(t *testing.T) {
+	client := &fakeGCS{}
+	sink := new(consumertest.TracesSink)
+	proc := newTestProcessor(t, createDefaultConfig(), newGCSVault(client, "vault-bucket", "", 0), sink)
+
+	td := ptrace.NewTraces()
+	span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+	span.Attributes().PutStr("gen_ai.prompt", "Tell me about quantum computing")
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	attrs := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	if v, _ := attrs.Get("gen_ai.prompt"); !strings.HasPrefix(v.Str(), gcsRefPrefix) || len(client.objects) != 1 {
+		t.Errorf("expected the prompt offloaded to gcs, got %s", v.Str())
+	}
+
+	if err := proc.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
+	if !client.closed {
+		t.Error("expected Shutdown to close the gcs client")
+	}
+}
+
+// rotatingTokenServer is a Cloud Storage endpoint that accepts uploads
+// authorized with the most recently issued access token only.
+type rotatingTokenServer struct {
+	mu      sync.Mutex
+	issued  int
+	uploads map[string]int
+}
+
+func (s *rotatingTokenServer) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.issued++
+	// Short-lived, like workload identity tokens close to expiry.
+	return &oauth2.Token{AccessToken: fmt.Sprintf("token-%d", s.issued), TokenType: "Bearer", Expiry: time.Now().Add(time.Second)}, nil
+}
+
+func (s *rotatingTokenServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	io.Copy(io.Discard, r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token != fmt.Sprintf("token-%d", s.issued) {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, `{"error":{"code":401,"message":"Invalid Credentials"}}`)
+		return
+	}
+	s.uploads[token]++
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, `{"bucket":"vault-bucket","name":"object"}`)
+}
+
+func TestGCSVaultCredentialRotation(t *testing.T) {
+	server := &rotatingTokenServer{uploads: map[string]int{}}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+	client, err := newCloudGCSClientWithOptions(GCSConfig{Bucket: "vault-bucket"},
+		option.WithEndpoint(srv.URL+"/storage/v1/"), option.WithTokenSource(server))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer client.Close()
+	vault := newGCSVault(client, "vault-bucket", "", 0)
+
+	// Each store is authorized with a fresh token once the last expired.
+	for _, prompt := range []string{"Tell me about quantum computing", "Tell me about black holes"} {
+		if _, err := vault.Store([]byte(prompt)); err != nil {
+			t.Fatalf("store failed: %v", err)
+		}
+	}
+	if len(server.uploads) != 2 {
+		t.Errorf("expected uploads under two tokens, got %v", server.uploads)
+	}
+
+	// A token rejected before its expiry is reported as expired credentials.
+	if err := checkGCSCredentials(&googleapi.Error{Code: http.StatusUnauthorized}); !errors.Is(err, ErrCredentialsExpired) {
+		t.Errorf("expected a 401 reported as expired credentials, got %v", err)
+	}
+	if err := checkGCSCredentials(&googleapi.Error{Code: http.StatusForbidden}); errors.Is(err, ErrCredentialsExpired) {
+		t.Errorf("expected a 403 not to be a credential error, got %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package promptvaultprocessor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// IntegrityScheme adds a digest to references next to the SHA-256 content
+// address, for organizations that require a specific integrity scheme such
+// as a Merkle proof or a notarization receipt. The digest is recorded as
+// #<name>=<digest> and checked by the same scheme on retrieval.
+type IntegrityScheme interface {
+	// Name identifies the scheme in references. It must be unique, made of
+	// lowercase letters and digits, and not "field".
+	Name() string
+	// Digest returns the digest of content. It must not contain '#'.
+	Digest(content []byte) (string, error)
+	// Verify returns an error if content does not match digest.
+	Verify(content []byte, digest string) error
+}
+
+// blake2bIntegrity is the built-in BLAKE2b-256 scheme.
+type blake2bIntegrity struct{}
+
+// BLAKE2bIntegrity returns the built-in scheme recording a BLAKE2b-256
+// checksum as #b2=<hex>.
+func BLAKE2bIntegrity() IntegrityScheme { return blake2bIntegrity{} }
+
+func (blake2bIntegrity) Name() string { return "b2" }
+
+func (blake2bIntegrity) Digest(content []byte) (string, error) {
+	sum := blake2b.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (blake2bIntegrity) Verify(content []byte, digest string) error {
+	sum := blake2b.Sum256(content)
+	if !strings.EqualFold(digest, hex.EncodeToString(sum[:])) {
+		return errors.New("content does not match its BLAKE2b-256")
+	}
+	return nil
+}
+
+// validIntegrityName reports whether name may identify a scheme in
+// references.
+func validIntegrityName(name string) bool {
+	if name == "" || name == "field" {
+		return false
+	}
+	for _, r := range name {
+		if !('a' <= r && r <= 'z' || '0' <= r && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// withIntegrity appends the digest of every configured scheme to ref.
+func (v *FilesystemVault) withIntegrity(ref string, content []byte) (string, error) {
+	if ref == "" {
+		return ref, nil
+	}
+	for _, scheme := range v.integrity {
+		digest, err := scheme.Digest(content)
+		if err != nil {
+			return "", fmt.Errorf("%s digest: %w", scheme.Name(), err)
+		}
+		if strings.Contains(digest, refFragmentSep) {
+			return "", fmt.Errorf("%s digest contains %q", scheme.Name(), refFragmentSep)
+		}
+		ref += refFragmentSep + scheme.Name() + "=" + digest
+	}
+	return ref, nil
+}
+
+// verifyIntegrity checks content against the SHA-256 and every digest of a
+// reference that carries digests. References without digests are not
+// verified. A digest from a scheme the vault does not know fails, so
+// content is never returned as verified when it was not.
+func (v *FilesystemVault) verifyIntegrity(ref string, content []byte) error {
+	base, fragments, ok := strings.Cut(ref, refFragmentSep)
+	if !ok {
+		return nil
+	}
+	hexHash, _, _ := strings.Cut(refHash(base), "-") // disambiguated name
+	sha := sha256.Sum256(content)
+	if !strings.EqualFold(hexHash, hex.EncodeToString(sha[:])) {
+		return fmt.Errorf("vault ref %s: content does not match its SHA-256", ref)
+	}
+	for _, fragment := range strings.Split(fragments, refFragmentSep) {
+		name, digest, _ := strings.Cut(fragment, "=")
+		scheme := v.verifier(name)
+		if scheme == nil {
+			return fmt.Errorf("vault ref %s: unknown integrity scheme %q", ref, name)
+		}
+		if err := scheme.Verify(content, digest); err != nil {
+			return fmt.Errorf("vault ref %s: %w", ref, err)
+		}
+	}
+	return nil
+}
+
+// verifier returns the scheme named name: a configured one, or the
+// built-in BLAKE2b, which verifies even when not configured.
+func (v *FilesystemVault) verifier(name string) IntegrityScheme {
+	for _, scheme := range v.integrity {
+		if scheme.Name() == name {
+			return scheme
+		}
+	}
+	if name == "b2" {
+		return blake2bIntegrity{}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package promptvaultprocessor
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// sha512Integrity is a custom scheme appending a SHA-512 digest, counting
+// verifications.
+type sha512Integrity struct {
+	verified int
+}
+
+func (*sha512Integrity) Name() string { return "s512" }
+
+func (*sha512Integrity) Digest(content []byte) (string, error) {
+	sum := sha512.Sum512(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (s *sha512Integrity) Verify(content []byte, digest string) error {
+	s.verified++
+	sum := sha512.Sum512(content)
+	if digest != hex.EncodeToString(sum[:]) {
+		return errors.New("content does not match its SHA-512")
+	}
+	return nil
+}
+
+func TestVaultCustomIntegrity(t *testing.T) {
+	dir := t.TempDir()
+	scheme := &sha512Integrity{}
+	vault, err := NewFilesystemVault(dir, WithSecondaryChecksum(), WithIntegrity(scheme))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	content := []byte("Tell me about quantum computing")
+
+	ref, err := vault.Store(content)
+	if err != nil {
+		t.Fatalf("store failed: %v", err)
+	}
+	s512, _ := scheme.Digest(content)
+	if !strings.Contains(ref, "#b2=") || !strings.HasSuffix(ref, "#s512="+s512) {
+		t.Fatalf("expected both digests in the reference, got %s", ref)
+	}
+
+	data, err := vault.Retrieve(ref)
+	if err != nil || !bytes.Equal(data, content) {
+		t.Fatalf("expected a verified round trip, got %q (%v)", data, err)
+	}
+	if scheme.verified != 1 {
+		t.Errorf("expected retrieval to verify through the plugin once, got %d", scheme.verified)
+	}
+
+	forged := strings.TrimSuffix(ref, s512) + fmt.Sprintf("%0128x", 0)
+	if _, err := vault.Retrieve(forged); err == nil || !strings.Contains(err.Error(), "SHA-512") {
+		t.Errorf("expected the plugin to reject a forged digest, got %v", err)
+	}
+
+	// A vault without the plugin cannot verify its digest and refuses.
+	plain, _ := NewFilesystemVault(dir)
+	if _, err := plain.Retrieve(ref); err == nil || !strings.Contains(err.Error(), `unknown integrity scheme "s512"`) {
+		t.Errorf("expected an unknown scheme error, got %v", err)
+	}
+}
+
+func TestVaultIntegrityNames(t *testing.T) {
+	for _, schemes := range [][]IntegrityScheme{
+		{&sha512Integrity{}, &sha512Integrity{}},
+		{BLAKE2bIntegrity(), BLAKE2bIntegrity()},
+	} {
+		if _, err := NewFilesystemVault(t.TempDir(), WithIntegrity(schemes...)); err == nil {
+			t.Errorf("expected duplicate scheme names to be rejected")
+		}
+	}
+}
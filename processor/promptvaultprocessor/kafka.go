@@ -0,0 +1,379 @@
+package promptvaultprocessor
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaRefPrefix starts every reference produced by a KafkaVault; the topic
+// and checksum follow.
+const kafkaRefPrefix = backendRefScheme + "kafka/"
+
+// errKafkaNotFound is returned by a KafkaClient lookup that finds no
+// message for the key.
+var errKafkaNotFound = errors.New("no message for key")
+
+// KafkaClient produces and looks up keyed messages on a single topic.
+type KafkaClient interface {
+	Produce(ctx context.Context, key, value []byte) error
+	Lookup(ctx context.Context, key []byte) ([]byte, error)
+	Close() error
+}
+
+// KafkaVault stores content as messages on a Kafka topic keyed by the
+// content's SHA-256, so downstream consumers can ingest vaulted content
+// directly. The topic should be compacted: identical content is produced
+// under the same key and compaction keeps one copy.
+type KafkaVault struct {
+	client  KafkaClient
+	topic   string
+	timeout time.Duration
+}
+
+// NewKafkaVault connects a KafkaVault to the brokers in cfg.
+func NewKafkaVault(cfg KafkaConfig) (*KafkaVault, error) {
+	if len(cfg.Brokers) == 0 || cfg.Topic == "" {
+		return nil, errors.New("kafka backend requires brokers and a topic")
+	}
+	return newKafkaVault(newKafkaGoClient(cfg), cfg.Topic, cfg.Timeout), nil
+}
+
+func newKafkaVault(client KafkaClient, topic string, timeout time.Duration) *KafkaVault {
+	return &KafkaVault{client: client, topic: topic, timeout: timeout}
+}
+
+// Store produces content keyed by its checksum and returns a reference of
+// the form promptvault://kafka/<topic>/<sha256>.
+func (v *KafkaVault) Store(content []byte) (string, error) {
+	return v.StoreContext(context.Background(), content)
+}
+
+// StoreContext is Store bounded by ctx as well as the configured timeout.
+func (v *KafkaVault) StoreContext(ctx context.Context, content []byte) (string, error) {
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+
+	ctx, cancel := v.context(ctx)
+	defer cancel()
+	if err := v.client.Produce(ctx, []byte(checksum), content); err != nil {
+		return "", fmt.Errorf("produce to kafka topic %s: %w", v.topic, err)
+	}
+	return kafkaRefPrefix + v.topic + "/" + checksum, nil
+}
+
+// PredictRef returns the reference Store will return for content.
+func (v *KafkaVault) PredictRef(content []byte, _ string) (string, error) {
+	sum := sha256.Sum256(content)
+	return kafkaRefPrefix + v.topic + "/" + hex.EncodeToString(sum[:]), nil
+}
+
+// RefScheme reports "promptvault", the scheme of backend references.
+func (v *KafkaVault) RefScheme() string {
+	return strings.TrimSuffix(backendRefScheme, "://")
+}
+
+// Retrieve looks the checksum in ref up on the topic and verifies the
+// message against it.
+func (v *KafkaVault) Retrieve(ref string) ([]byte, error) {
+	topic, checksum, ok := strings.Cut(strings.TrimPrefix(ref, kafkaRefPrefix), "/")
+	if !strings.HasPrefix(ref, kafkaRefPrefix) || !ok {
+		return nil, fmt.Errorf("not a kafka vault ref: %s", ref)
+	}
+	if topic != v.topic {
+		return nil, fmt.Errorf("vault ref %s is for topic %s, not %s", ref, topic, v.topic)
+	}
+
+	ctx, cancel := v.context(context.Background())
+	defer cancel()
+	content, err := v.client.Lookup(ctx, []byte(checksum))
+	if err != nil {
+		return nil, fmt.Errorf("vault ref not found: %s: %w", ref, err)
+	}
+	sum := sha256.Sum256(content)
+	if hex.EncodeToString(sum[:]) != checksum {
+		return nil, fmt.Errorf("vault ref %s: message does not match its checksum", ref)
+	}
+	return content, nil
+}
+
+// Close releases the producer.
+func (v *KafkaVault) Close() error {
+	return v.client.Close()
+}
+
+func (v *KafkaVault) context(parent context.Context) (context.Context, context.CancelFunc) {
+	if v.timeout > 0 {
+		return context.WithTimeout(parent, v.timeout)
+	}
+	return context.WithCancel(parent)
+}
+
+// kafkaGoClient implements KafkaClient with segmentio/kafka-go. Messages are
+// partitioned by key hash, so a lookup only reads the key's partition, and
+// only the part of it no earlier lookup has read (see kafkaKeyIndex).
+type kafkaGoClient struct {
+	brokers []string
+	topic   string
+	writer  *kafka.Writer
+	index   *kafkaKeyIndex
+}
+
+func newKafkaGoClient(cfg KafkaConfig) *kafkaGoClient {
+	return &kafkaGoClient{
+		brokers: cfg.Brokers,
+		topic:   cfg.Topic,
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(cfg.Brokers...),
+			Topic:        cfg.Topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			MaxAttempts:  cfg.MaxAttempts,
+		},
+		index: newKafkaKeyIndex(cfg.LookupIndexSize),
+	}
+}
+
+func (c *kafkaGoClient) Produce(ctx context.Context, key, value []byte) error {
+	return c.writer.WriteMessages(ctx, kafka.Message{Key: key, Value: value})
+}
+
+// Lookup returns the latest message with key from the key's partition.
+func (c *kafkaGoClient) Lookup(ctx context.Context, key []byte) ([]byte, error) {
+	partition, err := c.partitionFor(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := kafka.DialLeader(ctx, "tcp", c.brokers[0], c.topic, partition)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	return lookupPartition(kafkaConnReader{conn}, c.index, partition, key)
+}
+
+// partitionFor returns the partition the writer's hash balancer assigns to
+// key, balancing over the partition IDs in the topic's metadata.
+func (c *kafkaGoClient) partitionFor(ctx context.Context, key []byte) (int, error) {
+	conn, err := kafka.DialContext(ctx, "tcp", c.brokers[0])
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	partitions, err := conn.ReadPartitions(c.topic)
+	if err != nil {
+		return 0, err
+	}
+	if len(partitions) == 0 {
+		return 0, fmt.Errorf("kafka topic %s has no partitions", c.topic)
+	}
+	return kafkaPartition(key, partitions), nil
+}
+
+// kafkaPartition returns the partition ID the writer's hash balancer
+// assigns to key. The balancer picks a position in the topic's partitions
+// in ascending ID order; the writer lists them as 0..n-1, which for a Kafka
+// topic are its metadata IDs.
+func kafkaPartition(key []byte, partitions []kafka.Partition) int {
+	ids := make([]int, len(partitions))
+	for i, p := range partitions {
+		ids[i] = p.ID
+	}
+	sort.Ints(ids)
+	return ids[(&kafka.Hash{}).Balance(kafka.Message{Key: key}, ids...)]
+}
+
+func (c *kafkaGoClient) Close() error {
+	return c.writer.Close()
+}
+
+// kafkaPartitionReader reads the messages of one partition.
+type kafkaPartitionReader interface {
+	// ReadOffsets returns the first retained offset and the offset the
+	// next message will be written at.
+	ReadOffsets() (first, last int64, err error)
+	// ReadFrom calls fn with each message from offset on, in order, until
+	// fn returns false or no message is left.
+	ReadFrom(offset int64, fn func(kafka.Message) bool) error
+}
+
+// lookupPartition returns the value of the latest message with key in a
+// partition. A key indexed by an earlier lookup is read directly; otherwise
+// only the messages no earlier lookup has read are scanned, and indexed.
+func lookupPartition(r kafkaPartitionReader, index *kafkaKeyIndex, partition int, key []byte) ([]byte, error) {
+	first, last, err := r.ReadOffsets()
+	if err != nil {
+		return nil, err
+	}
+	if offset, ok := index.get(partition, key); ok && offset >= first {
+		var value []byte
+		err := r.ReadFrom(offset, func(msg kafka.Message) bool {
+			if msg.Offset == offset && bytes.Equal(msg.Key, key) {
+				value = msg.Value
+			}
+			return false
+		})
+		if err != nil {
+			return nil, err
+		}
+		if value != nil {
+			return value, nil
+		}
+		index.forget(partition, key)
+	}
+
+	var value []byte
+	if start := index.scanFrom(partition, first); start < last {
+		err = r.ReadFrom(start, func(msg kafka.Message) bool {
+			index.add(partition, msg.Key, msg.Offset)
+			if bytes.Equal(msg.Key, key) {
+				value = msg.Value
+			}
+			return msg.Offset+1 < last
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Messages before the index's window were read but are no longer
+	// indexed; only a key not found since needs them read again.
+	if floor := index.floor(partition); value == nil && floor > first {
+		err = r.ReadFrom(first, func(msg kafka.Message) bool {
+			if msg.Offset >= floor {
+				return false
+			}
+			if bytes.Equal(msg.Key, key) {
+				value = msg.Value
+			}
+			return msg.Offset+1 < floor
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if value == nil {
+		return nil, errKafkaNotFound
+	}
+	return value, nil
+}
+
+// kafkaConnReader reads a partition through a connection to its leader.
+type kafkaConnReader struct {
+	conn *kafka.Conn
+}
+
+func (r kafkaConnReader) ReadOffsets() (int64, int64, error) {
+	return r.conn.ReadOffsets()
+}
+
+func (r kafkaConnReader) ReadFrom(offset int64, fn func(kafka.Message) bool) error {
+	if _, err := r.conn.Seek(offset, kafka.SeekAbsolute); err != nil {
+		return err
+	}
+	for {
+		batch := r.conn.ReadBatch(1, 10<<20)
+		read := 0
+		for {
+			msg, err := batch.ReadMessage()
+			if err != nil {
+				break
+			}
+			read++
+			if !fn(msg) {
+				return batch.Close()
+			}
+		}
+		if err := batch.Close(); err != nil {
+			return err
+		}
+		if read == 0 {
+			return nil
+		}
+	}
+}
+
+// kafkaKeyIndex remembers, per partition, the offset of the latest message
+// seen for each key and how far the partition has been scanned, so lookups
+// read each message once. Once a partition holds max keys, its index starts
+// over from the message being added: keys before it are read again only by
+// lookups that miss the index.
+type kafkaKeyIndex struct {
+	mu         sync.Mutex
+	max        int
+	partitions map[int]*kafkaPartitionIndex
+}
+
+type kafkaPartitionIndex struct {
+	// The index holds every key in [floor, next); next is the first offset
+	// not scanned yet.
+	floor, next int64
+	offsets     map[string]int64
+}
+
+func newKafkaKeyIndex(max int) *kafkaKeyIndex {
+	return &kafkaKeyIndex{max: max, partitions: map[int]*kafkaPartitionIndex{}}
+}
+
+func (x *kafkaKeyIndex) partition(partition int) *kafkaPartitionIndex {
+	p, ok := x.partitions[partition]
+	if !ok {
+		p = &kafkaPartitionIndex{offsets: map[string]int64{}}
+		x.partitions[partition] = p
+	}
+	return p
+}
+
+func (x *kafkaKeyIndex) get(partition int, key []byte) (int64, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	offset, ok := x.partition(partition).offsets[string(key)]
+	return offset, ok
+}
+
+// scanFrom returns the offset a scan of partition starts at: the first one
+// not scanned yet, or first if that is later.
+func (x *kafkaKeyIndex) scanFrom(partition int, first int64) int64 {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return max(x.partition(partition).next, first)
+}
+
+// add records a scanned message.
+func (x *kafkaKeyIndex) add(partition int, key []byte, offset int64) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	p := x.partition(partition)
+	if _, ok := p.offsets[string(key)]; !ok && x.max > 0 && len(p.offsets) >= x.max {
+		p.floor, p.offsets = offset, map[string]int64{}
+	}
+	if offset >= p.offsets[string(key)] {
+		p.offsets[string(key)] = offset
+	}
+	p.next = max(p.next, offset+1)
+}
+
+// floor returns the first offset of partition the index covers.
+func (x *kafkaKeyIndex) floor(partition int) int64 {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.partition(partition).floor
+}
+
+// forget drops a key whose indexed message is gone, e.g. compacted.
+func (x *kafkaKeyIndex) forget(partition int, key []byte) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	delete(x.partition(partition).offsets, string(key))
+}
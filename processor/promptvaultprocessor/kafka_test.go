@@ -0,0 +1,238 @@
+package promptvaultprocessor
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+// fakeKafka is an in-memory KafkaClient recording produced messages.
+type fakeKafka struct {
+	mu       sync.Mutex
+	messages []fakeKafkaMessage
+	fail     error
+	closed   bool
+}
+
+type fakeKafkaMessage struct {
+	key, value string
+}
+
+func (k *fakeKafka) Produce(_ context.Context, key, value []byte) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.fail != nil {
+		return k.fail
+	}
+	k.messages = append(k.messages, fakeKafkaMessage{string(key), string(value)})
+	return nil
+}
+
+func (k *fakeKafka) Lookup(_ context.Context, key []byte) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, m := range k.messages {
+		if m.key == string(key) {
+			return []byte(m.value), nil
+		}
+	}
+	return nil, errKafkaNotFound
+}
+
+func (k *fakeKafka) Close() error {
+	k.closed = true
+	return nil
+}
+
+func TestKafkaVaultStoreAndRetrieve(t *testing.T) {
+	client := &fakeKafka{}
+	vault := newKafkaVault(client, "prompts", 0)
+
+	content := []byte("Tell me about quantum computing")
+	ref, err := vault.Store(content)
+	if err != nil {
+		t.Fatalf("store failed: %v", err)
+	}
+	if !strings.HasPrefix(ref, "promptvault://kafka/prompts/") {
+		t.Errorf("unexpected ref format: %s", ref)
+	}
+	if len(client.messages) != 1 || !strings.HasSuffix(ref, "/"+client.messages[0].key) {
+		t.Errorf("expected one message keyed by the ref checksum, got %v", client.messages)
+	}
+
+	got, err := vault.Retrieve(ref)
+	if err != nil {
+		t.Fatalf("retrieve failed: %v", err)
+	}
+	if string(got) != string(content) {
+		t.Errorf("expected %q, got %q", content, got)
+	}
+
+	if _, err := vault.Retrieve("promptvault://kafka/other/" + client.messages[0].key); err == nil {
+		t.Error("expected an error for a ref on another topic")
+	}
+	if _, err := vault.Retrieve("promptvault://kafka/prompts/0000"); err == nil {
+		t.Error("expected an error for an unknown checksum")
+	}
+
+	client.messages[0].value = "tampered"
+	if _, err := vault.Retrieve(ref); err == nil {
+		t.Error("expected an error for a message not matching its checksum")
+	}
+}
+
+func TestKafkaVaultProduceFailureKeepsContentInline(t *testing.T) {
+	client := &fakeKafka{fail: errors.New("leader not available")}
+	sink := new(consumertest.TracesSink)
+	proc := newTestProcessor(t, createDefaultConfig(), newKafkaVault(client, "prompts", 0), sink)
+
+	td := ptrace.NewTraces()
+	span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+	span.Attributes().PutStr("gen_ai.prompt", "Tell me about quantum computing")
+
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	attrs := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	if v, _ := attrs.Get("gen_ai.prompt"); v.Str() != "Tell me about quantum computing" {
+		t.Errorf("expected content to stay inline after a produce failure, got %s", v.Str())
+	}
+
+	if err := proc.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
+	if !client.closed {
+		t.Error("expected Shutdown to close the kafka client")
+	}
+}
+
+func TestNewKafkaVaultRequiresTopic(t *testing.T) {
+	if _, err := NewKafkaVault(KafkaConfig{Brokers: []string{"localhost:9092"}}); err == nil {
+		t.Error("expected an error without a topic")
+	}
+}
+
+// fakePartition is a kafkaPartitionReader over an in-memory partition,
+// counting the messages it reads.
+type fakePartition struct {
+	first int64
+	msgs  []kafka.Message
+	reads int
+}
+
+func (p *fakePartition) append(key, value string) {
+	p.msgs = append(p.msgs, kafka.Message{Key: []byte(key), Value: []byte(value), Offset: p.first + int64(len(p.msgs))})
+}
+
+func (p *fakePartition) ReadOffsets() (int64, int64, error) {
+	return p.first, p.first + int64(len(p.msgs)), nil
+}
+
+func (p *fakePartition) ReadFrom(offset int64, fn func(kafka.Message) bool) error {
+	for _, msg := range p.msgs[offset-p.first:] {
+		p.reads++
+		if !fn(msg) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func TestKafkaLookupReadsIndexedKeyOnly(t *testing.T) {
+	p := &fakePartition{}
+	for _, key := range []string{"a", "b", "c", "d"} {
+		p.append(key, "v-"+key)
+	}
+	index := newKafkaKeyIndex(0)
+
+	value, err := lookupPartition(p, index, 0, []byte("b"))
+	if err != nil || string(value) != "v-b" {
+		t.Fatalf("lookup b = %q, %v", value, err)
+	}
+	if p.reads != 4 {
+		t.Errorf("first lookup read %d messages, want the 4 in the partition", p.reads)
+	}
+
+	p.reads = 0
+	if value, err := lookupPartition(p, index, 0, []byte("d")); err != nil || string(value) != "v-d" {
+		t.Fatalf("lookup d = %q, %v", value, err)
+	}
+	if p.reads != 1 {
+		t.Errorf("indexed lookup read %d messages, want 1", p.reads)
+	}
+
+	// Only messages produced since the last scan are read for a new key.
+	p.append("e", "v-e")
+	p.reads = 0
+	if value, err := lookupPartition(p, index, 0, []byte("e")); err != nil || string(value) != "v-e" {
+		t.Fatalf("lookup e = %q, %v", value, err)
+	}
+	if p.reads != 1 {
+		t.Errorf("incremental lookup read %d messages, want 1", p.reads)
+	}
+
+	p.reads = 0
+	if _, err := lookupPartition(p, index, 0, []byte("missing")); !errors.Is(err, errKafkaNotFound) {
+		t.Errorf("lookup missing = %v, want errKafkaNotFound", err)
+	}
+	if p.reads != 0 {
+		t.Errorf("lookup of an unknown key read %d messages of a scanned partition", p.reads)
+	}
+}
+
+func TestKafkaLookupIndexBound(t *testing.T) {
+	p := &fakePartition{}
+	for _, key := range []string{"a", "b", "c", "d"} {
+		p.append(key, "v-"+key)
+	}
+	index := newKafkaKeyIndex(2)
+
+	if value, err := lookupPartition(p, index, 0, []byte("d")); err != nil || string(value) != "v-d" {
+		t.Fatalf("lookup d = %q, %v", value, err)
+	}
+	if n := len(index.partitions[0].offsets); n > 2 {
+		t.Errorf("index holds %d keys, want at most 2", n)
+	}
+	// A key dropped from the index is still found by scanning again.
+	if value, err := lookupPartition(p, index, 0, []byte("a")); err != nil || string(value) != "v-a" {
+		t.Fatalf("lookup a = %q, %v", value, err)
+	}
+}
+
+func TestKafkaLookupCompactedOffset(t *testing.T) {
+	p := &fakePartition{}
+	p.append("a", "v-a")
+	p.append("b", "v-b")
+	index := newKafkaKeyIndex(0)
+	if _, err := lookupPartition(p, index, 0, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	// The partition's start moved past the indexed offset of a.
+	p.first, p.msgs = 2, nil
+	p.append("a", "v-a2")
+	if value, err := lookupPartition(p, index, 0, []byte("a")); err != nil || string(value) != "v-a2" {
+		t.Fatalf("lookup a = %q, %v", value, err)
+	}
+}
+
+func TestKafkaPartitionUsesMetadataIDs(t *testing.T) {
+	// IDs out of order and with a gap, as metadata may list them.
+	partitions := []kafka.Partition{{ID: 7}, {ID: 2}, {ID: 4}}
+	ids := map[int]bool{2: true, 4: true, 7: true}
+	for _, key := range []string{"a", "b", "c", "d", "e", "f"} {
+		got := kafkaPartition([]byte(key), partitions)
+		if !ids[got] {
+			t.Errorf("key %s: partition %d is not in the topic", key, got)
+		}
+		want := []int{2, 4, 7}[(&kafka.Hash{}).Balance(kafka.Message{Key: []byte(key)}, 0, 1, 2)]
+		if got != want {
+			t.Errorf("key %s: partition %d, want %d as the writer picks", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package promptvaultprocessor
+
+import (
+	"path"
+	"slices"
+	"strings"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+)
+
+// isGlob reports whether key is a glob pattern rather than a literal key.
+func isGlob(key string) bool {
+	return strings.ContainsAny(key, "*?[")
+}
+
+// globPatterns returns the glob patterns among every configured key set,
+// so each can be matched when its own set applies.
+func globPatterns(cfg VaultConfig) []string {
+	var patterns []string
+	seen := map[string]bool{}
+	add := func(keys []string) {
+		for _, key := range keys {
+			if isGlob(key) && !seen[key] {
+				seen[key] = true
+				patterns = append(patterns, key)
+			}
+		}
+	}
+	add(cfg.Keys)
+	add(cfg.ResourceKeys)
+	add(cfg.ScopeKeys)
+	for _, keys := range builtinProfiles {
+		add(keys)
+	}
+	for _, keys := range cfg.Profiles {
+		add(keys)
+	}
+	return patterns
+}
+
+// matchesKey reports whether key is in keys, literally or through one of
+// the glob patterns keys contains.
+func (p *vaultProcessor) matchesKey(keys map[string]bool, key string) bool {
+	if keys[key] {
+		return true
+	}
+	for _, pattern := range p.keyPatterns {
+		if keys[pattern] {
+			if ok, _ := path.Match(pattern, key); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchesKeyPattern reports whether key starts with one of key_prefixes or
+// matches one of key_patterns.
+func (p *vaultProcessor) matchesKeyPattern(key string) bool {
+	if hasAnyPrefix(key, p.keyPrefixes) {
+		return true
+	}
+	for _, re := range p.keyRegexps {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyPrefix(key string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// attrIsTrue reports whether attrs hold key as the bool true or the string
+// "true".
+func attrIsTrue(attrs pcommon.Map, key string) bool {
+	v, ok := attrs.Get(key)
+	if !ok {
+		return false
+	}
+	switch v.Type() {
+	case pcommon.ValueTypeBool:
+		return v.Bool()
+	case pcommon.ValueTypeStr:
+		return v.Str() == "true"
+	}
+	return false
+}
+
+// matches reports whether key in attrs is selected for vaulting: by keys,
+// by key_patterns when byPattern is set, or by a sensitivity marker.
+func (p *vaultProcessor) matches(attrs pcommon.Map, keys map[string]bool, byPattern bool, key string) bool {
+	return p.matchesKey(keys, key) || (byPattern && p.matchesKeyPattern(key)) || p.markedSensitive(attrs, key)
+}
+
+// markedSensitive reports whether instrumentation flagged key as sensitive
+// through its companion marker attribute.
+func (p *vaultProcessor) markedSensitive(attrs pcommon.Map, key string) bool {
+	suffix := p.config.Vault.SensitiveMarkerSuffix
+	return suffix != "" && !strings.HasSuffix(key, suffix) && attrIsTrue(attrs, key+suffix)
+}
+
+func toSet(keys []string) map[string]bool {
+	set := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		set[k] = true
+	}
+	return set
+}
+
+// keysWhen returns keys, plus extra when scan is set.
+func keysWhen(keys []string, scan bool, extra []string) []string {
+	if !scan {
+		return keys
+	}
+	return append(slices.Clip(keys), extra...)
+}
+
+// priorityIndex maps each key to its rank in priority, keeping the first
+// occurrence of duplicates.
+func priorityIndex(priority []string) map[string]int {
+	index := make(map[string]int, len(priority))
+	for i, k := range priority {
+		if _, ok := index[k]; !ok {
+			index[k] = i
+		}
+	}
+	return index
+}
+
+// groupIndex maps each grouped key to the index of its group.
+func groupIndex(groups [][]string) map[string]int {
+	index := make(map[string]int)
+	for i, group := range groups {
+		for _, k := range group {
+			index[k] = i
+		}
+	}
+	return index
+}
+
+// priority ranks key by its position in KeyPriority; unlisted keys rank
+// after all listed ones.
+func (p *vaultProcessor) priority(key string) int {
+	if rank, ok := p.keyPriority[key]; ok {
+		return rank
+	}
+	return len(p.keyPriority)
+}
+
+// sizeThreshold returns the size below which the value of key stays
+// inline: its key_thresholds entry, else size_threshold.
+func (p *vaultProcessor) sizeThreshold(key string) int {
+	if threshold, ok := p.config.Vault.KeyThresholds[key]; ok {
+		return threshold
+	}
+	return p.config.Vault.SizeThreshold
+}
+
+// groupThreshold returns the size a group's combined values must reach to
+// be vaulted: the smallest threshold among its keys, so a group is never
+// kept inline when one of its keys alone would have been vaulted.
+func (p *vaultProcessor) groupThreshold(group int) int {
+	keys := p.config.Vault.Groups[group]
+	threshold := p.sizeThreshold(keys[0])
+	for _, key := range keys[1:] {
+		threshold = min(threshold, p.sizeThreshold(key))
+	}
+	return threshold
+}
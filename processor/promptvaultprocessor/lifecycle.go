@@ -0,0 +1,251 @@
+package promptvaultprocessor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/collector/component"
+	"go.uber.org/zap"
+)
+
+func (p *vaultProcessor) Start(_ context.Context, _ component.Host) error {
+	if p.disabledFor != "" {
+		p.logger.Info("promptvault processor disabled for this environment, passing data through",
+			zap.String("environment", p.disabledFor),
+		)
+		return nil
+	}
+	if checker, ok := p.vault.(EncryptionChecker); ok {
+		if err := checker.CheckEncryption(); err != nil {
+			return fmt.Errorf("crypto self-test failed: %w", err)
+		}
+	}
+	if p.destructiveDelay > 0 {
+		p.destructiveAt = p.now().Add(p.destructiveDelay)
+	}
+	if p.config.Vault.OffloadOnlyNovel && p.config.Vault.NovelCache.Warm && p.novel != nil {
+		p.warmNovelCache()
+	}
+	if p.config.Resolver.Enabled {
+		if err := p.startResolver(); err != nil {
+			return err
+		}
+	}
+	if p.dryRun != nil {
+		p.startDryRunReport()
+	}
+	if p.config.Storage.Filesystem.Compaction.Interval > 0 {
+		p.startCompaction()
+	}
+	if p.config.Storage.RetentionDays > 0 {
+		p.startSweep()
+	}
+	if p.config.Storage.Async.Enabled {
+		p.startAsync()
+	}
+	if p.config.SummaryInterval > 0 {
+		p.startSummary()
+	}
+	if err := p.startAudit(); err != nil {
+		return err
+	}
+
+	p.logger.Info("promptvault processor started",
+		zap.Int("vault_keys", len(p.keysSet)),
+		zap.String("mode", p.config.Vault.Mode),
+		zap.String("backend", p.config.Storage.Backend),
+	)
+	return nil
+}
+
+func (p *vaultProcessor) startResolver() error {
+	if p.config.Resolver.AuthToken == "" {
+		return errors.New("resolver requires an auth_token")
+	}
+	ln, err := net.Listen("tcp", p.config.Resolver.Endpoint)
+	if err != nil {
+		return fmt.Errorf("start resolver: %w", err)
+	}
+
+	p.resolver = &http.Server{
+		Handler:           newResolveHandler(p.vault.(VaultRetriever), p.config.Resolver.AuthToken, p.allowedSchemes),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := p.resolver.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			p.logger.Error("resolver stopped", zap.Error(err))
+		}
+	}()
+
+	p.logger.Info("promptvault resolver listening", zap.String("endpoint", ln.Addr().String()))
+	return nil
+}
+
+// startAudit opens the configured audit sink, unless one was provided, and
+// starts the queue feeding it.
+func (p *vaultProcessor) startAudit() error {
+	if p.auditSink == nil {
+		var err error
+		switch cfg := p.config.Audit; cfg.Sink {
+		case "":
+			return nil
+		case "file":
+			p.auditSink, err = NewFileAuditSink(cfg.Path)
+		case "syslog":
+			p.auditSink, err = NewSyslogAuditSink(cfg.Network, cfg.Address, cfg.Tag)
+		default:
+			err = fmt.Errorf("unsupported audit sink %q", cfg.Sink)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	p.audit = newAuditQueue(p.auditSink, p.config.Audit.QueueSize, p.logger, func() {
+		p.metrics.auditDropped.Add(context.Background(), 1)
+	})
+	return nil
+}
+
+// startDryRunReport logs the dry-run report every ReportInterval until
+// Shutdown, which logs it one final time.
+func (p *vaultProcessor) startDryRunReport() {
+	p.stopReport = make(chan struct{})
+	p.reportDone = make(chan struct{})
+	go func() {
+		defer close(p.reportDone)
+		ticker := time.NewTicker(p.config.DryRun.ReportInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.dryRun.log(p.logger)
+			case <-p.stopReport:
+				p.dryRun.log(p.logger)
+				return
+			}
+		}
+	}()
+}
+
+// startCompaction packs small objects every Compaction.Interval until
+// Shutdown.
+func (p *vaultProcessor) startCompaction() {
+	cfg := p.config.Storage.Filesystem.Compaction
+	compactor := p.vault.(Compactor)
+	p.stopCompaction = make(chan struct{})
+	p.compactionDone = make(chan struct{})
+	go func() {
+		defer close(p.compactionDone)
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				packed, err := compactor.Compact(cfg.MinAge, cfg.MaxObjectSize)
+				if err != nil {
+					p.logger.Error("vault compaction failed", zap.Int("packed", packed), zap.Error(err))
+				} else if packed > 0 {
+					p.logger.Info("vault compacted", zap.Int("packed", packed))
+				}
+			case <-p.stopCompaction:
+				return
+			}
+		}
+	}()
+}
+
+// startSweep deletes objects past storage.retention_days every
+// sweep_interval until Shutdown.
+func (p *vaultProcessor) startSweep() {
+	maxAge := time.Duration(p.config.Storage.RetentionDays) * 24 * time.Hour
+	interval := p.config.Storage.SweepInterval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	sweeper := p.vault.(Sweeper)
+	p.stopSweep = make(chan struct{})
+	p.sweepDone = make(chan struct{})
+	go func() {
+		defer close(p.sweepDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				objects, reclaimed, err := sweeper.Sweep(maxAge)
+				if err != nil {
+					p.logger.Error("vault retention sweep failed",
+						zap.Int("objects", objects), zap.Int64("bytes", reclaimed), zap.Error(err))
+				} else {
+					p.logger.Info("vault retention sweep",
+						zap.Int("objects", objects), zap.Int64("bytes", reclaimed))
+				}
+			case <-p.stopSweep:
+				return
+			}
+		}
+	}()
+}
+
+// startSummary logs the lifetime summary every SummaryInterval until
+// Shutdown, which logs it one final time.
+func (p *vaultProcessor) startSummary() {
+	p.stopSummary = make(chan struct{})
+	p.summaryDone = make(chan struct{})
+	go func() {
+		defer close(p.summaryDone)
+		ticker := time.NewTicker(p.config.SummaryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.stats.log(p.logger, p.vault)
+			case <-p.stopSummary:
+				return
+			}
+		}
+	}()
+}
+
+func (p *vaultProcessor) Shutdown(ctx context.Context) error {
+	if p.async != nil {
+		p.async.close()
+	}
+	if p.stopSummary != nil {
+		close(p.stopSummary)
+		<-p.summaryDone
+		p.stopSummary = nil
+	}
+	p.stats.log(p.logger, p.vault)
+	if p.stopReport != nil {
+		close(p.stopReport)
+		<-p.reportDone
+		p.stopReport = nil
+	}
+	if p.stopCompaction != nil {
+		close(p.stopCompaction)
+		<-p.compactionDone
+		p.stopCompaction = nil
+	}
+	if p.stopSweep != nil {
+		close(p.stopSweep)
+		<-p.sweepDone
+		p.stopSweep = nil
+	}
+	var err error
+	if p.resolver != nil {
+		err = p.resolver.Shutdown(ctx)
+	}
+	if p.audit != nil {
+		err = errors.Join(err, p.audit.close())
+	}
+	if closer, ok := p.vault.(io.Closer); ok {
+		err = errors.Join(err, closer.Close())
+	}
+	return err
+}
@@ -0,0 +1,186 @@
+package promptvaultprocessor
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/consumer/consumererror"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.uber.org/zap"
+)
+
+// logBodyKey names a log record's string body while it is vaulted or
+// restored, so its reference and sidecar attributes are log.body.<suffix>.
+const logBodyKey = "log.body"
+
+// newLogsProcessor creates a vault processor for a logs pipeline. Log
+// records are vaulted like spans, under the same keys, mode and thresholds.
+func newLogsProcessor(
+	set component.TelemetrySettings,
+	cfg *Config,
+	vault VaultStorage,
+	next consumer.Logs,
+) (*vaultProcessor, error) {
+	p, err := newVaultProcessor(set, cfg, vault, nil)
+	if err != nil {
+		return nil, err
+	}
+	p.nextLogs = next
+	return p, nil
+}
+
+// ConsumeLogs vaults the matching attributes of each log record, and its
+// string body with log_body, then forwards ld. Resource and scope
+// attributes are handled as for traces. Span-only options (trace_digest,
+// error_status_on_drop, provider_profiles) do not apply to log records.
+func (p *vaultProcessor) ConsumeLogs(ctx context.Context, ld plog.Logs) error {
+	if p.disabledFor != "" {
+		return p.nextLogs.ConsumeLogs(ctx, ld)
+	}
+	if p.config.Vault.Rehydrate {
+		if _, err := RestoreLogContent(ld, p.vault.(VaultRetriever), p.config.Vault); err != nil {
+			p.logger.Warn("some vault references could not be rehydrated", zap.Error(err))
+		}
+		return p.nextLogs.ConsumeLogs(ctx, ld)
+	}
+	if p.memoryPressure() {
+		p.metrics.memoryBypass.Add(ctx, int64(ld.LogRecordCount()))
+		p.stampLogCanary(ld)
+		return p.nextLogs.ConsumeLogs(ctx, ld)
+	}
+
+	if !p.acquireBatch() {
+		p.metrics.rejectedBatches.Add(ctx, 1)
+		return consumererror.NewLogs(errSaturated, ld)
+	}
+	defer p.releaseBatch()
+
+	batchCtx, cancel := p.batchContext(ctx)
+	defer cancel()
+
+	gated := p.config.Vault.Consent.enabled()
+	batchConsent := !gated || p.metadataConsent(ctx)
+
+	rls := ld.ResourceLogs()
+	for i := 0; i < rls.Len(); i++ {
+		rl := rls.At(i)
+		resourceConsent := batchConsent || p.attributeConsent(rl.Resource().Attributes())
+		if len(p.resourceKeys) > 0 && resourceConsent {
+			p.vaultContainer(batchCtx, rl.Resource().Attributes(), p.resourceKeys)
+		}
+		sls := rl.ScopeLogs()
+		for j := 0; j < sls.Len(); j++ {
+			sl := sls.At(j)
+			if len(p.scopeKeys) > 0 && resourceConsent {
+				p.vaultContainer(batchCtx, sl.Scope().Attributes(), p.scopeKeys)
+			}
+			records := sl.LogRecords()
+			for k := 0; k < records.Len(); k++ {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				lr := records.At(k)
+				if canary := p.config.Vault.CanaryAttribute; canary != "" {
+					lr.Attributes().PutBool(canary, true)
+				}
+				if !resourceConsent && !p.attributeConsent(lr.Attributes()) {
+					p.metrics.unconsentedSpans.Add(ctx, 1)
+					continue
+				}
+				p.processLogRecord(batchCtx, lr)
+			}
+		}
+	}
+	p.checkBatchTimeout(ctx, batchCtx)
+	if err := batchFailure(batchCtx); err != nil {
+		return consumererror.NewLogs(err, ld)
+	}
+	return p.nextLogs.ConsumeLogs(ctx, ld)
+}
+
+func (p *vaultProcessor) processLogRecord(ctx context.Context, lr plog.LogRecord) {
+	keys := p.keysSet
+	if p.config.Vault.LogBody {
+		keys = make(map[string]bool, len(p.keysSet)+1)
+		for key := range p.keysSet {
+			keys[key] = true
+		}
+		keys[logBodyKey] = true
+	}
+	var result offloadResult
+	withLogBody(lr, p.config.Vault.LogBody, func(attrs pcommon.Map) {
+		result = p.vaultAttributes(ctx, attrs, keys, true, lr.TraceID())
+	})
+	p.recordAudit(lr.TraceID(), lr.SpanID(), result.offloaded)
+	if p.config.Vault.MarkOffloaded && len(result.offloaded) > 0 {
+		lr.Attributes().PutBool(offloadedKey, true)
+	}
+}
+
+// withLogBody runs fn over the attributes of lr. With body set and a string
+// body, the body is added to them as logBodyKey for the duration of fn and
+// written back afterwards: the attribute's new value becomes the body, and
+// an empty body when fn removed it. An attribute already named logBodyKey
+// is replaced.
+func withLogBody(lr plog.LogRecord, body bool, fn func(attrs pcommon.Map)) {
+	attrs := lr.Attributes()
+	isStr := lr.Body().Type() == pcommon.ValueTypeStr
+	if !body {
+		fn(attrs)
+		return
+	}
+	if isStr {
+		attrs.PutStr(logBodyKey, lr.Body().Str())
+	}
+	fn(attrs)
+	if val, ok := attrs.Get(logBodyKey); ok {
+		val.CopyTo(lr.Body())
+		attrs.Remove(logBodyKey)
+	} else if isStr {
+		pcommon.NewValueEmpty().CopyTo(lr.Body())
+	}
+}
+
+// stampLogCanary marks every log record in ld with the canary attribute.
+func (p *vaultProcessor) stampLogCanary(ld plog.Logs) {
+	canary := p.config.Vault.CanaryAttribute
+	if canary == "" {
+		return
+	}
+	rls := ld.ResourceLogs()
+	for i := 0; i < rls.Len(); i++ {
+		sls := rls.At(i).ScopeLogs()
+		for j := 0; j < sls.Len(); j++ {
+			records := sls.At(j).LogRecords()
+			for k := 0; k < records.Len(); k++ {
+				records.At(k).Attributes().PutBool(canary, true)
+			}
+		}
+	}
+}
+
+// RestoreLogContent is RestoreContent for logs: it restores the resource,
+// scope and log record attributes of ld and, with cfg.LogBody, log bodies.
+func RestoreLogContent(ld plog.Logs, vault VaultRetriever, cfg VaultConfig) (restored int, err error) {
+	r := newRestorer(vault, cfg)
+	rls := ld.ResourceLogs()
+	for i := 0; i < rls.Len(); i++ {
+		rl := rls.At(i)
+		r.restore(rl.Resource().Attributes())
+		sls := rl.ScopeLogs()
+		for j := 0; j < sls.Len(); j++ {
+			sl := sls.At(j)
+			r.restore(sl.Scope().Attributes())
+			records := sl.LogRecords()
+			for k := 0; k < records.Len(); k++ {
+				r.startBudget()
+				withLogBody(records.At(k), cfg.LogBody, r.restore)
+				r.endBudget()
+			}
+		}
+	}
+	return r.restored, errors.Join(r.errs...)
+}
@@ -0,0 +1,173 @@
+package promptvaultprocessor
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/processor/processortest"
+	"go.opentelemetry.io/otel/metric/noop"
+	"go.uber.org/zap"
+)
+
+func newTestLogsProcessor(t *testing.T, cfg *Config, vault VaultStorage, sink *consumertest.LogsSink) *vaultProcessor {
+	t.Helper()
+	set := component.TelemetrySettings{Logger: zap.NewNop(), MeterProvider: noop.NewMeterProvider()}
+	proc, err := newLogsProcessor(set, cfg, vault, sink)
+	if err != nil {
+		t.Fatalf("failed to create processor: %v", err)
+	}
+	return proc
+}
+
+func newTestLogs(body string) plog.Logs {
+	ld := plog.NewLogs()
+	lr := ld.ResourceLogs().AppendEmpty().ScopeLogs().AppendEmpty().LogRecords().AppendEmpty()
+	lr.Body().SetStr(body)
+	lr.Attributes().PutStr("gen_ai.prompt", "Tell me about quantum computing")
+	lr.Attributes().PutStr("gen_ai.completion", "Quantum computing uses qubits...")
+	lr.Attributes().PutStr("http.method", "POST")
+	return ld
+}
+
+func firstLogRecord(ld plog.Logs) plog.LogRecord {
+	return ld.ResourceLogs().At(0).ScopeLogs().At(0).LogRecords().At(0)
+}
+
+func TestVaultLogAttributes(t *testing.T) {
+	for _, tc := range []struct {
+		mode       string
+		wantPrompt func(string) bool
+		wantRef    bool
+	}{
+		{"replace_with_ref", func(v string) bool { return strings.HasPrefix(v, "vault://") }, true},
+		{"remove", nil, true},
+		{"keep_and_ref", func(v string) bool { return v == "Tell me about quantum computing" }, true},
+	} {
+		dir := t.TempDir()
+		vault, _ := NewFilesystemVault(dir)
+		cfg := createDefaultConfig()
+		cfg.Vault.Mode = tc.mode
+		sink := new(consumertest.LogsSink)
+		proc := newTestLogsProcessor(t, cfg, vault, sink)
+
+		if err := proc.ConsumeLogs(context.Background(), newTestLogs("chat request")); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.mode, err)
+		}
+		lr := firstLogRecord(sink.AllLogs()[0])
+		prompt, ok := lr.Attributes().Get("gen_ai.prompt")
+		switch {
+		case tc.wantPrompt == nil && ok:
+			t.Errorf("%s: expected gen_ai.prompt to be removed, got %q", tc.mode, prompt.Str())
+		case tc.wantPrompt != nil && (!ok || !tc.wantPrompt(prompt.Str())):
+			t.Errorf("%s: unexpected gen_ai.prompt %q", tc.mode, prompt.Str())
+		}
+		if ref, ok := lr.Attributes().Get("gen_ai.prompt.vault_ref"); ok != tc.wantRef || !strings.HasPrefix(ref.Str(), "vault://") {
+			t.Errorf("%s: expected a gen_ai.prompt.vault_ref, got %v", tc.mode, lr.Attributes().AsRaw())
+		}
+		if v, _ := lr.Attributes().Get("http.method"); v.Str() != "POST" {
+			t.Errorf("%s: expected unrelated attributes untouched, got %q", tc.mode, v.Str())
+		}
+		if lr.Body().Str() != "chat request" {
+			t.Errorf("%s: expected the body untouched without log_body, got %q", tc.mode, lr.Body().Str())
+		}
+		if files := vaultFiles(t, dir); len(files) != 2 {
+			t.Errorf("%s: expected 2 vault objects, got %d", tc.mode, len(files))
+		}
+	}
+}
+
+func TestVaultLogBody(t *testing.T) {
+	dir := t.TempDir()
+	vault, _ := NewFilesystemVault(dir)
+	cfg := createDefaultConfig()
+	cfg.Vault.Keys = nil
+	cfg.Vault.LogBody = true
+	cfg.Vault.SizeThreshold = 10
+	sink := new(consumertest.LogsSink)
+	proc := newTestLogsProcessor(t, cfg, vault, sink)
+
+	ld := newTestLogs("User asked: Tell me about quantum computing")
+	short := ld.ResourceLogs().At(0).ScopeLogs().At(0).LogRecords().AppendEmpty()
+	short.Body().SetStr("ok")
+	structured := ld.ResourceLogs().At(0).ScopeLogs().At(0).LogRecords().AppendEmpty()
+	structured.Body().SetEmptyMap().PutStr("message", "Tell me about quantum computing")
+
+	if err := proc.ConsumeLogs(context.Background(), ld); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	records := sink.AllLogs()[0].ResourceLogs().At(0).ScopeLogs().At(0).LogRecords()
+	lr := records.At(0)
+	if !strings.HasPrefix(lr.Body().Str(), "vault://") {
+		t.Errorf("expected the body replaced by a reference, got %q", lr.Body().Str())
+	}
+	ref, ok := lr.Attributes().Get("log.body.vault_ref")
+	if !ok || ref.Str() != lr.Body().Str() {
+		t.Errorf("expected log.body.vault_ref to match the body, got %v", lr.Attributes().AsRaw())
+	}
+	if _, ok := lr.Attributes().Get(logBodyKey); ok {
+		t.Error("expected no log.body attribute to be left behind")
+	}
+	if v, _ := lr.Attributes().Get("gen_ai.prompt"); v.Str() != "Tell me about quantum computing" {
+		t.Errorf("expected attributes outside vault.keys untouched, got %q", v.Str())
+	}
+	if records.At(1).Body().Str() != "ok" {
+		t.Errorf("expected a body under size_threshold kept, got %q", records.At(1).Body().Str())
+	}
+	if records.At(2).Body().Type() != pcommon.ValueTypeMap {
+		t.Errorf("expected a structured body kept, got %v", records.At(2).Body().Type())
+	}
+	if files := vaultFiles(t, dir); len(files) != 1 {
+		t.Errorf("expected 1 vault object, got %d", len(files))
+	}
+}
+
+func TestRehydrateLogs(t *testing.T) {
+	for _, mode := range []string{"replace_with_ref", "remove", "keep_and_ref"} {
+		dir := t.TempDir()
+		vault, _ := NewFilesystemVault(dir)
+		cfg := createDefaultConfig()
+		cfg.Vault.Mode = mode
+		cfg.Vault.LogBody = true
+		sink := new(consumertest.LogsSink)
+		proc := newTestLogsProcessor(t, cfg, vault, sink)
+		const body = "User asked: Tell me about quantum computing"
+		if err := proc.ConsumeLogs(context.Background(), newTestLogs(body)); err != nil {
+			t.Fatalf("%s: unexpected error: %v", mode, err)
+		}
+
+		rcfg := createDefaultConfig()
+		rcfg.Vault.Rehydrate = true
+		rcfg.Vault.LogBody = true
+		rsink := new(consumertest.LogsSink)
+		rproc := newTestLogsProcessor(t, rcfg, vault, rsink)
+		if err := rproc.ConsumeLogs(context.Background(), sink.AllLogs()[0]); err != nil {
+			t.Fatalf("%s: unexpected error: %v", mode, err)
+		}
+		lr := firstLogRecord(rsink.AllLogs()[0])
+		if lr.Body().Str() != body {
+			t.Errorf("%s: expected the body restored, got %q", mode, lr.Body().Str())
+		}
+		want := newTestLogs(body)
+		if got, want := lr.Attributes().AsRaw(), firstLogRecord(want).Attributes().AsRaw(); len(got) != len(want) || got["gen_ai.prompt"] != want["gen_ai.prompt"] {
+			t.Errorf("%s: expected attributes restored, got %v", mode, got)
+		}
+	}
+}
+
+func TestCreateLogsProcessor(t *testing.T) {
+	factory := NewFactory()
+	cfg := factory.CreateDefaultConfig().(*Config)
+	cfg.Storage.Filesystem.BasePath = t.TempDir()
+	proc, err := factory.CreateLogsProcessor(context.Background(), processortest.NewNopSettings(), cfg, consumertest.NewNop())
+	if err != nil {
+		t.Fatalf("failed to create logs processor: %v", err)
+	}
+	if !proc.Capabilities().MutatesData {
+		t.Error("expected the logs processor to mutate data")
+	}
+}
@@ -0,0 +1,62 @@
+package promptvaultprocessor
+
+import (
+	"runtime/metrics"
+	"sync"
+	"time"
+)
+
+const heapObjectsMetric = "/memory/classes/heap/objects:bytes"
+
+// memoryGuard reports whether the heap is above the configured high-water
+// mark. Samples are rate limited so checking on every batch stays cheap.
+type memoryGuard struct {
+	limit    uint64
+	interval time.Duration
+	readHeap func() uint64
+	now      func() time.Time
+
+	mu        sync.Mutex
+	lastCheck time.Time
+	pressure  bool
+}
+
+// newMemoryGuard returns nil when the bypass is disabled.
+func newMemoryGuard(cfg MemoryConfig) *memoryGuard {
+	if cfg.BypassHeapMiB == 0 {
+		return nil
+	}
+	return &memoryGuard{
+		limit:    cfg.BypassHeapMiB << 20,
+		interval: cfg.CheckInterval,
+		readHeap: readHeapBytes,
+		now:      time.Now,
+	}
+}
+
+// underPressure samples the heap at most once per interval and returns
+// whether it was above the limit. changed reports a transition.
+func (g *memoryGuard) underPressure() (pressure, changed bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	if !g.lastCheck.IsZero() && now.Sub(g.lastCheck) < g.interval {
+		return g.pressure, false
+	}
+	g.lastCheck = now
+
+	pressure = g.readHeap() >= g.limit
+	changed = pressure != g.pressure
+	g.pressure = pressure
+	return pressure, changed
+}
+
+func readHeapBytes() uint64 {
+	sample := []metrics.Sample{{Name: heapObjectsMetric}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return sample[0].Value.Uint64()
+}
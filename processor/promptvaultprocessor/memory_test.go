@@ -0,0 +1,75 @@
+package promptvaultprocessor
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+func TestMemoryPressureBypassesOffload(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	cfg := createDefaultConfig()
+	cfg.Memory.BypassHeapMiB = 512
+	cfg.Memory.CheckInterval = 0
+	set, reader := newTestTelemetry()
+	sink := new(consumertest.TracesSink)
+	proc, err := newVaultProcessor(set, cfg, vault, sink)
+	if err != nil {
+		t.Fatalf("failed to create processor: %v", err)
+	}
+
+	heap := uint64(100 << 20)
+	proc.memory.readHeap = func() uint64 { return heap }
+
+	consume := func() string {
+		td := ptrace.NewTraces()
+		span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+		span.Attributes().PutStr("gen_ai.prompt", "Tell me about quantum computing")
+		if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		traces := sink.AllTraces()
+		attrs := traces[len(traces)-1].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+		v, _ := attrs.Get("gen_ai.prompt")
+		return v.Str()
+	}
+
+	if got := consume(); !strings.HasPrefix(got, "vault://") {
+		t.Errorf("expected offload below threshold, got: %s", got)
+	}
+
+	heap = 600 << 20
+	if got := consume(); got != "Tell me about quantum computing" {
+		t.Errorf("expected pass-through under memory pressure, got: %s", got)
+	}
+	if n := counterValue(t, reader, "processor_promptvault_memory_bypass"); n != 1 {
+		t.Errorf("expected 1 bypassed span, got %d", n)
+	}
+
+	heap = 100 << 20
+	if got := consume(); !strings.HasPrefix(got, "vault://") {
+		t.Errorf("expected offload to resume once pressure clears, got: %s", got)
+	}
+}
+
+func TestMemoryGuardRateLimitsSamples(t *testing.T) {
+	g := newMemoryGuard(MemoryConfig{BypassHeapMiB: 1, CheckInterval: time.Second})
+	now := time.Unix(0, 0)
+	g.now = func() time.Time { return now }
+	reads := 0
+	g.readHeap = func() uint64 { reads++; return 2 << 20 }
+
+	g.underPressure()
+	g.underPressure()
+	if reads != 1 {
+		t.Errorf("expected heap to be sampled once within the interval, got %d", reads)
+	}
+	now = now.Add(time.Second)
+	if pressure, _ := g.underPressure(); !pressure || reads != 2 {
+		t.Errorf("expected a fresh sample reporting pressure, got pressure=%v reads=%d", pressure, reads)
+	}
+}
@@ -0,0 +1,104 @@
+package promptvaultprocessor
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// memoryRefPrefix starts every reference produced by a MemoryVault; the
+// object name follows.
+const memoryRefPrefix = backendRefScheme + "memory/"
+
+// errMemoryVaultClosed is returned by a MemoryVault used after Close.
+var errMemoryVaultClosed = errors.New("memory vault closed")
+
+// MemoryVault keeps content in process memory, named <sha256>.<ext> like
+// filesystem objects so identical content is stored once. Nothing survives
+// a restart: it is meant for tests and for evaluating the processor without
+// provisioning storage.
+type MemoryVault struct {
+	mu        sync.RWMutex
+	objects   map[string][]byte // ref -> content
+	closed    bool
+	dedupHits atomic.Int64
+}
+
+// NewMemoryVault creates an empty MemoryVault.
+func NewMemoryVault() *MemoryVault {
+	return &MemoryVault{objects: map[string][]byte{}}
+}
+
+// Store keeps a copy of content and returns its reference.
+func (v *MemoryVault) Store(content []byte) (string, error) {
+	ref, _ := v.PredictRef(content, "")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.closed {
+		return "", errMemoryVaultClosed
+	}
+	if _, ok := v.objects[ref]; ok {
+		v.dedupHits.Add(1)
+		return ref, nil
+	}
+	v.objects[ref] = bytes.Clone(content)
+	return ref, nil
+}
+
+// PredictRef returns the reference Store will return for content. Objects
+// are named by their detected type, so contentType is not used.
+func (v *MemoryVault) PredictRef(content []byte, _ string) (string, error) {
+	return fmt.Sprintf("%s%x.%s", memoryRefPrefix, sha256.Sum256(content), contentTypeExt[detectContentType(content)]), nil
+}
+
+// RefScheme reports "promptvault", the scheme of backend references.
+func (v *MemoryVault) RefScheme() string {
+	return strings.TrimSuffix(backendRefScheme, "://")
+}
+
+// Retrieve returns a copy of the content stored under ref.
+func (v *MemoryVault) Retrieve(ref string) ([]byte, error) {
+	if !strings.HasPrefix(ref, memoryRefPrefix) {
+		return nil, fmt.Errorf("not a memory vault ref: %s", ref)
+	}
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if v.closed {
+		return nil, errMemoryVaultClosed
+	}
+	content, ok := v.objects[ref]
+	if !ok {
+		return nil, fmt.Errorf("vault ref not found: %s", ref)
+	}
+	return bytes.Clone(content), nil
+}
+
+// Exists reports whether Store would deduplicate content.
+func (v *MemoryVault) Exists(content []byte) (bool, error) {
+	ref, _ := v.PredictRef(content, "")
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if v.closed {
+		return false, errMemoryVaultClosed
+	}
+	_, ok := v.objects[ref]
+	return ok, nil
+}
+
+// DedupHits returns how many stores found their content already present.
+func (v *MemoryVault) DedupHits() int64 {
+	return v.dedupHits.Load()
+}
+
+// Close releases the stored content. The vault cannot be used afterwards.
+func (v *MemoryVault) Close() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.objects = nil
+	v.closed = true
+	return nil
+}
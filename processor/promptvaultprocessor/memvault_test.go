@@ -0,0 +1,130 @@
+package promptvaultprocessor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/collector/processor/processortest"
+)
+
+func TestMemoryVaultStoreRetrieve(t *testing.T) {
+	vault := NewMemoryVault()
+	content := []byte("Tell me about quantum computing")
+
+	ref, err := vault.Store(content)
+	if err != nil {
+		t.Fatalf("store failed: %v", err)
+	}
+	if !strings.HasPrefix(ref, "promptvault://memory/") || !strings.HasSuffix(ref, ".txt") {
+		t.Errorf("unexpected reference %s", ref)
+	}
+	content[0] = 'X' // the vault keeps its own copy
+	got, err := vault.Retrieve(ref)
+	if err != nil || string(got) != "Tell me about quantum computing" {
+		t.Errorf("expected the original content, got %q (%v)", got, err)
+	}
+	if predicted, _ := vault.PredictRef([]byte("Tell me about quantum computing"), ""); predicted != ref {
+		t.Errorf("predicted %s, stored as %s", predicted, ref)
+	}
+
+	for _, bad := range []string{"promptvault://memory/" + strings.Repeat("0", 64) + ".txt", "vault://" + strings.Repeat("0", 64)} {
+		if _, err := vault.Retrieve(bad); err == nil {
+			t.Errorf("expected %s not to resolve", bad)
+		}
+	}
+}
+
+func TestMemoryVaultDeduplication(t *testing.T) {
+	vault := NewMemoryVault()
+	content := []byte("What is the capital of France?")
+
+	ref1, _ := vault.Store(content)
+	ref2, _ := vault.Store(content)
+	if ref1 != ref2 {
+		t.Errorf("expected identical content to share a reference, got %s and %s", ref1, ref2)
+	}
+	if n := vault.DedupHits(); n != 1 {
+		t.Errorf("expected 1 dedup hit, got %d", n)
+	}
+	if len(vault.objects) != 1 {
+		t.Errorf("expected 1 object, got %d", len(vault.objects))
+	}
+	if ok, _ := vault.Exists(content); !ok {
+		t.Error("expected stored content to exist")
+	}
+	if ok, _ := vault.Exists([]byte("something else")); ok {
+		t.Error("expected unstored content not to exist")
+	}
+}
+
+func TestMemoryVaultConcurrentAccess(t *testing.T) {
+	vault := NewMemoryVault()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				content := []byte(fmt.Sprintf("prompt %d", j%10))
+				ref, err := vault.Store(content)
+				if err != nil {
+					t.Errorf("store failed: %v", err)
+					return
+				}
+				if got, err := vault.Retrieve(ref); err != nil || !bytes.Equal(got, content) {
+					t.Errorf("expected %q back, got %q (%v)", content, got, err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if len(vault.objects) != 10 {
+		t.Errorf("expected 10 distinct objects, got %d", len(vault.objects))
+	}
+}
+
+func TestMemoryVaultClose(t *testing.T) {
+	vault := NewMemoryVault()
+	ref, _ := vault.Store([]byte("Tell me about quantum computing"))
+	if err := vault.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	if _, err := vault.Retrieve(ref); err == nil {
+		t.Error("expected retrieve after close to fail")
+	}
+	if _, err := vault.Store([]byte("more")); err == nil {
+		t.Error("expected store after close to fail")
+	}
+}
+
+func TestMemoryBackend(t *testing.T) {
+	factory := NewFactory()
+	cfg := factory.CreateDefaultConfig().(*Config)
+	cfg.Storage.Backend = "memory"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("invalid config: %v", err)
+	}
+	sink := new(consumertest.TracesSink)
+	proc, err := factory.CreateTracesProcessor(context.Background(), processortest.NewNopSettings(), cfg, sink)
+	if err != nil {
+		t.Fatalf("failed to create processor: %v", err)
+	}
+
+	td := ptrace.NewTraces()
+	td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty().
+		Attributes().PutStr("gen_ai.prompt", "Tell me about quantum computing")
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	v, _ := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes().Get("gen_ai.prompt")
+	if !strings.HasPrefix(v.Str(), "promptvault://memory/") {
+		t.Errorf("expected a memory reference, got %q", v.Str())
+	}
+}
@@ -0,0 +1,174 @@
+package promptvaultprocessor
+
+import (
+	"context"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/metric"
+	"go.uber.org/zap"
+)
+
+const scopeName = "github.com/airblackbox/otel-prompt-vault/processor/promptvaultprocessor"
+
+// processorMetrics holds the instruments the processor reports through the
+// collector's internal telemetry.
+type processorMetrics struct {
+	unsupportedValueType metric.Int64Counter
+	memoryBypass         metric.Int64Counter
+	verifyMismatch       metric.Int64Counter
+	rejectedBatches      metric.Int64Counter
+	skippedAttributes    metric.Int64Counter
+	danglingRefs         metric.Int64Counter
+	throttledAttributes  metric.Int64Counter
+	auditDropped         metric.Int64Counter
+	unconsentedSpans     metric.Int64Counter
+	asyncDropped         metric.Int64Counter
+	asyncFailures        metric.Int64Counter
+	storeRetries         metric.Int64Counter
+	encodeFailures       metric.Int64Counter
+	credentialExpiries   metric.Int64Counter
+}
+
+func newProcessorMetrics(mp metric.MeterProvider, vault VaultStorage) (*processorMetrics, error) {
+	meter := mp.Meter(scopeName)
+
+	var m processorMetrics
+	var err error
+	if m.unsupportedValueType, err = meter.Int64Counter(
+		"processor_promptvault_unsupported_value_type",
+		metric.WithDescription("Matched attributes passed through because their value type is not offloaded."),
+		metric.WithUnit("{attributes}"),
+	); err != nil {
+		return nil, err
+	}
+	if m.memoryBypass, err = meter.Int64Counter(
+		"processor_promptvault_memory_bypass",
+		metric.WithDescription("Spans, log records and data points passed through without offloading because of memory pressure."),
+		metric.WithUnit("{spans}"),
+	); err != nil {
+		return nil, err
+	}
+	if m.verifyMismatch, err = meter.Int64Counter(
+		"processor_promptvault_verify_mismatch",
+		metric.WithDescription("Stored objects whose read-back content did not match what was written."),
+		metric.WithUnit("{objects}"),
+	); err != nil {
+		return nil, err
+	}
+	if m.rejectedBatches, err = meter.Int64Counter(
+		"processor_promptvault_rejected_batches",
+		metric.WithDescription("Batches rejected with a retryable error because max_in_flight_batches was reached."),
+		metric.WithUnit("{batches}"),
+	); err != nil {
+		return nil, err
+	}
+	if m.skippedAttributes, err = meter.Int64Counter(
+		"processor_promptvault_skipped_attributes",
+		metric.WithDescription("Attributes left inline because max_batch_processing_time ran out."),
+		metric.WithUnit("{attributes}"),
+	); err != nil {
+		return nil, err
+	}
+	if m.danglingRefs, err = meter.Int64Counter(
+		"processor_promptvault_dangling_refs",
+		metric.WithDescription("Reference-valued attributes whose reference did not resolve during validation."),
+		metric.WithUnit("{attributes}"),
+	); err != nil {
+		return nil, err
+	}
+	if m.throttledAttributes, err = meter.Int64Counter(
+		"processor_promptvault_throttled_attributes",
+		metric.WithDescription("Attributes left inline because storage.max_bytes_per_second was exceeded."),
+		metric.WithUnit("{attributes}"),
+	); err != nil {
+		return nil, err
+	}
+	if m.auditDropped, err = meter.Int64Counter(
+		"processor_promptvault_audit_dropped",
+		metric.WithDescription("Audit records dropped because the audit queue was full."),
+		metric.WithUnit("{records}"),
+	); err != nil {
+		return nil, err
+	}
+	if m.unconsentedSpans, err = meter.Int64Counter(
+		"processor_promptvault_unconsented_spans",
+		metric.WithDescription("Spans passed through without offloading because vault.consent found no approval."),
+		metric.WithUnit("{spans}"),
+	); err != nil {
+		return nil, err
+	}
+	if m.asyncDropped, err = meter.Int64Counter(
+		"processor_promptvault_async_dropped",
+		metric.WithDescription("Attribute writes refused because the storage.async queue was full."),
+		metric.WithUnit("{writes}"),
+	); err != nil {
+		return nil, err
+	}
+	if m.asyncFailures, err = meter.Int64Counter(
+		"processor_promptvault_async_failures",
+		metric.WithDescription("Background writes that failed after their reference was emitted."),
+		metric.WithUnit("{writes}"),
+	); err != nil {
+		return nil, err
+	}
+	if m.storeRetries, err = meter.Int64Counter(
+		"processor_promptvault_store_retries",
+		metric.WithDescription("Failed stores retried under storage.retry."),
+		metric.WithUnit("{attempts}"),
+	); err != nil {
+		return nil, err
+	}
+	if m.encodeFailures, err = meter.Int64Counter(
+		"processor_promptvault_encode_failures",
+		metric.WithDescription("Map and slice values that could not be encoded as JSON."),
+		metric.WithUnit("{attributes}"),
+	); err != nil {
+		return nil, err
+	}
+	if m.credentialExpiries, err = meter.Int64Counter(
+		"processor_promptvault_credential_expiries",
+		metric.WithDescription("Stores that failed on expired backend credentials and were retried with refreshed ones."),
+		metric.WithUnit("{attempts}"),
+	); err != nil {
+		return nil, err
+	}
+	if counter, ok := vault.(CollisionCounter); ok {
+		if _, err = meter.Int64ObservableCounter(
+			"processor_promptvault_hash_collisions",
+			metric.WithDescription("Stores that found different content under their object name and were disambiguated."),
+			metric.WithUnit("{objects}"),
+			metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+				o.Observe(counter.Collisions())
+				return nil
+			}),
+		); err != nil {
+			return nil, err
+		}
+	}
+	return &m, nil
+}
+
+// lifetimeStats accumulates totals over the processor's lifetime for the
+// summary logged on Shutdown.
+type lifetimeStats struct {
+	offloadedAttributes atomic.Int64
+	offloadedBytes      atomic.Int64
+	storeFailures       atomic.Int64
+}
+
+// log writes the lifetime summary. Dedup hits and hash collisions are
+// included when the vault counts them.
+func (s *lifetimeStats) log(logger *zap.Logger, vault VaultStorage) {
+	fields := []zap.Field{
+		zap.Int64("offloaded_attributes", s.offloadedAttributes.Load()),
+		zap.Int64("offloaded_bytes", s.offloadedBytes.Load()),
+		zap.Int64("store_failures", s.storeFailures.Load()),
+	}
+	if counter, ok := vault.(DedupCounter); ok {
+		fields = append(fields, zap.Int64("dedup_hits", counter.DedupHits()))
+	}
+	if counter, ok := vault.(CollisionCounter); ok {
+		fields = append(fields, zap.Int64("hash_collisions", counter.Collisions()))
+	}
+	logger.Info("promptvault lifetime summary", fields...)
+}
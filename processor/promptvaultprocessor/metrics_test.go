@@ -0,0 +1,152 @@
+package promptvaultprocessor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/otel/metric/noop"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// newTestTelemetry returns telemetry settings whose metrics can be collected
+// through the returned reader.
+func newTestTelemetry() (component.TelemetrySettings, *sdkmetric.ManualReader) {
+	reader := sdkmetric.NewManualReader()
+	return component.TelemetrySettings{
+		Logger:        zap.NewNop(),
+		MeterProvider: sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)),
+	}, reader
+}
+
+// counterValue sums all data points of the named counter.
+func counterValue(t *testing.T, reader *sdkmetric.ManualReader, name string) int64 {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("collect metrics: %v", err)
+	}
+	var total int64
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != name {
+				continue
+			}
+			sum, ok := m.Data.(metricdata.Sum[int64])
+			if !ok {
+				t.Fatalf("metric %s is not an int64 sum", name)
+			}
+			for _, dp := range sum.DataPoints {
+				total += dp.Value
+			}
+		}
+	}
+	return total
+}
+
+// rejectingVault fails to store one specific content.
+type rejectingVault struct {
+	*FilesystemVault
+	reject string
+}
+
+func (v rejectingVault) Store(content []byte) (string, error) {
+	if string(content) == v.reject {
+		return "", errors.New("backend unavailable")
+	}
+	return v.FilesystemVault.Store(content)
+}
+
+func TestLifetimeSummaryOnShutdown(t *testing.T) {
+	fsVault, _ := NewFilesystemVault(t.TempDir())
+	core, logs := observer.New(zapcore.InfoLevel)
+	set := component.TelemetrySettings{Logger: zap.New(core), MeterProvider: noop.NewMeterProvider()}
+	proc, err := newVaultProcessor(set, createDefaultConfig(), rejectingVault{fsVault, "doomed"}, new(consumertest.TracesSink))
+	if err != nil {
+		t.Fatalf("failed to create processor: %v", err)
+	}
+
+	td := ptrace.NewTraces()
+	spans := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans()
+	spans.AppendEmpty().Attributes().PutStr("gen_ai.prompt", "0123456789")
+	spans.AppendEmpty().Attributes().PutStr("gen_ai.prompt", "0123456789")
+	spans.AppendEmpty().Attributes().PutStr("gen_ai.completion", "abcde")
+	spans.AppendEmpty().Attributes().PutStr("gen_ai.completion", "doomed")
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := proc.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
+
+	entries := logs.FilterMessage("promptvault lifetime summary").All()
+	if len(entries) != 1 {
+		t.Fatalf("expected one lifetime summary, got %d", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	want := map[string]int64{
+		"offloaded_attributes": 3,
+		"offloaded_bytes":      25,
+		"dedup_hits":           1,
+		"hash_collisions":      0,
+		"store_failures":       1,
+	}
+	for name, value := range want {
+		if fields[name] != value {
+			t.Errorf("expected %s=%d, got %v", name, value, fields[name])
+		}
+	}
+}
+
+func TestLifetimeSummaryInterval(t *testing.T) {
+	fsVault, _ := NewFilesystemVault(t.TempDir())
+	core, logs := observer.New(zapcore.InfoLevel)
+	set := component.TelemetrySettings{Logger: zap.New(core), MeterProvider: noop.NewMeterProvider()}
+	cfg := createDefaultConfig()
+	cfg.SummaryInterval = 10 * time.Millisecond
+	proc, err := newVaultProcessor(set, cfg, fsVault, new(consumertest.TracesSink))
+	if err != nil {
+		t.Fatalf("failed to create processor: %v", err)
+	}
+	if err := proc.Start(context.Background(), nil); err != nil {
+		t.Fatalf("unexpected start error: %v", err)
+	}
+
+	td := ptrace.NewTraces()
+	td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty().Attributes().PutStr("gen_ai.prompt", "0123456789")
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for logs.FilterMessage("promptvault lifetime summary").Len() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected a periodic lifetime summary")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if fields := logs.FilterMessage("promptvault lifetime summary").All()[0].ContextMap(); fields["offloaded_attributes"] != int64(1) {
+		t.Errorf("expected the periodic summary to report the offload, got %v", fields)
+	}
+
+	periodic := logs.FilterMessage("promptvault lifetime summary").Len()
+	if err := proc.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
+	final := logs.FilterMessage("promptvault lifetime summary").Len()
+	if final <= periodic {
+		t.Error("expected Shutdown to log a final summary")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if n := logs.FilterMessage("promptvault lifetime summary").Len(); n != final {
+		t.Errorf("expected no summaries after shutdown, got %d more", n-final)
+	}
+}
@@ -0,0 +1,164 @@
+package promptvaultprocessor
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/consumer/consumererror"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.uber.org/zap"
+)
+
+// newMetricsProcessor creates a vault processor for a metrics pipeline.
+// Data point and exemplar attributes are vaulted like span attributes,
+// under the same keys, mode and thresholds.
+func newMetricsProcessor(
+	set component.TelemetrySettings,
+	cfg *Config,
+	vault VaultStorage,
+	next consumer.Metrics,
+) (*vaultProcessor, error) {
+	p, err := newVaultProcessor(set, cfg, vault, nil)
+	if err != nil {
+		return nil, err
+	}
+	p.nextMetrics = next
+	return p, nil
+}
+
+// ConsumeMetrics vaults the matching attributes of each data point and
+// exemplar, then forwards md. Resource and scope attributes are handled as
+// for traces. Attributes identify a metric's time series, so the canary
+// and vault.offloaded attributes are not added to data points; in
+// replace_with_ref mode each distinct vaulted value is its own series.
+func (p *vaultProcessor) ConsumeMetrics(ctx context.Context, md pmetric.Metrics) error {
+	if p.disabledFor != "" {
+		return p.nextMetrics.ConsumeMetrics(ctx, md)
+	}
+	if p.config.Vault.Rehydrate {
+		if _, err := RestoreMetricContent(md, p.vault.(VaultRetriever), p.config.Vault); err != nil {
+			p.logger.Warn("some vault references could not be rehydrated", zap.Error(err))
+		}
+		return p.nextMetrics.ConsumeMetrics(ctx, md)
+	}
+	if p.memoryPressure() {
+		p.metrics.memoryBypass.Add(ctx, int64(md.DataPointCount()))
+		return p.nextMetrics.ConsumeMetrics(ctx, md)
+	}
+
+	if !p.acquireBatch() {
+		p.metrics.rejectedBatches.Add(ctx, 1)
+		return consumererror.NewMetrics(errSaturated, md)
+	}
+	defer p.releaseBatch()
+
+	batchCtx, cancel := p.batchContext(ctx)
+	defer cancel()
+
+	gated := p.config.Vault.Consent.enabled()
+	batchConsent := !gated || p.metadataConsent(ctx)
+
+	rms := md.ResourceMetrics()
+	for i := 0; i < rms.Len(); i++ {
+		rm := rms.At(i)
+		resourceConsent := batchConsent || p.attributeConsent(rm.Resource().Attributes())
+		if len(p.resourceKeys) > 0 && resourceConsent {
+			p.vaultContainer(batchCtx, rm.Resource().Attributes(), p.resourceKeys)
+		}
+		sms := rm.ScopeMetrics()
+		for j := 0; j < sms.Len(); j++ {
+			sm := sms.At(j)
+			if len(p.scopeKeys) > 0 && resourceConsent {
+				p.vaultContainer(batchCtx, sm.Scope().Attributes(), p.scopeKeys)
+			}
+			metrics := sm.Metrics()
+			for k := 0; k < metrics.Len(); k++ {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				forEachDataPointAttributes(metrics.At(k), func(attrs pcommon.Map, traceID pcommon.TraceID, spanID pcommon.SpanID) {
+					if !resourceConsent && !p.attributeConsent(attrs) {
+						p.metrics.unconsentedSpans.Add(ctx, 1)
+						return
+					}
+					result := p.vaultAttributes(batchCtx, attrs, p.keysSet, true, traceID)
+					p.recordAudit(traceID, spanID, result.offloaded)
+				})
+			}
+		}
+	}
+	p.checkBatchTimeout(ctx, batchCtx)
+	if err := batchFailure(batchCtx); err != nil {
+		return consumererror.NewMetrics(err, md)
+	}
+	return p.nextMetrics.ConsumeMetrics(ctx, md)
+}
+
+// forEachDataPointAttributes calls fn with the attributes of every data
+// point of m, then with the filtered attributes of its exemplars, which
+// carry the trace and span they were recorded in.
+func forEachDataPointAttributes(m pmetric.Metric, fn func(attrs pcommon.Map, traceID pcommon.TraceID, spanID pcommon.SpanID)) {
+	exemplars := func(es pmetric.ExemplarSlice) {
+		for i := 0; i < es.Len(); i++ {
+			e := es.At(i)
+			fn(e.FilteredAttributes(), e.TraceID(), e.SpanID())
+		}
+	}
+	numbers := func(dps pmetric.NumberDataPointSlice) {
+		for i := 0; i < dps.Len(); i++ {
+			fn(dps.At(i).Attributes(), pcommon.TraceID{}, pcommon.SpanID{})
+			exemplars(dps.At(i).Exemplars())
+		}
+	}
+	switch m.Type() {
+	case pmetric.MetricTypeGauge:
+		numbers(m.Gauge().DataPoints())
+	case pmetric.MetricTypeSum:
+		numbers(m.Sum().DataPoints())
+	case pmetric.MetricTypeHistogram:
+		dps := m.Histogram().DataPoints()
+		for i := 0; i < dps.Len(); i++ {
+			fn(dps.At(i).Attributes(), pcommon.TraceID{}, pcommon.SpanID{})
+			exemplars(dps.At(i).Exemplars())
+		}
+	case pmetric.MetricTypeExponentialHistogram:
+		dps := m.ExponentialHistogram().DataPoints()
+		for i := 0; i < dps.Len(); i++ {
+			fn(dps.At(i).Attributes(), pcommon.TraceID{}, pcommon.SpanID{})
+			exemplars(dps.At(i).Exemplars())
+		}
+	case pmetric.MetricTypeSummary:
+		dps := m.Summary().DataPoints()
+		for i := 0; i < dps.Len(); i++ {
+			fn(dps.At(i).Attributes(), pcommon.TraceID{}, pcommon.SpanID{})
+		}
+	}
+}
+
+// RestoreMetricContent is RestoreContent for metrics: it restores the
+// resource, scope, data point and exemplar attributes of md.
+func RestoreMetricContent(md pmetric.Metrics, vault VaultRetriever, cfg VaultConfig) (restored int, err error) {
+	r := newRestorer(vault, cfg)
+	rms := md.ResourceMetrics()
+	for i := 0; i < rms.Len(); i++ {
+		rm := rms.At(i)
+		r.restore(rm.Resource().Attributes())
+		sms := rm.ScopeMetrics()
+		for j := 0; j < sms.Len(); j++ {
+			sm := sms.At(j)
+			r.restore(sm.Scope().Attributes())
+			metrics := sm.Metrics()
+			for k := 0; k < metrics.Len(); k++ {
+				forEachDataPointAttributes(metrics.At(k), func(attrs pcommon.Map, _ pcommon.TraceID, _ pcommon.SpanID) {
+					r.startBudget()
+					r.restore(attrs)
+					r.endBudget()
+				})
+			}
+		}
+	}
+	return r.restored, errors.Join(r.errs...)
+}
@@ -0,0 +1,136 @@
+package promptvaultprocessor
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/processor/processortest"
+	"go.opentelemetry.io/otel/metric/noop"
+	"go.uber.org/zap"
+)
+
+func newTestMetricsProcessor(t *testing.T, cfg *Config, vault VaultStorage, sink *consumertest.MetricsSink) *vaultProcessor {
+	t.Helper()
+	set := component.TelemetrySettings{Logger: zap.NewNop(), MeterProvider: noop.NewMeterProvider()}
+	proc, err := newMetricsProcessor(set, cfg, vault, sink)
+	if err != nil {
+		t.Fatalf("failed to create processor: %v", err)
+	}
+	return proc
+}
+
+// newTestMetrics returns a sum and a histogram whose data points, and the
+// sum's exemplar, carry a prompt.
+func newTestMetrics() pmetric.Metrics {
+	md := pmetric.NewMetrics()
+	metrics := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics()
+
+	sum := metrics.AppendEmpty()
+	sum.SetName("gen_ai.client.token.usage")
+	dp := sum.SetEmptySum().DataPoints().AppendEmpty()
+	dp.SetIntValue(42)
+	dp.Attributes().PutStr("gen_ai.prompt", "Tell me about quantum computing")
+	dp.Attributes().PutStr("gen_ai.request.model", "gpt-4")
+	ex := dp.Exemplars().AppendEmpty()
+	ex.SetTraceID(pcommon.TraceID([16]byte{1}))
+	ex.FilteredAttributes().PutStr("gen_ai.completion", "Quantum computing uses qubits...")
+
+	hist := metrics.AppendEmpty()
+	hist.SetName("gen_ai.client.operation.duration")
+	hdp := hist.SetEmptyHistogram().DataPoints().AppendEmpty()
+	hdp.Attributes().PutStr("gen_ai.prompt", "Tell me about quantum computing")
+	return md
+}
+
+func TestVaultMetricAttributes(t *testing.T) {
+	dir := t.TempDir()
+	vault, _ := NewFilesystemVault(dir)
+	sink := new(consumertest.MetricsSink)
+	proc := newTestMetricsProcessor(t, createDefaultConfig(), vault, sink)
+
+	if err := proc.ConsumeMetrics(context.Background(), newTestMetrics()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	metrics := sink.AllMetrics()[0].ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics()
+	dp := metrics.At(0).Sum().DataPoints().At(0)
+	if v, _ := dp.Attributes().Get("gen_ai.prompt"); !strings.HasPrefix(v.Str(), "vault://") {
+		t.Errorf("expected the data point prompt vaulted, got %q", v.Str())
+	}
+	if v, _ := dp.Attributes().Get("gen_ai.request.model"); v.Str() != "gpt-4" {
+		t.Errorf("expected unrelated attributes untouched, got %q", v.Str())
+	}
+	if v, _ := dp.Exemplars().At(0).FilteredAttributes().Get("gen_ai.completion"); !strings.HasPrefix(v.Str(), "vault://") {
+		t.Errorf("expected the exemplar completion vaulted, got %q", v.Str())
+	}
+	hdp := metrics.At(1).Histogram().DataPoints().At(0)
+	if v, _ := hdp.Attributes().Get("gen_ai.prompt"); !strings.HasPrefix(v.Str(), "vault://") {
+		t.Errorf("expected the histogram prompt vaulted, got %q", v.Str())
+	}
+	if files := vaultFiles(t, dir); len(files) != 2 {
+		t.Errorf("expected 2 vault objects, got %d", len(files))
+	}
+}
+
+func TestVaultMetricSizeThreshold(t *testing.T) {
+	dir := t.TempDir()
+	vault, _ := NewFilesystemVault(dir)
+	cfg := createDefaultConfig()
+	cfg.Vault.Mode = "remove"
+	cfg.Vault.SizeThreshold = 32
+	sink := new(consumertest.MetricsSink)
+	proc := newTestMetricsProcessor(t, cfg, vault, sink)
+
+	if err := proc.ConsumeMetrics(context.Background(), newTestMetrics()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dp := sink.AllMetrics()[0].ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0).Sum().DataPoints().At(0)
+	if v, _ := dp.Attributes().Get("gen_ai.prompt"); v.Str() != "Tell me about quantum computing" {
+		t.Errorf("expected the prompt under size_threshold kept, got %q", v.Str())
+	}
+	attrs := dp.Exemplars().At(0).FilteredAttributes()
+	if _, ok := attrs.Get("gen_ai.completion"); ok {
+		t.Error("expected the completion removed")
+	}
+	if _, ok := attrs.Get("gen_ai.completion.vault_ref"); !ok {
+		t.Error("expected a gen_ai.completion.vault_ref")
+	}
+}
+
+func TestRehydrateMetrics(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	sink := new(consumertest.MetricsSink)
+	proc := newTestMetricsProcessor(t, createDefaultConfig(), vault, sink)
+	if err := proc.ConsumeMetrics(context.Background(), newTestMetrics()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cfg := createDefaultConfig()
+	cfg.Vault.Rehydrate = true
+	rsink := new(consumertest.MetricsSink)
+	rproc := newTestMetricsProcessor(t, cfg, vault, rsink)
+	if err := rproc.ConsumeMetrics(context.Background(), sink.AllMetrics()[0]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dp := rsink.AllMetrics()[0].ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0).Sum().DataPoints().At(0)
+	want := newTestMetrics().ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0).Sum().DataPoints().At(0)
+	if got, want := dp.Attributes().AsRaw(), want.Attributes().AsRaw(); len(got) != len(want) || got["gen_ai.prompt"] != want["gen_ai.prompt"] {
+		t.Errorf("expected data point attributes restored, got %v", got)
+	}
+	if v, _ := dp.Exemplars().At(0).FilteredAttributes().Get("gen_ai.completion"); v.Str() != "Quantum computing uses qubits..." {
+		t.Errorf("expected the exemplar restored, got %q", v.Str())
+	}
+}
+
+func TestCreateMetricsProcessor(t *testing.T) {
+	factory := NewFactory()
+	cfg := factory.CreateDefaultConfig().(*Config)
+	cfg.Storage.Filesystem.BasePath = t.TempDir()
+	if _, err := factory.CreateMetricsProcessor(context.Background(), processortest.NewNopSettings(), cfg, consumertest.NewNop()); err != nil {
+		t.Fatalf("failed to create metrics processor: %v", err)
+	}
+}
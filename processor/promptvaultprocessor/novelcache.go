@@ -0,0 +1,123 @@
+package promptvaultprocessor
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// existsCache remembers, for offload_only_novel, the checksums of content
+// the vault reported present, so repeated values skip the existence check.
+// Entries expire ttl after the vault confirmed them, so the cache cannot
+// vouch for an object a retention sweep has removed for longer than that.
+type existsCache struct {
+	mu  sync.Mutex
+	max int
+	ttl time.Duration
+	// seen holds when each checksum was confirmed present.
+	seen map[[sha256.Size]byte]time.Time
+}
+
+// newExistsCache caches up to max checksums for ttl (0 = until evicted).
+// It returns nil, an empty cache, when max is not positive.
+func newExistsCache(max int, ttl time.Duration) *existsCache {
+	if max <= 0 {
+		return nil
+	}
+	return &existsCache{max: max, ttl: ttl, seen: make(map[[sha256.Size]byte]time.Time)}
+}
+
+// existsCacheTTL returns how long cached answers are trusted: cfg's ttl,
+// capped at the storage retention window.
+func existsCacheTTL(cfg *Config) time.Duration {
+	ttl := cfg.Vault.NovelCache.TTL
+	if days := cfg.Storage.RetentionDays; days > 0 {
+		if window := time.Duration(days) * 24 * time.Hour; ttl == 0 || ttl > window {
+			ttl = window
+		}
+	}
+	return ttl
+}
+
+func (c *existsCache) expired(at, now time.Time) bool {
+	return c.ttl > 0 && now.Sub(at) >= c.ttl
+}
+
+// has reports whether hash was confirmed present within ttl of now.
+func (c *existsCache) has(hash [sha256.Size]byte, now time.Time) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	at, ok := c.seen[hash]
+	if ok && c.expired(at, now) {
+		delete(c.seen, hash)
+		return false
+	}
+	return ok
+}
+
+// add records hash as confirmed present at at.
+func (c *existsCache) add(hash [sha256.Size]byte, at time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, cached := c.seen[hash]; !cached && len(c.seen) >= c.max {
+		// Forget expired entries first; failing that, evict an arbitrary
+		// one. Its content is checked against the vault again.
+		for k, seen := range c.seen {
+			if c.expired(seen, at) {
+				delete(c.seen, k)
+			}
+		}
+		if len(c.seen) >= c.max {
+			for k := range c.seen {
+				delete(c.seen, k)
+				break
+			}
+		}
+	}
+	c.seen[hash] = at
+}
+
+// warmNovelCache fills the novel_cache with the objects the vault stored
+// within its ttl. A failed listing only leaves the cache cold.
+func (p *vaultProcessor) warmNovelCache() {
+	now := p.now()
+	var since time.Time
+	if p.novel.ttl > 0 {
+		since = now.Add(-p.novel.ttl)
+	}
+	hashes, err := p.vault.(Lister).List(since, p.novel.max)
+	if err != nil {
+		p.logger.Warn("novel_cache warm-up failed", zap.Error(err))
+		return
+	}
+	for _, hash := range hashes {
+		p.novel.add(hash, now)
+	}
+	p.logger.Debug("novel_cache warmed", zap.Int("checksums", len(hashes)))
+}
+
+// contentExists reports, for offload_only_novel, whether the vault already
+// holds content, answering from the novel_cache when it can.
+func (p *vaultProcessor) contentExists(key string, content []byte) bool {
+	hash := sha256.Sum256(content)
+	now := p.now()
+	if p.novel.has(hash, now) {
+		return true
+	}
+	exists, err := p.vault.(ExistenceChecker).Exists(content)
+	if err != nil {
+		p.logger.Warn("vault exists check failed", zap.String("key", key), zap.Error(err))
+	}
+	if exists {
+		p.novel.add(hash, now)
+	}
+	return exists
+}
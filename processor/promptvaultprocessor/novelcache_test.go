@@ -0,0 +1,176 @@
+package promptvaultprocessor
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+// countingExistsVault counts the existence checks that reach the vault.
+type countingExistsVault struct {
+	*FilesystemVault
+	checks atomic.Int64
+}
+
+func (v *countingExistsVault) Exists(content []byte) (bool, error) {
+	v.checks.Add(1)
+	return v.FilesystemVault.Exists(content)
+}
+
+func TestVaultNovelCacheExpiry(t *testing.T) {
+	dir := t.TempDir()
+	fs, _ := NewFilesystemVault(dir)
+	vault := &countingExistsVault{FilesystemVault: fs}
+	cfg := createDefaultConfig()
+	cfg.Vault.OffloadOnlyNovel = true
+	cfg.Vault.NovelCache = NovelCacheConfig{Size: 10, TTL: time.Hour}
+	sink := new(consumertest.TracesSink)
+	proc := newTestProcessor(t, cfg, vault, sink)
+	clock := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	proc.now = func() time.Time { return clock }
+
+	const prompt = "You are a helpful assistant."
+	consume := func() string {
+		td := ptrace.NewTraces()
+		span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+		span.Attributes().PutStr("gen_ai.prompt", prompt)
+		if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		traces := sink.AllTraces()
+		v, _ := traces[len(traces)-1].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes().Get("gen_ai.prompt")
+		return v.Str()
+	}
+
+	if got := consume(); !strings.HasPrefix(got, "vault://") {
+		t.Fatalf("expected first occurrence to be offloaded, got: %s", got)
+	}
+	if got := consume(); got != prompt {
+		t.Fatalf("expected repeated content to stay inline, got: %s", got)
+	}
+	checks := vault.checks.Load()
+	if got := consume(); got != prompt {
+		t.Fatalf("expected cached content to stay inline, got: %s", got)
+	}
+	if n := vault.checks.Load(); n != checks {
+		t.Errorf("expected the cached answer to skip the vault, got %d more checks", n-checks)
+	}
+
+	// A sweep removes the object; the cache still vouches for it until
+	// the entry expires.
+	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			os.Remove(path)
+		}
+		return nil
+	})
+	clock = clock.Add(30 * time.Minute)
+	if got := consume(); got != prompt {
+		t.Errorf("expected the entry to be trusted within its ttl, got: %s", got)
+	}
+	clock = clock.Add(time.Hour)
+	got := consume()
+	if !strings.HasPrefix(got, "vault://") {
+		t.Fatalf("expected an expired entry to force a re-store, got: %s", got)
+	}
+	if data, err := fs.Retrieve(got); err != nil || string(data) != prompt {
+		t.Errorf("expected the content stored again, got %q, %v", data, err)
+	}
+}
+
+func TestExistsCacheTTL(t *testing.T) {
+	tests := []struct {
+		ttl  time.Duration
+		days int
+		want time.Duration
+	}{
+		{ttl: 0, days: 0, want: 0},
+		{ttl: time.Hour, days: 0, want: time.Hour},
+		{ttl: 0, days: 7, want: 7 * 24 * time.Hour},
+		{ttl: time.Hour, days: 7, want: time.Hour},
+		{ttl: 30 * 24 * time.Hour, days: 7, want: 7 * 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		cfg := createDefaultConfig()
+		cfg.Vault.NovelCache.TTL = tt.ttl
+		cfg.Storage.RetentionDays = tt.days
+		if got := existsCacheTTL(cfg); got != tt.want {
+			t.Errorf("ttl %v with %d retention days: got %v, want %v", tt.ttl, tt.days, got, tt.want)
+		}
+	}
+}
+
+func TestExistsCacheBound(t *testing.T) {
+	c := newExistsCache(2, time.Hour)
+	now := time.Now()
+	for i := byte(0); i < 3; i++ {
+		c.add([32]byte{i}, now)
+	}
+	if n := len(c.seen); n != 2 {
+		t.Errorf("expected 2 cached checksums, got %d", n)
+	}
+	if !c.has([32]byte{2}, now) {
+		t.Error("expected the latest checksum cached")
+	}
+	if newExistsCache(0, time.Hour).has([32]byte{2}, now) {
+		t.Error("expected a disabled cache to hold nothing")
+	}
+}
+
+func TestVaultNovelCacheWarm(t *testing.T) {
+	now := time.Now()
+	clock := now.Add(-48 * time.Hour)
+	fs, _ := NewFilesystemVault(t.TempDir(), WithClock(func() time.Time { return clock }))
+	if _, err := fs.Store([]byte("Stored two days ago, outside the ttl.")); err != nil {
+		t.Fatal(err)
+	}
+	clock = now
+	const prompt = "You are a helpful assistant."
+	if _, err := fs.Store([]byte(prompt)); err != nil {
+		t.Fatal(err)
+	}
+	vault := &countingExistsVault{FilesystemVault: fs}
+
+	cfg := createDefaultConfig()
+	cfg.Vault.OffloadOnlyNovel = true
+	cfg.Vault.NovelCache = NovelCacheConfig{Size: 10, TTL: 24 * time.Hour, Warm: true}
+	sink := new(consumertest.TracesSink)
+	proc := newTestProcessor(t, cfg, vault, sink)
+	if err := proc.Start(context.Background(), componenttest.NewNopHost()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer proc.Shutdown(context.Background())
+
+	if !proc.novel.has(sha256.Sum256([]byte(prompt)), now) {
+		t.Error("expected the recent object in the warmed cache")
+	}
+	if proc.novel.has(sha256.Sum256([]byte("Stored two days ago, outside the ttl.")), now) {
+		t.Error("expected objects older than the ttl left out")
+	}
+
+	td := ptrace.NewTraces()
+	span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+	span.Attributes().PutStr("gen_ai.prompt", prompt)
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, _ := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes().Get("gen_ai.prompt")
+	if got.Str() != prompt {
+		t.Errorf("expected stored content to stay inline, got: %s", got.Str())
+	}
+	if n := vault.checks.Load(); n != 0 {
+		t.Errorf("expected the warmed cache to answer without the vault, got %d checks", n)
+	}
+	if n := fs.DedupHits(); n != 0 {
+		t.Errorf("expected no store of existing content, got %d dedup hits", n)
+	}
+}
@@ -0,0 +1,232 @@
+package promptvaultprocessor
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// packDir holds pack files under each base path.
+const packDir = "packs"
+
+// packEntry locates a packed object: its stored bytes (raw, gzip or
+// envelope, as named) at offset in pack.
+type packEntry struct {
+	pack   string
+	Name   string `json:"name"`
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+}
+
+// Compact packs objects last used more than minAge ago and no larger than
+// maxSize bytes (as stored) into one pack file per base path, with an index
+// alongside, and removes the originals. References are not rewritten:
+// Retrieve finds packed objects through the index. Objects with their own
+// retention stay standalone so Sweep can honor their windows; packs are
+// dated by their newest object and swept as a whole once that exceeds the
+// sweep's max age.
+func (v *FilesystemVault) Compact(minAge time.Duration, maxSize int64) (packed int, err error) {
+	if err := v.loadPacks(); err != nil {
+		return 0, err
+	}
+	cutoff := v.now().Add(-minAge)
+	for _, base := range v.basePaths {
+		var candidates []string
+		err := filepath.Walk(base, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if info.IsDir() {
+				if path != base && (info.Name() == packDir || strings.HasPrefix(info.Name(), retentionDirPrefix)) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.Size() <= maxSize && info.ModTime().Before(cutoff) {
+				candidates = append(candidates, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return packed, err
+		}
+		if len(candidates) < 2 {
+			continue
+		}
+		n, err := v.pack(base, candidates)
+		packed += n
+		if err != nil {
+			return packed, err
+		}
+	}
+	return packed, nil
+}
+
+// pack writes objects into a new pack under base. The pack and its index
+// are complete before any original is removed.
+func (v *FilesystemVault) pack(base string, objects []string) (int, error) {
+	dir := filepath.Join(base, packDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return 0, fmt.Errorf("create pack dir: %w", err)
+	}
+	name := filepath.Join(dir, fmt.Sprintf("%d.pack", v.now().UnixNano()))
+
+	f, err := os.Create(name + ".tmp")
+	if err != nil {
+		return 0, fmt.Errorf("create pack: %w", err)
+	}
+	var entries []packEntry
+	var offset int64
+	var newest time.Time
+	for _, path := range objects {
+		info, err := os.Stat(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue // swept or packed meanwhile
+			}
+			f.Close()
+			return 0, err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue // swept or packed meanwhile
+			}
+			f.Close()
+			return 0, err
+		}
+		if _, err := f.Write(data); err != nil {
+			f.Close()
+			return 0, fmt.Errorf("write pack: %w", err)
+		}
+		entries = append(entries, packEntry{pack: name, Name: filepath.Base(path), Offset: offset, Length: int64(len(data))})
+		offset += int64(len(data))
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+	}
+	if err := errors.Join(f.Sync(), f.Close()); err != nil {
+		return 0, fmt.Errorf("write pack: %w", err)
+	}
+	if len(entries) == 0 {
+		return 0, os.Remove(name + ".tmp")
+	}
+
+	var index strings.Builder
+	enc := json.NewEncoder(&index)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return 0, err
+		}
+	}
+	if err := os.WriteFile(name+".idx.tmp", []byte(index.String()), 0o644); err != nil {
+		return 0, fmt.Errorf("write pack index: %w", err)
+	}
+	// Date the pack by its most recently used object, which Sweep ages it
+	// by, so packing never extends an object's retention.
+	if err := errors.Join(os.Chtimes(name+".tmp", newest, newest), os.Chtimes(name+".idx.tmp", newest, newest)); err != nil {
+		return 0, fmt.Errorf("set pack time: %w", err)
+	}
+	if err := os.Rename(name+".tmp", name); err != nil {
+		return 0, fmt.Errorf("write pack: %w", err)
+	}
+	if err := os.Rename(name+".idx.tmp", name+".idx"); err != nil {
+		return 0, fmt.Errorf("write pack index: %w", err)
+	}
+
+	// A concurrent Sweep may have dropped the indexes; they are reloaded
+	// from disk, including this pack's, on next use.
+	v.packMu.Lock()
+	if v.packs != nil {
+		for _, e := range entries {
+			v.packs[objectHash(e.Name)] = e
+		}
+	}
+	v.packMu.Unlock()
+
+	for _, path := range objects {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return len(entries), fmt.Errorf("remove packed object: %w", err)
+		}
+	}
+	return len(entries), nil
+}
+
+// loadPacks reads the indexes of existing packs once.
+func (v *FilesystemVault) loadPacks() error {
+	v.packMu.Lock()
+	defer v.packMu.Unlock()
+	if v.packs != nil {
+		return nil
+	}
+	packs := map[string]packEntry{}
+	for _, base := range v.basePaths {
+		indexes, err := filepath.Glob(filepath.Join(base, packDir, "*.pack.idx"))
+		if err != nil {
+			return err
+		}
+		for _, index := range indexes {
+			if err := readPackIndex(index, packs); err != nil {
+				return err
+			}
+		}
+	}
+	v.packs = packs
+	return nil
+}
+
+func readPackIndex(index string, into map[string]packEntry) error {
+	f, err := os.Open(index)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	pack := strings.TrimSuffix(index, ".idx")
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e packEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("read pack index %s: %w", index, err)
+		}
+		e.pack = pack
+		into[objectHash(e.Name)] = e
+	}
+	return scanner.Err()
+}
+
+// readPacked returns the stored bytes and name of a packed object.
+func (v *FilesystemVault) readPacked(hexHash string) (data []byte, name string, err error) {
+	if err := v.loadPacks(); err != nil {
+		return nil, "", err
+	}
+	v.packMu.Lock()
+	e, ok := v.packs[strings.ToLower(hexHash)]
+	v.packMu.Unlock()
+	if !ok {
+		return nil, "", os.ErrNotExist
+	}
+	f, err := os.Open(e.pack)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	data = make([]byte, e.Length)
+	if _, err := f.ReadAt(data, e.Offset); err != nil {
+		return nil, "", fmt.Errorf("read pack %s: %w", e.pack, err)
+	}
+	return data, e.Name, nil
+}
+
+// objectHash returns the hash an object file is named after.
+func objectHash(name string) string {
+	hash, _, _ := strings.Cut(name, ".")
+	return hash
+}
@@ -0,0 +1,157 @@
+package promptvaultprocessor
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFilesystemVaultCompact(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	vault, _ := NewFilesystemVault(dir, WithGzip(64), WithSecondaryChecksum(), WithClock(func() time.Time { return now }))
+
+	contents := [][]byte{
+		[]byte("What is the capital of France?"),
+		[]byte(`{"role":"user","content":"hi"}`),
+		[]byte(strings.Repeat("Tell me about quantum computing. ", 10)),
+	}
+	refs := make([]string, len(contents))
+	for i, c := range contents {
+		ref, err := vault.Store(c)
+		if err != nil {
+			t.Fatalf("store failed: %v", err)
+		}
+		refs[i] = ref
+	}
+	large := bytes.Repeat([]byte{0x00, 0x01, 0xfe, 0xff}, 2048)
+	largeRef, _ := vault.Store(large)
+
+	if packed, err := vault.Compact(time.Hour, 4096); err != nil || packed != 0 {
+		t.Fatalf("expected fresh objects to stay standalone, packed %d (%v)", packed, err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	hot := []byte("stored just now")
+	hotRef, _ := vault.Store(hot)
+	packed, err := vault.Compact(time.Hour, 4096)
+	if err != nil {
+		t.Fatalf("compact failed: %v", err)
+	}
+	if packed != len(contents) {
+		t.Errorf("expected %d objects packed, got %d", len(contents), packed)
+	}
+
+	var standalone, packs int
+	for _, f := range vaultFiles(t, dir) {
+		switch {
+		case filepath.Base(filepath.Dir(f)) == packDir:
+			if filepath.Ext(f) == ".pack" {
+				packs++
+			}
+		default:
+			standalone++
+		}
+	}
+	if packs != 1 || standalone != 2 {
+		t.Errorf("expected one pack and the large and hot objects standalone, got %d packs, %d objects", packs, standalone)
+	}
+
+	// References are unchanged and resolve from the pack, also from a
+	// vault opened afterwards that loads the pack index from disk.
+	reopened, _ := NewFilesystemVault(dir, WithSecondaryChecksum())
+	for _, v := range []*FilesystemVault{vault, reopened} {
+		for i, ref := range refs {
+			got, err := v.Retrieve(ref)
+			if err != nil || !bytes.Equal(got, contents[i]) {
+				t.Errorf("expected %s to resolve from the pack, got %q (%v)", ref, got, err)
+			}
+		}
+		if part, err := v.RetrieveRange(refs[0], 8, 3); err != nil || string(part) != "the" {
+			t.Errorf("expected range %q from the pack, got %q (%v)", "the", part, err)
+		}
+		for ref, want := range map[string][]byte{largeRef: large, hotRef: hot} {
+			if got, err := v.Retrieve(ref); err != nil || !bytes.Equal(got, want) {
+				t.Errorf("expected standalone %s to resolve, got %v", ref, err)
+			}
+		}
+	}
+
+	// Packs are swept as a whole once they age out.
+	now = now.Add(48 * time.Hour)
+	if _, _, err := vault.Sweep(24 * time.Hour); err != nil {
+		t.Fatalf("sweep failed: %v", err)
+	}
+	if _, err := vault.Retrieve(refs[0]); err == nil {
+		t.Error("expected the packed object to be gone after its pack was swept")
+	}
+}
+
+func TestFilesystemVaultCompactKeepsAge(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	vault, _ := NewFilesystemVault(dir, WithClock(func() time.Time { return now }))
+	ref, _ := vault.Store([]byte("What is the capital of France?"))
+	vault.Store([]byte("What is the capital of Spain?"))
+
+	now = now.Add(2 * time.Hour)
+	if packed, err := vault.Compact(time.Hour, 4096); err != nil || packed != 2 {
+		t.Fatalf("expected 2 objects packed, got %d (%v)", packed, err)
+	}
+	// The pack is as old as its objects, not as the compaction.
+	if _, _, err := vault.Sweep(90 * time.Minute); err != nil {
+		t.Fatalf("sweep failed: %v", err)
+	}
+	if _, err := vault.Retrieve(ref); err == nil {
+		t.Error("expected packing not to extend the objects' retention")
+	}
+}
+
+func TestFilesystemVaultCompactConcurrentSweep(t *testing.T) {
+	dir := t.TempDir()
+	var mu sync.Mutex
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	clock := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	vault, _ := NewFilesystemVault(dir, WithClock(clock))
+	var refs []string
+	for i := 0; i < 50; i++ {
+		ref, _ := vault.Store([]byte(fmt.Sprintf("prompt number %d", i)))
+		refs = append(refs, ref)
+	}
+	mu.Lock()
+	now = now.Add(2 * time.Hour)
+	mu.Unlock()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			vault.Sweep(30 * 24 * time.Hour)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			if _, err := vault.Compact(time.Hour, 4096); err != nil {
+				t.Errorf("compact failed: %v", err)
+			}
+			vault.Store([]byte(fmt.Sprintf("late prompt %d", i)))
+		}
+	}()
+	wg.Wait()
+
+	for _, ref := range refs {
+		if _, err := vault.Retrieve(ref); err != nil {
+			t.Errorf("expected %s to resolve after concurrent compaction, got %v", ref, err)
+		}
+	}
+}
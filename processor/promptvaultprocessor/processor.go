@@ -2,120 +2,582 @@ package promptvaultprocessor
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"runtime"
+	"slices"
+	"sort"
+	"time"
 
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/consumer"
 	"go.opentelemetry.io/collector/pdata/pcommon"
-	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 	"go.uber.org/zap"
 )
 
+// offloadedKey marks spans that had at least one attribute offloaded.
+const offloadedKey = "vault.offloaded"
+
 type vaultProcessor struct {
 	logger       *zap.Logger
+	metrics      *processorMetrics
+	stats        lifetimeStats
 	config       *Config
 	vault        VaultStorage
 	nextConsumer consumer.Traces
+	nextLogs     consumer.Logs
+	nextMetrics  consumer.Metrics
 	keysSet      map[string]bool
+	keyPrefixes  []string
+	keyPatterns  []string
+	keyRegexps   []*regexp.Regexp
+	valueFilter  *valueFilter
+	valueFilters map[string]*valueFilter
+	keyPriority  map[string]int
+	resourceKeys map[string]bool
+	scopeKeys    map[string]bool
+	groupOf      map[string]int
+	profiles     map[string]map[string]bool
+
+	jsonExclusions [][]string
+
+	conversationKeys map[string]bool
+	encryptKeys      map[string]bool
+	conversations    *conversationLog
+	novel            *existsCache
+	memory           *memoryGuard
+	limiter          *byteLimiter
+	flights          *storeGroup
+	async            *asyncWriter
+	inFlight         chan struct{}
+	concurrency      int
+
+	now              func() time.Time
+	destructiveAt    time.Time
+	destructiveDelay time.Duration
+
+	resolver *http.Server
+
+	auditSink AuditSink
+	audit     *auditQueue
+
+	dryRun     *dryRunReport
+	stopReport chan struct{}
+	reportDone chan struct{}
+
+	stopCompaction chan struct{}
+	compactionDone chan struct{}
+
+	stopSweep chan struct{}
+	sweepDone chan struct{}
+
+	stopSummary chan struct{}
+	summaryDone chan struct{}
+
+	// disabledFor is the environment, from DisableForEnvironments, the
+	// processor passes data through untouched for. Empty when enabled.
+	disabledFor string
+
+	// allowedSchemes are the reference schemes looked up when validating
+	// or resolving references (vault.allowed_schemes).
+	allowedSchemes map[string]bool
 }
 
 func newVaultProcessor(
-	logger *zap.Logger,
+	set component.TelemetrySettings,
 	cfg *Config,
 	vault VaultStorage,
 	next consumer.Traces,
-) *vaultProcessor {
-	keysSet := make(map[string]bool, len(cfg.Vault.Keys))
-	for _, k := range cfg.Vault.Keys {
-		keysSet[k] = true
+) (*vaultProcessor, error) {
+	if _, ok := vault.(KeyedVaultStorage); cfg.Vault.KeyedAddressing && !ok {
+		return nil, errors.New("keyed_addressing is not supported by the configured vault")
+	}
+	if _, ok := vault.(VaultRetriever); cfg.Vault.Rehydrate && !ok {
+		return nil, errors.New("rehydrate requires a vault that supports Retrieve")
+	}
+	if _, ok := vault.(VaultRetriever); cfg.Storage.VerifyAfterWrite && !ok {
+		return nil, errors.New("verify_after_write requires a vault that supports Retrieve")
+	}
+	if _, ok := vault.(ExistenceChecker); cfg.Vault.OffloadOnlyNovel && !ok {
+		return nil, errors.New("offload_only_novel requires a vault that supports Exists")
+	}
+	if _, ok := vault.(Lister); cfg.Vault.NovelCache.Warm && !ok {
+		return nil, errors.New("novel_cache warm requires a vault that supports List")
+	}
+	if _, ok := vault.(Sweeper); cfg.Storage.RetentionDays > 0 && !ok {
+		return nil, errors.New("storage.retention_days is not supported by the configured vault")
+	}
+	if _, ok := vault.(EncryptingVaultStorage); len(cfg.Crypto.Keys) > 0 && !ok {
+		return nil, errors.New("crypto.keys is not supported by the configured vault")
+	}
+	if _, ok := vault.(RefPredictor); cfg.Storage.Async.Enabled && !ok {
+		return nil, errors.New("storage.async is not supported by the configured vault")
+	}
+	if _, ok := vault.(Compactor); cfg.Storage.Filesystem.Compaction.Interval > 0 && !ok {
+		return nil, errors.New("compaction is not supported by the configured vault")
+	}
+	if _, ok := vault.(RetentionStorage); len(cfg.Vault.RetentionDays) > 0 && !ok {
+		return nil, errors.New("retention_days is not supported by the configured vault")
+	}
+	if _, ok := vault.(VaultRetriever); cfg.Vault.OnReference == "validate" && !ok {
+		return nil, errors.New("on_reference validate requires a vault that supports Retrieve")
+	}
+	if _, ok := vault.(VaultRetriever); cfg.Resolver.Enabled && !ok {
+		return nil, errors.New("resolver requires a vault that supports Retrieve")
 	}
 
-	return &vaultProcessor{
-		logger:       logger,
-		config:       cfg,
-		vault:        vault,
-		nextConsumer: next,
-		keysSet:      keysSet,
+	metrics, err := newProcessorMetrics(set.MeterProvider, vault)
+	if err != nil {
+		return nil, err
+	}
+
+	destructiveAt, destructiveDelay, err := parseDestructiveAfter(cfg.Vault.DestructiveAfter)
+	if err != nil {
+		return nil, err
+	}
+
+	var disabledFor string
+	if len(cfg.Vault.DisableForEnvironments) > 0 {
+		if env, ok := set.Resource.Attributes().Get(cfg.Vault.EnvironmentAttribute); ok &&
+			slices.Contains(cfg.Vault.DisableForEnvironments, env.AsString()) {
+			disabledFor = env.AsString()
+		}
 	}
+
+	keyRegexps := make([]*regexp.Regexp, 0, len(cfg.Vault.KeyPatterns))
+	for _, pattern := range cfg.Vault.KeyPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key_patterns entry %q: %w", pattern, err)
+		}
+		keyRegexps = append(keyRegexps, re)
+	}
+
+	valueFilter, valueFilters, err := compileValueFilters(cfg.Vault)
+	if err != nil {
+		return nil, err
+	}
+
+	concurrency := cfg.Vault.Concurrency
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+	var inFlight chan struct{}
+	if cfg.Vault.MaxInFlightBatches > 0 {
+		inFlight = make(chan struct{}, cfg.Vault.MaxInFlightBatches)
+	}
+
+	return &vaultProcessor{
+		logger:           set.Logger,
+		metrics:          metrics,
+		config:           cfg,
+		vault:            vault,
+		nextConsumer:     next,
+		keysSet:          toSet(cfg.Vault.Keys),
+		keyPrefixes:      cfg.Vault.KeyPrefixes,
+		keyPatterns:      globPatterns(cfg.Vault),
+		keyRegexps:       keyRegexps,
+		valueFilter:      valueFilter,
+		valueFilters:     valueFilters,
+		keyPriority:      priorityIndex(cfg.Vault.KeyPriority),
+		resourceKeys:     toSet(keysWhen(cfg.Vault.ResourceKeys, cfg.Vault.ScanResourceAttributes, cfg.Vault.Keys)),
+		scopeKeys:        toSet(keysWhen(cfg.Vault.ScopeKeys, cfg.Vault.ScanScopeAttributes, cfg.Vault.Keys)),
+		groupOf:          groupIndex(cfg.Vault.Groups),
+		profiles:         buildProfiles(cfg.Vault.Profiles),
+		jsonExclusions:   parseJSONPaths(cfg.Vault.JSONExclusions),
+		conversationKeys: toSet(cfg.Vault.Conversation.Keys),
+		encryptKeys:      toSet(cfg.Crypto.Keys),
+		conversations:    newConversationLog(cfg.Vault.Conversation.MaxConversations, cfg.Vault.Conversation.IdleTimeout),
+		novel:            newExistsCache(cfg.Vault.NovelCache.Size, existsCacheTTL(cfg)),
+		memory:           newMemoryGuard(cfg.Memory),
+		limiter:          newByteLimiter(cfg.Storage.MaxBytesPerSecond),
+		flights:          newStoreGroup(cfg.Storage.CollapseConcurrentStores),
+		dryRun:           newDryRunReport(cfg.DryRun),
+		inFlight:         inFlight,
+		concurrency:      concurrency,
+		now:              time.Now,
+		destructiveAt:    destructiveAt,
+		destructiveDelay: destructiveDelay,
+		disabledFor:      disabledFor,
+		allowedSchemes:   allowedSchemes(cfg.Vault.AllowedSchemes, vault),
+	}, nil
 }
 
-func (p *vaultProcessor) Start(_ context.Context, _ component.Host) error {
-	p.logger.Info("promptvault processor started",
-		zap.Int("vault_keys", len(p.keysSet)),
-		zap.String("mode", p.config.Vault.Mode),
-		zap.String("backend", p.config.Storage.Backend),
-	)
-	return nil
+// vaultContainer vaults keys in the attributes of a resource or scope,
+// which belong to no single trace.
+func (p *vaultProcessor) vaultContainer(ctx context.Context, attrs pcommon.Map, keys map[string]bool) {
+	result := p.vaultAttributes(ctx, attrs, keys, false, pcommon.TraceID{})
+	p.recordAudit(pcommon.TraceID{}, pcommon.SpanID{}, result.offloaded)
 }
 
-func (p *vaultProcessor) Shutdown(_ context.Context) error {
-	return nil
+// vaultedAttr records the reference an attribute was offloaded to.
+type vaultedAttr struct {
+	key string
+	ref string
 }
 
-func (p *vaultProcessor) Capabilities() consumer.Capabilities {
-	return consumer.Capabilities{MutatesData: true}
+// failedAttr records an attribute whose offload was attempted but did not
+// complete, and whether the store-failure policy dropped its content.
+type failedAttr struct {
+	key     string
+	err     error
+	dropped bool
 }
 
-func (p *vaultProcessor) ConsumeTraces(ctx context.Context, td ptrace.Traces) error {
-	rss := td.ResourceSpans()
-	for i := 0; i < rss.Len(); i++ {
-		ilss := rss.At(i).ScopeSpans()
-		for j := 0; j < ilss.Len(); j++ {
-			spans := ilss.At(j).Spans()
-			for k := 0; k < spans.Len(); k++ {
-				p.vaultSpan(spans.At(k))
-			}
-		}
+// offloadResult reports what offloading did with each matched attribute.
+type offloadResult struct {
+	// offloaded holds the attributes now referencing the vault, including
+	// rewritten references (on_reference: rewrite).
+	offloaded []vaultedAttr
+	// skipped holds matched keys left inline by policy: value type, size,
+	// entropy, novelty, groups, caps, dry-run or an existing reference.
+	skipped []string
+	// failed holds keys whose offload timed out, was throttled or could
+	// not be stored.
+	failed []failedAttr
+}
+
+// refs returns the reference of each offloaded key.
+func (r *offloadResult) refs() map[string]string {
+	refs := make(map[string]string, len(r.offloaded))
+	for _, v := range r.offloaded {
+		refs[v.key] = v.ref
 	}
-	return p.nextConsumer.ConsumeTraces(ctx, td)
+	return refs
 }
 
-func (p *vaultProcessor) vaultSpan(span ptrace.Span) {
-	attrs := span.Attributes()
+// merge appends other's attributes to r, their keys prefixed with prefix.
+func (r *offloadResult) merge(other offloadResult, prefix string) {
+	for _, v := range other.offloaded {
+		r.offloaded = append(r.offloaded, vaultedAttr{key: prefix + v.key, ref: v.ref})
+	}
+	for _, key := range other.skipped {
+		r.skipped = append(r.skipped, prefix+key)
+	}
+	for _, f := range other.failed {
+		f.key = prefix + f.key
+		r.failed = append(r.failed, f)
+	}
+}
+
+// dropped returns the failed attributes whose content was dropped.
+func (r offloadResult) dropped() []failedAttr {
+	var dropped []failedAttr
+	for _, f := range r.failed {
+		if f.dropped {
+			dropped = append(dropped, f)
+		}
+	}
+	return dropped
+}
 
+// vaultAttributes offloads the values of attrs whose key is in keys (see
+// matchesKey) or, with byPattern, matches key_prefixes or key_patterns, and
+// reports what it did with each. traceID scopes conversation tracking for
+// trace-scoped conversations.
+func (p *vaultProcessor) vaultAttributes(ctx context.Context, attrs pcommon.Map, keys map[string]bool, byPattern bool, traceID pcommon.TraceID) (result offloadResult) {
 	// Collect keys to vault (can't modify map while iterating)
 	type vaultEntry struct {
-		key     string
-		content string
+		key         string
+		content     []byte
+		contentType string
+		group       int
 	}
 	var toVault []vaultEntry
+	var existingRefs []vaultedAttr
+	groupSize := map[int]int{}
 
 	attrs.Range(func(key string, val pcommon.Value) bool {
-		if !p.keysSet[key] {
+		if !p.matches(attrs, keys, byPattern, key) {
+			if p.dryRun != nil {
+				p.dryRun.observeUnmatched(key, valueSize(val))
+			}
+			return true
+		}
+
+		// String, bytes, map and slice values are offloaded; scalars pass
+		// through. Bytes (images, audio) are stored as-is and tagged binary;
+		// maps and slices (structured messages) are stored as JSON and
+		// tagged with their type.
+		var content []byte
+		var contentType string
+		if val.Type() == pcommon.ValueTypeStr && isVaultRef(val.Str()) {
+			// Already a reference: storing it again would only churn.
+			if p.config.Vault.OnReference != "skip" {
+				existingRefs = append(existingRefs, vaultedAttr{key: key, ref: val.Str()})
+			} else {
+				result.skipped = append(result.skipped, key)
+			}
 			return true
 		}
 
-		content := val.Str()
-		if len(content) < p.config.Vault.SizeThreshold {
+		switch val.Type() {
+		case pcommon.ValueTypeStr:
+			content = canonicalizeJSON([]byte(val.Str()), p.jsonExclusions)
+		case pcommon.ValueTypeBytes:
+			content = val.Bytes().AsRaw()
+			contentType = contentTypeBinary
+		case pcommon.ValueTypeMap, pcommon.ValueTypeSlice:
+			encoded, err := json.Marshal(val.AsRaw())
+			if err != nil {
+				p.metrics.encodeFailures.Add(ctx, 1,
+					metric.WithAttributes(attribute.String("value_type", val.Type().String())))
+				if p.config.Vault.OnEncodeFailure == "fail" {
+					countFailure(ctx, true)
+				}
+				if p.config.Vault.OnEncodeFailure != "string" {
+					p.logger.Debug("skipping unencodable value", zap.String("key", key), zap.Error(err))
+					result.skipped = append(result.skipped, key)
+					return true
+				}
+				if encoded, err = json.Marshal(finiteJSON(val.AsRaw())); err != nil {
+					result.skipped = append(result.skipped, key)
+					return true
+				}
+				content = encoded
+				break
+			}
+			content = canonicalizeJSON(encoded, p.jsonExclusions)
+			contentType = contentTypeMap
+			if val.Type() == pcommon.ValueTypeSlice {
+				contentType = contentTypeSlice
+			}
+		default:
+			p.logger.Debug("skipping unsupported value type",
+				zap.String("key", key),
+				zap.String("type", val.Type().String()),
+			)
+			p.metrics.unsupportedValueType.Add(ctx, 1,
+				metric.WithAttributes(attribute.String("value_type", val.Type().String())))
+			result.skipped = append(result.skipped, key)
+			return true
+		}
+		if p.dryRun != nil {
+			p.dryRun.observeMatched(key, len(content))
+		}
+
+		if threshold := p.config.Vault.MinEntropy; threshold > 0 && byteEntropy(content) < threshold {
+			result.skipped = append(result.skipped, key)
 			return true
 		}
+		if f := p.valueFilterFor(key); f != nil && !f.allows(content) {
+			result.skipped = append(result.skipped, key)
+			return true
+		}
+
+		group, grouped := p.groupOf[key]
+		if !grouped {
+			group = -1
+			if len(content) < p.sizeThreshold(key) {
+				result.skipped = append(result.skipped, key)
+				return true
+			}
+		}
+
+		if p.config.Vault.OffloadOnlyNovel {
+			if p.contentExists(key, content) {
+				result.skipped = append(result.skipped, key)
+				return true
+			}
+		}
 
-		toVault = append(toVault, vaultEntry{key: key, content: content})
+		if grouped {
+			groupSize[group] += len(content)
+		}
+		toVault = append(toVault, vaultEntry{key: key, content: content, contentType: contentType, group: group})
 		return true
 	})
 
-	for _, entry := range toVault {
-		ref, err := p.vault.Store([]byte(entry.content))
+	// Grouped keys are vaulted together only when the group as a whole
+	// reaches its threshold.
+	if len(groupSize) > 0 {
+		kept := toVault[:0]
+		for _, entry := range toVault {
+			if entry.group < 0 || groupSize[entry.group] >= p.groupThreshold(entry.group) {
+				kept = append(kept, entry)
+			} else {
+				result.skipped = append(result.skipped, entry.key)
+			}
+		}
+		toVault = kept
+	}
+
+	// Offload the most sensitive keys first so that caps (and the batch
+	// time and byte limits) leave the least sensitive content inline.
+	if len(p.keyPriority) > 0 {
+		sort.SliceStable(toVault, func(i, j int) bool {
+			return p.priority(toVault[i].key) < p.priority(toVault[j].key)
+		})
+	}
+	if limit := p.config.Vault.MaxOffloadsPerSpan; limit > 0 && len(toVault) > limit {
+		for _, entry := range toVault[limit:] {
+			result.skipped = append(result.skipped, entry.key)
+		}
+		toVault = toVault[:limit]
+	}
+
+	if p.dryRun != nil {
+		for _, entry := range toVault {
+			p.dryRun.observeOffload(len(entry.content))
+			result.skipped = append(result.skipped, entry.key)
+		}
+		return result
+	}
+
+	var conversationID string
+	if len(toVault) > 0 && len(p.conversationKeys) > 0 {
+		if v, ok := attrs.Get(p.config.Vault.Conversation.IDAttribute); ok {
+			conversationID = v.AsString()
+		} else if p.config.Vault.Conversation.TraceScoped && !traceID.IsEmpty() {
+			conversationID = "trace:" + traceID.String()
+		}
+	}
+
+	mode := p.effectiveMode()
+	failed := func(key string, err error) {
+		result.failed = append(result.failed, failedAttr{key: key, err: err, dropped: p.dropOnFailure(ctx, attrs, mode, key)})
+	}
+	for _, existing := range existingRefs {
+		if p.applyReferencePolicy(ctx, attrs, mode, existing) {
+			result.offloaded = append(result.offloaded, existing)
+		} else {
+			result.skipped = append(result.skipped, existing.key)
+		}
+	}
+
+	// With bundling, text values are stored together as one JSON object
+	// and each key's reference selects its field. Binary, conversation,
+	// per-key retention and per-key encrypted values are still stored on
+	// their own.
+	var bundle, bundleRefs map[string]string
+	var bundleErr error
+	if p.config.Vault.Bundle {
+		bundle = map[string]string{}
+		for _, entry := range toVault {
+			if entry.contentType == "" && (conversationID == "" || !p.conversationKeys[entry.key]) && p.config.Vault.RetentionDays[entry.key] == 0 && !p.encryptKeys[entry.key] {
+				bundle[entry.key] = string(entry.content)
+			}
+		}
+		if len(bundle) > 0 {
+			bundleRefs, bundleErr = p.storeBundle(ctx, bundle)
+		}
+	}
+
+	// Entries are stored up to vault.concurrency at a time; the attributes
+	// are rewritten afterwards, in order, so results do not depend on which
+	// store finishes first.
+	storeEntry := func(entry vaultEntry) (string, error) {
+		if _, ok := bundle[entry.key]; ok {
+			return bundleRefs[entry.key], bundleErr
+		}
+		if err := ctx.Err(); err != nil {
+			p.metrics.skippedAttributes.Add(context.WithoutCancel(ctx), 1)
+			return "", err
+		}
+		if p.limiter != nil && !p.limiter.allow(len(entry.content)) {
+			p.metrics.throttledAttributes.Add(ctx, 1)
+			return "", errThrottled
+		}
+		var ref string
+		var err error
+		conversational := conversationID != "" && p.conversationKeys[entry.key]
+		if conversational {
+			// A delta names the previous turn, which must already be
+			// stored, so turns are never written asynchronously.
+			var touch func(ref string) error
+			if toucher, ok := p.vault.(Toucher); ok {
+				touch = toucher.Touch
+			}
+			ref, err = p.conversations.store(func(object []byte) (string, error) {
+				return p.storeNow(ctx, entry.key, object, entry.contentType)
+			}, touch, conversationID, entry.key, entry.content)
+		} else {
+			ref, err = p.store(ctx, entry.key, entry.content, entry.contentType)
+		}
+		if err == nil && p.config.Storage.VerifyAfterWrite {
+			err = p.verify(ctx, ref, entry.content, conversational)
+		}
 		if err != nil {
+			p.stats.storeFailures.Add(1)
 			p.logger.Warn("vault store failed",
 				zap.String("key", entry.key),
 				zap.Error(err),
 			)
+		}
+		return ref, err
+	}
+	refs := make([]string, len(toVault))
+	errs := make([]error, len(toVault))
+	var parallel []int
+	for i, entry := range toVault {
+		// Conversation turns build on each other, so they are stored in
+		// order.
+		if conversationID != "" && p.conversationKeys[entry.key] {
+			refs[i], errs[i] = storeEntry(entry)
+		} else {
+			parallel = append(parallel, i)
+		}
+	}
+	p.storeConcurrently(len(parallel), func(i int) {
+		refs[parallel[i]], errs[parallel[i]] = storeEntry(toVault[parallel[i]])
+	})
+
+	for i, entry := range toVault {
+		ref := refs[i]
+		if err := errs[i]; err != nil {
+			failed(entry.key, err)
 			continue
 		}
 
-		switch p.config.Vault.Mode {
+		switch mode {
 		case "replace_with_ref":
-			attrs.PutStr(entry.key, ref)
-			attrs.PutStr(entry.key+".vault_ref", ref)
+			attrs.PutStr(entry.key, p.primaryRef(ref))
+			attrs.PutStr(p.refKey(entry.key), ref)
 		case "remove":
 			attrs.Remove(entry.key)
-			attrs.PutStr(entry.key+".vault_ref", ref)
+			attrs.PutStr(p.refKey(entry.key), ref)
+		case "keep_and_ref":
+			attrs.PutStr(p.refKey(entry.key), ref)
+		case "sidecar":
+			sidecar, err := p.sidecar(entry.content)
+			if err != nil {
+				p.logger.Warn("sidecar compression failed",
+					zap.String("key", entry.key),
+					zap.Error(err),
+				)
+				result.failed = append(result.failed, failedAttr{key: entry.key, err: err})
+				continue
+			}
+			attrs.PutStr(entry.key, p.primaryRef(ref))
+			attrs.PutStr(p.refKey(entry.key), ref)
+			attrs.PutEmptyBytes(entry.key + p.config.Vault.Sidecar.Suffix).FromRaw(sidecar)
 		}
 
+		result.offloaded = append(result.offloaded, vaultedAttr{key: entry.key, ref: ref})
+		p.stats.offloadedAttributes.Add(1)
+		p.stats.offloadedBytes.Add(int64(len(entry.content)))
+
 		p.logger.Debug("vaulted attribute",
 			zap.String("key", entry.key),
 			zap.String("ref", ref),
 			zap.Int("content_bytes", len(entry.content)),
 		)
 	}
-}
\ No newline at end of file
+	return result
+}
+
+// valueSize returns the size of a string or bytes value, 0 for other types.
+func valueSize(val pcommon.Value) int {
+	switch val.Type() {
+	case pcommon.ValueTypeStr:
+		return len(val.Str())
+	case pcommon.ValueTypeBytes:
+		return val.Bytes().Len()
+	}
+	return 0
+}
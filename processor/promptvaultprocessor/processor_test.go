@@ -1,17 +1,43 @@
 package promptvaultprocessor
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/consumer/consumererror"
 	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/pcommon"
 	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/otel/metric/noop"
 	"go.uber.org/zap"
 )
 
+// newTestProcessor builds a processor with no-op telemetry.
+func newTestProcessor(t *testing.T, cfg *Config, vault VaultStorage, next consumer.Traces) *vaultProcessor {
+	t.Helper()
+	set := component.TelemetrySettings{Logger: zap.NewNop(), MeterProvider: noop.NewMeterProvider()}
+	proc, err := newVaultProcessor(set, cfg, vault, next)
+	if err != nil {
+		t.Fatalf("failed to create processor: %v", err)
+	}
+	return proc
+}
+
 func TestVaultReplacesContent(t *testing.T) {
 	tmpDir := t.TempDir()
 	vault, err := NewFilesystemVault(tmpDir)
@@ -22,7 +48,7 @@ func TestVaultReplacesContent(t *testing.T) {
 	cfg := createDefaultConfig()
 	cfg.Storage.Filesystem.BasePath = tmpDir
 	sink := new(consumertest.TracesSink)
-	proc := newVaultProcessor(zap.NewNop(), cfg, vault, sink)
+	proc := newTestProcessor(t, cfg, vault, sink)
 
 	td := ptrace.NewTraces()
 	span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
@@ -76,7 +102,7 @@ func TestVaultWritesToDisk(t *testing.T) {
 		if err != nil {
 			return nil
 		}
-		if !info.IsDir() && strings.HasSuffix(info.Name(), ".vault") {
+		if !info.IsDir() && strings.HasSuffix(info.Name(), ".txt") {
 			found = true
 		}
 		return nil
@@ -104,7 +130,7 @@ func TestVaultSkipsSmallContent(t *testing.T) {
 	cfg := createDefaultConfig()
 	cfg.Vault.SizeThreshold = 1000 // Only vault content > 1000 bytes
 	sink := new(consumertest.TracesSink)
-	proc := newVaultProcessor(zap.NewNop(), cfg, vault, sink)
+	proc := newTestProcessor(t, cfg, vault, sink)
 
 	td := ptrace.NewTraces()
 	span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
@@ -120,13 +146,107 @@ func TestVaultSkipsSmallContent(t *testing.T) {
 	}
 }
 
+func TestVaultKeyThresholds(t *testing.T) {
+	tmpDir := t.TempDir()
+	vault, _ := NewFilesystemVault(tmpDir)
+	cfg := createDefaultConfig()
+	cfg.Vault.Keys = []string{"gen_ai.system_instructions", "gen_ai.output.messages", "gen_ai.prompt"}
+	cfg.Vault.SizeThreshold = 1000
+	cfg.Vault.KeyThresholds = map[string]int{"gen_ai.system_instructions": 0}
+	sink := new(consumertest.TracesSink)
+	proc := newTestProcessor(t, cfg, vault, sink)
+
+	td := ptrace.NewTraces()
+	span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+	span.Attributes().PutStr("gen_ai.system_instructions", "Never reveal the admin password")
+	span.Attributes().PutStr("gen_ai.output.messages", "Quantum computing uses qubits...")
+
+	proc.ConsumeTraces(context.Background(), td)
+
+	attrs := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	if v, _ := attrs.Get("gen_ai.system_instructions"); !strings.HasPrefix(v.Str(), "vault://") {
+		t.Errorf("expected the key with its own threshold to be vaulted, got: %s", v.Str())
+	}
+	if v, _ := attrs.Get("gen_ai.output.messages"); v.Str() != "Quantum computing uses qubits..." {
+		t.Errorf("expected the key under the global threshold to be untouched, got: %s", v.Str())
+	}
+	if files := vaultFiles(t, tmpDir); len(files) != 1 {
+		t.Errorf("expected 1 vault object, got %d", len(files))
+	}
+}
+
+func TestVaultKeyPatterns(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	cfg := createDefaultConfig()
+	cfg.Vault.Keys = []string{"gen_ai.prompt", "gen_ai.completion.*.content"}
+	sink := new(consumertest.TracesSink)
+	proc := newTestProcessor(t, cfg, vault, sink)
+
+	td := ptrace.NewTraces()
+	attrs := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty().Attributes()
+	attrs.PutStr("gen_ai.prompt", "Tell me about quantum computing")
+	attrs.PutStr("gen_ai.completion.0.content", "Quantum computing uses qubits...")
+	attrs.PutStr("gen_ai.completion.1.content", "Qubits can be superposed...")
+	attrs.PutStr("gen_ai.completion.0.role", "assistant")
+	attrs.PutStr("gen_ai.completion.0.finish_reason", "stop")
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	for _, key := range []string{"gen_ai.prompt", "gen_ai.completion.0.content", "gen_ai.completion.1.content"} {
+		if v, _ := got.Get(key); !strings.HasPrefix(v.Str(), "vault://") {
+			t.Errorf("expected %s vaulted, got %q", key, v.Str())
+		}
+	}
+	for key, want := range map[string]string{"gen_ai.completion.0.role": "assistant", "gen_ai.completion.0.finish_reason": "stop"} {
+		if v, _ := got.Get(key); v.Str() != want {
+			t.Errorf("expected %s untouched, got %q", key, v.Str())
+		}
+	}
+}
+
+func TestVaultKeyRegexps(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	cfg := createDefaultConfig()
+	cfg.Vault.Keys = nil
+	cfg.Vault.KeyPatterns = []string{`^gen_ai\.messages\.\d+\.content$`}
+	sink := new(consumertest.TracesSink)
+	proc := newTestProcessor(t, cfg, vault, sink)
+
+	td := ptrace.NewTraces()
+	attrs := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty().Attributes()
+	messages := []string{"You are a helpful assistant.", "Tell me about quantum computing", "Quantum computing uses qubits..."}
+	for i, content := range messages {
+		attrs.PutStr(fmt.Sprintf("gen_ai.messages.%d.content", i), content)
+		attrs.PutStr(fmt.Sprintf("gen_ai.messages.%d.role", i), "user")
+	}
+	attrs.PutStr("gen_ai.messages.last.content", "not numbered")
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	for i := range messages {
+		if v, _ := got.Get(fmt.Sprintf("gen_ai.messages.%d.content", i)); !strings.HasPrefix(v.Str(), "vault://") {
+			t.Errorf("expected message %d vaulted, got %q", i, v.Str())
+		}
+		if v, _ := got.Get(fmt.Sprintf("gen_ai.messages.%d.role", i)); v.Str() != "user" {
+			t.Errorf("expected role %d untouched, got %q", i, v.Str())
+		}
+	}
+	if v, _ := got.Get("gen_ai.messages.last.content"); v.Str() != "not numbered" {
+		t.Errorf("expected a non-matching key untouched, got %q", v.Str())
+	}
+}
+
 func TestVaultRemoveMode(t *testing.T) {
 	tmpDir := t.TempDir()
 	vault, _ := NewFilesystemVault(tmpDir)
 	cfg := createDefaultConfig()
 	cfg.Vault.Mode = "remove"
 	sink := new(consumertest.TracesSink)
-	proc := newVaultProcessor(zap.NewNop(), cfg, vault, sink)
+	proc := newTestProcessor(t, cfg, vault, sink)
 
 	td := ptrace.NewTraces()
 	span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
@@ -163,4 +283,1672 @@ func TestVaultRetrieve(t *testing.T) {
 	if string(data) != original {
 		t.Errorf("expected %q, got %q", original, string(data))
 	}
-}
\ No newline at end of file
+}
+
+func TestVaultResourceAndScopeKeys(t *testing.T) {
+	tmpDir := t.TempDir()
+	vault, _ := NewFilesystemVault(tmpDir)
+	cfg := createDefaultConfig()
+	sink := new(consumertest.TracesSink)
+	proc := newTestProcessor(t, cfg, vault, sink)
+
+	td := ptrace.NewTraces()
+	rs := td.ResourceSpans().AppendEmpty()
+	rs.Resource().Attributes().PutStr("gen_ai.system_instructions", "resource level instructions")
+	ss := rs.ScopeSpans().AppendEmpty()
+	ss.Scope().Attributes().PutStr("gen_ai.prompt", "scope level prompt")
+	span := ss.Spans().AppendEmpty()
+	span.Attributes().PutStr("gen_ai.system_instructions", "span level instructions")
+
+	proc.ConsumeTraces(context.Background(), td)
+
+	out := sink.AllTraces()[0].ResourceSpans().At(0)
+	resAttr, _ := out.Resource().Attributes().Get("gen_ai.system_instructions")
+	if resAttr.Str() != "resource level instructions" {
+		t.Errorf("expected resource attribute untouched without resource_keys, got: %s", resAttr.Str())
+	}
+	scopeAttr, _ := out.ScopeSpans().At(0).Scope().Attributes().Get("gen_ai.prompt")
+	if scopeAttr.Str() != "scope level prompt" {
+		t.Errorf("expected scope attribute untouched without scope_keys, got: %s", scopeAttr.Str())
+	}
+	spanAttr, _ := out.ScopeSpans().At(0).Spans().At(0).Attributes().Get("gen_ai.system_instructions")
+	if !strings.HasPrefix(spanAttr.Str(), "vault://") {
+		t.Errorf("expected span attribute to be vaulted, got: %s", spanAttr.Str())
+	}
+
+	// Once configured, resource and scope keys are vaulted independently.
+	cfg.Vault.ResourceKeys = []string{"gen_ai.system_instructions"}
+	cfg.Vault.ScopeKeys = []string{"gen_ai.prompt"}
+	sink.Reset()
+	proc = newTestProcessor(t, cfg, vault, sink)
+
+	td = ptrace.NewTraces()
+	rs = td.ResourceSpans().AppendEmpty()
+	rs.Resource().Attributes().PutStr("gen_ai.system_instructions", "resource level instructions")
+	rs.ScopeSpans().AppendEmpty().Scope().Attributes().PutStr("gen_ai.prompt", "scope level prompt")
+
+	proc.ConsumeTraces(context.Background(), td)
+
+	out = sink.AllTraces()[0].ResourceSpans().At(0)
+	resAttr, _ = out.Resource().Attributes().Get("gen_ai.system_instructions")
+	if !strings.HasPrefix(resAttr.Str(), "vault://") {
+		t.Errorf("expected resource attribute to be vaulted, got: %s", resAttr.Str())
+	}
+	scopeAttr, _ = out.ScopeSpans().At(0).Scope().Attributes().Get("gen_ai.prompt")
+	if !strings.HasPrefix(scopeAttr.Str(), "vault://") {
+		t.Errorf("expected scope attribute to be vaulted, got: %s", scopeAttr.Str())
+	}
+}
+
+func TestVaultScanResourceAndScopeAttributes(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	cfg := createDefaultConfig()
+	cfg.Vault.ScanResourceAttributes = true
+	cfg.Vault.ScanScopeAttributes = true
+	cfg.Vault.ResourceKeys = []string{"app.prompt_template"}
+	sink := new(consumertest.TracesSink)
+	proc := newTestProcessor(t, cfg, vault, sink)
+
+	td := ptrace.NewTraces()
+	rs := td.ResourceSpans().AppendEmpty()
+	rs.Resource().Attributes().PutStr("gen_ai.system_instructions", "resource level instructions")
+	rs.Resource().Attributes().PutStr("app.prompt_template", "Answer as a pirate")
+	rs.Resource().Attributes().PutStr("service.name", "chatbot")
+	ss := rs.ScopeSpans().AppendEmpty()
+	ss.Scope().Attributes().PutStr("gen_ai.prompt", "scope level prompt")
+	ss.Spans().AppendEmpty().Attributes().PutStr("gen_ai.prompt", "span level prompt")
+
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	out := sink.AllTraces()[0].ResourceSpans().At(0)
+	for _, key := range []string{"gen_ai.system_instructions", "app.prompt_template"} {
+		if v, _ := out.Resource().Attributes().Get(key); !strings.HasPrefix(v.Str(), "vault://") {
+			t.Errorf("expected resource attribute %s vaulted, got %q", key, v.Str())
+		}
+	}
+	if v, _ := out.Resource().Attributes().Get("service.name"); v.Str() != "chatbot" {
+		t.Errorf("expected unmatched resource attributes untouched, got %q", v.Str())
+	}
+	if v, _ := out.ScopeSpans().At(0).Scope().Attributes().Get("gen_ai.prompt"); !strings.HasPrefix(v.Str(), "vault://") {
+		t.Errorf("expected the scope attribute vaulted, got %q", v.Str())
+	}
+	if len(cfg.Vault.ResourceKeys) != 1 {
+		t.Errorf("expected the configured resource_keys unchanged, got %v", cfg.Vault.ResourceKeys)
+	}
+}
+
+func TestVaultValueTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		set     func(v pcommon.Value)
+		offload bool
+	}{
+		{name: "Empty", set: func(pcommon.Value) {}},
+		{name: "Str", set: func(v pcommon.Value) { v.SetStr("a prompt") }, offload: true},
+		{name: "Int", set: func(v pcommon.Value) { v.SetInt(42) }},
+		{name: "Double", set: func(v pcommon.Value) { v.SetDouble(4.2) }},
+		{name: "Bool", set: func(v pcommon.Value) { v.SetBool(true) }},
+		{name: "Map", set: func(v pcommon.Value) { v.SetEmptyMap().PutStr("role", "user") }, offload: true},
+		{name: "Slice", set: func(v pcommon.Value) { v.SetEmptySlice().AppendEmpty().SetStr("hi") }, offload: true},
+		{name: "Bytes", set: func(v pcommon.Value) { v.SetEmptyBytes().FromRaw([]byte{0x01, 0x02}) }, offload: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vault, _ := NewFilesystemVault(t.TempDir())
+			set, reader := newTestTelemetry()
+			sink := new(consumertest.TracesSink)
+			proc, err := newVaultProcessor(set, createDefaultConfig(), vault, sink)
+			if err != nil {
+				t.Fatalf("failed to create processor: %v", err)
+			}
+
+			td := ptrace.NewTraces()
+			span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+			tt.set(span.Attributes().PutEmpty("gen_ai.prompt"))
+			want := pcommon.NewValueEmpty()
+			tt.set(want)
+
+			if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			attrs := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+			got, _ := attrs.Get("gen_ai.prompt")
+			_, hasRef := attrs.Get("gen_ai.prompt.vault_ref")
+			skipped := counterValue(t, reader, "processor_promptvault_unsupported_value_type")
+
+			if tt.offload {
+				if !hasRef || !strings.HasPrefix(got.Str(), "vault://") {
+					t.Errorf("expected %s value to be offloaded, got: %s", tt.name, got.AsString())
+				}
+				if skipped != 0 {
+					t.Errorf("expected no unsupported_value_type increments, got %d", skipped)
+				}
+				return
+			}
+			if hasRef {
+				t.Errorf("expected %s value not to be offloaded", tt.name)
+			}
+			if got.Type() != want.Type() || got.AsString() != want.AsString() {
+				t.Errorf("expected %s value to pass through unchanged, got: %s", tt.name, got.AsString())
+			}
+			if skipped != 1 {
+				t.Errorf("expected unsupported_value_type to be 1, got %d", skipped)
+			}
+		})
+	}
+}
+
+func TestVaultEncodeFailure(t *testing.T) {
+	for _, policy := range []string{"keep", "string", "fail"} {
+		t.Run(policy, func(t *testing.T) {
+			vault, _ := NewFilesystemVault(t.TempDir())
+			cfg := createDefaultConfig()
+			cfg.Vault.OnEncodeFailure = policy
+			set, reader := newTestTelemetry()
+			sink := new(consumertest.TracesSink)
+			proc, err := newVaultProcessor(set, cfg, vault, sink)
+			if err != nil {
+				t.Fatalf("failed to create processor: %v", err)
+			}
+
+			td := ptrace.NewTraces()
+			span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+			messages := span.Attributes().PutEmptySlice("gen_ai.prompt")
+			messages.AppendEmpty().SetStr("hello")
+			messages.AppendEmpty().SetDouble(math.NaN())
+			messages.AppendEmpty().SetStr("bad \xff utf-8")
+			span.Attributes().PutStr("gen_ai.completion", "Quantum computing uses qubits...")
+
+			err = proc.ConsumeTraces(context.Background(), td)
+			if policy == "fail" {
+				if !errors.Is(err, errEncodeFailed) || !consumererror.IsPermanent(err) {
+					t.Fatalf("expected a permanent encode failure, got %v", err)
+				}
+				if len(sink.AllTraces()) != 0 {
+					t.Error("expected the batch not to be forwarded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			attrs := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+			if n := counterValue(t, reader, "processor_promptvault_encode_failures"); n != 1 {
+				t.Errorf("expected 1 encode failure, got %d", n)
+			}
+			if v, _ := attrs.Get("gen_ai.completion"); !strings.HasPrefix(v.Str(), "vault://") {
+				t.Errorf("expected the other attribute offloaded, got %q", v.Str())
+			}
+			got, _ := attrs.Get("gen_ai.prompt")
+			ref, hasRef := attrs.Get("gen_ai.prompt.vault_ref")
+			if policy == "keep" {
+				if hasRef || got.Type() != pcommon.ValueTypeSlice || got.Slice().Len() != 3 || !math.IsNaN(got.Slice().At(1).Double()) {
+					t.Errorf("expected the slice left inline, got %v", got.AsRaw())
+				}
+				return
+			}
+			if !hasRef {
+				t.Fatalf("expected the string form to be offloaded, got %v", got.AsRaw())
+			}
+			data, err := vault.Retrieve(ref.Str())
+			if err != nil {
+				t.Fatalf("retrieve failed: %v", err)
+			}
+			if want := `["hello","NaN","bad ` + "\uFFFD" + ` utf-8"]`; string(data) != want {
+				t.Errorf("expected %s, got %s", want, data)
+			}
+		})
+	}
+}
+
+func TestVaultInvalidUTF8Slice(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	cfg := createDefaultConfig()
+	cfg.Vault.OnEncodeFailure = "fail"
+	set, reader := newTestTelemetry()
+	sink := new(consumertest.TracesSink)
+	proc, err := newVaultProcessor(set, cfg, vault, sink)
+	if err != nil {
+		t.Fatalf("failed to create processor: %v", err)
+	}
+
+	td := ptrace.NewTraces()
+	span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+	span.SetName("chat")
+	messages := span.Attributes().PutEmptySlice("gen_ai.prompt")
+	messages.AppendEmpty().SetStr("hello")
+	messages.AppendEmpty().SetStr("bad \xff utf-8")
+	span.Attributes().PutInt("gen_ai.usage.input_tokens", 12)
+
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("invalid UTF-8 should not fail the batch: %v", err)
+	}
+	if n := counterValue(t, reader, "processor_promptvault_encode_failures"); n != 0 {
+		t.Errorf("expected no encode failures, got %d", n)
+	}
+
+	out := sink.AllTraces()[0]
+	got := out.ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0)
+	if got.Name() != "chat" {
+		t.Errorf("span name changed to %q", got.Name())
+	}
+	if v, ok := got.Attributes().Get("gen_ai.usage.input_tokens"); !ok || v.Int() != 12 {
+		t.Errorf("expected other attributes untouched, got %v", got.Attributes().AsRaw())
+	}
+	if _, ok := got.Attributes().Get("gen_ai.prompt.vault_ref"); !ok {
+		t.Fatalf("expected the slice to be offloaded, got %v", got.Attributes().AsRaw())
+	}
+
+	if _, err := RestoreContent(out, vault, cfg.Vault); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	restored, _ := got.Attributes().Get("gen_ai.prompt")
+	if restored.Type() != pcommon.ValueTypeSlice || restored.Slice().Len() != 2 {
+		t.Fatalf("expected a 2-element slice back, got %v", restored.AsRaw())
+	}
+	if s := restored.Slice().At(1).Str(); s != "bad \uFFFD utf-8" {
+		t.Errorf("expected the invalid byte replaced, got %q", s)
+	}
+}
+
+func TestVaultRefNamespace(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	cfg := createDefaultConfig()
+	cfg.Vault.RefNamespace = "vault."
+	cfg.Vault.RefSuffix = ""
+	sink := new(consumertest.TracesSink)
+	proc := newTestProcessor(t, cfg, vault, sink)
+
+	td := ptrace.NewTraces()
+	span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+	span.Attributes().PutStr("gen_ai.prompt", "Tell me about quantum computing")
+	span.Attributes().PutStr("gen_ai.completion", "Quantum computing uses qubits...")
+
+	proc.ConsumeTraces(context.Background(), td)
+
+	attrs := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+
+	for _, key := range []string{"gen_ai.prompt", "gen_ai.completion"} {
+		ref, ok := attrs.Get("vault." + key)
+		if !ok || !strings.HasPrefix(ref.Str(), "vault://") {
+			t.Errorf("expected reference under vault.%s, got: %v", key, ref.AsString())
+		}
+	}
+
+	attrs.Range(func(key string, _ pcommon.Value) bool {
+		if strings.HasPrefix(key, "gen_ai.") && key != "gen_ai.prompt" && key != "gen_ai.completion" {
+			t.Errorf("unexpected attribute in gen_ai namespace: %s", key)
+		}
+		return true
+	})
+}
+
+func TestVaultKeyedAddressing(t *testing.T) {
+	for _, keyed := range []bool{false, true} {
+		vault, _ := NewFilesystemVault(t.TempDir())
+		cfg := createDefaultConfig()
+		cfg.Vault.KeyedAddressing = keyed
+		sink := new(consumertest.TracesSink)
+		proc := newTestProcessor(t, cfg, vault, sink)
+
+		td := ptrace.NewTraces()
+		span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+		span.Attributes().PutStr("gen_ai.prompt", "echo this back")
+		span.Attributes().PutStr("gen_ai.completion", "echo this back")
+
+		proc.ConsumeTraces(context.Background(), td)
+
+		attrs := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+		prompt, _ := attrs.Get("gen_ai.prompt.vault_ref")
+		completion, _ := attrs.Get("gen_ai.completion.vault_ref")
+
+		if keyed && prompt.Str() == completion.Str() {
+			t.Errorf("expected distinct refs with keyed addressing, both got %s", prompt.Str())
+		}
+		if !keyed && prompt.Str() != completion.Str() {
+			t.Errorf("expected shared ref without keyed addressing, got %s and %s", prompt.Str(), completion.Str())
+		}
+		for _, ref := range []string{prompt.Str(), completion.Str()} {
+			if data, err := vault.Retrieve(ref); err != nil || string(data) != "echo this back" {
+				t.Errorf("expected %s to resolve to original content, got %q (%v)", ref, data, err)
+			}
+		}
+	}
+}
+
+// corruptingVault flips a byte in everything it reads back.
+type corruptingVault struct {
+	*FilesystemVault
+}
+
+func (v corruptingVault) Retrieve(ref string) ([]byte, error) {
+	data, err := v.FilesystemVault.Retrieve(ref)
+	if err == nil && len(data) > 0 {
+		data[0] ^= 0xff
+	}
+	return data, err
+}
+
+func TestVaultVerifyAfterWrite(t *testing.T) {
+	fsVault, _ := NewFilesystemVault(t.TempDir())
+	cfg := createDefaultConfig()
+	cfg.Storage.VerifyAfterWrite = true
+	set, reader := newTestTelemetry()
+	sink := new(consumertest.TracesSink)
+	proc, err := newVaultProcessor(set, cfg, corruptingVault{fsVault}, sink)
+	if err != nil {
+		t.Fatalf("failed to create processor: %v", err)
+	}
+
+	td := ptrace.NewTraces()
+	span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+	span.Attributes().PutStr("gen_ai.prompt", "content the backend will corrupt")
+
+	proc.ConsumeTraces(context.Background(), td)
+
+	attrs := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	prompt, _ := attrs.Get("gen_ai.prompt")
+	if prompt.Str() != "content the backend will corrupt" {
+		t.Errorf("expected content to stay inline after failed verification, got: %s", prompt.Str())
+	}
+	if _, ok := attrs.Get("gen_ai.prompt.vault_ref"); ok {
+		t.Error("expected no vault_ref for an unverified write")
+	}
+	if n := counterValue(t, reader, "processor_promptvault_verify_mismatch"); n != 1 {
+		t.Errorf("expected 1 verify mismatch, got %d", n)
+	}
+
+	// A healthy backend passes verification.
+	sink.Reset()
+	proc = newTestProcessor(t, cfg, fsVault, sink)
+	td = ptrace.NewTraces()
+	span = td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+	span.Attributes().PutStr("gen_ai.prompt", "content that survives")
+	proc.ConsumeTraces(context.Background(), td)
+
+	attrs = sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	prompt, _ = attrs.Get("gen_ai.prompt")
+	if !strings.HasPrefix(prompt.Str(), "vault://") {
+		t.Errorf("expected verified content to be offloaded, got: %s", prompt.Str())
+	}
+}
+
+func TestVaultOffloadOnlyNovel(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	cfg := createDefaultConfig()
+	cfg.Vault.OffloadOnlyNovel = true
+	sink := new(consumertest.TracesSink)
+	proc := newTestProcessor(t, cfg, vault, sink)
+
+	consume := func(prompt string) string {
+		td := ptrace.NewTraces()
+		span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+		span.Attributes().PutStr("gen_ai.prompt", prompt)
+		proc.ConsumeTraces(context.Background(), td)
+		traces := sink.AllTraces()
+		v, _ := traces[len(traces)-1].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes().Get("gen_ai.prompt")
+		return v.Str()
+	}
+
+	if got := consume("You are a helpful assistant."); !strings.HasPrefix(got, "vault://") {
+		t.Errorf("expected first occurrence to be offloaded, got: %s", got)
+	}
+	if got := consume("You are a helpful assistant."); got != "You are a helpful assistant." {
+		t.Errorf("expected repeated content to stay inline, got: %s", got)
+	}
+	if got := consume("Something new entirely."); !strings.HasPrefix(got, "vault://") {
+		t.Errorf("expected novel content to be offloaded, got: %s", got)
+	}
+}
+
+func TestVaultGroupedKeysUseCombinedSize(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	cfg := createDefaultConfig()
+	cfg.Vault.SizeThreshold = 30
+	cfg.Vault.Groups = [][]string{{"gen_ai.prompt", "gen_ai.completion"}}
+	sink := new(consumertest.TracesSink)
+	proc := newTestProcessor(t, cfg, vault, sink)
+
+	consume := func(prompt, completion string) (string, string) {
+		td := ptrace.NewTraces()
+		span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+		span.Attributes().PutStr("gen_ai.prompt", prompt)
+		span.Attributes().PutStr("gen_ai.completion", completion)
+		proc.ConsumeTraces(context.Background(), td)
+		traces := sink.AllTraces()
+		attrs := traces[len(traces)-1].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+		p, _ := attrs.Get("gen_ai.prompt")
+		c, _ := attrs.Get("gen_ai.completion")
+		return p.Str(), c.Str()
+	}
+
+	// Each value is below the threshold, together they exceed it.
+	p, c := consume("What is a qubit?", "A quantum bit.")
+	if !strings.HasPrefix(p, "vault://") || !strings.HasPrefix(c, "vault://") {
+		t.Errorf("expected both keys offloaded together, got prompt=%q completion=%q", p, c)
+	}
+
+	p, c = consume("Hi", "Hello!")
+	if p != "Hi" || c != "Hello!" {
+		t.Errorf("expected both keys inline for a small turn, got prompt=%q completion=%q", p, c)
+	}
+}
+
+func TestVaultGroupedKeysUseSmallestThreshold(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		thresholds map[string]int
+		offloaded  bool
+	}{
+		{name: "lower key threshold applies to the group", thresholds: map[string]int{"gen_ai.prompt": 20}, offloaded: true},
+		{name: "higher key threshold does not raise it", thresholds: map[string]int{"gen_ai.prompt": 5000}, offloaded: false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			vault, _ := NewFilesystemVault(t.TempDir())
+			cfg := createDefaultConfig()
+			cfg.Vault.SizeThreshold = 1000
+			cfg.Vault.KeyThresholds = tt.thresholds
+			cfg.Vault.Groups = [][]string{{"gen_ai.prompt", "gen_ai.completion"}}
+			sink := new(consumertest.TracesSink)
+			proc := newTestProcessor(t, cfg, vault, sink)
+
+			td := ptrace.NewTraces()
+			attrs := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty().Attributes()
+			attrs.PutStr("gen_ai.prompt", "What is a qubit?")
+			attrs.PutStr("gen_ai.completion", "A quantum bit.")
+			if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, key := range []string{"gen_ai.prompt", "gen_ai.completion"} {
+				if v, _ := attrs.Get(key); strings.HasPrefix(v.Str(), "vault://") != tt.offloaded {
+					t.Errorf("expected %s offloaded=%v, got %q", key, tt.offloaded, v.Str())
+				}
+			}
+		})
+	}
+}
+
+func TestVaultProviderProfiles(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	cfg := createDefaultConfig()
+	cfg.Vault.ProviderProfiles = true
+	cfg.Vault.Profiles = map[string][]string{
+		"aws.bedrock": {"gen_ai.completion"},
+	}
+	sink := new(consumertest.TracesSink)
+	proc := newTestProcessor(t, cfg, vault, sink)
+
+	td := ptrace.NewTraces()
+	spans := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans()
+	for _, provider := range []string{"openai", "aws.bedrock"} {
+		span := spans.AppendEmpty()
+		span.Attributes().PutStr("gen_ai.system", provider)
+		span.Attributes().PutStr("gen_ai.prompt", "What is a qubit?")
+		span.Attributes().PutStr("gen_ai.completion", "A quantum bit.")
+		span.Attributes().PutStr("gen_ai.tool.call.arguments", `{"q":"qubit"}`)
+	}
+
+	proc.ConsumeTraces(context.Background(), td)
+
+	out := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans()
+	vaulted := func(i int, key string) bool {
+		v, _ := out.At(i).Attributes().Get(key)
+		return strings.HasPrefix(v.Str(), "vault://")
+	}
+
+	// openai uses the built-in profile, including tool call content.
+	for _, key := range []string{"gen_ai.prompt", "gen_ai.completion", "gen_ai.tool.call.arguments"} {
+		if !vaulted(0, key) {
+			t.Errorf("openai span: expected %s to be vaulted", key)
+		}
+	}
+	// aws.bedrock uses the configured override.
+	if !vaulted(1, "gen_ai.completion") {
+		t.Error("bedrock span: expected gen_ai.completion to be vaulted")
+	}
+	for _, key := range []string{"gen_ai.prompt", "gen_ai.tool.call.arguments"} {
+		if vaulted(1, key) {
+			t.Errorf("bedrock span: expected %s to stay inline", key)
+		}
+	}
+}
+
+func TestVaultDestructiveAfterGracePeriod(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	cfg := createDefaultConfig()
+	cfg.Vault.Mode = "remove"
+	cfg.Vault.DestructiveAfter = "24h"
+	sink := new(consumertest.TracesSink)
+	proc := newTestProcessor(t, cfg, vault, sink)
+
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	proc.now = func() time.Time { return now }
+	if err := proc.Start(context.Background(), nil); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+
+	consume := func() pcommon.Map {
+		td := ptrace.NewTraces()
+		span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+		span.Attributes().PutStr("gen_ai.prompt", "sensitive content here")
+		proc.ConsumeTraces(context.Background(), td)
+		traces := sink.AllTraces()
+		return traces[len(traces)-1].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	}
+
+	attrs := consume()
+	if v, ok := attrs.Get("gen_ai.prompt"); !ok || v.Str() != "sensitive content here" {
+		t.Errorf("expected content kept before cutoff, got: %v", v.AsString())
+	}
+	if _, ok := attrs.Get("gen_ai.prompt.vault_ref"); !ok {
+		t.Error("expected vault_ref before cutoff")
+	}
+
+	now = now.Add(25 * time.Hour)
+	attrs = consume()
+	if _, ok := attrs.Get("gen_ai.prompt"); ok {
+		t.Error("expected content removed after cutoff")
+	}
+	if _, ok := attrs.Get("gen_ai.prompt.vault_ref"); !ok {
+		t.Error("expected vault_ref after cutoff")
+	}
+}
+
+func TestVaultDestructiveAfterTimestamp(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	cfg := createDefaultConfig()
+	cfg.Vault.DestructiveAfter = "2026-04-01T00:00:00Z"
+	proc := newTestProcessor(t, cfg, vault, new(consumertest.TracesSink))
+
+	proc.now = func() time.Time { return time.Date(2026, 3, 31, 23, 0, 0, 0, time.UTC) }
+	if mode := proc.effectiveMode(); mode != "keep_and_ref" {
+		t.Errorf("expected keep_and_ref before the timestamp, got %s", mode)
+	}
+	proc.now = func() time.Time { return time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC) }
+	if mode := proc.effectiveMode(); mode != "replace_with_ref" {
+		t.Errorf("expected replace_with_ref from the timestamp on, got %s", mode)
+	}
+
+	cfg.Vault.DestructiveAfter = "next tuesday"
+	set := component.TelemetrySettings{Logger: zap.NewNop(), MeterProvider: noop.NewMeterProvider()}
+	if _, err := newVaultProcessor(set, cfg, vault, new(consumertest.TracesSink)); err == nil {
+		t.Error("expected an error for an unparseable destructive_after")
+	}
+}
+
+// blockingVault blocks every Store until release is closed and signals
+// started when a Store is waiting.
+type blockingVault struct {
+	*FilesystemVault
+	started chan struct{}
+	release chan struct{}
+}
+
+func (v *blockingVault) Store(content []byte) (string, error) {
+	v.block()
+	return v.FilesystemVault.Store(content)
+}
+
+func (v *blockingVault) StoreAt(content []byte, contentType string, encrypt bool, at time.Time) (string, error) {
+	v.block()
+	return v.FilesystemVault.StoreAt(content, contentType, encrypt, at)
+}
+
+func (v *blockingVault) block() {
+	select {
+	case v.started <- struct{}{}:
+	default:
+	}
+	<-v.release
+}
+
+func TestVaultRejectsWhenSaturated(t *testing.T) {
+	fsVault, _ := NewFilesystemVault(t.TempDir())
+	vault := &blockingVault{FilesystemVault: fsVault, started: make(chan struct{}, 1), release: make(chan struct{})}
+	cfg := createDefaultConfig()
+	cfg.Vault.MaxInFlightBatches = 1
+	set, reader := newTestTelemetry()
+	sink := new(consumertest.TracesSink)
+	proc, err := newVaultProcessor(set, cfg, vault, sink)
+	if err != nil {
+		t.Fatalf("failed to create processor: %v", err)
+	}
+
+	newBatch := func() ptrace.Traces {
+		td := ptrace.NewTraces()
+		span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+		span.Attributes().PutStr("gen_ai.prompt", "Tell me about quantum computing")
+		span.Attributes().PutStr("gen_ai.completion", "Quantum computing uses qubits...")
+		return td
+	}
+
+	done := make(chan error)
+	go func() { done <- proc.ConsumeTraces(context.Background(), newBatch()) }()
+	<-vault.started
+
+	td := newBatch()
+	err = proc.ConsumeTraces(context.Background(), td)
+	if err == nil {
+		t.Fatal("expected an error while saturated")
+	}
+	if consumererror.IsPermanent(err) {
+		t.Error("expected a retryable error, got a permanent one")
+	}
+	var tracesErr consumererror.Traces
+	if !errors.As(err, &tracesErr) || tracesErr.Data().SpanCount() != 1 {
+		t.Error("expected a consumererror.Traces carrying the rejected batch")
+	}
+	attrs := td.ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	if v, _ := attrs.Get("gen_ai.prompt"); v.Str() != "Tell me about quantum computing" || attrs.Len() != 2 {
+		t.Error("expected the rejected batch to be left untouched")
+	}
+	if n := counterValue(t, reader, "processor_promptvault_rejected_batches"); n != 1 {
+		t.Errorf("expected 1 rejected batch, got %d", n)
+	}
+
+	close(vault.release)
+	if err := <-done; err != nil {
+		t.Fatalf("in-flight batch failed: %v", err)
+	}
+
+	// With the slot free again, batches are accepted.
+	if err := proc.ConsumeTraces(context.Background(), newBatch()); err != nil {
+		t.Errorf("expected batch to be accepted after saturation cleared, got: %v", err)
+	}
+}
+
+func TestVaultOffloadsLargeBytesAttribute(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	sink := new(consumertest.TracesSink)
+	proc := newTestProcessor(t, createDefaultConfig(), vault, sink)
+
+	image := make([]byte, 4<<20)
+	rand.New(rand.NewSource(1)).Read(image)
+
+	td := ptrace.NewTraces()
+	span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+	span.Attributes().PutEmptyBytes("gen_ai.input.messages").FromRaw(image)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	runtime.ReadMemStats(&after)
+
+	// One copy out of the pdata value is unavoidable; nothing beyond that.
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 2*uint64(len(image)) {
+		t.Errorf("expected at most one extra copy of %d bytes, allocated %d", len(image), allocated)
+	}
+
+	attrs := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	ref, _ := attrs.Get("gen_ai.input.messages.vault_ref")
+	if !strings.HasSuffix(ref.Str(), ".bin") {
+		t.Errorf("expected a binary reference, got: %s", ref.Str())
+	}
+	data, err := vault.Retrieve(ref.Str())
+	if err != nil {
+		t.Fatalf("retrieve failed: %v", err)
+	}
+	if !bytes.Equal(data, image) {
+		t.Error("retrieved bytes do not match the original attribute")
+	}
+}
+
+func TestVaultSidecarMode(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	sink := new(consumertest.TracesSink)
+	cfg := createDefaultConfig()
+	cfg.Vault.Mode = "sidecar"
+	proc := newTestProcessor(t, cfg, vault, sink)
+
+	prompt := "Summarize the quarterly report for the board."
+	td := ptrace.NewTraces()
+	span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+	span.Attributes().PutStr("gen_ai.prompt", prompt)
+
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	attrs := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	visible, _ := attrs.Get("gen_ai.prompt")
+	if !strings.HasPrefix(visible.Str(), "vault://") {
+		t.Errorf("expected the visible attribute to hold a reference, got: %s", visible.Str())
+	}
+	sidecar, ok := attrs.Get("gen_ai.prompt.vault_sidecar")
+	if !ok || sidecar.Type() != pcommon.ValueTypeBytes {
+		t.Fatal("expected a bytes sidecar attribute")
+	}
+	original, err := gunzipBytes(sidecar.Bytes().AsRaw())
+	if err != nil {
+		t.Fatalf("sidecar is not gzip-compressed: %v", err)
+	}
+	if string(original) != prompt {
+		t.Errorf("expected sidecar to hold the original, got: %s", original)
+	}
+}
+
+// verboseRefVault returns long JSON references embedding a preview.
+type verboseRefVault struct {
+	*FilesystemVault
+}
+
+func (v verboseRefVault) Store(content []byte) (string, error) {
+	ref, err := v.FilesystemVault.Store(content)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`{"uri":%q,"preview":%q}`, ref, content), nil
+}
+
+func TestVaultMaxRefValueLength(t *testing.T) {
+	fsVault, _ := NewFilesystemVault(t.TempDir())
+	sink := new(consumertest.TracesSink)
+	cfg := createDefaultConfig()
+	cfg.Vault.MaxRefValueLength = 80
+	proc := newTestProcessor(t, cfg, verboseRefVault{fsVault}, sink)
+
+	td := ptrace.NewTraces()
+	span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+	span.Attributes().PutStr("gen_ai.prompt", strings.Repeat("Tell me about quantum computing. ", 10))
+
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	attrs := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	primary, _ := attrs.Get("gen_ai.prompt")
+	full, _ := attrs.Get("gen_ai.prompt.vault_ref")
+	if len(primary.Str()) > 80 {
+		t.Errorf("expected primary attribute within 80 bytes, got %d: %s", len(primary.Str()), primary.Str())
+	}
+	if !strings.HasPrefix(primary.Str(), "vault://") {
+		t.Errorf("expected the essential reference in the primary attribute, got: %s", primary.Str())
+	}
+	if !strings.Contains(full.Str(), `"preview"`) {
+		t.Errorf("expected the full reference in the sibling, got: %s", full.Str())
+	}
+	if essentialRef(full.Str()) != primary.Str() {
+		t.Errorf("expected primary %s to identify the same content as %s", primary.Str(), full.Str())
+	}
+}
+
+// slowVault delays every store.
+type slowVault struct {
+	*FilesystemVault
+	delay time.Duration
+}
+
+func (v slowVault) Store(content []byte) (string, error) {
+	time.Sleep(v.delay)
+	return v.FilesystemVault.Store(content)
+}
+
+func TestVaultMaxBatchProcessingTime(t *testing.T) {
+	fsVault, _ := NewFilesystemVault(t.TempDir())
+	cfg := createDefaultConfig()
+	cfg.Vault.MaxBatchProcessingTime = 50 * time.Millisecond
+	set, reader := newTestTelemetry()
+	sink := new(consumertest.TracesSink)
+	proc, err := newVaultProcessor(set, cfg, slowVault{fsVault, 20 * time.Millisecond}, sink)
+	if err != nil {
+		t.Fatalf("failed to create processor: %v", err)
+	}
+
+	const total = 10
+	td := ptrace.NewTraces()
+	spans := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans()
+	for i := 0; i < total; i++ {
+		spans.AppendEmpty().Attributes().PutStr("gen_ai.prompt", fmt.Sprintf("prompt number %d", i))
+	}
+
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sink.AllTraces()) != 1 {
+		t.Fatal("expected the batch to be forwarded")
+	}
+
+	var vaulted, inline int64
+	got := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans()
+	for i := 0; i < got.Len(); i++ {
+		v, _ := got.At(i).Attributes().Get("gen_ai.prompt")
+		if strings.HasPrefix(v.Str(), "vault://") {
+			vaulted++
+		} else {
+			inline++
+		}
+	}
+	if vaulted == 0 || inline == 0 {
+		t.Errorf("expected partial processing, got %d vaulted and %d inline", vaulted, inline)
+	}
+	if skipped := counterValue(t, reader, "processor_promptvault_skipped_attributes"); skipped != inline {
+		t.Errorf("expected %d skipped attributes, got %d", inline, skipped)
+	}
+}
+
+// latencyVault simulates a remote backend: each store takes delay, and
+// content listed in fail is refused. It records the most stores it saw at
+// once.
+type latencyVault struct {
+	*FilesystemVault
+	delay   time.Duration
+	fail    map[string]bool
+	current atomic.Int64
+	peak    atomic.Int64
+}
+
+func (v *latencyVault) Store(content []byte) (string, error) {
+	n := v.current.Add(1)
+	defer v.current.Add(-1)
+	for peak := v.peak.Load(); n > peak && !v.peak.CompareAndSwap(peak, n); peak = v.peak.Load() {
+	}
+	time.Sleep(v.delay)
+	if v.fail[string(content)] {
+		return "", errors.New("backend unavailable")
+	}
+	return v.FilesystemVault.Store(content)
+}
+
+// newWideSpanTraces returns one span with keys gen_ai.input.0 to n-1.
+func newWideSpanTraces(n int) ptrace.Traces {
+	td := ptrace.NewTraces()
+	span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+	for i := 0; i < n; i++ {
+		span.Attributes().PutStr(fmt.Sprintf("gen_ai.input.%d", i), fmt.Sprintf("message number %d", i))
+	}
+	return td
+}
+
+func TestVaultConcurrentStores(t *testing.T) {
+	fsVault, _ := NewFilesystemVault(t.TempDir())
+	vault := &latencyVault{FilesystemVault: fsVault, delay: 20 * time.Millisecond, fail: map[string]bool{"message number 3": true}}
+	cfg := createDefaultConfig()
+	cfg.Vault.KeyPrefixes = []string{"gen_ai.input."}
+	cfg.Vault.Concurrency = 4
+	sink := new(consumertest.TracesSink)
+	proc := newTestProcessor(t, cfg, vault, sink)
+
+	if err := proc.ConsumeTraces(context.Background(), newWideSpanTraces(8)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if peak := vault.peak.Load(); peak < 2 || peak > 4 {
+		t.Errorf("expected between 2 and 4 concurrent stores, got %d", peak)
+	}
+
+	attrs := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	for i := 0; i < 8; i++ {
+		key := fmt.Sprintf("gen_ai.input.%d", i)
+		content := fmt.Sprintf("message number %d", i)
+		v, _ := attrs.Get(key)
+		if i == 3 {
+			if v.Str() != content {
+				t.Errorf("expected %s to stay inline after its store failed, got %q", key, v.Str())
+			}
+			continue
+		}
+		got, err := fsVault.Retrieve(v.Str())
+		if err != nil || string(got) != content {
+			t.Errorf("expected %s to reference its own content, got %q (%v)", key, got, err)
+		}
+	}
+}
+
+// BenchmarkVaultConcurrency compares storing a span's attributes one at a
+// time with storing them concurrently against a 1ms backend.
+func BenchmarkVaultConcurrency(b *testing.B) {
+	for _, concurrency := range []int{1, 8} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			fsVault, _ := NewFilesystemVault(b.TempDir())
+			vault := &latencyVault{FilesystemVault: fsVault, delay: time.Millisecond}
+			cfg := createDefaultConfig()
+			cfg.Vault.KeyPrefixes = []string{"gen_ai.input."}
+			cfg.Vault.Concurrency = concurrency
+			set := component.TelemetrySettings{Logger: zap.NewNop(), MeterProvider: noop.NewMeterProvider()}
+			proc, err := newVaultProcessor(set, cfg, vault, consumertest.NewNop())
+			if err != nil {
+				b.Fatalf("failed to create processor: %v", err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := proc.ConsumeTraces(context.Background(), newWideSpanTraces(16)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// cancellingVault is a remote vault whose first write cancels the batch
+// and then blocks until its context is done.
+type cancellingVault struct {
+	cancel context.CancelFunc
+	stores atomic.Int64
+}
+
+func (v *cancellingVault) Store(content []byte) (string, error) {
+	return v.StoreContext(context.Background(), content)
+}
+
+func (v *cancellingVault) StoreContext(ctx context.Context, _ []byte) (string, error) {
+	v.stores.Add(1)
+	v.cancel()
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func TestVaultHonorsCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	vault := &cancellingVault{cancel: cancel}
+	sink := new(consumertest.TracesSink)
+	proc := newTestProcessor(t, createDefaultConfig(), vault, sink)
+
+	td := ptrace.NewTraces()
+	spans := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans()
+	for i := 0; i < 3; i++ {
+		spans.AppendEmpty().Attributes().PutStr("gen_ai.prompt", fmt.Sprintf("prompt number %d", i))
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- proc.ConsumeTraces(ctx, td) }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ConsumeTraces did not return after its context was cancelled")
+	}
+	if n := vault.stores.Load(); n != 1 {
+		t.Errorf("expected the batch to stop after the cancelled store, got %d stores", n)
+	}
+	if len(sink.AllTraces()) != 0 {
+		t.Error("expected a cancelled batch not to be forwarded")
+	}
+}
+
+func TestVaultKeyPrefixesBaggage(t *testing.T) {
+	dir := t.TempDir()
+	vault, _ := NewFilesystemVault(dir)
+	sink := new(consumertest.TracesSink)
+	cfg := createDefaultConfig()
+	cfg.Vault.KeyPrefixes = []string{"baggage."}
+	proc := newTestProcessor(t, cfg, vault, sink)
+
+	prompt := strings.Repeat("You are a helpful assistant for ACME support. ", 100)
+	td := ptrace.NewTraces()
+	spans := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans()
+	for i := 0; i < 5; i++ {
+		attrs := spans.AppendEmpty().Attributes()
+		attrs.PutStr("baggage.prompt", prompt)
+		attrs.PutStr("baggage.user_tier", "gold")
+		attrs.PutStr("http.route", "/chat")
+	}
+
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans()
+	first, _ := got.At(0).Attributes().Get("baggage.prompt")
+	if !strings.HasPrefix(first.Str(), "vault://") {
+		t.Fatalf("expected baggage.prompt to be vaulted, got %.40s", first.Str())
+	}
+	for i := 1; i < got.Len(); i++ {
+		if v, _ := got.At(i).Attributes().Get("baggage.prompt"); v.Str() != first.Str() {
+			t.Errorf("span %d: expected shared reference %s, got %s", i, first.Str(), v.Str())
+		}
+	}
+	if v, _ := got.At(0).Attributes().Get("http.route"); v.Str() != "/chat" {
+		t.Errorf("expected non-matching keys untouched, got %s", v.Str())
+	}
+	// Both baggage values are stored once each despite appearing on every span.
+	if files := vaultFiles(t, dir); len(files) != 2 {
+		t.Errorf("expected 2 deduplicated objects, got %d", len(files))
+	}
+}
+
+func TestVaultSensitiveMarker(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	sink := new(consumertest.TracesSink)
+	cfg := createDefaultConfig()
+	cfg.Vault.SensitiveMarkerSuffix = ".sensitive"
+	proc := newTestProcessor(t, cfg, vault, sink)
+
+	td := ptrace.NewTraces()
+	attrs := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty().Attributes()
+	attrs.PutStr("app.customer_note", "Patient reports chest pain")
+	attrs.PutBool("app.customer_note.sensitive", true)
+	attrs.PutStr("app.ticket_id", "T-1234")
+	attrs.PutBool("app.ticket_id.sensitive", false)
+
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	if _, ok := got.Get("app.customer_note.vault_ref"); !ok {
+		t.Error("expected the attribute marked sensitive to be offloaded")
+	}
+	if v, ok := got.Get("app.customer_note.sensitive"); !ok || !v.Bool() {
+		t.Error("expected the sensitivity marker to be left intact")
+	}
+	if v, _ := got.Get("app.ticket_id"); v.Str() != "T-1234" {
+		t.Errorf("expected an attribute marked not sensitive to stay inline, got %q", v.Str())
+	}
+}
+
+func TestVaultRetentionDays(t *testing.T) {
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	vault, _ := NewFilesystemVault(t.TempDir(), WithClock(func() time.Time { return now }))
+	sink := new(consumertest.TracesSink)
+	cfg := createDefaultConfig()
+	cfg.Vault.RetentionDays = map[string]int{
+		"gen_ai.system_instructions": 30,
+		"gen_ai.prompt":              1,
+	}
+	proc := newTestProcessor(t, cfg, vault, sink)
+
+	td := ptrace.NewTraces()
+	attrs := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty().Attributes()
+	attrs.PutStr("gen_ai.system_instructions", "You are a helpful assistant")
+	attrs.PutStr("gen_ai.prompt", "Tell me about quantum computing")
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	system, _ := got.Get("gen_ai.system_instructions.vault_ref")
+	prompt, _ := got.Get("gen_ai.prompt.vault_ref")
+
+	// Two days later the prompt is past its one-day window; the system
+	// instructions are kept even though the sweep's own max age is shorter.
+	now = now.Add(48 * time.Hour)
+	removed, _, err := vault.Sweep(time.Hour)
+	if err != nil {
+		t.Fatalf("sweep failed: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("expected only the prompt to be swept, got %d objects", removed)
+	}
+	if _, err := vault.Retrieve(prompt.Str()); err == nil {
+		t.Error("expected the prompt to be gone after its retention")
+	}
+	if _, err := vault.Retrieve(system.Str()); err != nil {
+		t.Errorf("expected the system instructions to be retained: %v", err)
+	}
+}
+
+func TestVaultMarkOffloaded(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	sink := new(consumertest.TracesSink)
+	cfg := createDefaultConfig()
+	cfg.Vault.MarkOffloaded = true
+	proc := newTestProcessor(t, cfg, vault, sink)
+
+	td := ptrace.NewTraces()
+	spans := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans()
+	spans.AppendEmpty().Attributes().PutStr("gen_ai.prompt", "Tell me about quantum computing")
+	spans.AppendEmpty().Attributes().PutStr("http.route", "/chat")
+
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans()
+	if v, ok := got.At(0).Attributes().Get("vault.offloaded"); !ok || !v.Bool() {
+		t.Error("expected vault.offloaded=true on the span with an offload")
+	}
+	if _, ok := got.At(1).Attributes().Get("vault.offloaded"); ok {
+		t.Error("expected no vault.offloaded on the span without an offload")
+	}
+}
+
+func TestVaultCanaryAttribute(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	sink := new(consumertest.TracesSink)
+	cfg := createDefaultConfig()
+	cfg.Vault.CanaryAttribute = "promptvault.processed"
+	proc := newTestProcessor(t, cfg, vault, sink)
+
+	td := ptrace.NewTraces()
+	spans := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans()
+	spans.AppendEmpty().Attributes().PutStr("gen_ai.prompt", "Tell me about quantum computing")
+	spans.AppendEmpty().Attributes().PutStr("http.route", "/chat")
+	spans.AppendEmpty()
+
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans()
+	for i := 0; i < got.Len(); i++ {
+		if v, ok := got.At(i).Attributes().Get("promptvault.processed"); !ok || !v.Bool() {
+			t.Errorf("span %d: expected the canary attribute", i)
+		}
+	}
+}
+
+func TestVaultEventAttributes(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	sink := new(consumertest.TracesSink)
+	proc := newTestProcessor(t, createDefaultConfig(), vault, sink)
+
+	td := ptrace.NewTraces()
+	span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+	span.Attributes().PutStr("gen_ai.system", "openai")
+	event := span.Events().AppendEmpty()
+	event.SetName("gen_ai.content.prompt")
+	event.Attributes().PutStr("gen_ai.prompt", "Tell me about quantum computing")
+	span.Events().AppendEmpty().Attributes().PutStr("exception.message", "timeout")
+
+	result := proc.processSpan(context.Background(), span)
+	if len(result.offloaded) != 1 || result.offloaded[0].key != "event_0/gen_ai.prompt" {
+		t.Fatalf("expected the event attribute offloaded, got %+v", result.offloaded)
+	}
+
+	attrs := span.Events().At(0).Attributes()
+	prompt, _ := attrs.Get("gen_ai.prompt")
+	ref, _ := attrs.Get("gen_ai.prompt.vault_ref")
+	if !strings.HasPrefix(prompt.Str(), "vault://") || ref.Str() != result.offloaded[0].ref {
+		t.Errorf("expected the event attribute replaced by its reference, got %v", attrs.AsRaw())
+	}
+	if got, err := vault.Retrieve(ref.Str()); err != nil || string(got) != "Tell me about quantum computing" {
+		t.Errorf("expected the event reference to resolve, got %q (%v)", got, err)
+	}
+	if v, _ := span.Events().At(1).Attributes().Get("exception.message"); v.Str() != "timeout" {
+		t.Error("expected unmatched event attributes untouched")
+	}
+}
+
+func TestVaultEventDuplicates(t *testing.T) {
+	const prompt = "Tell me about quantum computing"
+	for _, tt := range []struct {
+		policy        string
+		wantStores    int64
+		wantSpanAttr  bool
+		wantEventAttr bool
+	}{
+		{policy: "store", wantStores: 2, wantSpanAttr: true, wantEventAttr: true},
+		{policy: "reference", wantStores: 1, wantSpanAttr: true, wantEventAttr: true},
+		{policy: "prefer_attribute", wantStores: 1, wantSpanAttr: true},
+		{policy: "prefer_event", wantStores: 1, wantEventAttr: true},
+	} {
+		t.Run(tt.policy, func(t *testing.T) {
+			dir := t.TempDir()
+			fsVault, _ := NewFilesystemVault(dir)
+			vault := &flakyVault{FilesystemVault: fsVault}
+			cfg := createDefaultConfig()
+			cfg.Vault.EventDuplicates = tt.policy
+			proc := newTestProcessor(t, cfg, vault, new(consumertest.TracesSink))
+
+			span := ptrace.NewSpan()
+			span.Attributes().PutStr("gen_ai.prompt", prompt)
+			span.Events().AppendEmpty().Attributes().PutStr("gen_ai.prompt", prompt)
+			span.Events().AppendEmpty().Attributes().PutStr("gen_ai.prompt", "a different prompt")
+			proc.processSpan(context.Background(), span)
+
+			if n := vault.attempts.Load(); n != tt.wantStores+1 {
+				t.Errorf("expected %d stores, got %d", tt.wantStores+1, n)
+			}
+			if n := len(vaultFiles(t, dir)); n != 2 {
+				t.Errorf("expected 2 stored objects, got %d", n)
+			}
+			spanRef, hasSpan := span.Attributes().Get("gen_ai.prompt.vault_ref")
+			eventRef, hasEvent := span.Events().At(0).Attributes().Get("gen_ai.prompt.vault_ref")
+			if hasSpan != tt.wantSpanAttr || hasEvent != tt.wantEventAttr {
+				t.Fatalf("expected span/event references %v/%v, got %v/%v", tt.wantSpanAttr, tt.wantEventAttr, hasSpan, hasEvent)
+			}
+			if _, ok := span.Attributes().Get("gen_ai.prompt"); ok != tt.wantSpanAttr {
+				t.Errorf("expected the span attribute present=%v", tt.wantSpanAttr)
+			}
+			if _, ok := span.Events().At(0).Attributes().Get("gen_ai.prompt"); ok != tt.wantEventAttr {
+				t.Errorf("expected the event attribute present=%v", tt.wantEventAttr)
+			}
+			if hasSpan && hasEvent && spanRef.Str() != eventRef.Str() {
+				t.Errorf("expected both locations to reference the same object, got %s and %s", spanRef.Str(), eventRef.Str())
+			}
+			if _, ok := span.Events().At(1).Attributes().Get("gen_ai.prompt.vault_ref"); !ok {
+				t.Error("expected the distinct event value vaulted on its own")
+			}
+		})
+	}
+}
+
+func TestVaultDisableForEnvironments(t *testing.T) {
+	for _, tt := range []struct {
+		env      string
+		disabled bool
+	}{
+		{env: "dev", disabled: true},
+		{env: "prod", disabled: false},
+	} {
+		t.Run(tt.env, func(t *testing.T) {
+			dir := t.TempDir()
+			vault, _ := NewFilesystemVault(dir)
+			sink := new(consumertest.TracesSink)
+			cfg := createDefaultConfig()
+			cfg.Vault.DisableForEnvironments = []string{"dev", "local"}
+			cfg.Vault.CanaryAttribute = "promptvault.processed"
+
+			set := component.TelemetrySettings{Logger: zap.NewNop(), MeterProvider: noop.NewMeterProvider(), Resource: pcommon.NewResource()}
+			set.Resource.Attributes().PutStr("deployment.environment", tt.env)
+			proc, err := newVaultProcessor(set, cfg, vault, sink)
+			if err != nil {
+				t.Fatalf("failed to create processor: %v", err)
+			}
+			if err := proc.Start(context.Background(), nil); err != nil {
+				t.Fatalf("start failed: %v", err)
+			}
+			defer proc.Shutdown(context.Background())
+			if got := proc.Capabilities().MutatesData; got == tt.disabled {
+				t.Errorf("expected MutatesData %v, got %v", !tt.disabled, got)
+			}
+
+			td := ptrace.NewTraces()
+			span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+			span.Attributes().PutStr("gen_ai.prompt", "Tell me about quantum computing")
+			if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got := sink.AllTraces()[0]
+			attrs := got.ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+			prompt, _ := attrs.Get("gen_ai.prompt")
+			_, canary := attrs.Get("promptvault.processed")
+			if tt.disabled {
+				if prompt.Str() != "Tell me about quantum computing" || canary || attrs.Len() != 1 {
+					t.Errorf("expected the span untouched, got %v", attrs.AsRaw())
+				}
+				if files := vaultFiles(t, dir); len(files) != 0 {
+					t.Errorf("expected nothing stored, got %v", files)
+				}
+				// The very same traces are forwarded, not a copy.
+				span.Attributes().PutStr("marker", "x")
+				if _, ok := attrs.Get("marker"); !ok {
+					t.Error("expected the batch to be forwarded without cloning")
+				}
+				return
+			}
+			if !strings.HasPrefix(prompt.Str(), "vault://") || !canary {
+				t.Errorf("expected normal offloading, got %v", attrs.AsRaw())
+			}
+		})
+	}
+}
+
+func TestVaultOnReference(t *testing.T) {
+	for _, tt := range []struct {
+		behavior    string
+		wantRefAttr bool
+		wantDangled int64
+	}{
+		{behavior: "skip"},
+		{behavior: "validate", wantDangled: 1},
+		{behavior: "rewrite", wantRefAttr: true},
+	} {
+		t.Run(tt.behavior, func(t *testing.T) {
+			dir := t.TempDir()
+			vault, _ := NewFilesystemVault(dir)
+			existing, _ := vault.Store([]byte("Tell me about quantum computing"))
+			files := len(vaultFiles(t, dir))
+
+			cfg := createDefaultConfig()
+			cfg.Vault.OnReference = tt.behavior
+			set, reader := newTestTelemetry()
+			sink := new(consumertest.TracesSink)
+			proc, err := newVaultProcessor(set, cfg, vault, sink)
+			if err != nil {
+				t.Fatalf("failed to create processor: %v", err)
+			}
+
+			td := ptrace.NewTraces()
+			attrs := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty().Attributes()
+			attrs.PutStr("gen_ai.prompt", existing)
+			attrs.PutStr("gen_ai.completion", "vault://0000.txt")
+
+			if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+			if v, _ := got.Get("gen_ai.prompt"); v.Str() != existing {
+				t.Errorf("expected the reference to be left in place, got %s", v.Str())
+			}
+			if n := len(vaultFiles(t, dir)); n != files {
+				t.Errorf("expected no new objects for reference values, got %d more", n-files)
+			}
+			ref, ok := got.Get("gen_ai.prompt.vault_ref")
+			if ok != tt.wantRefAttr || (ok && ref.Str() != existing) {
+				t.Errorf("unexpected reference attribute: present=%v value=%q", ok, ref.Str())
+			}
+			if dangled := counterValue(t, reader, "processor_promptvault_dangling_refs"); dangled != tt.wantDangled {
+				t.Errorf("expected %d dangling refs, got %d", tt.wantDangled, dangled)
+			}
+		})
+	}
+}
+
+func TestVaultOnReferenceValidateBundle(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	base, _ := vault.Store([]byte(`{"gen_ai.prompt":"Tell me about quantum computing"}`))
+
+	cfg := createDefaultConfig()
+	cfg.Vault.OnReference = "validate"
+	set, reader := newTestTelemetry()
+	proc, err := newVaultProcessor(set, cfg, vault, new(consumertest.TracesSink))
+	if err != nil {
+		t.Fatalf("failed to create processor: %v", err)
+	}
+
+	td := ptrace.NewTraces()
+	attrs := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty().Attributes()
+	attrs.PutStr("gen_ai.prompt", base+refFieldSep+"gen_ai.prompt")
+	attrs.PutStr("gen_ai.completion", base+refFieldSep+"gen_ai.completion")
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The prompt field exists in the bundle; the completion field does not.
+	if dangled := counterValue(t, reader, "processor_promptvault_dangling_refs"); dangled != 1 {
+		t.Errorf("expected 1 dangling ref, got %d", dangled)
+	}
+}
+
+func TestVaultOnReferenceValidateAllowedSchemes(t *testing.T) {
+	fsVault, _ := NewFilesystemVault(t.TempDir())
+	vault := &recordingRetriever{FilesystemVault: fsVault, lookups: map[string]bool{}}
+	cfg := createDefaultConfig()
+	cfg.Vault.OnReference = "validate"
+	set, reader := newTestTelemetry()
+	proc, err := newVaultProcessor(set, cfg, vault, new(consumertest.TracesSink))
+	if err != nil {
+		t.Fatalf("failed to create processor: %v", err)
+	}
+
+	foreign := "promptvault://s3/untrusted-bucket/" + strings.Repeat("0", 64) + ".txt"
+	td := ptrace.NewTraces()
+	td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty().Attributes().PutStr("gen_ai.prompt", foreign)
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if vault.lookups[foreign] {
+		t.Error("expected a reference with an unlisted scheme not to be looked up")
+	}
+	if dangled := counterValue(t, reader, "processor_promptvault_dangling_refs"); dangled != 1 {
+		t.Errorf("expected the refused reference counted as dangling, got %d", dangled)
+	}
+}
+
+// failingVault fails every store.
+type failingVault struct{}
+
+func (failingVault) Store([]byte) (string, error) {
+	return "", errors.New("bucket unreachable")
+}
+
+func TestVaultErrorStatusOnDrop(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		policy      string
+		mode        string
+		wantDropped bool
+	}{
+		{name: "drop in replace mode", policy: "drop", mode: "replace_with_ref", wantDropped: true},
+		{name: "drop in remove mode", policy: "drop", mode: "remove", wantDropped: true},
+		{name: "drop in keep_and_ref mode keeps content", policy: "drop", mode: "keep_and_ref"},
+		{name: "keep policy", policy: "keep", mode: "replace_with_ref"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := new(consumertest.TracesSink)
+			cfg := createDefaultConfig()
+			cfg.Vault.Mode = tt.mode
+			cfg.Vault.OnStoreFailure = tt.policy
+			cfg.Vault.ErrorStatusOnDrop = true
+			proc := newTestProcessor(t, cfg, failingVault{}, sink)
+
+			td := ptrace.NewTraces()
+			span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+			span.Attributes().PutStr("gen_ai.prompt", "Tell me about quantum computing")
+
+			if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0)
+			_, present := got.Attributes().Get("gen_ai.prompt")
+			if present == tt.wantDropped {
+				t.Errorf("expected content dropped=%v, attribute present=%v", tt.wantDropped, present)
+			}
+			status := got.Status()
+			if !tt.wantDropped {
+				if status.Code() != ptrace.StatusCodeUnset {
+					t.Errorf("expected status untouched, got %s", status.Code())
+				}
+				return
+			}
+			if status.Code() != ptrace.StatusCodeError {
+				t.Errorf("expected Error status, got %s", status.Code())
+			}
+			if msg := status.Message(); !strings.Contains(msg, "gen_ai.prompt") || !strings.Contains(msg, "bucket unreachable") {
+				t.Errorf("expected a descriptive status message, got %q", msg)
+			}
+		})
+	}
+}
+
+// flakyVault fails its first failures stores, then stores normally.
+type flakyVault struct {
+	*FilesystemVault
+	failures int
+	attempts atomic.Int64
+}
+
+func (v *flakyVault) Store(content []byte) (string, error) {
+	if int(v.attempts.Add(1)) <= v.failures {
+		return "", errors.New("slow down")
+	}
+	return v.FilesystemVault.Store(content)
+}
+
+func TestVaultRetriesTransientFailures(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		failures     int
+		wantStored   bool
+		wantAttempts int64
+	}{
+		{name: "succeeds on retry", failures: 2, wantStored: true, wantAttempts: 3},
+		{name: "attempts exhausted", failures: 5, wantAttempts: 3},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fsVault, _ := NewFilesystemVault(t.TempDir())
+			vault := &flakyVault{FilesystemVault: fsVault, failures: tt.failures}
+			cfg := createDefaultConfig()
+			cfg.Storage.Retry = RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+			set, reader := newTestTelemetry()
+			sink := new(consumertest.TracesSink)
+			proc, err := newVaultProcessor(set, cfg, vault, sink)
+			if err != nil {
+				t.Fatalf("failed to create processor: %v", err)
+			}
+
+			td := ptrace.NewTraces()
+			td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty().
+				Attributes().PutStr("gen_ai.prompt", "Tell me about quantum computing")
+			if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			v, _ := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes().Get("gen_ai.prompt")
+			if stored := strings.HasPrefix(v.Str(), "vault://"); stored != tt.wantStored {
+				t.Errorf("expected stored=%v, got %q", tt.wantStored, v.Str())
+			}
+			if n := vault.attempts.Load(); n != tt.wantAttempts {
+				t.Errorf("expected %d attempts, got %d", tt.wantAttempts, n)
+			}
+			if n := counterValue(t, reader, "processor_promptvault_store_retries"); n != 2 {
+				t.Errorf("expected 2 retries, got %d", n)
+			}
+		})
+	}
+}
+
+func TestVaultRetryHonorsContext(t *testing.T) {
+	fsVault, _ := NewFilesystemVault(t.TempDir())
+	vault := &flakyVault{FilesystemVault: fsVault, failures: 100}
+	cfg := createDefaultConfig()
+	cfg.Storage.Retry = RetryConfig{MaxAttempts: 100, InitialBackoff: time.Hour, MaxBackoff: time.Hour}
+	cfg.Vault.MaxBatchProcessingTime = 20 * time.Millisecond
+	sink := new(consumertest.TracesSink)
+	proc := newTestProcessor(t, cfg, vault, sink)
+
+	td := ptrace.NewTraces()
+	td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty().
+		Attributes().PutStr("gen_ai.prompt", "Tell me about quantum computing")
+	start := time.Now()
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the backoff cut short by the batch deadline, took %s", elapsed)
+	}
+	if n := vault.attempts.Load(); n != 1 {
+		t.Errorf("expected 1 attempt before the deadline, got %d", n)
+	}
+}
+
+func TestVaultFailOnStoreFailure(t *testing.T) {
+	for _, tt := range []struct {
+		mode     string
+		wantFail bool
+	}{
+		{mode: "replace_with_ref", wantFail: true},
+		{mode: "remove", wantFail: true},
+		{mode: "keep_and_ref"},
+	} {
+		t.Run(tt.mode, func(t *testing.T) {
+			sink := new(consumertest.TracesSink)
+			cfg := createDefaultConfig()
+			cfg.Vault.Mode = tt.mode
+			cfg.Vault.OnStoreFailure = "fail"
+			proc := newTestProcessor(t, cfg, failingVault{}, sink)
+
+			td := ptrace.NewTraces()
+			td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty().
+				Attributes().PutStr("gen_ai.prompt", "Tell me about quantum computing")
+			err := proc.ConsumeTraces(context.Background(), td)
+			if !tt.wantFail {
+				if err != nil || sink.SpanCount() != 1 {
+					t.Errorf("expected the batch forwarded, got %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, errStoreFailed) || consumererror.IsPermanent(err) {
+				t.Fatalf("expected a retryable store failure, got %v", err)
+			}
+			if sink.SpanCount() != 0 {
+				t.Error("expected the batch not to be forwarded")
+			}
+			var tracesErr consumererror.Traces
+			if !errors.As(err, &tracesErr) || tracesErr.Data().SpanCount() != 1 {
+				t.Error("expected a consumererror.Traces carrying the batch")
+			}
+		})
+	}
+}
+
+func TestVaultKeyPriorityWithCap(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	sink := new(consumertest.TracesSink)
+	cfg := createDefaultConfig()
+	cfg.Vault.MaxOffloadsPerSpan = 1
+	cfg.Vault.KeyPriority = []string{"gen_ai.system_instructions", "gen_ai.prompt"}
+	proc := newTestProcessor(t, cfg, vault, sink)
+
+	td := ptrace.NewTraces()
+	attrs := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty().Attributes()
+	// Inserted lower priority first so iteration order alone would pick it.
+	attrs.PutStr("gen_ai.prompt", "Tell me about quantum computing")
+	attrs.PutStr("gen_ai.system_instructions", "You are a confidential internal assistant")
+
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	if v, _ := got.Get("gen_ai.system_instructions"); !strings.HasPrefix(v.Str(), "vault://") {
+		t.Errorf("expected the higher-priority key to be offloaded, got %s", v.Str())
+	}
+	if v, _ := got.Get("gen_ai.prompt"); v.Str() != "Tell me about quantum computing" {
+		t.Errorf("expected the lower-priority key to stay inline, got %s", v.Str())
+	}
+}
+
+func TestProcessSpanModes(t *testing.T) {
+	for _, mode := range []string{"replace_with_ref", "remove", "keep_and_ref", "sidecar"} {
+		t.Run(mode, func(t *testing.T) {
+			vault, _ := NewFilesystemVault(t.TempDir())
+			cfg := createDefaultConfig()
+			cfg.Vault.Mode = mode
+			cfg.Vault.SizeThreshold = 10
+			proc := newTestProcessor(t, cfg, vault, new(consumertest.TracesSink))
+
+			span := ptrace.NewSpan()
+			span.Attributes().PutStr("gen_ai.prompt", "Tell me about quantum computing")
+			span.Attributes().PutStr("gen_ai.completion", "Short")
+			span.Attributes().PutInt("gen_ai.system_instructions", 42)
+			span.Attributes().PutStr("http.route", "/chat")
+
+			result := proc.processSpan(context.Background(), span)
+			if len(result.offloaded) != 1 || result.offloaded[0].key != "gen_ai.prompt" {
+				t.Errorf("expected gen_ai.prompt offloaded, got %+v", result.offloaded)
+			}
+			sort.Strings(result.skipped)
+			if want := []string{"gen_ai.completion", "gen_ai.system_instructions"}; !reflect.DeepEqual(result.skipped, want) {
+				t.Errorf("expected %v skipped, got %v", want, result.skipped)
+			}
+			if len(result.failed) != 0 {
+				t.Errorf("expected no failures, got %+v", result.failed)
+			}
+		})
+	}
+}
+
+func TestProcessSpanFailures(t *testing.T) {
+	for _, tt := range []struct {
+		policy      string
+		wantDropped bool
+	}{
+		{policy: "keep"},
+		{policy: "drop", wantDropped: true},
+	} {
+		t.Run(tt.policy, func(t *testing.T) {
+			cfg := createDefaultConfig()
+			cfg.Vault.OnStoreFailure = tt.policy
+			proc := newTestProcessor(t, cfg, failingVault{}, new(consumertest.TracesSink))
+
+			span := ptrace.NewSpan()
+			span.Attributes().PutStr("gen_ai.prompt", "Tell me about quantum computing")
+
+			result := proc.processSpan(context.Background(), span)
+			if len(result.offloaded) != 0 || len(result.skipped) != 0 {
+				t.Errorf("expected nothing offloaded or skipped, got %+v", result)
+			}
+			if len(result.failed) != 1 {
+				t.Fatalf("expected one failure, got %+v", result.failed)
+			}
+			f := result.failed[0]
+			if f.key != "gen_ai.prompt" || f.err == nil || f.dropped != tt.wantDropped {
+				t.Errorf("unexpected failure %+v", f)
+			}
+			if _, ok := span.Attributes().Get("gen_ai.prompt"); ok == tt.wantDropped {
+				t.Errorf("expected content present = %v", !tt.wantDropped)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package promptvaultprocessor
+
+// contentKeys are the GenAI semantic-convention attributes that carry
+// prompt or completion content.
+var contentKeys = []string{
+	"gen_ai.prompt",
+	"gen_ai.completion",
+	"gen_ai.system_instructions",
+	"gen_ai.input.messages",
+	"gen_ai.output.messages",
+}
+
+// toolKeys carry tool call arguments and results, which often echo content.
+var toolKeys = []string{
+	"gen_ai.tool.call.arguments",
+	"gen_ai.tool.call.result",
+}
+
+// builtinProfiles maps gen_ai.system values to the keys their
+// instrumentations use for content. Vault.Profiles can extend or override
+// these per provider.
+var builtinProfiles = map[string][]string{
+	"openai":      append(append([]string(nil), contentKeys...), toolKeys...),
+	"anthropic":   append(append([]string(nil), contentKeys...), toolKeys...),
+	"aws.bedrock": contentKeys,
+	"vertex_ai":   contentKeys,
+}
+
+// buildProfiles merges configured profiles over the built-in ones.
+func buildProfiles(configured map[string][]string) map[string]map[string]bool {
+	profiles := make(map[string]map[string]bool, len(builtinProfiles)+len(configured))
+	for provider, keys := range builtinProfiles {
+		profiles[provider] = toSet(keys)
+	}
+	for provider, keys := range configured {
+		profiles[provider] = toSet(keys)
+	}
+	return profiles
+}
@@ -0,0 +1,50 @@
+package promptvaultprocessor
+
+import (
+	"sync"
+	"time"
+)
+
+// byteLimiter is a token bucket over stored bytes shared by all batches.
+// It holds at most one second's worth of tokens.
+type byteLimiter struct {
+	rate float64
+	now  func() time.Time
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newByteLimiter returns nil when bytesPerSecond is 0 (no limit).
+func newByteLimiter(bytesPerSecond int) *byteLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &byteLimiter{
+		rate:   float64(bytesPerSecond),
+		now:    time.Now,
+		tokens: float64(bytesPerSecond),
+	}
+}
+
+// allow reports whether n bytes may be stored now and, if so, takes them
+// from the bucket. A value larger than the bucket is allowed once the bucket
+// is full and leaves it in debt, so oversized values are slowed rather than
+// starved.
+func (l *byteLimiter) allow(n int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if !l.last.IsZero() {
+		l.tokens = min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+
+	if l.tokens < min(float64(n), l.rate) {
+		return false
+	}
+	l.tokens -= float64(n)
+	return true
+}
@@ -0,0 +1,109 @@
+package promptvaultprocessor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+func TestByteLimiter(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	l := newByteLimiter(1000)
+	l.now = func() time.Time { return now }
+
+	if !l.allow(600) || l.allow(600) {
+		t.Fatal("expected the second 600 bytes to exceed a 1000 byte bucket")
+	}
+	now = now.Add(500 * time.Millisecond)
+	if !l.allow(600) {
+		t.Error("expected the bucket to refill over time")
+	}
+
+	// Oversized values pass once the bucket is full, then leave it in debt.
+	now = now.Add(10 * time.Second)
+	if !l.allow(5000) {
+		t.Error("expected an oversized value to pass on a full bucket")
+	}
+	now = now.Add(time.Second)
+	if l.allow(1) {
+		t.Error("expected the bucket to still be in debt")
+	}
+
+	if newByteLimiter(0) != nil {
+		t.Error("expected no limiter for 0")
+	}
+}
+
+func TestByteLimiterConcurrent(t *testing.T) {
+	l := newByteLimiter(1000)
+	l.now = func() time.Time { return time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC) }
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if l.allow(100) {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 10 {
+		t.Errorf("expected exactly 10 of 50 concurrent stores allowed, got %d", allowed)
+	}
+}
+
+func TestVaultMaxBytesPerSecond(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	cfg := createDefaultConfig()
+	cfg.Storage.MaxBytesPerSecond = 1000
+	set, reader := newTestTelemetry()
+	sink := new(consumertest.TracesSink)
+	proc, err := newVaultProcessor(set, cfg, vault, sink)
+	if err != nil {
+		t.Fatalf("failed to create processor: %v", err)
+	}
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	proc.limiter.now = func() time.Time { return now }
+
+	send := func() (vaulted int) {
+		td := ptrace.NewTraces()
+		spans := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans()
+		for i := 0; i < 10; i++ {
+			spans.AppendEmpty().Attributes().PutStr("gen_ai.prompt", fmt.Sprintf("%03d%s", i, strings.Repeat("x", 297)))
+		}
+		if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got := sink.AllTraces()[len(sink.AllTraces())-1].ResourceSpans().At(0).ScopeSpans().At(0).Spans()
+		for i := 0; i < got.Len(); i++ {
+			if v, _ := got.At(i).Attributes().Get("gen_ai.prompt"); strings.HasPrefix(v.Str(), "vault://") {
+				vaulted++
+			}
+		}
+		return vaulted
+	}
+
+	if vaulted := send(); vaulted != 3 {
+		t.Errorf("expected 3 of 10 values within the limit, got %d", vaulted)
+	}
+	if throttled := counterValue(t, reader, "processor_promptvault_throttled_attributes"); throttled != 7 {
+		t.Errorf("expected 7 throttled attributes, got %d", throttled)
+	}
+
+	now = now.Add(time.Second)
+	if vaulted := send(); vaulted != 3 {
+		t.Errorf("expected offloading to recover after a second, got %d", vaulted)
+	}
+}
@@ -0,0 +1,124 @@
+package promptvaultprocessor
+
+import (
+	"strings"
+	"time"
+)
+
+const refScheme = "vault://"
+
+// backendRefScheme starts references from the Kafka, S3 and GCS vaults,
+// followed by the backend name.
+const backendRefScheme = "promptvault://"
+
+// Filesystem references name the partition their object was written to in
+// front of its hash, vault://[retention-<N>d/]YYYY/MM/DD/<sha256>.<ext>, so
+// the object is found without searching. References without a partition,
+// from earlier versions, still resolve.
+
+// refFragmentSep introduces each fragment of a reference, such as an
+// integrity digest or a bundle field: vault://<sha256>.<ext>#b2=<blake2b-256>.
+const refFragmentSep = "#"
+
+// CanonicalRef returns the content identity of a vault reference: the
+// scheme and hash, without the content-type extension. References to the
+// same content compare equal in canonical form even when they were tagged
+// with different content types or predate extensions. Bare references,
+// without a scheme, are read as filesystem ones. References in any other
+// scheme, such as promptvault://s3/..., already name one object and are
+// returned unchanged. Integrity fragments are dropped, but a bundle field
+// is kept, since two fields of one bundle are different content. Use it as
+// a map key for dedup or audit indexes.
+func CanonicalRef(ref string) string {
+	if !strings.HasPrefix(ref, refScheme) && strings.Contains(ref, "://") {
+		return ref
+	}
+	ref, field, hasField := strings.Cut(ref, refFieldSep)
+	ref, _, _ = strings.Cut(ref, refFragmentSep)
+	_, name := splitPartition(strings.TrimPrefix(ref, refScheme))
+	hash, _, _ := strings.Cut(name, ".")
+	if hasField {
+		return refScheme + strings.ToLower(hash) + refFieldSep + field
+	}
+	return refScheme + strings.ToLower(hash)
+}
+
+// splitPartition splits the partition off the front of s, the part of a
+// reference after its scheme. It returns an empty partition when s does not
+// start with a well-formed one, so nothing else is taken for a path.
+func splitPartition(s string) (partition, rest string) {
+	rest = s
+	if dir, after, ok := strings.Cut(rest, "/"); ok && strings.HasPrefix(dir, retentionDirPrefix) {
+		days := strings.TrimSuffix(strings.TrimPrefix(dir, retentionDirPrefix), "d")
+		if !strings.HasSuffix(dir, "d") || days == "" || strings.Trim(days, "0123456789") != "" || days[0] == '0' {
+			return "", s
+		}
+		rest = after
+	}
+	const layout = "2006/01/02/"
+	if len(rest) < len(layout) {
+		return "", s
+	}
+	if _, err := time.Parse(layout, rest[:len(layout)]); err != nil {
+		return "", s
+	}
+	rest = rest[len(layout):]
+	return strings.TrimSuffix(s[:len(s)-len(rest)], "/"), rest
+}
+
+// RefsEqual reports whether two references identify the same content.
+func RefsEqual(a, b string) bool {
+	return CanonicalRef(a) == CanonicalRef(b)
+}
+
+// isVaultRef reports whether s is a reference produced by a vault rather
+// than content.
+func isVaultRef(s string) bool {
+	return strings.HasPrefix(s, refScheme) || strings.HasPrefix(s, backendRefScheme)
+}
+
+// essentialRef extracts the bare vault://<hash> from ref, which may be a
+// longer encoding embedding it, dropping any partition. It returns "" when
+// ref holds no vault reference.
+func essentialRef(ref string) string {
+	i := strings.Index(ref, refScheme)
+	if i < 0 {
+		return ""
+	}
+	_, hash := splitPartition(ref[i+len(refScheme):])
+	end := strings.IndexFunc(hash, func(r rune) bool {
+		return !('0' <= r && r <= '9' || 'a' <= r && r <= 'f' || 'A' <= r && r <= 'F')
+	})
+	if end >= 0 {
+		hash = hash[:end]
+	}
+	if hash == "" {
+		return ""
+	}
+	return refScheme + strings.ToLower(hash)
+}
+
+// allowedSchemes returns the reference schemes to follow: schemes when set,
+// otherwise the scheme of vault's own references, or both vault schemes
+// for a vault that does not report one (see RefSchemer).
+func allowedSchemes(schemes []string, vault any) map[string]bool {
+	if len(schemes) == 0 {
+		if schemer, ok := vault.(RefSchemer); ok {
+			schemes = []string{schemer.RefScheme()}
+		} else {
+			schemes = []string{strings.TrimSuffix(refScheme, "://"), strings.TrimSuffix(backendRefScheme, "://")}
+		}
+	}
+	allowed := make(map[string]bool, len(schemes))
+	for _, s := range schemes {
+		allowed[strings.TrimSuffix(strings.ToLower(s), "://")] = true
+	}
+	return allowed
+}
+
+// refSchemeAllowed reports whether ref uses one of the allowed schemes.
+// References without a scheme are never allowed.
+func refSchemeAllowed(ref string, allowed map[string]bool) bool {
+	scheme, _, ok := strings.Cut(ref, "://")
+	return ok && allowed[strings.ToLower(scheme)]
+}
@@ -0,0 +1,93 @@
+package promptvaultprocessor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRefsEqual(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	content := []byte("same content, different tags")
+
+	textRef, _ := vault.StoreTyped(content, contentTypeText)
+	binRef, _ := vault.StoreTyped(content, contentTypeBinary)
+	otherRef, _ := vault.Store([]byte("different content"))
+	legacyRef := CanonicalRef(textRef)
+
+	if textRef == binRef {
+		t.Fatalf("expected refs to differ as strings, both are %s", textRef)
+	}
+	if !RefsEqual(textRef, binRef) {
+		t.Errorf("expected %s and %s to identify the same content", textRef, binRef)
+	}
+	if !RefsEqual(textRef, legacyRef) {
+		t.Errorf("expected %s and legacy %s to identify the same content", textRef, legacyRef)
+	}
+	if RefsEqual(textRef, otherRef) {
+		t.Errorf("expected %s and %s to differ", textRef, otherRef)
+	}
+
+	index := map[string]int{}
+	for _, ref := range []string{textRef, binRef, legacyRef, otherRef} {
+		index[CanonicalRef(ref)]++
+	}
+	if len(index) != 2 {
+		t.Errorf("expected 2 canonical keys, got %v", index)
+	}
+}
+
+func TestRefsEqualBackendSchemes(t *testing.T) {
+	s3Ref := s3RefPrefix + "bucket/prompts/" + strings.Repeat("a", 64) + ".txt"
+	if got := CanonicalRef(s3Ref); got != s3Ref {
+		t.Errorf("expected %s unchanged, got %s", s3Ref, got)
+	}
+	other := s3RefPrefix + "bucket/prompts/" + strings.Repeat("b", 64) + ".txt"
+	if RefsEqual(s3Ref, other) {
+		t.Errorf("expected %s and %s to differ", s3Ref, other)
+	}
+	if RefsEqual(s3Ref, refScheme+strings.Repeat("a", 64)) {
+		t.Error("expected a backend reference not to equal a filesystem one")
+	}
+
+	hash := strings.Repeat("c", 64)
+	if got := CanonicalRef(hash + ".txt"); got != refScheme+hash {
+		t.Errorf("expected a bare reference read as a filesystem one, got %s", got)
+	}
+}
+
+func TestRefsEqualBundleFields(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir(), WithSecondaryChecksum())
+	base, _ := vault.Store([]byte(`{"gen_ai.prompt":"Hi","gen_ai.completion":"Hello"}`))
+	prompt := base + refFieldSep + "gen_ai.prompt"
+	completion := base + refFieldSep + "gen_ai.completion"
+	if RefsEqual(prompt, completion) {
+		t.Errorf("expected two fields of one bundle to differ: %s, %s", prompt, completion)
+	}
+	if RefsEqual(prompt, base) {
+		t.Error("expected a bundle field not to equal the whole bundle")
+	}
+	// Integrity fragments still do not matter.
+	bare, _, _ := strings.Cut(base, refFragmentSep)
+	if !RefsEqual(prompt, bare+refFieldSep+"gen_ai.prompt") {
+		t.Errorf("expected %s to equal the field without integrity fragments", prompt)
+	}
+}
+
+func TestEssentialRef(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{"vault://ABCdef01.json", "vault://abcdef01"},
+		{"vault://2024/01/02/abcdef01.txt", "vault://abcdef01"},
+		{"vault://retention-30d/2024/01/02/abcdef01.txt#b2=ff", "vault://abcdef01"},
+		{`{"uri":"vault://abcdef01.txt","preview":"Tell me"}`, "vault://abcdef01"},
+		{"s3://bucket/key", ""},
+		{"vault://.txt", ""},
+	}
+	for _, tt := range tests {
+		if got := essentialRef(tt.ref); got != tt.want {
+			t.Errorf("essentialRef(%q) = %q, want %q", tt.ref, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package promptvaultprocessor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.uber.org/zap"
+)
+
+// parseDestructiveAfter parses destructive_after as either a timestamp or a
+// delay after start. An empty value returns zero for both.
+func parseDestructiveAfter(after string) (time.Time, time.Duration, error) {
+	if after == "" {
+		return time.Time{}, 0, nil
+	}
+	if at, err := time.Parse(time.RFC3339, after); err == nil {
+		return at, 0, nil
+	}
+	delay, err := time.ParseDuration(after)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("vault.destructive_after %q is neither an RFC 3339 timestamp nor a duration", after)
+	}
+	return time.Time{}, delay, nil
+}
+
+// effectiveMode returns the configured mode, or keep_and_ref while the
+// destructive_after grace period is still running.
+func (p *vaultProcessor) effectiveMode() string {
+	if !p.destructiveAt.IsZero() && p.now().Before(p.destructiveAt) {
+		return "keep_and_ref"
+	}
+	return p.config.Vault.Mode
+}
+
+// applyReferencePolicy handles a matched attribute whose value is already
+// a reference under on_reference "validate" or "rewrite", and reports
+// whether the attribute now counts as offloaded. "validate" only checks
+// the reference resolves.
+func (p *vaultProcessor) applyReferencePolicy(ctx context.Context, attrs pcommon.Map, mode string, existing vaultedAttr) bool {
+	if p.config.Vault.OnReference == "validate" {
+		if _, err := resolveAllowed(p.vault.(VaultRetriever), existing.ref, p.allowedSchemes); err != nil {
+			p.logger.Warn("attribute holds a reference that does not resolve",
+				zap.String("key", existing.key),
+				zap.String("ref", existing.ref),
+				zap.Error(err),
+			)
+			p.metrics.danglingRefs.Add(ctx, 1)
+		}
+		return false
+	}
+	p.rewriteRef(attrs, mode, existing.key, existing.ref)
+	return true
+}
+
+// rewriteRef lays out an attribute that already held ref as if it had just
+// been offloaded in mode. Sidecar mode has no original to keep.
+func (p *vaultProcessor) rewriteRef(attrs pcommon.Map, mode, key, ref string) {
+	switch mode {
+	case "remove":
+		attrs.Remove(key)
+	case "keep_and_ref":
+	default:
+		attrs.PutStr(key, p.primaryRef(ref))
+	}
+	attrs.PutStr(p.refKey(key), ref)
+}
+
+// primaryRef returns the reference to write into the original attribute,
+// shortened to its essential form when it exceeds MaxRefValueLength.
+func (p *vaultProcessor) primaryRef(ref string) string {
+	limit := p.config.Vault.MaxRefValueLength
+	if limit <= 0 || len(ref) <= limit {
+		return ref
+	}
+	base, field, bundled := strings.Cut(ref, refFieldSep)
+	if short := essentialRef(base); short != "" {
+		if bundled {
+			short += refFieldSep + field
+		}
+		return short
+	}
+	return ref
+}
+
+// refKey returns the attribute name holding the reference for key.
+func (p *vaultProcessor) refKey(key string) string {
+	return p.config.Vault.RefNamespace + key + p.config.Vault.RefSuffix
+}
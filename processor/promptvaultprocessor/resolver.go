@@ -0,0 +1,64 @@
+package promptvaultprocessor
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// newResolveHandler serves GET /resolve?ref=<ref>, returning the vaulted
+// content for ref: a bundle field, or the whole history for a conversation
+// turn. Every request must carry "Authorization: Bearer <token>".
+// References whose scheme is not allowed (see allowedSchemes) are refused
+// before the vault is consulted.
+func newResolveHandler(vault VaultRetriever, token string, allowed map[string]bool) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/resolve", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorized(r, token) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		ref := r.URL.Query().Get("ref")
+		if ref == "" {
+			http.Error(w, "missing ref parameter", http.StatusBadRequest)
+			return
+		}
+		if !refSchemeAllowed(ref, allowed) {
+			http.Error(w, "reference scheme not allowed", http.StatusForbidden)
+			return
+		}
+		data, err := resolveRef(vault, ref)
+		if err != nil {
+			http.Error(w, "reference not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", resolveContentType(data))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		_, _ = w.Write(data)
+	})
+	return mux
+}
+
+func authorized(r *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+func resolveContentType(data []byte) string {
+	switch detectContentType(data) {
+	case contentTypeJSON:
+		return "application/json"
+	case contentTypeText:
+		return "text/plain; charset=utf-8"
+	default:
+		return "application/octet-stream"
+	}
+}
@@ -0,0 +1,121 @@
+package promptvaultprocessor
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"go.opentelemetry.io/collector/consumer/consumertest"
+)
+
+func TestResolveHandler(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	ref, _ := vault.Store([]byte("Tell me about quantum computing"))
+
+	srv := httptest.NewServer(newResolveHandler(vault, "s3cret", map[string]bool{"vault": true}))
+	defer srv.Close()
+
+	get := func(ref, token string) (int, string) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/resolve?ref="+url.QueryEscape(ref), nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if code, body := get(ref, "s3cret"); code != http.StatusOK || body != "Tell me about quantum computing" {
+		t.Errorf("expected 200 with content, got %d: %q", code, body)
+	}
+	if code, _ := get(ref, ""); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without token, got %d", code)
+	}
+	if code, _ := get(ref, "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 with wrong token, got %d", code)
+	}
+	if code, _ := get("vault://0000.txt", "s3cret"); code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown ref, got %d", code)
+	}
+	if code, _ := get("http://169.254.169.254/latest/meta-data", "s3cret"); code != http.StatusForbidden {
+		t.Errorf("expected 403 for an unlisted scheme, got %d", code)
+	}
+	if code, _ := get("0000.txt", "s3cret"); code != http.StatusForbidden {
+		t.Errorf("expected 403 for a reference without a scheme, got %d", code)
+	}
+}
+
+func TestResolverRequiresAuthToken(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	cfg := createDefaultConfig()
+	cfg.Resolver.Enabled = true
+	cfg.Resolver.Endpoint = "localhost:0"
+	proc := newTestProcessor(t, cfg, vault, new(consumertest.TracesSink))
+
+	if err := proc.Start(context.Background(), nil); err == nil {
+		proc.Shutdown(context.Background())
+		t.Fatal("expected Start to fail without an auth token")
+	}
+
+	cfg.Resolver.AuthToken = "s3cret"
+	if err := proc.Start(context.Background(), nil); err != nil {
+		t.Fatalf("unexpected start error: %v", err)
+	}
+	if err := proc.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
+}
+
+func TestResolveHandlerConversation(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	log := newConversationLog(10, 0)
+	log.store(vault.Store, vault.Touch, "conv", "k", []byte(`{"role":"user","content":"hello"}`))
+	ref, _ := log.store(vault.Store, vault.Touch, "conv", "k", []byte(`{"role":"user","content":"hello"}{"role":"assistant","content":"hi"}`))
+
+	srv := httptest.NewServer(newResolveHandler(vault, "s3cret", map[string]bool{"vault": true}))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/resolve?ref="+url.QueryEscape(ref), nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if want := `{"role":"user","content":"hello"}{"role":"assistant","content":"hi"}`; resp.StatusCode != http.StatusOK || string(body) != want {
+		t.Errorf("expected 200 with the full conversation, got %d: %q", resp.StatusCode, body)
+	}
+}
+
+func TestAllowedSchemesDefaultToBackend(t *testing.T) {
+	fsVault, _ := NewFilesystemVault(t.TempDir())
+	for _, tt := range []struct {
+		name    string
+		vault   VaultStorage
+		schemes []string
+		want    map[string]bool
+	}{
+		{name: "filesystem", vault: fsVault, want: map[string]bool{"vault": true}},
+		{name: "kafka", vault: newKafkaVault(&fakeKafka{}, "prompts", 0), want: map[string]bool{"promptvault": true}},
+		{name: "memory", vault: NewMemoryVault(), want: map[string]bool{"promptvault": true}},
+		{name: "configured", vault: fsVault, schemes: []string{"Vault", "promptvault://"}, want: map[string]bool{"vault": true, "promptvault": true}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := createDefaultConfig()
+			cfg.Vault.AllowedSchemes = tt.schemes
+			proc := newTestProcessor(t, cfg, tt.vault, new(consumertest.TracesSink))
+			if !reflect.DeepEqual(proc.allowedSchemes, tt.want) {
+				t.Errorf("expected allowed schemes %v, got %v", tt.want, proc.allowedSchemes)
+			}
+		})
+	}
+}
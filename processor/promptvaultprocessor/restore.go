@@ -0,0 +1,235 @@
+package promptvaultprocessor
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+// RestoreContent puts the original content back into the vaulted
+// attributes of td (resource, scope, span and span event attributes) and
+// removes their reference and sidecar attributes. It does not depend on
+// cfg.Mode: each attribute is restored from wherever its reference is, so
+// traces written under different modes restore alike. A reference in the attribute itself
+// (replace_with_ref, sidecar) or only in its reference attribute (remove) is
+// resolved; content still in place (keep_and_ref) is kept. Bundle and
+// conversation references resolve to their content. Only references with a
+// scheme in cfg.AllowedSchemes are looked up. With cfg.RehydrateMaxBytes,
+// each span and its events get at most that many bytes of content back;
+// the remaining references are left in place without error. Attributes
+// that cannot be resolved are left as they are and reported in the
+// returned error.
+func RestoreContent(td ptrace.Traces, vault VaultRetriever, cfg VaultConfig) (restored int, err error) {
+	r := newRestorer(vault, cfg)
+	rss := td.ResourceSpans()
+	for i := 0; i < rss.Len(); i++ {
+		rs := rss.At(i)
+		r.restore(rs.Resource().Attributes())
+		sss := rs.ScopeSpans()
+		for j := 0; j < sss.Len(); j++ {
+			ss := sss.At(j)
+			r.restore(ss.Scope().Attributes())
+			spans := ss.Spans()
+			for k := 0; k < spans.Len(); k++ {
+				span := spans.At(k)
+				r.startBudget()
+				r.restore(span.Attributes())
+				for e := 0; e < span.Events().Len(); e++ {
+					r.restore(span.Events().At(e).Attributes())
+				}
+				r.endBudget()
+			}
+		}
+	}
+	return r.restored, errors.Join(r.errs...)
+}
+
+type restorer struct {
+	vault    VaultRetriever
+	cfg      VaultConfig
+	allowed  map[string]bool
+	restored int
+	errs     []error
+
+	// budget is the content still allowed into the current span, log
+	// record or data point under cfg.RehydrateMaxBytes; -1 is unlimited.
+	budget int
+}
+
+func newRestorer(vault VaultRetriever, cfg VaultConfig) *restorer {
+	return &restorer{vault: vault, cfg: cfg, allowed: allowedSchemes(cfg.AllowedSchemes, vault), budget: -1}
+}
+
+// startBudget starts a span, log record or data point under
+// cfg.RehydrateMaxBytes; endBudget ends it.
+func (r *restorer) startBudget() {
+	if r.cfg.RehydrateMaxBytes > 0 {
+		r.budget = r.cfg.RehydrateMaxBytes
+	}
+}
+
+func (r *restorer) endBudget() {
+	r.budget = -1
+}
+
+func (r *restorer) restore(attrs pcommon.Map) {
+	// Reference attributes first, so they are not mistaken for attributes
+	// replaced by their reference.
+	siblings := map[string]string{}
+	attrs.Range(func(key string, val pcommon.Value) bool {
+		if base, ok := r.baseKey(key); ok && val.Type() == pcommon.ValueTypeStr && isVaultRef(val.Str()) {
+			siblings[base] = val.Str()
+		}
+		return true
+	})
+	primaries := map[string]string{}
+	attrs.Range(func(key string, val pcommon.Value) bool {
+		if _, ok := r.baseKey(key); !ok && val.Type() == pcommon.ValueTypeStr && isVaultRef(val.Str()) {
+			primaries[key] = val.Str()
+		}
+		return true
+	})
+
+	keys := make([]string, 0, len(siblings)+len(primaries))
+	for key := range siblings {
+		keys = append(keys, key)
+	}
+	for key := range primaries {
+		if _, ok := siblings[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		// The reference attribute holds the full reference; the attribute
+		// itself may hold a shortened one (max_ref_value_length).
+		ref, ok := siblings[key]
+		if !ok {
+			ref = primaries[key]
+		}
+		if _, inPlace := attrs.Get(key); inPlace && primaries[key] == "" {
+			r.clear(attrs, key)
+			r.restored++
+			continue
+		}
+		content, err := resolveAllowed(r.vault, ref, r.allowed)
+		if err != nil {
+			r.errs = append(r.errs, fmt.Errorf("restore %s from %s: %w", key, ref, err))
+			continue
+		}
+		if r.budget >= 0 {
+			if len(content) > r.budget {
+				continue
+			}
+			r.budget -= len(content)
+		}
+		if err := putContent(attrs, key, ref, content); err != nil {
+			r.errs = append(r.errs, fmt.Errorf("restore %s from %s: %w", key, ref, err))
+			continue
+		}
+		r.clear(attrs, key)
+		r.restored++
+	}
+}
+
+// putContent sets key to content as the value type recorded in ref's
+// extension: bytes for binary objects, a map or slice for JSON-encoded
+// structured values, a string otherwise.
+func putContent(attrs pcommon.Map, key, ref string, content []byte) error {
+	switch refContentType(ref) {
+	case contentTypeBinary:
+		attrs.PutEmptyBytes(key).FromRaw(content)
+	case contentTypeMap:
+		var raw map[string]any
+		if err := decodeJSON(content, &raw); err != nil {
+			return err
+		}
+		return attrs.PutEmptyMap(key).FromRaw(normalizeJSON(raw).(map[string]any))
+	case contentTypeSlice:
+		var raw []any
+		if err := decodeJSON(content, &raw); err != nil {
+			return err
+		}
+		return attrs.PutEmptySlice(key).FromRaw(normalizeJSON(raw).([]any))
+	default:
+		attrs.PutStr(key, string(content))
+	}
+	return nil
+}
+
+// decodeJSON decodes content into v, keeping numbers as json.Number.
+func decodeJSON(content []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// normalizeJSON converts the json.Numbers in v to int64 where they are
+// integral and float64 otherwise, so integer attributes keep their type.
+func normalizeJSON(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for k, e := range v {
+			v[k] = normalizeJSON(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = normalizeJSON(e)
+		}
+	}
+	return v
+}
+
+// baseKey returns the attribute key a reference attribute name belongs to.
+func (r *restorer) baseKey(key string) (string, bool) {
+	ns, suffix := r.cfg.RefNamespace, r.cfg.RefSuffix
+	if ns == "" && suffix == "" || len(key) <= len(ns)+len(suffix) {
+		return "", false
+	}
+	if !strings.HasPrefix(key, ns) || !strings.HasSuffix(key, suffix) {
+		return "", false
+	}
+	return key[len(ns) : len(key)-len(suffix)], true
+}
+
+// clear removes the reference and sidecar attributes of key.
+func (r *restorer) clear(attrs pcommon.Map, key string) {
+	attrs.Remove(r.cfg.RefNamespace + key + r.cfg.RefSuffix)
+	if r.cfg.Sidecar.Suffix != "" {
+		attrs.Remove(key + r.cfg.Sidecar.Suffix)
+	}
+}
+
+// errSchemeNotAllowed reports a reference whose scheme is not in
+// vault.allowed_schemes; it is never looked up.
+var errSchemeNotAllowed = errors.New("reference scheme not allowed")
+
+// resolveAllowed is resolveRef for references with an allowed scheme.
+func resolveAllowed(vault VaultRetriever, ref string, allowed map[string]bool) ([]byte, error) {
+	if !refSchemeAllowed(ref, allowed) {
+		return nil, errSchemeNotAllowed
+	}
+	return resolveRef(vault, ref)
+}
+
+// resolveRef retrieves the content behind any reference the processor
+// writes, following bundle fields and conversation delta chains.
+func resolveRef(vault VaultRetriever, ref string) ([]byte, error) {
+	if strings.Contains(ref, refFieldSep) {
+		return ResolveBundleField(vault, ref)
+	}
+	return ResolveConversation(vault, ref)
+}
@@ -0,0 +1,303 @@
+package promptvaultprocessor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/otel/metric/noop"
+	"go.uber.org/zap"
+)
+
+func TestRestoreContentAcrossModes(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	prompts := map[string]string{
+		"replace_with_ref": "Tell me about quantum computing",
+		"remove":           "Tell me about black holes",
+		"keep_and_ref":     "Tell me about entropy",
+		"sidecar":          "Tell me about superconductors",
+	}
+
+	// Write one span under each mode, then archive them together.
+	archive := ptrace.NewTraces()
+	spans := archive.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans()
+	modes := []string{"replace_with_ref", "remove", "keep_and_ref", "sidecar"}
+	for _, mode := range modes {
+		sink := new(consumertest.TracesSink)
+		cfg := createDefaultConfig()
+		cfg.Vault.Mode = mode
+		proc := newTestProcessor(t, cfg, vault, sink)
+
+		td := ptrace.NewTraces()
+		attrs := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty().Attributes()
+		attrs.PutStr("gen_ai.prompt", prompts[mode])
+		attrs.PutStr("http.route", "/chat")
+		if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+			t.Fatalf("%s: unexpected error: %v", mode, err)
+		}
+		sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).CopyTo(spans.AppendEmpty())
+	}
+
+	// Restore under a single current config whose mode matches none of
+	// the spans in particular.
+	current := createDefaultConfig().Vault
+	current.Mode = "remove"
+	restored, err := RestoreContent(archive, vault, current)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if restored != len(modes) {
+		t.Errorf("expected %d restored attributes, got %d", len(modes), restored)
+	}
+
+	for i, mode := range modes {
+		attrs := spans.At(i).Attributes()
+		if v, _ := attrs.Get("gen_ai.prompt"); v.Str() != prompts[mode] {
+			t.Errorf("%s: expected original content, got %q", mode, v.Str())
+		}
+		if _, ok := attrs.Get("gen_ai.prompt.vault_ref"); ok {
+			t.Errorf("%s: expected the reference attribute to be removed", mode)
+		}
+		if _, ok := attrs.Get("gen_ai.prompt.vault_sidecar"); ok {
+			t.Errorf("%s: expected the sidecar attribute to be removed", mode)
+		}
+		if v, _ := attrs.Get("http.route"); v.Str() != "/chat" {
+			t.Errorf("%s: expected unrelated attributes untouched, got %q", mode, v.Str())
+		}
+	}
+}
+
+func TestRestoreStructuredValues(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	sink := new(consumertest.TracesSink)
+	cfg := createDefaultConfig()
+	cfg.Vault.Keys = append(cfg.Vault.Keys, "gen_ai.input.messages", "gen_ai.request.options")
+	proc := newTestProcessor(t, cfg, vault, sink)
+
+	td := ptrace.NewTraces()
+	attrs := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty().Attributes()
+	messages := attrs.PutEmptySlice("gen_ai.input.messages")
+	system := messages.AppendEmpty().SetEmptyMap()
+	system.PutStr("role", "system")
+	system.PutStr("content", "You are a helpful assistant.")
+	user := messages.AppendEmpty().SetEmptyMap()
+	user.PutStr("role", "user")
+	user.PutEmptySlice("parts").AppendEmpty().SetEmptyMap().PutStr("text", "Tell me about quantum computing")
+	options := attrs.PutEmptyMap("gen_ai.request.options")
+	options.PutInt("max_tokens", 512)
+	options.PutDouble("temperature", 0.7)
+	options.PutBool("stream", true)
+	want := ptrace.NewTraces()
+	td.CopyTo(want)
+
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	archived := sink.AllTraces()[0]
+	vaulted := archived.ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	if v, _ := vaulted.Get("gen_ai.input.messages.vault_ref"); !strings.HasSuffix(v.Str(), ".slice") {
+		t.Errorf("expected a slice reference, got %q", v.Str())
+	}
+	if v, _ := vaulted.Get("gen_ai.request.options.vault_ref"); !strings.HasSuffix(v.Str(), ".map") {
+		t.Errorf("expected a map reference, got %q", v.Str())
+	}
+
+	if _, err := RestoreContent(archived, vault, cfg.Vault); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := archived.ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	wantAttrs := want.ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	for _, key := range []string{"gen_ai.input.messages", "gen_ai.request.options"} {
+		g, _ := got.Get(key)
+		w, _ := wantAttrs.Get(key)
+		if g.Type() != w.Type() || !reflect.DeepEqual(g.AsRaw(), w.AsRaw()) {
+			t.Errorf("expected %s restored as %s, got %s %s", key, w.AsString(), g.Type(), g.AsString())
+		}
+	}
+}
+
+func TestRestoreContentUnresolvable(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	td := ptrace.NewTraces()
+	attrs := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty().Attributes()
+	missing := "vault://" + strings.Repeat("0", 64) + ".txt"
+	attrs.PutStr("gen_ai.prompt.vault_ref", missing)
+
+	restored, err := RestoreContent(td, vault, createDefaultConfig().Vault)
+	if err == nil || restored != 0 {
+		t.Fatalf("expected an error and nothing restored, got %d (%v)", restored, err)
+	}
+	if v, _ := attrs.Get("gen_ai.prompt.vault_ref"); v.Str() != missing {
+		t.Error("expected an unresolvable reference to be left in place")
+	}
+}
+
+func TestVaultRehydrateRoundTrip(t *testing.T) {
+	fsVault, _ := NewFilesystemVault(t.TempDir())
+	for _, tt := range []struct {
+		name  string
+		vault VaultStorage
+	}{
+		{name: "filesystem", vault: fsVault},
+		{name: "kafka", vault: newKafkaVault(&fakeKafka{}, "prompts", 0)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// The first collector offloads, the second restores.
+			offloaded := new(consumertest.TracesSink)
+			offloader := newTestProcessor(t, createDefaultConfig(), tt.vault, offloaded)
+			restored := new(consumertest.TracesSink)
+			cfg := createDefaultConfig()
+			cfg.Vault.Rehydrate = true
+			rehydrator := newTestProcessor(t, cfg, tt.vault, restored)
+
+			td := ptrace.NewTraces()
+			span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+			span.Attributes().PutStr("gen_ai.prompt", "Tell me about quantum computing")
+			span.Attributes().PutStr("http.route", "/chat")
+			span.Events().AppendEmpty().Attributes().PutStr("gen_ai.completion", "Quantum computing uses qubits")
+			if err := offloader.ConsumeTraces(context.Background(), td); err != nil {
+				t.Fatalf("offload failed: %v", err)
+			}
+			attrs := offloaded.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+			if v, _ := attrs.Get("gen_ai.prompt"); !isVaultRef(v.Str()) {
+				t.Fatalf("expected the prompt offloaded, got %q", v.Str())
+			}
+
+			if err := rehydrator.ConsumeTraces(context.Background(), offloaded.AllTraces()[0]); err != nil {
+				t.Fatalf("rehydrate failed: %v", err)
+			}
+			got := restored.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0)
+			want := map[string]any{"gen_ai.prompt": "Tell me about quantum computing", "http.route": "/chat"}
+			if raw := got.Attributes().AsRaw(); len(raw) != len(want) || raw["gen_ai.prompt"] != want["gen_ai.prompt"] {
+				t.Errorf("expected %v, got %v", want, raw)
+			}
+			if v, _ := got.Events().At(0).Attributes().Get("gen_ai.completion"); v.Str() != "Quantum computing uses qubits" {
+				t.Errorf("expected the event attribute rehydrated, got %q", v.Str())
+			}
+		})
+	}
+}
+
+func TestVaultRehydrateLeavesUnresolvedRefs(t *testing.T) {
+	cfg := createDefaultConfig()
+	cfg.Vault.Rehydrate = true
+	sink := new(consumertest.TracesSink)
+	vault, _ := NewFilesystemVault(t.TempDir())
+	proc := newTestProcessor(t, cfg, vault, sink)
+
+	td := ptrace.NewTraces()
+	missing := "vault://" + strings.Repeat("0", 64) + ".txt"
+	td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty().Attributes().PutStr("gen_ai.prompt", missing)
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	attrs := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	if v, _ := attrs.Get("gen_ai.prompt"); v.Str() != missing {
+		t.Errorf("expected the unresolved reference left in place, got %q", v.Str())
+	}
+
+	set := component.TelemetrySettings{Logger: zap.NewNop(), MeterProvider: noop.NewMeterProvider()}
+	if _, err := newVaultProcessor(set, cfg, failingVault{}, sink); err == nil {
+		t.Error("expected an error for rehydrate with a vault that cannot retrieve")
+	}
+}
+
+// recordingRetriever records the references looked up in the vault.
+type recordingRetriever struct {
+	*FilesystemVault
+	lookups map[string]bool
+}
+
+func (r *recordingRetriever) Retrieve(ref string) ([]byte, error) {
+	r.lookups[ref] = true
+	return r.FilesystemVault.Retrieve(ref)
+}
+
+func TestRestoreContentAllowedSchemes(t *testing.T) {
+	fsVault, _ := NewFilesystemVault(t.TempDir())
+	ref, _ := fsVault.Store([]byte("Tell me about quantum computing"))
+	foreign := "promptvault://s3/untrusted-bucket/" + strings.Repeat("0", 64) + ".txt"
+
+	for _, tt := range []struct {
+		name       string
+		schemes    []string
+		wantPrompt string
+		refused    string
+	}{
+		{name: "default follows the vault's own scheme", wantPrompt: "Tell me about quantum computing", refused: foreign},
+		{name: "unlisted scheme is refused", schemes: []string{"promptvault"}, wantPrompt: ref, refused: ref},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			vault := &recordingRetriever{FilesystemVault: fsVault, lookups: map[string]bool{}}
+			cfg := createDefaultConfig().Vault
+			cfg.AllowedSchemes = tt.schemes
+			td := ptrace.NewTraces()
+			attrs := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty().Attributes()
+			attrs.PutStr("gen_ai.prompt", ref)
+			attrs.PutStr("gen_ai.completion", foreign)
+
+			_, err := RestoreContent(td, vault, cfg)
+			if !errors.Is(err, errSchemeNotAllowed) {
+				t.Errorf("expected a refused reference reported, got %v", err)
+			}
+			if vault.lookups[tt.refused] {
+				t.Errorf("expected %s not looked up", tt.refused)
+			}
+			if v, _ := attrs.Get("gen_ai.prompt"); v.Str() != tt.wantPrompt {
+				t.Errorf("expected prompt %q, got %q", tt.wantPrompt, v.Str())
+			}
+			if v, _ := attrs.Get("gen_ai.completion"); v.Str() != foreign {
+				t.Errorf("expected the foreign reference left untouched, got %q", v.Str())
+			}
+		})
+	}
+}
+
+func TestRestoreContentBudget(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	td := ptrace.NewTraces()
+	rs := td.ResourceSpans().AppendEmpty()
+	system, _ := vault.Store([]byte(strings.Repeat("s", 3000)))
+	rs.Resource().Attributes().PutStr("gen_ai.system_instructions", system)
+	spans := rs.ScopeSpans().AppendEmpty().Spans()
+	for i := 0; i < 2; i++ {
+		span := spans.AppendEmpty()
+		for _, key := range []string{"a.prompt", "b.prompt", "c.prompt"} {
+			ref, _ := vault.Store([]byte(strings.Repeat(key[:1], 1000) + fmt.Sprint(i)))
+			span.Attributes().PutStr(key, ref)
+		}
+	}
+
+	cfg := createDefaultConfig().Vault
+	cfg.RehydrateMaxBytes = 2500
+	restored, err := RestoreContent(td, vault, cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Two of each span's three values fit; resource attributes are not
+	// part of any span's budget.
+	if restored != 5 {
+		t.Errorf("expected 5 values restored, got %d", restored)
+	}
+	for i := 0; i < spans.Len(); i++ {
+		var size, refs int
+		spans.At(i).Attributes().Range(func(_ string, v pcommon.Value) bool {
+			if isVaultRef(v.Str()) {
+				refs++
+			} else {
+				size += len(v.Str())
+			}
+			return true
+		})
+		if size > cfg.RehydrateMaxBytes || refs != 1 {
+			t.Errorf("span %d: expected one reference left and at most %d bytes restored, got %d refs, %d bytes", i, cfg.RehydrateMaxBytes, refs, size)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package promptvaultprocessor
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// s3RefPrefix starts every reference produced by an S3Vault; the bucket
+// and object key follow.
+const s3RefPrefix = backendRefScheme + "s3/"
+
+// errS3NotFound is returned by an S3Client Get that finds no object.
+var errS3NotFound = errors.New("no such object")
+
+// S3Client puts and gets objects in a single bucket.
+type S3Client interface {
+	Put(ctx context.Context, key string, body []byte, contentType string) error
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// S3Vault stores content as objects in an S3 (or S3-compatible, e.g.
+// MinIO) bucket, named <prefix><sha256>.<ext> like filesystem objects, so
+// identical content is written to the same key.
+type S3Vault struct {
+	client  S3Client
+	bucket  string
+	prefix  string
+	timeout time.Duration
+}
+
+// NewS3Vault creates an S3Vault for the bucket in cfg. Credentials come
+// from the default AWS chain (environment, shared config, instance role,
+// web identity); temporary ones are refreshed before they expire.
+func NewS3Vault(cfg S3Config) (*S3Vault, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("s3 backend requires a bucket")
+	}
+	client, err := newAWSS3Client(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newS3Vault(client, cfg.Bucket, cfg.Prefix, cfg.Timeout), nil
+}
+
+func newS3Vault(client S3Client, bucket, prefix string, timeout time.Duration) *S3Vault {
+	return &S3Vault{client: client, bucket: bucket, prefix: prefix, timeout: timeout}
+}
+
+// Store puts content under its checksum and returns a reference of the
+// form promptvault://s3/<bucket>/<prefix><sha256>.<ext>.
+func (v *S3Vault) Store(content []byte) (string, error) {
+	return v.StoreContext(context.Background(), content)
+}
+
+// StoreContext is Store bounded by ctx as well as the configured timeout.
+func (v *S3Vault) StoreContext(ctx context.Context, content []byte) (string, error) {
+	key := v.objectKey(content)
+	ctx, cancel := v.context(ctx)
+	defer cancel()
+	if err := v.client.Put(ctx, key, content, mimeTypes[detectContentType(content)]); err != nil {
+		return "", fmt.Errorf("put s3 object %s/%s: %w", v.bucket, key, err)
+	}
+	return s3RefPrefix + v.bucket + "/" + key, nil
+}
+
+// PredictRef returns the reference Store will return for content. Objects
+// are named by their detected type, so contentType is not used.
+func (v *S3Vault) PredictRef(content []byte, _ string) (string, error) {
+	return s3RefPrefix + v.bucket + "/" + v.objectKey(content), nil
+}
+
+// objectKey returns the key content is stored under.
+func (v *S3Vault) objectKey(content []byte) string {
+	return fmt.Sprintf("%s%x.%s", v.prefix, sha256.Sum256(content), contentTypeExt[detectContentType(content)])
+}
+
+// RefScheme reports "promptvault", the scheme of backend references.
+func (v *S3Vault) RefScheme() string {
+	return strings.TrimSuffix(backendRefScheme, "://")
+}
+
+// Retrieve gets the object named in ref and verifies it against the
+// checksum in its name.
+func (v *S3Vault) Retrieve(ref string) ([]byte, error) {
+	bucket, key, ok := strings.Cut(strings.TrimPrefix(ref, s3RefPrefix), "/")
+	if !strings.HasPrefix(ref, s3RefPrefix) || !ok {
+		return nil, fmt.Errorf("not an s3 vault ref: %s", ref)
+	}
+	if bucket != v.bucket {
+		return nil, fmt.Errorf("vault ref %s is for bucket %s, not %s", ref, bucket, v.bucket)
+	}
+
+	ctx, cancel := v.context(context.Background())
+	defer cancel()
+	content, err := v.client.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("vault ref not found: %s: %w", ref, err)
+	}
+	if !matchesKeyChecksum(key, content) {
+		return nil, fmt.Errorf("vault ref %s: object does not match its checksum", ref)
+	}
+	return content, nil
+}
+
+// matchesKeyChecksum reports whether content matches the SHA-256 an object
+// key <prefix><sha256>.<ext> is named after.
+func matchesKeyChecksum(key string, content []byte) bool {
+	checksum, _, _ := strings.Cut(path.Base(key), ".")
+	sum := sha256.Sum256(content)
+	return strings.EqualFold(hex.EncodeToString(sum[:]), checksum)
+}
+
+func (v *S3Vault) context(parent context.Context) (context.Context, context.CancelFunc) {
+	if v.timeout > 0 {
+		return context.WithTimeout(parent, v.timeout)
+	}
+	return context.WithCancel(parent)
+}
+
+// mimeTypes maps detected content types to the MIME type object store
+// objects are written with.
+var mimeTypes = map[string]string{
+	contentTypeText:   "text/plain; charset=utf-8",
+	contentTypeJSON:   "application/json",
+	contentTypeBinary: "application/octet-stream",
+	contentTypeGzip:   "application/gzip",
+}
+
+// awsCredentialsExpiryWindow is how long before they expire temporary
+// credentials are refreshed, so no request is signed with credentials
+// that expire in flight.
+const awsCredentialsExpiryWindow = time.Minute
+
+// awsExpiredCredentialCodes are the error codes S3 and STS answer requests
+// signed with expired or revoked temporary credentials with.
+var awsExpiredCredentialCodes = map[string]bool{
+	"ExpiredToken":          true,
+	"ExpiredTokenException": true,
+	"TokenRefreshRequired":  true,
+	"InvalidToken":          true,
+}
+
+// awsS3Client implements S3Client with the AWS SDK.
+type awsS3Client struct {
+	client *s3.Client
+	bucket string
+	// creds caches the credentials requests are signed with; it is
+	// invalidated when S3 reports them expired.
+	creds *aws.CredentialsCache
+}
+
+func newAWSS3Client(cfg S3Config) (*awsS3Client, error) {
+	awsCfg, err := awsconfig.LoadDefaultConfig(context.Background(),
+		awsconfig.WithRegion(cfg.Region),
+		awsconfig.WithCredentialsCacheOptions(func(o *aws.CredentialsCacheOptions) {
+			o.ExpiryWindow = awsCredentialsExpiryWindow
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("load aws config: %w", err)
+	}
+	return newAWSS3ClientFromConfig(awsCfg, cfg), nil
+}
+
+func newAWSS3ClientFromConfig(awsCfg aws.Config, cfg S3Config) *awsS3Client {
+	creds, ok := awsCfg.Credentials.(*aws.CredentialsCache)
+	if !ok && awsCfg.Credentials != nil {
+		creds = aws.NewCredentialsCache(awsCfg.Credentials, func(o *aws.CredentialsCacheOptions) {
+			o.ExpiryWindow = awsCredentialsExpiryWindow
+		})
+		awsCfg.Credentials = creds
+	}
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+			o.UsePathStyle = true
+		}
+	})
+	return &awsS3Client{client: client, bucket: cfg.Bucket, creds: creds}
+}
+
+func (c *awsS3Client) Put(ctx context.Context, key string, body []byte, contentType string) error {
+	_, err := c.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(c.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String(contentType),
+	})
+	return c.checkCredentials(err)
+}
+
+// checkCredentials marks err as ErrCredentialsExpired when S3 rejected the
+// request's credentials as expired, and drops the cached credentials so the
+// next request is signed with fresh ones.
+func (c *awsS3Client) checkCredentials(err error) error {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) || !awsExpiredCredentialCodes[apiErr.ErrorCode()] {
+		return err
+	}
+	if c.creds != nil {
+		c.creds.Invalidate()
+	}
+	return fmt.Errorf("%w: %w", ErrCredentialsExpired, err)
+}
+
+func (c *awsS3Client) Get(ctx context.Context, key string) ([]byte, error) {
+	out, err := c.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var missing *types.NoSuchKey
+		if errors.As(err, &missing) {
+			return nil, errS3NotFound
+		}
+		return nil, c.checkCredentials(err)
+	}
+	defer out.Body.Close()
+	return io.ReadAll(out.Body)
+}
@@ -0,0 +1,230 @@
+package promptvaultprocessor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/collector/processor/processortest"
+)
+
+// fakeS3 is an in-memory S3Client.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]string
+	types   map[string]string
+}
+
+func (s *fakeS3) Put(_ context.Context, key string, body []byte, contentType string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.objects == nil {
+		s.objects, s.types = map[string]string{}, map[string]string{}
+	}
+	s.objects[key] = string(body)
+	s.types[key] = contentType
+	return nil
+}
+
+func (s *fakeS3) Get(_ context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	body, ok := s.objects[key]
+	if !ok {
+		return nil, errS3NotFound
+	}
+	return []byte(body), nil
+}
+
+func TestS3VaultStoreAndRetrieve(t *testing.T) {
+	client := &fakeS3{}
+	vault := newS3Vault(client, "vault-bucket", "prompts/", 0)
+
+	content := []byte(`{"role":"user","content":"Tell me about quantum computing"}`)
+	ref, err := vault.Store(content)
+	if err != nil {
+		t.Fatalf("store failed: %v", err)
+	}
+	if !strings.HasPrefix(ref, "promptvault://s3/vault-bucket/prompts/") || !strings.HasSuffix(ref, ".json") {
+		t.Errorf("unexpected ref format: %s", ref)
+	}
+	key := strings.TrimPrefix(ref, "promptvault://s3/vault-bucket/")
+	if client.objects[key] != string(content) || client.types[key] != "application/json" {
+		t.Errorf("expected one JSON object under %s, got %v", key, client.types)
+	}
+	if again, _ := vault.Store(content); again != ref || len(client.objects) != 1 {
+		t.Errorf("expected identical content under the same key, got %s", again)
+	}
+
+	got, err := vault.Retrieve(ref)
+	if err != nil || string(got) != string(content) {
+		t.Fatalf("expected %q, got %q (%v)", content, got, err)
+	}
+	if _, err := vault.Retrieve("promptvault://s3/other-bucket/" + key); err == nil {
+		t.Error("expected an error for a ref in another bucket")
+	}
+	if _, err := vault.Retrieve("promptvault://s3/vault-bucket/prompts/0000.txt"); err == nil {
+		t.Error("expected an error for a missing object")
+	}
+
+	client.objects[key] = "tampered"
+	if _, err := vault.Retrieve(ref); err == nil {
+		t.Error("expected an error for an object not matching its checksum")
+	}
+}
+
+func TestS3VaultOffloads(t *testing.T) {
+	client := &fakeS3{}
+	sink := new(consumertest.TracesSink)
+	proc := newTestProcessor(t, createDefaultConfig(), newS3Vault(client, "vault-bucket", "", 0), sink)
+
+	td := ptrace.NewTraces()
+	span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+	span.Attributes().PutStr("gen_ai.prompt", "Tell me about quantum computing")
+	if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	attrs := sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	v, _ := attrs.Get("gen_ai.prompt")
+	if !strings.HasPrefix(v.Str(), s3RefPrefix) || len(client.objects) != 1 {
+		t.Errorf("expected the prompt offloaded to s3, got %s", v.Str())
+	}
+}
+
+func TestCreateTracesProcessorBackends(t *testing.T) {
+	factory := NewFactory()
+	set := processortest.NewNopSettings()
+
+	cfg := createDefaultConfig()
+	cfg.Storage.Backend = "s3"
+	if _, err := factory.CreateTracesProcessor(context.Background(), set, cfg, consumertest.NewNop()); err == nil {
+		t.Error("expected an error for the s3 backend without a bucket")
+	}
+
+	cfg = createDefaultConfig()
+	cfg.Storage.Backend = "gcs"
+	if _, err := factory.CreateTracesProcessor(context.Background(), set, cfg, consumertest.NewNop()); err == nil {
+		t.Error("expected an error for the gcs backend without a bucket")
+	}
+
+	cfg = createDefaultConfig()
+	cfg.Storage.Backend = "azure"
+	if _, err := factory.CreateTracesProcessor(context.Background(), set, cfg, consumertest.NewNop()); err == nil {
+		t.Error("expected an error for an unknown backend rather than falling back to filesystem")
+	}
+
+	cfg = createDefaultConfig()
+	cfg.Storage.Filesystem.BasePath = t.TempDir()
+	proc, err := factory.CreateTracesProcessor(context.Background(), set, cfg, consumertest.NewNop())
+	if err != nil {
+		t.Fatalf("unexpected error for the filesystem backend: %v", err)
+	}
+	if err := proc.Start(context.Background(), componenttest.NewNopHost()); err != nil {
+		t.Fatalf("unexpected start error: %v", err)
+	}
+	if err := proc.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
+}
+
+// expiringS3Server is an S3 endpoint that accepts puts signed with any
+// access key until it is expired, then answers ExpiredToken like S3.
+type expiringS3Server struct {
+	mu      sync.Mutex
+	expired map[string]bool
+	puts    map[string]int
+}
+
+func (s *expiringS3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_, credential, _ := strings.Cut(r.Header.Get("Authorization"), "Credential=")
+	accessKey, _, _ := strings.Cut(credential, "/")
+	io.Copy(io.Discard, r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.expired[accessKey] {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>ExpiredToken</Code><Message>The provided token has expired.</Message></Error>`)
+		return
+	}
+	s.puts[accessKey]++
+	w.Header().Set("ETag", `"etag"`)
+}
+
+func TestS3VaultCredentialRotation(t *testing.T) {
+	server := &expiringS3Server{expired: map[string]bool{}, puts: map[string]int{}}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	// Each fetch issues the next temporary credentials, valid for an hour.
+	var issued atomic.Int64
+	provider := aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+		n := issued.Add(1)
+		return aws.Credentials{
+			AccessKeyID:     fmt.Sprintf("ASIA%d", n),
+			SecretAccessKey: "secret",
+			SessionToken:    "token",
+			CanExpire:       true,
+			Expires:         time.Now().Add(time.Hour),
+		}, nil
+	})
+	awsCfg := aws.Config{
+		Region:      "us-east-1",
+		Credentials: provider,
+		Retryer:     func() aws.Retryer { return aws.NopRetryer{} },
+	}
+	client := newAWSS3ClientFromConfig(awsCfg, S3Config{Bucket: "vault-bucket", Endpoint: srv.URL})
+	set, reader := newTestTelemetry()
+	sink := new(consumertest.TracesSink)
+	proc, err := newVaultProcessor(set, createDefaultConfig(), newS3Vault(client, "vault-bucket", "", 0), sink)
+	if err != nil {
+		t.Fatalf("failed to create processor: %v", err)
+	}
+
+	consume := func(prompt string) string {
+		td := ptrace.NewTraces()
+		td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty().Attributes().PutStr("gen_ai.prompt", prompt)
+		if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		v, _ := td.ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes().Get("gen_ai.prompt")
+		return v.Str()
+	}
+	if got := consume("Tell me about quantum computing"); !strings.HasPrefix(got, s3RefPrefix) {
+		t.Fatalf("expected the prompt offloaded, got %q", got)
+	}
+
+	// The credentials expire early, e.g. revoked with their session.
+	server.mu.Lock()
+	server.expired["ASIA1"] = true
+	server.mu.Unlock()
+	if got := consume("Tell me about black holes"); !strings.HasPrefix(got, s3RefPrefix) {
+		t.Errorf("expected the prompt offloaded with refreshed credentials, got %q", got)
+	}
+	if server.puts["ASIA1"] != 1 || server.puts["ASIA2"] != 1 {
+		t.Errorf("expected one put with each credential, got %v", server.puts)
+	}
+	if n := counterValue(t, reader, "processor_promptvault_credential_expiries"); n != 1 {
+		t.Errorf("expected 1 credential expiry, got %d", n)
+	}
+	if n := proc.stats.storeFailures.Load(); n != 0 {
+		t.Errorf("expected no store failures, got %d", n)
+	}
+
+	// Errors unrelated to credentials are not marked.
+	if err := client.checkCredentials(errS3NotFound); errors.Is(err, ErrCredentialsExpired) {
+		t.Errorf("expected %v not to be a credential error", err)
+	}
+}
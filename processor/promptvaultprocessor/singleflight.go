@@ -0,0 +1,51 @@
+package promptvaultprocessor
+
+import "sync"
+
+// storeCall is a store in progress or completed.
+type storeCall struct {
+	wg   sync.WaitGroup
+	ref  string
+	err  error
+	dups int
+}
+
+// storeGroup collapses concurrent identical stores into one backend call.
+// Unlike deduplication, which skips content stored by an earlier call, it
+// only joins calls that overlap in time and keeps nothing afterwards.
+type storeGroup struct {
+	mu    sync.Mutex
+	calls map[string]*storeCall
+}
+
+// newStoreGroup returns nil when collapsing is disabled.
+func newStoreGroup(enabled bool) *storeGroup {
+	if !enabled {
+		return nil
+	}
+	return &storeGroup{calls: map[string]*storeCall{}}
+}
+
+// do runs fn unless a call with the same key is in flight, in which case it
+// waits for that call and returns its result.
+func (g *storeGroup) do(key string, fn func() (string, error)) (string, error) {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		c.dups++
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.ref, c.err
+	}
+	c := &storeCall{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	c.ref, c.err = fn()
+	c.wg.Done()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	return c.ref, c.err
+}
@@ -0,0 +1,74 @@
+package promptvaultprocessor
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/collector/consumer/consumertest"
+)
+
+// gatedVault counts stores and holds each one until release is closed.
+type gatedVault struct {
+	release chan struct{}
+	puts    atomic.Int64
+}
+
+func (v *gatedVault) Store(content []byte) (string, error) {
+	v.puts.Add(1)
+	<-v.release
+	return "vault://" + string(content), nil
+}
+
+func TestCollapseConcurrentStores(t *testing.T) {
+	vault := &gatedVault{release: make(chan struct{})}
+	cfg := createDefaultConfig()
+	cfg.Storage.CollapseConcurrentStores = true
+	proc := newTestProcessor(t, cfg, vault, new(consumertest.TracesSink))
+
+	const callers = 8
+	refs := make([]string, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ref, err := proc.store(context.Background(), "gen_ai.prompt", []byte("same"), "")
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			refs[i] = ref
+		}(i)
+	}
+
+	// Release the backend once every caller has joined the first store.
+	deadline := time.Now().Add(5 * time.Second)
+	for joined := 0; joined < callers-1; {
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d callers joined the in-flight store", joined)
+		}
+		time.Sleep(time.Millisecond)
+		proc.flights.mu.Lock()
+		joined = 0
+		for _, c := range proc.flights.calls {
+			joined = c.dups
+		}
+		proc.flights.mu.Unlock()
+	}
+	close(vault.release)
+	wg.Wait()
+
+	if puts := vault.puts.Load(); puts != 1 {
+		t.Errorf("expected exactly one backend store, got %d", puts)
+	}
+	for i, ref := range refs {
+		if ref != "vault://same" {
+			t.Errorf("caller %d: expected the shared reference, got %q", i, ref)
+		}
+	}
+	if len(proc.flights.calls) != 0 {
+		t.Errorf("expected completed stores to be forgotten, %d remain", len(proc.flights.calls))
+	}
+}
@@ -0,0 +1,177 @@
+package promptvaultprocessor
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// errThrottled is the failure recorded for attributes left unstored by the
+// max_bytes_per_second limit.
+var errThrottled = errors.New("max_bytes_per_second exceeded")
+
+// storeConcurrently calls store for 0 to n-1 on up to p.concurrency
+// goroutines and returns when all calls have.
+func (p *vaultProcessor) storeConcurrently(n int, store func(i int)) {
+	workers := min(p.concurrency, n)
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			store(i)
+		}
+		return
+	}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				store(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+}
+
+// sidecar encodes content for the sidecar attribute.
+func (p *vaultProcessor) sidecar(content []byte) ([]byte, error) {
+	if p.config.Vault.Sidecar.Compression == "gzip" {
+		return gzipBytes(content)
+	}
+	return content, nil
+}
+
+// store writes content to the vault, addressing it by key as well when
+// keyed addressing is enabled. A non-empty contentType is recorded in the
+// reference when the vault supports it. With collapse_concurrent_stores,
+// identical stores already in flight are joined instead of repeated. With
+// storage.async, content-addressed stores are queued and return the
+// predicted reference at once.
+func (p *vaultProcessor) store(ctx context.Context, key string, content []byte, contentType string) (string, error) {
+	if p.async != nil && !p.config.Vault.KeyedAddressing && p.config.Vault.RetentionDays[key] == 0 {
+		return p.storeAsync(ctx, key, content, contentType)
+	}
+	return p.storeNow(ctx, key, content, contentType)
+}
+
+// storeNow is store without storage.async: the content is in the vault
+// when it returns.
+func (p *vaultProcessor) storeNow(ctx context.Context, key string, content []byte, contentType string) (string, error) {
+	if p.flights == nil {
+		return p.storeRetrying(ctx, key, content, contentType, time.Time{})
+	}
+	sum := sha256.Sum256(content)
+	flight := hex.EncodeToString(sum[:]) + "/" + contentType
+	if p.config.Vault.KeyedAddressing {
+		flight += "/" + key
+	}
+	if days := p.config.Vault.RetentionDays[key]; days > 0 {
+		flight += "/" + strconv.Itoa(days)
+	}
+	if p.encryptKeys[key] {
+		flight += "/encrypted"
+	}
+	return p.flights.do(flight, func() (string, error) {
+		return p.storeRetrying(ctx, key, content, contentType, time.Time{})
+	})
+}
+
+// storeRetrying performs a store, retrying failures up to
+// storage.retry.max_attempts times with exponential backoff. It gives up
+// early when ctx is done.
+func (p *vaultProcessor) storeRetrying(ctx context.Context, key string, content []byte, contentType string, at time.Time) (string, error) {
+	retry := p.config.Storage.Retry
+	backoff := retry.InitialBackoff
+	reauthenticated := false
+	for attempt := 1; ; attempt++ {
+		ref, err := p.storeOnce(ctx, key, content, contentType, at)
+		if errors.Is(err, ErrCredentialsExpired) && !reauthenticated && ctx.Err() == nil {
+			// The vault signs the next request with refreshed credentials;
+			// expiry is not the backend failing, so it costs no attempt.
+			reauthenticated = true
+			p.logger.Warn("vault credentials expired, retrying with refreshed credentials",
+				zap.String("key", key),
+				zap.Error(err),
+			)
+			p.metrics.credentialExpiries.Add(context.WithoutCancel(ctx), 1)
+			attempt--
+			continue
+		}
+		if err == nil || attempt >= retry.MaxAttempts || ctx.Err() != nil {
+			return ref, err
+		}
+		p.logger.Debug("vault store failed, retrying",
+			zap.String("key", key),
+			zap.Int("attempt", attempt),
+			zap.Duration("backoff", backoff),
+			zap.Error(err),
+		)
+		p.metrics.storeRetries.Add(context.WithoutCancel(ctx), 1)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return "", err
+		}
+		backoff = min(2*backoff, retry.MaxBackoff)
+	}
+}
+
+// storeOnce performs one store against the backend. A non-zero at pins
+// the store to the time its reference was predicted for (see storeAsync).
+func (p *vaultProcessor) storeOnce(ctx context.Context, key string, content []byte, contentType string, at time.Time) (string, error) {
+	if p.config.Vault.KeyedAddressing {
+		return p.vault.(KeyedVaultStorage).StoreKeyed(key, content)
+	}
+	if days := p.config.Vault.RetentionDays[key]; days > 0 {
+		return p.vault.(RetentionStorage).StoreRetained(content, contentType, days)
+	}
+	if !at.IsZero() {
+		return p.vault.(PinningVaultStorage).StoreAt(content, contentType, p.encryptKeys[key], at)
+	}
+	if p.encryptKeys[key] {
+		return p.vault.(EncryptingVaultStorage).StoreEncrypted(content, contentType)
+	}
+	if typed, ok := p.vault.(TypedVaultStorage); ok && contentType != "" {
+		return typed.StoreTyped(content, contentType)
+	}
+	if remote, ok := p.vault.(ContextVaultStorage); ok {
+		return remote.StoreContext(ctx, content)
+	}
+	return p.vault.Store(content)
+}
+
+// verify reads ref back and checks it matches content. Conversation refs
+// are resolved through their delta chain.
+func (p *vaultProcessor) verify(ctx context.Context, ref string, content []byte, conversational bool) error {
+	retriever := p.vault.(VaultRetriever)
+	var data []byte
+	var err error
+	if conversational {
+		data, err = ResolveConversation(retriever, ref)
+	} else {
+		data, err = retriever.Retrieve(ref)
+	}
+	if err != nil {
+		return fmt.Errorf("verify %s: %w", ref, err)
+	}
+	if !bytes.Equal(data, content) {
+		p.metrics.verifyMismatch.Add(ctx, 1)
+		return fmt.Errorf("verify %s: stored content does not match original", ref)
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package promptvaultprocessor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/consumer/consumererror"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.uber.org/zap"
+)
+
+// rehydrate restores vaulted content in td and forwards it. References
+// that do not resolve are left in place and logged.
+func (p *vaultProcessor) rehydrate(ctx context.Context, td ptrace.Traces) error {
+	if _, err := RestoreContent(td, p.vault.(VaultRetriever), p.config.Vault); err != nil {
+		p.logger.Warn("some vault references could not be rehydrated", zap.Error(err))
+	}
+	return p.nextConsumer.ConsumeTraces(ctx, td)
+}
+
+// Capabilities reports MutatesData false when disabled, so the pipeline
+// does not clone data for a processor that passes it through untouched.
+func (p *vaultProcessor) Capabilities() consumer.Capabilities {
+	return consumer.Capabilities{MutatesData: p.disabledFor == ""}
+}
+
+func (p *vaultProcessor) ConsumeTraces(ctx context.Context, td ptrace.Traces) error {
+	if p.disabledFor != "" {
+		return p.nextConsumer.ConsumeTraces(ctx, td)
+	}
+	if p.config.Vault.Rehydrate {
+		return p.rehydrate(ctx, td)
+	}
+	if p.memoryPressure() {
+		p.metrics.memoryBypass.Add(ctx, int64(td.SpanCount()))
+		p.stampCanary(td)
+		return p.nextConsumer.ConsumeTraces(ctx, td)
+	}
+
+	if !p.acquireBatch() {
+		p.metrics.rejectedBatches.Add(ctx, 1)
+		return consumererror.NewTraces(errSaturated, td)
+	}
+	defer p.releaseBatch()
+
+	// Offloading runs under batchCtx so it can be cut short; the batch
+	// itself is forwarded with the caller's ctx.
+	batchCtx, cancel := p.batchContext(ctx)
+	defer cancel()
+
+	var digests traceDigests
+	if p.config.Vault.TraceDigest {
+		digests = traceDigests{}
+	}
+
+	// With consent gating, a span is offloaded only when the request,
+	// its resource or the span itself carries approval.
+	gated := p.config.Vault.Consent.enabled()
+	batchConsent := !gated || p.metadataConsent(ctx)
+
+	rss := td.ResourceSpans()
+	for i := 0; i < rss.Len(); i++ {
+		rs := rss.At(i)
+		resourceConsent := batchConsent || p.attributeConsent(rs.Resource().Attributes())
+		if len(p.resourceKeys) > 0 && resourceConsent {
+			p.vaultContainer(batchCtx, rs.Resource().Attributes(), p.resourceKeys)
+		}
+		ilss := rs.ScopeSpans()
+		for j := 0; j < ilss.Len(); j++ {
+			ils := ilss.At(j)
+			if len(p.scopeKeys) > 0 && resourceConsent {
+				p.vaultContainer(batchCtx, ils.Scope().Attributes(), p.scopeKeys)
+			}
+			spans := ils.Spans()
+			for k := 0; k < spans.Len(); k++ {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				span := spans.At(k)
+				if canary := p.config.Vault.CanaryAttribute; canary != "" {
+					span.Attributes().PutBool(canary, true)
+				}
+				if !resourceConsent && !p.attributeConsent(span.Attributes()) {
+					p.metrics.unconsentedSpans.Add(ctx, 1)
+					continue
+				}
+				result := p.processSpan(batchCtx, span)
+				if digests != nil {
+					digests.add(span, result.offloaded)
+				}
+			}
+		}
+	}
+	if digests != nil {
+		digests.stamp()
+	}
+	p.checkBatchTimeout(ctx, batchCtx)
+	if err := batchFailure(batchCtx); err != nil {
+		return consumererror.NewTraces(err, td)
+	}
+	return p.nextConsumer.ConsumeTraces(ctx, td)
+}
+
+// stampCanary marks every span in td with the canary attribute, for paths
+// that skip the per-span loop.
+func (p *vaultProcessor) stampCanary(td ptrace.Traces) {
+	canary := p.config.Vault.CanaryAttribute
+	if canary == "" {
+		return
+	}
+	rss := td.ResourceSpans()
+	for i := 0; i < rss.Len(); i++ {
+		ilss := rss.At(i).ScopeSpans()
+		for j := 0; j < ilss.Len(); j++ {
+			spans := ilss.At(j).Spans()
+			for k := 0; k < spans.Len(); k++ {
+				spans.At(k).Attributes().PutBool(canary, true)
+			}
+		}
+	}
+}
+
+// recordAudit queues one audit record per vaulted attribute when auditing
+// is enabled.
+func (p *vaultProcessor) recordAudit(traceID pcommon.TraceID, spanID pcommon.SpanID, vaulted []vaultedAttr) {
+	if p.audit == nil || len(vaulted) == 0 {
+		return
+	}
+	for _, r := range auditRecords(p.now(), traceID, spanID, p.effectiveMode(), vaulted) {
+		p.audit.add(r)
+	}
+}
+
+// processSpan offloads the attributes of one span and applies the span-level
+// effects: error status on dropped content, audit records and the offloaded
+// marker. The result says what happened to each matched key.
+func (p *vaultProcessor) processSpan(ctx context.Context, span ptrace.Span) offloadResult {
+	keys := p.spanKeys(span)
+	dups := p.eventDuplicates(span, keys)
+	var result offloadResult
+	if p.config.Vault.EventDuplicates == "prefer_event" {
+		// The events are vaulted first; span attributes repeating an
+		// offloaded event value are then removed.
+		result = p.vaultEvents(ctx, span, keys)
+		refs := result.refs()
+		for _, d := range dups {
+			if _, ok := refs[eventPrefix(d.event)+d.key]; ok {
+				span.Attributes().Remove(d.spanKey)
+			}
+		}
+		result.merge(p.vaultAttributes(ctx, span.Attributes(), keys, true, span.TraceID()), "")
+	} else {
+		result = p.vaultAttributes(ctx, span.Attributes(), keys, true, span.TraceID())
+		// Event values repeating an offloaded span attribute are set aside
+		// while the events are vaulted: prefer_attribute drops them,
+		// reference gives them the span attribute's reference.
+		refs := result.refs()
+		var shared []eventDuplicate
+		for _, d := range dups {
+			ref, ok := refs[d.spanKey]
+			if !ok {
+				continue
+			}
+			span.Events().At(d.event).Attributes().Remove(d.key)
+			if p.config.Vault.EventDuplicates == "reference" {
+				d.ref = ref
+				shared = append(shared, d)
+			}
+		}
+		result.merge(p.vaultEvents(ctx, span, keys), "")
+		for _, d := range shared {
+			attrs := span.Events().At(d.event).Attributes()
+			attrs.PutStr(d.key, d.value)
+			p.rewriteRef(attrs, p.effectiveMode(), d.key, d.ref)
+			result.offloaded = append(result.offloaded, vaultedAttr{key: eventPrefix(d.event) + d.key, ref: d.ref})
+		}
+	}
+	if dropped := result.dropped(); len(dropped) > 0 && p.config.Vault.ErrorStatusOnDrop {
+		msgs := make([]string, len(dropped))
+		for i, d := range dropped {
+			msgs[i] = fmt.Sprintf("content of %s dropped after store failure: %v", d.key, d.err)
+		}
+		span.Status().SetCode(ptrace.StatusCodeError)
+		span.Status().SetMessage("promptvault: " + strings.Join(msgs, "; "))
+	}
+	p.recordAudit(span.TraceID(), span.SpanID(), result.offloaded)
+	if p.config.Vault.MarkOffloaded && len(result.offloaded) > 0 {
+		span.Attributes().PutBool(offloadedKey, true)
+	}
+	return result
+}
+
+// vaultEvents offloads the matched attributes of span's events. Older
+// instrumentations record prompts on events such as gen_ai.content.prompt;
+// their keys are reported as event_<i>/<key>.
+func (p *vaultProcessor) vaultEvents(ctx context.Context, span ptrace.Span, keys map[string]bool) (result offloadResult) {
+	for i := 0; i < span.Events().Len(); i++ {
+		event := p.vaultAttributes(ctx, span.Events().At(i).Attributes(), keys, true, span.TraceID())
+		result.merge(event, eventPrefix(i))
+	}
+	return result
+}
+
+func eventPrefix(i int) string {
+	return fmt.Sprintf("event_%d/", i)
+}
+
+// eventDuplicate is a matched span event attribute repeating the string
+// value of a matched span attribute.
+type eventDuplicate struct {
+	event   int
+	key     string
+	value   string
+	spanKey string
+	ref     string
+}
+
+// eventDuplicates returns the event attributes of span that repeat a
+// matched span attribute, unless event_duplicates is store.
+func (p *vaultProcessor) eventDuplicates(span ptrace.Span, keys map[string]bool) []eventDuplicate {
+	if p.config.Vault.EventDuplicates == "store" || span.Events().Len() == 0 {
+		return nil
+	}
+	spanKeys := map[string]string{} // value -> span attribute key
+	span.Attributes().Range(func(key string, val pcommon.Value) bool {
+		if val.Type() == pcommon.ValueTypeStr && !isVaultRef(val.Str()) && p.matches(span.Attributes(), keys, true, key) {
+			spanKeys[val.Str()] = key
+		}
+		return true
+	})
+	if len(spanKeys) == 0 {
+		return nil
+	}
+	var dups []eventDuplicate
+	for i := 0; i < span.Events().Len(); i++ {
+		attrs := span.Events().At(i).Attributes()
+		attrs.Range(func(key string, val pcommon.Value) bool {
+			if val.Type() != pcommon.ValueTypeStr {
+				return true
+			}
+			if spanKey, ok := spanKeys[val.Str()]; ok && p.matches(attrs, keys, true, key) {
+				dups = append(dups, eventDuplicate{event: i, key: key, value: val.Str(), spanKey: spanKey})
+			}
+			return true
+		})
+	}
+	return dups
+}
+
+// spanKeys returns the key set to apply to span: its provider's profile
+// when profiles are enabled and one exists, Keys otherwise.
+func (p *vaultProcessor) spanKeys(span ptrace.Span) map[string]bool {
+	if !p.config.Vault.ProviderProfiles {
+		return p.keysSet
+	}
+	provider, ok := span.Attributes().Get(p.config.Vault.ProviderAttribute)
+	if !ok {
+		return p.keysSet
+	}
+	if keys, ok := p.profiles[provider.AsString()]; ok {
+		return keys
+	}
+	return p.keysSet
+}
@@ -0,0 +1,101 @@
+package promptvaultprocessor
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+// UpgradeRefs rewrites the vault references in td (resource, scope, span
+// and span event attributes) to the current reference format of to. Each
+// referenced object is read from from and stored in to with the content type
+// its reference records, so references from
+// an older format or a previous backend keep resolving once archived traces
+// are re-ingested. Pass the same vault as from and to to only upgrade the
+// format. References that cannot be read are left as they are and reported
+// in the returned error; upgraded counts rewritten attribute values.
+func UpgradeRefs(td ptrace.Traces, from VaultRetriever, to VaultStorage) (upgraded int, err error) {
+	u := refUpgrader{from: from, to: to, done: map[string]string{}}
+	rss := td.ResourceSpans()
+	for i := 0; i < rss.Len(); i++ {
+		rs := rss.At(i)
+		u.upgrade(rs.Resource().Attributes())
+		sss := rs.ScopeSpans()
+		for j := 0; j < sss.Len(); j++ {
+			ss := sss.At(j)
+			u.upgrade(ss.Scope().Attributes())
+			spans := ss.Spans()
+			for k := 0; k < spans.Len(); k++ {
+				span := spans.At(k)
+				u.upgrade(span.Attributes())
+				events := span.Events()
+				for e := 0; e < events.Len(); e++ {
+					u.upgrade(events.At(e).Attributes())
+				}
+			}
+		}
+	}
+	return u.upgraded, errors.Join(u.errs...)
+}
+
+type refUpgrader struct {
+	from     VaultRetriever
+	to       VaultStorage
+	done     map[string]string // old ref -> new ref
+	upgraded int
+	errs     []error
+}
+
+func (u *refUpgrader) upgrade(attrs pcommon.Map) {
+	attrs.Range(func(_ string, val pcommon.Value) bool {
+		if val.Type() != pcommon.ValueTypeStr || !isVaultRef(val.Str()) {
+			return true
+		}
+		old := val.Str()
+		ref, ok := u.done[old]
+		if !ok {
+			ref = u.copy(old)
+			u.done[old] = ref
+		}
+		if ref != old {
+			val.SetStr(ref)
+			u.upgraded++
+		}
+		return true
+	})
+}
+
+// copy stores the object behind old in to, under the content type its
+// reference records, and returns its new reference, or old when it cannot
+// be copied. A bundle reference copies the whole bundle and selects the same
+// field in the copy. A conversation turn is stored with its full history,
+// so the copy does not chain back into from.
+func (u *refUpgrader) copy(old string) string {
+	base, field, bundled := strings.Cut(old, refFieldSep)
+	var content []byte
+	var err error
+	if bundled {
+		content, err = u.from.Retrieve(base)
+	} else {
+		content, err = ResolveConversation(u.from, base)
+	}
+	var ref string
+	if err == nil {
+		if typed, ok := u.to.(TypedVaultStorage); ok && refContentType(base) != "" {
+			ref, err = typed.StoreTyped(content, refContentType(base))
+		} else {
+			ref, err = u.to.Store(content)
+		}
+	}
+	if err != nil {
+		u.errs = append(u.errs, fmt.Errorf("upgrade %s: %w", old, err))
+		return old
+	}
+	if bundled {
+		ref += refFieldSep + field
+	}
+	return ref
+}
@@ -0,0 +1,133 @@
+package promptvaultprocessor
+
+import (
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+func TestUpgradeRefsLegacyFormat(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	ref, _ := vault.Store([]byte("Tell me about quantum computing"))
+	legacy := strings.TrimSuffix(ref, ".txt")
+
+	td := ptrace.NewTraces()
+	span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+	span.Attributes().PutStr("gen_ai.prompt", legacy)
+	span.Attributes().PutStr("gen_ai.prompt.vault_ref", legacy)
+	span.Events().AppendEmpty().Attributes().PutStr("gen_ai.prompt", legacy)
+	span.Attributes().PutStr("http.route", "/chat")
+
+	upgraded, err := UpgradeRefs(td, vault, vault)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if upgraded != 3 {
+		t.Errorf("expected 3 upgraded values, got %d", upgraded)
+	}
+	if v, _ := span.Attributes().Get("gen_ai.prompt"); v.Str() != ref {
+		t.Errorf("attribute: expected %s, got %s", ref, v.Str())
+	}
+	if v, _ := span.Events().At(0).Attributes().Get("gen_ai.prompt"); v.Str() != ref {
+		t.Errorf("event: expected %s, got %s", ref, v.Str())
+	}
+	if v, _ := span.Attributes().Get("http.route"); v.Str() != "/chat" {
+		t.Errorf("expected non-reference values untouched, got %s", v.Str())
+	}
+}
+
+func TestUpgradeRefsToNewBackend(t *testing.T) {
+	oldVault, _ := NewFilesystemVault(t.TempDir())
+	newVault, _ := NewFilesystemVault(t.TempDir())
+	ref, _ := oldVault.Store([]byte("Tell me about quantum computing"))
+
+	td := ptrace.NewTraces()
+	span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+	span.Attributes().PutStr("gen_ai.prompt", ref)
+	span.Attributes().PutStr("gen_ai.completion", "vault://0000.txt")
+
+	upgraded, err := UpgradeRefs(td, oldVault, newVault)
+	if err == nil || !strings.Contains(err.Error(), "vault://0000.txt") {
+		t.Errorf("expected an error naming the unreadable reference, got %v", err)
+	}
+	if upgraded != 0 {
+		t.Errorf("expected no rewritten values for an identical format, got %d", upgraded)
+	}
+
+	v, _ := span.Attributes().Get("gen_ai.prompt")
+	data, err := newVault.Retrieve(v.Str())
+	if err != nil {
+		t.Fatalf("expected the reference to resolve in the new backend: %v", err)
+	}
+	if string(data) != "Tell me about quantum computing" {
+		t.Errorf("unexpected content %q", data)
+	}
+}
+
+func TestUpgradeRefsBundleField(t *testing.T) {
+	oldVault, _ := NewFilesystemVault(t.TempDir())
+	newVault, _ := NewFilesystemVault(t.TempDir())
+	base, _ := oldVault.Store([]byte(`{"gen_ai.completion":"Quantum computing uses qubits...","gen_ai.prompt":"Tell me about quantum computing"}`))
+	ref := base + refFieldSep + "gen_ai.prompt"
+
+	td := ptrace.NewTraces()
+	span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+	span.Attributes().PutStr("gen_ai.prompt", ref)
+
+	if _, err := UpgradeRefs(td, oldVault, newVault); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	v, _ := span.Attributes().Get("gen_ai.prompt")
+	if !strings.HasSuffix(v.Str(), refFieldSep+"gen_ai.prompt") {
+		t.Errorf("expected the bundle field kept, got %s", v.Str())
+	}
+	data, err := ResolveBundleField(newVault, v.Str())
+	if err != nil {
+		t.Fatalf("expected the reference to resolve in the new backend: %v", err)
+	}
+	if string(data) != "Tell me about quantum computing" {
+		t.Errorf("unexpected content %q", data)
+	}
+}
+
+func TestUpgradeRefsConversation(t *testing.T) {
+	oldVault, _ := NewFilesystemVault(t.TempDir())
+	newVault, _ := NewFilesystemVault(t.TempDir())
+	log := newConversationLog(10, 0)
+	log.store(oldVault.Store, oldVault.Touch, "conv", "k", []byte(`{"role":"user","content":"hello"}`))
+	ref, _ := log.store(oldVault.Store, oldVault.Touch, "conv", "k", []byte(`{"role":"user","content":"hello"}{"role":"assistant","content":"hi"}`))
+
+	td := ptrace.NewTraces()
+	span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+	span.Attributes().PutStr("gen_ai.input.messages", ref)
+
+	if _, err := UpgradeRefs(td, oldVault, newVault); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	v, _ := span.Attributes().Get("gen_ai.input.messages")
+	data, err := ResolveConversation(newVault, v.Str())
+	if err != nil {
+		t.Fatalf("expected the turn to resolve from the new backend alone: %v", err)
+	}
+	if want := `{"role":"user","content":"hello"}{"role":"assistant","content":"hi"}`; string(data) != want {
+		t.Errorf("expected the full conversation, got %q", data)
+	}
+}
+
+func TestUpgradeRefsKeepsContentType(t *testing.T) {
+	oldVault, _ := NewFilesystemVault(t.TempDir())
+	newVault, _ := NewFilesystemVault(t.TempDir())
+	ref, _ := oldVault.StoreTyped([]byte(`{"role":"user"}`), contentTypeMap)
+
+	td := ptrace.NewTraces()
+	span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+	span.Attributes().PutStr("gen_ai.input.messages", ref)
+
+	if _, err := UpgradeRefs(td, oldVault, newVault); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v, _ := span.Attributes().Get("gen_ai.input.messages"); !strings.HasSuffix(v.Str(), ".map") {
+		t.Errorf("expected the map content type kept, got %s", v.Str())
+	}
+}
@@ -0,0 +1,109 @@
+package promptvaultprocessor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// valueFilter is a compiled ValueFilterConfig.
+type valueFilter struct {
+	pattern *regexp.Regexp
+	field   []string
+}
+
+// compileValueFilter compiles cfg, returning nil for an empty filter.
+func compileValueFilter(cfg ValueFilterConfig) (*valueFilter, error) {
+	if cfg.Pattern == "" && cfg.JSONField == "" {
+		return nil, nil
+	}
+	f := &valueFilter{}
+	if cfg.Pattern != "" {
+		re, err := regexp.Compile(cfg.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value filter pattern %q: %w", cfg.Pattern, err)
+		}
+		f.pattern = re
+	}
+	if paths := parseJSONPaths([]string{cfg.JSONField}); len(paths) > 0 {
+		f.field = paths[0]
+	}
+	return f, nil
+}
+
+// compileValueFilters compiles the global value filter and the per-key
+// filters that override it.
+func compileValueFilters(cfg VaultConfig) (global *valueFilter, perKey map[string]*valueFilter, err error) {
+	if global, err = compileValueFilter(cfg.ValueFilter); err != nil {
+		return nil, nil, err
+	}
+	perKey = make(map[string]*valueFilter, len(cfg.ValueFilters))
+	for key, fc := range cfg.ValueFilters {
+		if perKey[key], err = compileValueFilter(fc); err != nil {
+			return nil, nil, fmt.Errorf("value_filters for %s: %w", key, err)
+		}
+	}
+	return global, perKey, nil
+}
+
+// allows reports whether content passes the filter. For a JSON document
+// with a field set, the field must be present and the pattern is matched
+// against its value (a string as is, anything else as JSON). Content that
+// is not JSON is matched against the pattern as a whole.
+func (f *valueFilter) allows(content []byte) bool {
+	target := content
+	if len(f.field) > 0 && detectContentType(content) == contentTypeJSON {
+		dec := json.NewDecoder(bytes.NewReader(content))
+		dec.UseNumber()
+		var doc any
+		if err := dec.Decode(&doc); err != nil {
+			return false
+		}
+		value, ok := lookupJSONPath(doc, f.field)
+		if !ok {
+			return false
+		}
+		if s, isStr := value.(string); isStr {
+			target = []byte(s)
+		} else if target, ok = marshalJSON(value); !ok {
+			return false
+		}
+	}
+	return f.pattern == nil || f.pattern.Match(target)
+}
+
+// lookupJSONPath returns the value at path in node. Arrays are traversed
+// transparently, as for json_exclusions: the first element holding the
+// path wins.
+func lookupJSONPath(node any, path []string) (any, bool) {
+	if len(path) == 0 {
+		return node, true
+	}
+	switch n := node.(type) {
+	case []any:
+		for _, elem := range n {
+			if v, ok := lookupJSONPath(elem, path); ok {
+				return v, true
+			}
+		}
+	case map[string]any:
+		if child, ok := n[path[0]]; ok {
+			return lookupJSONPath(child, path[1:])
+		}
+	}
+	return nil, false
+}
+
+func marshalJSON(v any) ([]byte, bool) {
+	data, err := json.Marshal(v)
+	return data, err == nil
+}
+
+// valueFilterFor returns the filter applying to key, if any.
+func (p *vaultProcessor) valueFilterFor(key string) *valueFilter {
+	if f, ok := p.valueFilters[key]; ok {
+		return f
+	}
+	return p.valueFilter
+}
@@ -0,0 +1,78 @@
+package promptvaultprocessor
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+func TestValueFilterAllows(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  ValueFilterConfig
+		content string
+		want    bool
+	}{
+		{name: "pattern matches", filter: ValueFilterConfig{Pattern: `(?i)password|ssn`}, content: "my SSN is 123", want: true},
+		{name: "pattern does not match", filter: ValueFilterConfig{Pattern: `(?i)password|ssn`}, content: "hello", want: false},
+		{name: "field present", filter: ValueFilterConfig{JSONField: "messages"}, content: `{"messages":[{"role":"user"}]}`, want: true},
+		{name: "field missing", filter: ValueFilterConfig{JSONField: "messages"}, content: `{"stub":true}`, want: false},
+		{name: "field in array", filter: ValueFilterConfig{JSONField: "$.content"}, content: `[{"role":"system"},{"content":"hi"}]`, want: true},
+		{name: "pattern on string field", filter: ValueFilterConfig{JSONField: "role", Pattern: `^user$`}, content: `{"role":"user","content":"hi"}`, want: true},
+		{name: "pattern on other field", filter: ValueFilterConfig{JSONField: "role", Pattern: `^user$`}, content: `{"role":"system"}`, want: false},
+		{name: "pattern on object field", filter: ValueFilterConfig{JSONField: "meta", Pattern: `"tokens":\d{4,}`}, content: `{"meta":{"tokens":12000}}`, want: true},
+		{name: "field on non-JSON uses pattern", filter: ValueFilterConfig{JSONField: "messages", Pattern: "quantum"}, content: "Tell me about quantum computing", want: true},
+		{name: "field on non-JSON without pattern", filter: ValueFilterConfig{JSONField: "messages"}, content: "plain text", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := compileValueFilter(tt.filter)
+			if err != nil {
+				t.Fatalf("compile: %v", err)
+			}
+			if got := f.allows([]byte(tt.content)); got != tt.want {
+				t.Errorf("allows(%s) = %v, want %v", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVaultValueFilters(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+	cfg := createDefaultConfig()
+	cfg.Vault.ValueFilter = ValueFilterConfig{Pattern: "quantum"}
+	cfg.Vault.ValueFilters = map[string]ValueFilterConfig{
+		"gen_ai.input.messages": {JSONField: "messages"},
+	}
+	sink := new(consumertest.TracesSink)
+	proc := newTestProcessor(t, cfg, vault, sink)
+
+	newSpan := func(prompt, messages string) ptrace.Traces {
+		td := ptrace.NewTraces()
+		attrs := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty().Attributes()
+		attrs.PutStr("gen_ai.prompt", prompt)
+		attrs.PutStr("gen_ai.input.messages", messages)
+		return td
+	}
+	for _, td := range []ptrace.Traces{
+		newSpan("Tell me about quantum computing", `{"messages":[{"role":"user","content":"hi"}]}`),
+		newSpan("What is the capital of France?", `{"stub":true}`),
+	} {
+		if err := proc.ConsumeTraces(context.Background(), td); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	for i, want := range []bool{true, false} {
+		attrs := sink.AllTraces()[i].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+		for _, key := range []string{"gen_ai.prompt", "gen_ai.input.messages"} {
+			v, _ := attrs.Get(key)
+			if vaulted := strings.HasPrefix(v.Str(), "vault://"); vaulted != want {
+				t.Errorf("span %d: expected %s vaulted=%v, got %q", i, key, want, v.Str())
+			}
+		}
+	}
+}
@@ -1,79 +1,1049 @@
 package promptvaultprocessor
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/cipher"
 	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/klauspost/compress/zstd"
 )
 
+// ErrCredentialsExpired is wrapped by store errors caused by backend
+// credentials that expired or were rejected as no longer valid. The vault
+// refreshes its credentials on the next request, so the processor retries
+// such a store once more without counting it against storage.retry.
+var ErrCredentialsExpired = errors.New("vault credentials expired")
+
 // VaultStorage handles persisting content to a backend.
 type VaultStorage interface {
 	Store(content []byte) (ref string, err error)
 }
 
+// ContextVaultStorage is implemented by vaults whose writes go to a remote
+// backend. StoreContext is Store bounded by ctx, so a cancelled batch or a
+// collector shutting down does not wait on a slow write.
+type ContextVaultStorage interface {
+	StoreContext(ctx context.Context, content []byte) (ref string, err error)
+}
+
+// TypedVaultStorage is implemented by vaults that can record a content-type
+// hint in the reference.
+type TypedVaultStorage interface {
+	StoreTyped(content []byte, contentType string) (ref string, err error)
+}
+
+// KeyedVaultStorage is implemented by vaults that can fold the attribute key
+// into the content address.
+type KeyedVaultStorage interface {
+	StoreKeyed(key string, content []byte) (ref string, err error)
+}
+
+// RangeRetriever is implemented by vaults that can read part of an object
+// without fetching all of it.
+type RangeRetriever interface {
+	RetrieveRange(ref string, offset, length int64) ([]byte, error)
+}
+
+// RetentionStorage is implemented by vaults that can keep an object for
+// its own retention window instead of the vault-wide one.
+type RetentionStorage interface {
+	StoreRetained(content []byte, contentType string, retentionDays int) (string, error)
+}
+
+// Compactor is implemented by vaults that can pack small objects together.
+type Compactor interface {
+	Compact(minAge time.Duration, maxSize int64) (packed int, err error)
+}
+
+// RefPredictor is implemented by vaults that can compute the reference a
+// store of content will return without writing it, so the write can happen
+// in the background (storage.async).
+type RefPredictor interface {
+	PredictRef(content []byte, contentType string) (string, error)
+}
+
+// PinningVaultStorage is implemented by vaults whose references depend on
+// when content is stored, such as date partitions. PinRef predicts the
+// reference like PredictRef and returns the time it is pinned to; StoreAt
+// stores content as of that time, so a write queued by storage.async lands
+// under its predicted reference even when it runs after midnight. encrypt
+// encrypts the object as StoreEncrypted does.
+type PinningVaultStorage interface {
+	PinRef(content []byte, contentType string) (ref string, at time.Time, err error)
+	StoreAt(content []byte, contentType string, encrypt bool, at time.Time) (string, error)
+}
+
+// Sweeper is implemented by vaults that can delete objects past a maximum
+// age.
+type Sweeper interface {
+	Sweep(maxAge time.Duration) (objects int, reclaimed int64, err error)
+}
+
+// EncryptingVaultStorage is implemented by vaults that can encrypt
+// selected objects.
+type EncryptingVaultStorage interface {
+	StoreEncrypted(content []byte, contentType string) (ref string, err error)
+}
+
+// EncryptionChecker is implemented by vaults that can verify their
+// encryption round-trips before any content is stored.
+type EncryptionChecker interface {
+	CheckEncryption() error
+}
+
+// Toucher is implemented by vaults that can restart an object's retention
+// window, so a sweep keeps objects that are still in use.
+type Toucher interface {
+	Touch(ref string) error
+}
+
+// ExistenceChecker is implemented by vaults that can tell whether content is
+// already stored without writing it.
+type ExistenceChecker interface {
+	Exists(content []byte) (bool, error)
+}
+
+// Lister is implemented by vaults that can list the checksums of the
+// objects they hold.
+type Lister interface {
+	List(since time.Time, limit int) ([][sha256.Size]byte, error)
+}
+
+// DedupCounter is implemented by vaults that count stores answered by an
+// existing object.
+type DedupCounter interface {
+	DedupHits() int64
+}
+
+// CollisionCounter is implemented by vaults that detect different content
+// stored under the same name.
+type CollisionCounter interface {
+	Collisions() int64
+}
+
+// VaultRetriever reads content back from a vault by reference.
+type VaultRetriever interface {
+	Retrieve(ref string) ([]byte, error)
+}
+
+// RefSchemer is implemented by vaults that report the scheme of the
+// references they produce, e.g. "vault". It is the only scheme followed
+// when vault.allowed_schemes is not set.
+type RefSchemer interface {
+	RefScheme() string
+}
+
 // FilesystemVault stores content as files on disk.
 type FilesystemVault struct {
-	basePath string
+	// basePaths holds one or more roots; objects are spread across them by
+	// the first byte of their hash.
+	basePaths []string
+
+	// compressMinSize enables compression, with the compression
+	// algorithm, for objects of at least this many bytes. 0 disables it.
+	compressMinSize int
+	compression     byte
+
+	// envelope writes objects as self-describing envelopes (see
+	// EncodeEnvelope) with a ".pv" suffix instead of raw or ".gz" files.
+	envelope bool
+
+	// envelopeVersion is the envelope version written.
+	envelopeVersion byte
+
+	// encryptionKey encrypts envelope payloads with AES-256-GCM (aead):
+	// every object's when encryptAll is set, otherwise only those from
+	// StoreEncrypted.
+	encryptionKey []byte
+	encryptAll    bool
+	aead          cipher.AEAD
+
+	// integrity adds each scheme's digest to references and verifies it,
+	// together with the SHA-256, on Retrieve.
+	integrity []IntegrityScheme
+
+	// now is the clock used for date partitions and object ages.
+	now func() time.Time
+
+	// dedupHits counts stores that found the object already present.
+	dedupHits atomic.Int64
+
+	// collisionCheckMax compares content of up to this many bytes with
+	// the object already stored under its name before deduplicating. 0
+	// trusts the name.
+	collisionCheckMax int
+
+	// collisions counts stores that found different content under their
+	// object name.
+	collisions atomic.Int64
+
+	// packs indexes objects moved into pack files by Compact, by hash.
+	// It is loaded from the pack indexes on first use.
+	packMu sync.Mutex
+	packs  map[string]packEntry
+}
+
+// FilesystemOption configures optional FilesystemVault behavior.
+type FilesystemOption func(*FilesystemVault)
+
+// WithGzip compresses objects of at least minSize bytes before writing them.
+// Compressed objects get an extra ".gz" suffix so Retrieve knows to
+// decompress them.
+func WithGzip(minSize int) FilesystemOption {
+	return func(v *FilesystemVault) {
+		if minSize < 1 {
+			minSize = 1
+		}
+		v.compressMinSize = minSize
+		v.compression = compressionGzip
+	}
+}
+
+// WithZstd compresses objects of at least minSize bytes with zstd, which
+// shrinks prompt text further than gzip at a lower CPU cost. It implies
+// WithEnvelope: the envelope records the algorithm, so there is no raw
+// ".zst" layout, and requires envelope version 2 or later.
+func WithZstd(minSize int) FilesystemOption {
+	return func(v *FilesystemVault) {
+		if minSize < 1 {
+			minSize = 1
+		}
+		v.envelope = true
+		v.compressMinSize = minSize
+		v.compression = compressionZstd
+	}
+}
+
+// WithEnvelope writes objects as self-describing envelopes recording their
+// size, content type and compression, so they can be decoded from their
+// bytes alone. Objects written without an envelope remain readable.
+func WithEnvelope() FilesystemOption {
+	return func(v *FilesystemVault) {
+		v.envelope = true
+	}
+}
+
+// WithEnvelopeVersion writes envelopes in an older version (from 1) so
+// that tools which only understand that version can still decode them.
+// It implies WithEnvelope.
+func WithEnvelopeVersion(version int) FilesystemOption {
+	return func(v *FilesystemVault) {
+		v.envelope = true
+		v.envelopeVersion = byte(min(max(version, 0), 255))
+	}
+}
+
+// WithEncryption encrypts every object written with AES-256-GCM under
+// key (32 bytes), using a random nonce per object. It implies WithEnvelope:
+// the envelope records the algorithm, and its header is authenticated with
+// the ciphertext. Objects stay named by the SHA-256 of their plaintext, so
+// deduplication and references are unchanged. Retrieve decrypts
+// transparently; unencrypted objects written earlier remain readable.
+func WithEncryption(key []byte) FilesystemOption {
+	return func(v *FilesystemVault) {
+		v.envelope = true
+		v.encryptionKey = key
+		v.encryptAll = true
+	}
+}
+
+// WithEncryptionKey is like WithEncryption but only encrypts objects
+// written with StoreEncrypted; other stores stay plaintext. Retrieve
+// decrypts whichever objects are encrypted, so the two kinds can be mixed.
+func WithEncryptionKey(key []byte) FilesystemOption {
+	return func(v *FilesystemVault) {
+		v.envelope = true
+		v.encryptionKey = key
+	}
+}
+
+// WithSecondaryChecksum records a BLAKE2b-256 checksum next to the SHA-256
+// in references from Store and StoreTyped. Retrieve verifies both whenever a
+// reference carries the second checksum, so a weakness in one algorithm
+// alone cannot pass off altered content. Keyed references are addressed by
+// key and content and carry no second checksum.
+func WithSecondaryChecksum() FilesystemOption {
+	return WithIntegrity(BLAKE2bIntegrity())
+}
+
+// WithIntegrity records the digest of each scheme, in order, in references
+// from Store and StoreTyped, and verifies them on Retrieve. Like
+// WithSecondaryChecksum, keyed references carry no digests.
+func WithIntegrity(schemes ...IntegrityScheme) FilesystemOption {
+	return func(v *FilesystemVault) {
+		v.integrity = append(v.integrity, schemes...)
+	}
+}
+
+// WithCollisionCheck makes a store of content up to maxSize bytes that
+// finds an object under its name compare the object with the content
+// before deduplicating. If they differ, which for SHA-256 means a bug in
+// how objects are named rather than a real collision, the content is stored
+// under a disambiguated name, <sha256>-<n>, and counted in Collisions.
+func WithCollisionCheck(maxSize int) FilesystemOption {
+	return func(v *FilesystemVault) {
+		v.collisionCheckMax = max(maxSize, 0)
+	}
+}
+
+// WithClock overrides the clock used for date partitions, object
+// modification times and retention ages.
+func WithClock(now func() time.Time) FilesystemOption {
+	return func(v *FilesystemVault) {
+		v.now = now
+	}
+}
+
+// WithBasePaths spreads objects across several roots (e.g. one per disk)
+// instead of the single base path, so writes parallelize across devices.
+// The root holding an object is derived from its hash.
+func WithBasePaths(paths ...string) FilesystemOption {
+	return func(v *FilesystemVault) {
+		if len(paths) > 0 {
+			v.basePaths = paths
+		}
+	}
 }
 
 // NewFilesystemVault creates a new filesystem-based vault.
-func NewFilesystemVault(basePath string) (*FilesystemVault, error) {
-	if err := os.MkdirAll(basePath, 0o755); err != nil {
-		return nil, fmt.Errorf("create vault dir: %w", err)
+func NewFilesystemVault(basePath string, opts ...FilesystemOption) (*FilesystemVault, error) {
+	v := &FilesystemVault{basePaths: []string{basePath}, envelopeVersion: envelopeVersion, now: time.Now}
+	for _, opt := range opts {
+		opt(v)
+	}
+	if v.envelopeVersion < 1 || v.envelopeVersion > envelopeVersion {
+		return nil, fmt.Errorf("envelope version must be between 1 and %d", envelopeVersion)
+	}
+	if v.compression == compressionZstd && v.envelopeVersion < 2 {
+		return nil, fmt.Errorf("zstd compression requires envelope version 2 or later")
+	}
+	if v.encryptionKey != nil {
+		if v.envelopeVersion < 2 {
+			return nil, fmt.Errorf("encryption requires envelope version 2 or later")
+		}
+		var err error
+		if v.aead, err = newAEAD(v.encryptionKey); err != nil {
+			return nil, err
+		}
 	}
-	return &FilesystemVault{basePath: basePath}, nil
+	seen := map[string]bool{}
+	for _, scheme := range v.integrity {
+		name := scheme.Name()
+		if !validIntegrityName(name) || seen[name] {
+			return nil, fmt.Errorf("invalid or duplicate integrity scheme name %q", name)
+		}
+		seen[name] = true
+	}
+	for _, p := range v.basePaths {
+		if err := os.MkdirAll(p, 0o755); err != nil {
+			return nil, fmt.Errorf("create vault dir: %w", err)
+		}
+	}
+	return v, nil
 }
 
 // Store writes content to a file and returns a vault reference.
-// The reference format is: vault://<sha256>
+// The reference format is: vault://<sha256>.<ext>, where ext reflects the
+// detected content type (txt, json, bin or gz).
 func (v *FilesystemVault) Store(content []byte) (string, error) {
+	ref, err := v.store(sha256.Sum256(content), content, "", 0, v.encryptAll)
+	if err != nil {
+		return "", err
+	}
+	return v.withIntegrity(ref, content)
+}
+
+// PredictRef returns the reference StoreTyped (or Store, for an empty
+// contentType) will return for content, without writing it. Names
+// disambiguated by WithCollisionCheck depend on what is already stored, so
+// they cannot be predicted.
+func (v *FilesystemVault) PredictRef(content []byte, contentType string) (string, error) {
+	ref, _, err := v.PinRef(content, contentType)
+	return ref, err
+}
+
+// PinRef is PredictRef, also returning the time whose date partition the
+// reference names, for StoreAt.
+func (v *FilesystemVault) PinRef(content []byte, contentType string) (string, time.Time, error) {
+	if v.collisionCheckMax > 0 {
+		return "", time.Time{}, fmt.Errorf("references cannot be predicted with a collision check")
+	}
 	hash := sha256.Sum256(content)
-	hexHash := fmt.Sprintf("%x", hash)
+	now := v.now().UTC()
+	name, _ := v.objectPath(hash, content, now, 0)
+	ref, err := v.withIntegrity(v.objectRef(objectPartition(now, 0), hash, "", name, contentType), content)
+	return ref, now, err
+}
+
+// StoreAt is StoreTyped (or Store, for an empty contentType) as of at: the
+// object goes to at's date partition, as the reference PinRef returned for
+// at names. encrypt encrypts it as StoreEncrypted does.
+func (v *FilesystemVault) StoreAt(content []byte, contentType string, encrypt bool, at time.Time) (string, error) {
+	if encrypt && v.aead == nil {
+		return "", errors.New("vault has no encryption key")
+	}
+	ref, err := v.storeAt(sha256.Sum256(content), content, contentType, 0, encrypt || v.encryptAll, at.UTC())
+	if err != nil {
+		return "", err
+	}
+	return v.withIntegrity(ref, content)
+}
+
+// StoreTyped is like Store but records contentType (one of the detected
+// content types) in the reference instead of detecting it. The object itself
+// is identified by its bytes only, so the same content stored under
+// different type hints still deduplicates to a single object.
+func (v *FilesystemVault) StoreTyped(content []byte, contentType string) (string, error) {
+	ref, err := v.store(sha256.Sum256(content), content, contentType, 0, v.encryptAll)
+	if err != nil {
+		return "", err
+	}
+	return v.withIntegrity(ref, content)
+}
+
+// StoreEncrypted is like StoreTyped but always encrypts the object, even
+// when the vault was opened with WithEncryptionKey. Stored content found in
+// plaintext is rewritten encrypted, since the object is shared with every
+// reference to the same content.
+func (v *FilesystemVault) StoreEncrypted(content []byte, contentType string) (string, error) {
+	if v.aead == nil {
+		return "", errors.New("vault has no encryption key")
+	}
+	ref, err := v.store(sha256.Sum256(content), content, contentType, 0, true)
+	if err != nil {
+		return "", err
+	}
+	return v.withIntegrity(ref, content)
+}
+
+// StoreRetained is like StoreTyped but keeps the object for retentionDays
+// regardless of the maxAge passed to Sweep. The object is written to a
+// retention-<N>d partition, so the window travels with the object itself.
+func (v *FilesystemVault) StoreRetained(content []byte, contentType string, retentionDays int) (string, error) {
+	if retentionDays < 1 {
+		return "", fmt.Errorf("invalid retention of %d days", retentionDays)
+	}
+	ref, err := v.store(sha256.Sum256(content), content, contentType, retentionDays, v.encryptAll)
+	if err != nil {
+		return "", err
+	}
+	return v.withIntegrity(ref, content)
+}
+
+// StoreKeyed is like Store but folds the attribute key into the content
+// address, so identical content under different keys yields distinct objects.
+func (v *FilesystemVault) StoreKeyed(key string, content []byte) (string, error) {
+	h := sha256.New()
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	h.Write(content)
+	var hash [sha256.Size]byte
+	h.Sum(hash[:0])
+	return v.store(hash, content, "", 0, v.encryptAll)
+}
+
+func (v *FilesystemVault) store(hash [sha256.Size]byte, content []byte, contentType string, retentionDays int, encrypt bool) (string, error) {
+	return v.storeAt(hash, content, contentType, retentionDays, encrypt, v.now().UTC())
+}
+
+func (v *FilesystemVault) storeAt(hash [sha256.Size]byte, content []byte, contentType string, retentionDays int, encrypt bool, now time.Time) (string, error) {
+	name, path := v.objectPath(hash, content, now, retentionDays)
 
 	// Use date-partitioned directories for organization
-	now := time.Now().UTC()
-	dir := filepath.Join(v.basePath, now.Format("2006/01/02"))
-	if err := os.MkdirAll(dir, 0o755); err != nil {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
 		return "", fmt.Errorf("create date dir: %w", err)
 	}
 
-	path := filepath.Join(dir, hexHash+".vault")
+	// Deduplicate: if same hash exists (compressed or not), skip write.
+	// Touch the object so retention counts from its most recent use.
+	suffix := ""
+	var plaintext string // an object to replace with its encrypted form
+	for n := 1; ; n++ {
+		existing := findObject(path)
+		if existing == "" {
+			break
+		}
+		same, err := v.matchesObject(existing, content)
+		if err != nil {
+			return "", err
+		}
+		if same && encrypt && !encryptedObject(existing) {
+			plaintext = existing
+			break
+		}
+		if same {
+			_ = os.Chtimes(existing, now, now)
+			v.dedupHits.Add(1)
+			return v.objectRef(objectPartition(now, retentionDays), hash, suffix, name, contentType), nil
+		}
+		v.collisions.Add(1)
+		hashName, ext, _ := strings.Cut(name, ".")
+		suffix = "-" + strconv.Itoa(n)
+		path = filepath.Join(filepath.Dir(path), hashName+suffix+"."+ext)
+	}
+	ref := v.objectRef(objectPartition(now, retentionDays), hash, suffix, name, contentType)
 
-	// Deduplicate: if same hash exists, skip write
-	if _, err := os.Stat(path); err == nil {
-		return fmt.Sprintf("vault://%s", hexHash), nil
+	data := content
+	compression := byte(compressionNone)
+	if v.compressMinSize > 0 && len(content) >= v.compressMinSize && compressible(content, contentType) {
+		var packed []byte
+		var err error
+		if v.compression == compressionZstd {
+			packed = zstdBytes(content)
+		} else if packed, err = gzipBytes(content); err != nil {
+			return "", fmt.Errorf("compress vault content: %w", err)
+		}
+		// Only keep the compressed form when it actually saves space.
+		if len(packed) < len(content) {
+			data = packed
+			compression = v.compression
+		}
+	}
+	var aead cipher.AEAD
+	if encrypt {
+		aead = v.aead
+	}
+	switch {
+	case v.envelope:
+		enveloped, err := wrapEnvelope(data, len(content), detectContentType(content), compression, v.envelopeVersion, aead)
+		if err != nil {
+			return "", err
+		}
+		data = enveloped
+		path += ".pv"
+	case compression != compressionNone:
+		path += ".gz"
 	}
 
-	if err := os.WriteFile(path, content, 0o644); err != nil {
+	if err := os.WriteFile(path, data, 0o644); err != nil {
 		return "", fmt.Errorf("write vault file: %w", err)
 	}
+	if err := os.Chtimes(path, now, now); err != nil {
+		return "", fmt.Errorf("set vault file time: %w", err)
+	}
+	if plaintext != "" && plaintext != path {
+		if err := os.Remove(plaintext); err != nil && !os.IsNotExist(err) {
+			return "", fmt.Errorf("remove plaintext vault file: %w", err)
+		}
+	}
+
+	return ref, nil
+}
+
+// encryptedObject reports whether the object at path is an encrypted
+// envelope.
+func encryptedObject(path string) bool {
+	if filepath.Ext(path) != ".pv" {
+		return false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	header := make([]byte, 6)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return false
+	}
+	return bytes.Equal(header[:4], envelopeMagic) && header[5]&envelopeFlagEncrypted != 0
+}
+
+// objectRef returns the reference for an object: its partition, hash,
+// disambiguating suffix and the extension of contentType, or of the
+// detected type in name.
+func (v *FilesystemVault) objectRef(partition string, hash [sha256.Size]byte, suffix, name, contentType string) string {
+	_, ext, _ := strings.Cut(name, ".")
+	if typed, ok := contentTypeExt[contentType]; ok {
+		ext = typed
+	}
+	return fmt.Sprintf("%s%s/%x%s.%s", refScheme, partition, hash, suffix, ext)
+}
+
+// matchesObject reports whether the object at path holds content. Without
+// WithCollisionCheck, or for content above its size, the name is trusted.
+func (v *FilesystemVault) matchesObject(path string, content []byte) (bool, error) {
+	if v.collisionCheckMax == 0 || len(content) > v.collisionCheckMax {
+		return true, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, fmt.Errorf("read vault file: %w", err)
+	}
+	if data, err = v.decodeObject(path, data); err != nil {
+		return false, err
+	}
+	return bytes.Equal(data, content), nil
+}
+
+// decodeObject returns the content of an object stored at path as data.
+func (v *FilesystemVault) decodeObject(path string, data []byte) ([]byte, error) {
+	var err error
+	switch filepath.Ext(path) {
+	case ".gz":
+		data, err = gunzipBytes(data)
+	case ".pv":
+		data, _, err = decodeEnvelope(data, v.aead)
+	}
+	return data, err
+}
+
+// DedupHits returns how many stores found their object already present.
+func (v *FilesystemVault) DedupHits() int64 {
+	return v.dedupHits.Load()
+}
+
+// Collisions returns how many stores found different content under their
+// object name (see WithCollisionCheck).
+func (v *FilesystemVault) Collisions() int64 {
+	return v.collisions.Load()
+}
+
+// compressible reports whether content is worth gzipping. Binary and
+// already-compressed content, by hint or detection, rarely shrinks, so it is
+// stored as-is without spending CPU on an attempt.
+func compressible(content []byte, hint string) bool {
+	contentType := hint
+	if _, ok := contentTypeExt[contentType]; !ok {
+		contentType = detectContentType(content)
+	}
+	switch contentType {
+	case contentTypeText, contentTypeJSON, contentTypeMap, contentTypeSlice:
+		return true
+	}
+	return false
+}
+
+// Exists reports whether Store would deduplicate content, i.e. whether it is
+// already present in the current date partition.
+func (v *FilesystemVault) Exists(content []byte) (bool, error) {
+	_, path := v.objectPath(sha256.Sum256(content), content, v.now().UTC(), 0)
+	return findObject(path) != "", nil
+}
+
+// List returns the checksums of up to limit objects (0 = all) in date
+// partitions from since's day on, newest partitions first. Packed objects
+// and objects disambiguated after a collision are not listed.
+func (v *FilesystemVault) List(since time.Time, limit int) ([][sha256.Size]byte, error) {
+	type listed struct {
+		day  time.Time
+		hash [sha256.Size]byte
+	}
+	var objects []listed
+	from := since.UTC().Truncate(24 * time.Hour)
+	for _, base := range v.basePaths {
+		err := filepath.Walk(base, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if info.IsDir() {
+				if day, ok := partitionDate(path); ok && day.Before(from) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			day, ok := partitionDate(filepath.Dir(path))
+			if !ok {
+				return nil
+			}
+			name, _, _ := strings.Cut(info.Name(), ".")
+			hash, err := hex.DecodeString(name)
+			if err != nil || len(hash) != sha256.Size {
+				return nil
+			}
+			objects = append(objects, listed{day: day, hash: [sha256.Size]byte(hash)})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(objects, func(i, j int) bool { return objects[i].day.After(objects[j].day) })
+	if limit > 0 && len(objects) > limit {
+		objects = objects[:limit]
+	}
+	hashes := make([][sha256.Size]byte, len(objects))
+	for i, o := range objects {
+		hashes[i] = o.hash
+	}
+	return hashes, nil
+}
+
+// objectPath returns the object name and date-partitioned path for content.
+// The extension always comes from the detected type so that identical bytes
+// map to one canonical object. Objects with their own retention go under a
+// retention-<N>d directory in front of the date partition.
+func (v *FilesystemVault) objectPath(hash [sha256.Size]byte, content []byte, now time.Time, retentionDays int) (name, path string) {
+	name = fmt.Sprintf("%x.%s", hash, contentTypeExt[detectContentType(content)])
+	base := v.basePaths[int(hash[0])%len(v.basePaths)]
+	return name, filepath.Join(base, filepath.FromSlash(objectPartition(now, retentionDays)), name)
+}
+
+// objectPartition returns the directory, relative to a base path, objects
+// stored at now with retentionDays go to. References record it.
+func objectPartition(now time.Time, retentionDays int) string {
+	partition := now.Format("2006/01/02")
+	if retentionDays > 0 {
+		partition = fmt.Sprintf("%s%dd/%s", retentionDirPrefix, retentionDays, partition)
+	}
+	return partition
+}
+
+// objectExts are the extensions objectPath can give an object.
+var objectExts = []string{
+	contentTypeExt[contentTypeText],
+	contentTypeExt[contentTypeJSON],
+	contentTypeExt[contentTypeBinary],
+	contentTypeExt[contentTypeGzip],
+}
+
+// retentionDirPrefix starts the directory of objects stored with their own
+// retention window.
+const retentionDirPrefix = "retention-"
+
+// retentionOf returns the retention window encoded in the path of an object
+// under base, or false for objects without their own window.
+func retentionOf(base, path string) (time.Duration, bool) {
+	rel, err := filepath.Rel(base, path)
+	if err != nil {
+		return 0, false
+	}
+	dir, _, _ := strings.Cut(filepath.ToSlash(rel), "/")
+	days, ok := strings.CutPrefix(dir, retentionDirPrefix)
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(days, "d"))
+	if err != nil || n < 1 {
+		return 0, false
+	}
+	return time.Duration(n) * 24 * time.Hour, true
+}
+
+// partitionDate returns the day of a YYYY/MM/DD date partition directory.
+func partitionDate(dir string) (time.Time, bool) {
+	day := filepath.Base(dir)
+	month := filepath.Base(filepath.Dir(dir))
+	year := filepath.Base(filepath.Dir(filepath.Dir(dir)))
+	t, err := time.Parse("2006/01/02", year+"/"+month+"/"+day)
+	return t, err == nil
+}
+
+// validObjectName reports whether name, taken from a reference, can name
+// an object: a hex SHA-256, optionally with a -<n> disambiguating suffix.
+// Objects are found by name prefix, so anything else (an empty or partial
+// hash, a pack's timestamp, path elements) is refused before the vault is
+// searched.
+func validObjectName(name string) bool {
+	hexHash, suffix, disambiguated := strings.Cut(name, "-")
+	if len(hexHash) != 2*sha256.Size {
+		return false
+	}
+	if _, err := hex.DecodeString(hexHash); err != nil {
+		return false
+	}
+	if disambiguated {
+		n, err := strconv.Atoi(suffix)
+		return err == nil && n > 0 && strconv.Itoa(n) == suffix
+	}
+	return true
+}
+
+// searchOrder returns the base paths to search for hexHash, starting with
+// the one it is distributed to. The others are searched as a fallback in
+// case the set of base paths changed since the object was written.
+func (v *FilesystemVault) searchOrder(hexHash string) []string {
+	b, err := hex.DecodeString(hexHash[:min(2, len(hexHash))])
+	if err != nil || len(b) == 0 || len(v.basePaths) == 1 {
+		return v.basePaths
+	}
+	i := int(b[0]) % len(v.basePaths)
+	order := append([]string{v.basePaths[i]}, v.basePaths[:i]...)
+	return append(order, v.basePaths[i+1:]...)
+}
+
+// findObject returns the stored path for an object, compressed, enveloped
+// or raw, or "" when it does not exist.
+func findObject(path string) string {
+	for _, p := range []string{path, path + ".gz", path + ".pv"} {
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// RefScheme reports "vault", the scheme of filesystem references.
+func (v *FilesystemVault) RefScheme() string {
+	return strings.TrimSuffix(refScheme, "://")
+}
+
+// Retrieve reads content back from the vault by reference.
 
-	return fmt.Sprintf("vault://%s", hexHash), nil
-}// Retrieve reads content back from the vault by reference.
 func (v *FilesystemVault) Retrieve(ref string) ([]byte, error) {
-	// Walk the vault looking for the hash file
-	hexHash := ref
-	if len(ref) > 8 && ref[:8] == "vault://" {
-		hexHash = ref[8:]
+	data, path, err := v.read(ref)
+	if err != nil {
+		return nil, err
 	}
+	if data, err = v.decodeObject(path, data); err != nil {
+		return nil, err
+	}
+	if err := v.verifyIntegrity(ref, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
 
-	var found string
-	err := filepath.Walk(v.basePath, func(path string, info os.FileInfo, err error) error {
+// read returns the stored bytes for ref and the name they were stored
+// under, from a standalone object or, failing that, a pack.
+func (v *FilesystemVault) read(ref string) ([]byte, string, error) {
+	if !validObjectName(refHash(ref)) {
+		return nil, "", fmt.Errorf("invalid vault ref: %s", ref)
+	}
+	path, err := v.find(ref)
+	if err == nil {
+		data, readErr := os.ReadFile(path)
+		if !os.IsNotExist(readErr) {
+			return data, path, readErr
+		}
+		err = readErr
+	}
+	data, name, packErr := v.readPacked(refHash(ref))
+	if packErr != nil {
+		if os.IsNotExist(packErr) {
+			return nil, "", err
+		}
+		return nil, "", packErr
+	}
+	return data, name, nil
+}
+
+// RetrieveRange reads up to length bytes of the content stored under ref,
+// starting at offset. The range is clipped to the end of the content.
+// Uncompressed objects are read with a seek; compressed objects are
+// decompressed up to the end of the range; enveloped and packed objects are
+// decoded in full. Range reads are not verified against the content hash,
+// since that covers the whole object only.
+func (v *FilesystemVault) RetrieveRange(ref string, offset, length int64) ([]byte, error) {
+	if offset < 0 || length < 0 {
+		return nil, fmt.Errorf("invalid range: offset %d, length %d", offset, length)
+	}
+	path, err := v.find(ref)
+	if err != nil || filepath.Ext(path) == ".pv" {
+		content, err := v.Retrieve(ref)
+		if err != nil {
+			return nil, err
+		}
+		start := min(offset, int64(len(content)))
+		return content[start:min(start+length, int64(len(content)))], nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if filepath.Ext(path) == ".gz" {
+		zr, err := gzip.NewReader(f)
 		if err != nil {
-			return nil // skip errors
+			return nil, fmt.Errorf("decompress vault content: %w", err)
+		}
+		defer zr.Close()
+		if _, err := io.CopyN(io.Discard, zr, offset); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("decompress vault content: %w", err)
+		}
+		r = zr
+	} else if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(io.LimitReader(r, length))
+}
+
+// find locates the object for ref. Objects are identified by hash alone:
+// the extension in a reference records its content type and legacy
+// references carry none.
+func (v *FilesystemVault) find(ref string) (string, error) {
+	hexHash := refHash(ref)
+	if !validObjectName(hexHash) {
+		return "", fmt.Errorf("invalid vault ref: %s", ref)
+	}
+
+	// The partition in the reference locates the object directly. Without
+	// one, or when the object has since moved (swept and stored again on a
+	// later day), the vault is searched.
+	if partition := refPartition(ref); partition != "" {
+		for _, base := range v.searchOrder(hexHash) {
+			dir := filepath.Join(base, filepath.FromSlash(partition))
+			for _, ext := range objectExts {
+				if path := findObject(filepath.Join(dir, hexHash+"."+ext)); path != "" {
+					return path, nil
+				}
+			}
+		}
+	}
+
+	var found string
+	for _, base := range v.searchOrder(hexHash) {
+		_ = filepath.Walk(base, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil // skip errors
+			}
+			if !info.IsDir() && strings.HasPrefix(info.Name(), hexHash+".") {
+				found = path
+				return filepath.SkipAll
+			}
+			return nil
+		})
+		if found != "" {
+			return found, nil
 		}
-		if !info.IsDir() && info.Name() == hexHash+".vault" {
-			found = path
-			return filepath.SkipAll
+	}
+	return "", fmt.Errorf("vault ref not found: %s", ref)
+}
+
+// refHash returns the object hash a reference names.
+func refHash(ref string) string {
+	base, _, _ := strings.Cut(ref, refFragmentSep)
+	_, name := splitPartition(strings.TrimPrefix(base, refScheme))
+	hexHash, _, _ := strings.Cut(name, ".")
+	return hexHash
+}
+
+// refPartition returns the partition a reference names, or "" for
+// references from before partitions were recorded.
+func refPartition(ref string) string {
+	base, _, _ := strings.Cut(ref, refFragmentSep)
+	partition, _ := splitPartition(strings.TrimPrefix(base, refScheme))
+	return partition
+}
+
+// Sweep deletes objects older than maxAge and returns how many objects and
+// bytes were reclaimed. Age is measured from each object's modification time
+// rather than its date partition, so an object written just before midnight
+// is not treated as a day old right after it. Objects stored with their own
+// retention (StoreRetained) are kept for that window instead of maxAge.
+// Packs written by Compact are deleted as a whole, by their own age. Date
+// partitions younger than the window are skipped without being listed.
+func (v *FilesystemVault) Sweep(maxAge time.Duration) (objects int, reclaimed int64, err error) {
+	now := v.now()
+	defer func() {
+		// Reload the pack indexes on next use in case packs went away.
+		v.packMu.Lock()
+		v.packs = nil
+		v.packMu.Unlock()
+	}()
+	for _, base := range v.basePaths {
+		err = filepath.Walk(base, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			window := maxAge
+			if retention, ok := retentionOf(base, path); ok {
+				window = retention
+			}
+			if info.IsDir() {
+				// Objects are only written and refreshed on their partition's
+				// day, so a partition from the window holds nothing to sweep.
+				if day, ok := partitionDate(path); ok && !day.Before(now.Add(-window)) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !info.ModTime().Before(now.Add(-window)) {
+				return nil
+			}
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("remove vault file: %w", err)
+			}
+			objects++
+			reclaimed += info.Size()
+			return nil
+		})
+		if err != nil {
+			return objects, reclaimed, err
 		}
-		return nil
-	})
+	}
+	return objects, reclaimed, nil
+}
 
-	if err != nil || found == "" {
-		return nil, fmt.Errorf("vault ref not found: %s", ref)
+// Touch marks the object behind ref as used now, so Sweep counts its age
+// from this use. A packed object touches its pack and index.
+func (v *FilesystemVault) Touch(ref string) error {
+	now := v.now()
+	path, err := v.find(ref)
+	if err == nil {
+		return os.Chtimes(path, now, now)
 	}
+	if loadErr := v.loadPacks(); loadErr != nil {
+		return loadErr
+	}
+	v.packMu.Lock()
+	e, ok := v.packs[strings.ToLower(refHash(ref))]
+	v.packMu.Unlock()
+	if !ok {
+		return err
+	}
+	return errors.Join(os.Chtimes(e.pack, now, now), os.Chtimes(e.pack+".idx", now, now))
+}
+
+func gzipBytes(content []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(content); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// zstdEncoder and zstdDecoder are shared: EncodeAll and DecodeAll are safe
+// for concurrent use.
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+func zstdBytes(content []byte) []byte {
+	return zstdEncoder.EncodeAll(content, make([]byte, 0, len(content)/2))
+}
+
+func unzstdBytes(data []byte) ([]byte, error) {
+	content, err := zstdDecoder.DecodeAll(data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decompress vault content: %w", err)
+	}
+	return content, nil
+}
 
-	return os.ReadFile(found)
-}
\ No newline at end of file
+func gunzipBytes(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decompress vault content: %w", err)
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
@@ -0,0 +1,696 @@
+package promptvaultprocessor
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/otel/metric/noop"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/blake2b"
+)
+
+// vaultFiles returns the paths of all objects stored under dir.
+func vaultFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk vault dir: %v", err)
+	}
+	return files
+}
+
+func TestVaultCompressesLargeContent(t *testing.T) {
+	tmpDir := t.TempDir()
+	vault, err := NewFilesystemVault(tmpDir, WithGzip(1024))
+	if err != nil {
+		t.Fatalf("failed to create vault: %v", err)
+	}
+
+	original := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 2000)
+	ref, err := vault.Store([]byte(original))
+	if err != nil {
+		t.Fatalf("store failed: %v", err)
+	}
+
+	files := vaultFiles(t, tmpDir)
+	if len(files) != 1 {
+		t.Fatalf("expected 1 vault file, got %d", len(files))
+	}
+	if !strings.HasSuffix(files[0], ".txt.gz") {
+		t.Errorf("expected compressed .txt.gz file, got: %s", files[0])
+	}
+	info, _ := os.Stat(files[0])
+	if info.Size() >= int64(len(original)/10) {
+		t.Errorf("expected compressed size well below %d bytes, got %d", len(original), info.Size())
+	}
+
+	data, err := vault.Retrieve(ref)
+	if err != nil {
+		t.Fatalf("retrieve failed: %v", err)
+	}
+	if string(data) != original {
+		t.Error("retrieved content does not match original")
+	}
+
+	// Storing the same content again must still deduplicate.
+	ref2, _ := vault.Store([]byte(original))
+	if ref2 != ref {
+		t.Errorf("expected same ref for same content, got %s and %s", ref, ref2)
+	}
+	if n := len(vaultFiles(t, tmpDir)); n != 1 {
+		t.Errorf("expected dedup to keep 1 file, got %d", n)
+	}
+}
+
+func TestVaultZstdCompression(t *testing.T) {
+	tmpDir := t.TempDir()
+	clock := func() time.Time { return time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC) }
+	vault, err := NewFilesystemVault(tmpDir, WithZstd(1024), WithClock(clock))
+	if err != nil {
+		t.Fatalf("failed to create vault: %v", err)
+	}
+
+	// Roughly 50KB, like a long conversation history.
+	original := []byte(strings.Repeat(`{"role":"user","content":"Tell me about quantum computing"},`, 850))
+	ref, err := vault.Store(original)
+	if err != nil {
+		t.Fatalf("store failed: %v", err)
+	}
+	if want := fmt.Sprintf("vault://2024/01/02/%x.txt", sha256.Sum256(original)); ref != want {
+		t.Errorf("expected the reference to address the plaintext, got %s", ref)
+	}
+
+	files := vaultFiles(t, tmpDir)
+	if len(files) != 1 || !strings.HasSuffix(files[0], ".pv") {
+		t.Fatalf("expected one enveloped object, got %v", files)
+	}
+	raw, _ := os.ReadFile(files[0])
+	if len(raw) >= len(original)/10 {
+		t.Errorf("expected compressed size well below %d bytes, got %d", len(original), len(raw))
+	}
+	if _, h, err := DecodeEnvelope(raw); err != nil || h.Compression != compressionZstd {
+		t.Errorf("expected a zstd envelope, got %+v (%v)", h, err)
+	}
+
+	data, err := vault.Retrieve(ref)
+	if err != nil || !bytes.Equal(data, original) {
+		t.Errorf("expected the content to round trip, got %v", err)
+	}
+	part, err := vault.RetrieveRange(ref, 2, 4)
+	if err != nil || string(part) != "role" {
+		t.Errorf("expected range %q, got %q (%v)", "role", part, err)
+	}
+
+	if _, err := NewFilesystemVault(t.TempDir(), WithZstd(1), WithEnvelopeVersion(1)); err == nil {
+		t.Error("expected an error combining zstd with version 1 envelopes")
+	}
+}
+
+func TestVaultSkipsCompressionForSmallContent(t *testing.T) {
+	tmpDir := t.TempDir()
+	vault, _ := NewFilesystemVault(tmpDir, WithGzip(1024))
+
+	ref, err := vault.Store([]byte("short prompt"))
+	if err != nil {
+		t.Fatalf("store failed: %v", err)
+	}
+
+	files := vaultFiles(t, tmpDir)
+	if len(files) != 1 || !strings.HasSuffix(files[0], ".txt") {
+		t.Fatalf("expected a single uncompressed .txt file, got: %v", files)
+	}
+
+	data, err := vault.Retrieve(ref)
+	if err != nil {
+		t.Fatalf("retrieve failed: %v", err)
+	}
+	if string(data) != "short prompt" {
+		t.Errorf("expected %q, got %q", "short prompt", string(data))
+	}
+}
+
+func TestVaultCompressionFollowsContentType(t *testing.T) {
+	tmpDir := t.TempDir()
+	vault, _ := NewFilesystemVault(tmpDir, WithGzip(64))
+
+	// Both payloads compress well; only the JSON one should be attempted.
+	jsonContent := []byte(`[` + strings.Repeat(`{"role":"user","content":"hi"},`, 50) + `{}]`)
+	binaryContent := bytes.Repeat([]byte{0x00, 0x01, 0x02, 0x03}, 512)
+
+	jsonRef, err := vault.Store(jsonContent)
+	if err != nil {
+		t.Fatalf("store json failed: %v", err)
+	}
+	binaryRef, err := vault.Store(binaryContent)
+	if err != nil {
+		t.Fatalf("store binary failed: %v", err)
+	}
+
+	var gz, bin int
+	for _, f := range vaultFiles(t, tmpDir) {
+		switch {
+		case strings.HasSuffix(f, ".json.gz"):
+			gz++
+		case strings.HasSuffix(f, ".bin"):
+			bin++
+		}
+	}
+	if gz != 1 || bin != 1 {
+		t.Errorf("expected a compressed .json.gz and an uncompressed .bin, got: %v", vaultFiles(t, tmpDir))
+	}
+
+	for ref, want := range map[string][]byte{jsonRef: jsonContent, binaryRef: binaryContent} {
+		data, err := vault.Retrieve(ref)
+		if err != nil {
+			t.Fatalf("retrieve %s failed: %v", ref, err)
+		}
+		if !bytes.Equal(data, want) {
+			t.Errorf("%s did not round-trip", ref)
+		}
+	}
+}
+
+func TestVaultPartitionedRefs(t *testing.T) {
+	tmpDir := t.TempDir()
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	vault, _ := NewFilesystemVault(tmpDir, WithClock(func() time.Time { return now }))
+	content := []byte("What is the capital of France?")
+
+	ref, _ := vault.Store(content)
+	if want := fmt.Sprintf("vault://2026/03/01/%x.txt", sha256.Sum256(content)); ref != want {
+		t.Fatalf("expected the partition in the reference, got %s, want %s", ref, want)
+	}
+	retained, _ := vault.StoreRetained(content, "", 30)
+	if !strings.HasPrefix(retained, "vault://retention-30d/2026/03/01/") {
+		t.Errorf("expected the retention partition in the reference, got %s", retained)
+	}
+	legacy := "vault://" + refHash(ref) + ".txt"
+	for _, r := range []string{ref, retained, legacy} {
+		if got, err := vault.Retrieve(r); err != nil || !bytes.Equal(got, content) {
+			t.Errorf("expected %s to resolve, got %q (%v)", r, got, err)
+		}
+	}
+
+	// Stored again on a later day and swept from its first partition, the
+	// object is still found for the old reference.
+	now = now.Add(48 * time.Hour)
+	vault.Store(content)
+	if err := os.RemoveAll(filepath.Join(tmpDir, "2026", "03", "01")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := vault.Retrieve(ref); err != nil || !bytes.Equal(got, content) {
+		t.Errorf("expected the moved object to resolve, got %q (%v)", got, err)
+	}
+}
+
+func TestVaultRejectsMalformedRefs(t *testing.T) {
+	tmpDir := t.TempDir()
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	vault, _ := NewFilesystemVault(tmpDir, WithClock(func() time.Time { return now }))
+	content := []byte("What is the capital of France?")
+	ref, _ := vault.Store(content)
+	vault.Store([]byte("Tell me about quantum computing"))
+	now = now.Add(48 * time.Hour)
+	if packed, err := vault.Compact(time.Hour, 4096); err != nil || packed != 2 {
+		t.Fatalf("expected 2 objects packed, got %d (%v)", packed, err)
+	}
+
+	// A packed object still resolves by its reference.
+	if got, err := vault.Retrieve(ref); err != nil || !bytes.Equal(got, content) {
+		t.Errorf("expected the packed object to resolve, got %q (%v)", got, err)
+	}
+
+	// Objects are found by name prefix: none of these may reach a file.
+	pack := strings.TrimSuffix(filepath.Base(vaultFiles(t, filepath.Join(tmpDir, packDir))[0]), ".pack")
+	pack = strings.TrimSuffix(pack, ".pack.idx")
+	hexHash := refHash(ref)
+	for _, bad := range []string{
+		"vault://",
+		"vault://" + pack,
+		"vault://" + hexHash[:10],
+		"vault://../../etc/passwd",
+		"vault://" + hexHash + "/../../x.txt",
+		"vault://" + hexHash + "-0.txt",
+		"vault://" + hexHash + "-01.txt",
+		"vault://2026/13/01/" + hexHash + ".txt",
+		"vault://../2026/03/01/" + hexHash + ".txt",
+		"vault://retention-0d/2026/03/01/" + hexHash + ".txt",
+		"vault://" + strings.Repeat("g", 64) + ".txt",
+	} {
+		if data, err := vault.Retrieve(bad); err == nil || !strings.Contains(err.Error(), "invalid vault ref") {
+			t.Errorf("expected %q to be refused, got %d bytes (%v)", bad, len(data), err)
+		}
+		if _, err := vault.RetrieveRange(bad, 0, 4); err == nil {
+			t.Errorf("expected a range read of %q to be refused", bad)
+		}
+	}
+}
+
+func TestVaultSweepUsesModTimeAcrossMidnight(t *testing.T) {
+	tmpDir := t.TempDir()
+	now := time.Date(2026, 3, 1, 23, 59, 0, 0, time.UTC)
+	vault, _ := NewFilesystemVault(tmpDir, WithClock(func() time.Time { return now }))
+
+	ref, err := vault.Store([]byte("written just before midnight"))
+	if err != nil {
+		t.Fatalf("store failed: %v", err)
+	}
+
+	// Thirty minutes later the object sits in yesterday's partition, but it
+	// is only half an hour old and must survive a one hour retention.
+	now = now.Add(30 * time.Minute)
+	removed, _, err := vault.Sweep(time.Hour)
+	if err != nil {
+		t.Fatalf("sweep failed: %v", err)
+	}
+	if removed != 0 {
+		t.Fatalf("expected no objects swept at 00:29, got %d", removed)
+	}
+	if _, err := vault.Retrieve(ref); err != nil {
+		t.Fatalf("expected object to survive sweep: %v", err)
+	}
+
+	now = now.Add(time.Hour)
+	removed, reclaimed, err := vault.Sweep(time.Hour)
+	if err != nil {
+		t.Fatalf("sweep failed: %v", err)
+	}
+	if removed != 1 || reclaimed != int64(len("written just before midnight")) {
+		t.Errorf("expected 1 object / %d bytes swept, got %d / %d", len("written just before midnight"), removed, reclaimed)
+	}
+	if _, err := vault.Retrieve(ref); err == nil {
+		t.Error("expected object to be gone after it aged past retention")
+	}
+}
+
+func TestVaultSweepSkipsRecentPartitions(t *testing.T) {
+	tmpDir := t.TempDir()
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	vault, _ := NewFilesystemVault(tmpDir, WithClock(func() time.Time { return now }))
+	vault.Store([]byte("old prompt"))
+	now = now.Add(48 * time.Hour)
+	vault.Store([]byte("recent prompt"))
+
+	// Backdating the recent object shows its partition is not listed: only
+	// partitions older than the window are.
+	old := now.Add(-72 * time.Hour)
+	for _, f := range vaultFiles(t, tmpDir) {
+		if strings.Contains(f, filepath.Join("2026", "03", "12")) {
+			os.Chtimes(f, old, old)
+		}
+	}
+	removed, _, err := vault.Sweep(24 * time.Hour)
+	if err != nil {
+		t.Fatalf("sweep failed: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("expected only the old partition swept, got %d objects", removed)
+	}
+	if files := vaultFiles(t, tmpDir); len(files) != 1 || !strings.Contains(files[0], filepath.Join("2026", "03", "12")) {
+		t.Errorf("expected the recent object kept, got %v", files)
+	}
+}
+
+func TestVaultRetentionSweep(t *testing.T) {
+	tmpDir := t.TempDir()
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	var mu sync.Mutex
+	clock := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	vault, _ := NewFilesystemVault(tmpDir, WithClock(clock))
+	cfg := createDefaultConfig()
+	cfg.Storage.RetentionDays = 1
+	cfg.Storage.SweepInterval = 10 * time.Millisecond
+	proc := newTestProcessor(t, cfg, vault, consumertest.NewNop())
+
+	vault.Store([]byte("expires after a day"))
+	if err := proc.Start(context.Background(), nil); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	mu.Lock()
+	now = now.Add(36 * time.Hour)
+	mu.Unlock()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(vaultFiles(t, tmpDir)) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := proc.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+	if files := vaultFiles(t, tmpDir); len(files) != 0 {
+		t.Errorf("expected the background sweep to delete the expired object, got %v", files)
+	}
+
+	if _, err := newVaultProcessor(component.TelemetrySettings{Logger: zap.NewNop(), MeterProvider: noop.NewMeterProvider()}, cfg, failingVault{}, consumertest.NewNop()); err == nil {
+		t.Error("expected an error for retention_days with a vault that cannot sweep")
+	}
+}
+
+func TestVaultSweepRefreshesDeduplicatedObjects(t *testing.T) {
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	vault, _ := NewFilesystemVault(t.TempDir(), WithClock(func() time.Time { return now }))
+
+	vault.Store([]byte("reused content"))
+	now = now.Add(50 * time.Minute)
+	vault.Store([]byte("reused content"))
+	now = now.Add(50 * time.Minute)
+
+	if removed, _, _ := vault.Sweep(time.Hour); removed != 0 {
+		t.Errorf("expected recently reused object to be kept, got %d swept", removed)
+	}
+}
+
+func TestVaultContentTypeExtensions(t *testing.T) {
+	tests := []struct {
+		name    string
+		content []byte
+		ext     string
+	}{
+		{name: "json", content: []byte(`[{"role":"user","content":"hi"}]`), ext: ".json"},
+		{name: "binary", content: []byte{0x00, 0x01, 0xfe, 0xff, 0x10}, ext: ".bin"},
+		{name: "text", content: []byte("plain prompt text"), ext: ".txt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			vault, _ := NewFilesystemVault(tmpDir)
+
+			ref, err := vault.Store(tt.content)
+			if err != nil {
+				t.Fatalf("store failed: %v", err)
+			}
+			if !strings.HasSuffix(ref, tt.ext) {
+				t.Errorf("expected ref to record %s extension, got: %s", tt.ext, ref)
+			}
+
+			// Same content must dedup to the same name and extension.
+			vault.Store(tt.content)
+			files := vaultFiles(t, tmpDir)
+			if len(files) != 1 || filepath.Ext(files[0]) != tt.ext {
+				t.Fatalf("expected a single %s object, got: %v", tt.ext, files)
+			}
+
+			data, err := vault.Retrieve(ref)
+			if err != nil {
+				t.Fatalf("retrieve failed: %v", err)
+			}
+			if !bytes.Equal(data, tt.content) {
+				t.Errorf("expected %q, got %q", tt.content, data)
+			}
+		})
+	}
+}
+
+func TestVaultRetrievesLegacyRef(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir())
+
+	ref, _ := vault.Store([]byte("stored before extensions"))
+	legacy, _, _ := strings.Cut(ref, ".")
+
+	data, err := vault.Retrieve(legacy)
+	if err != nil {
+		t.Fatalf("retrieve of legacy ref %s failed: %v", legacy, err)
+	}
+	if string(data) != "stored before extensions" {
+		t.Errorf("unexpected content: %q", data)
+	}
+}
+
+func TestVaultDistributesAcrossBasePaths(t *testing.T) {
+	paths := []string{t.TempDir(), t.TempDir(), t.TempDir()}
+	vault, err := NewFilesystemVault(paths[0], WithBasePaths(paths...))
+	if err != nil {
+		t.Fatalf("failed to create vault: %v", err)
+	}
+
+	refs := map[string]string{}
+	for i := 0; i < 30; i++ {
+		content := fmt.Sprintf("prompt number %d", i)
+		ref, err := vault.Store([]byte(content))
+		if err != nil {
+			t.Fatalf("store failed: %v", err)
+		}
+		refs[ref] = content
+	}
+
+	for i, p := range paths {
+		files := vaultFiles(t, p)
+		if len(files) == 0 {
+			t.Errorf("expected objects under base path %d", i)
+		}
+		for _, f := range files {
+			hash, _ := hex.DecodeString(filepath.Base(f)[:2])
+			if want := int(hash[0]) % len(paths); want != i {
+				t.Errorf("object %s stored under base path %d, expected %d", filepath.Base(f), i, want)
+			}
+		}
+	}
+
+	for ref, content := range refs {
+		data, err := vault.Retrieve(ref)
+		if err != nil {
+			t.Fatalf("retrieve %s failed: %v", ref, err)
+		}
+		if string(data) != content {
+			t.Errorf("expected %q, got %q", content, data)
+		}
+	}
+}
+
+func TestVaultDedupIgnoresContentTypeHint(t *testing.T) {
+	tmpDir := t.TempDir()
+	vault, _ := NewFilesystemVault(tmpDir)
+	content := []byte("identical bytes, different tags")
+
+	textRef, err := vault.StoreTyped(content, contentTypeText)
+	if err != nil {
+		t.Fatalf("store failed: %v", err)
+	}
+	binRef, err := vault.StoreTyped(content, contentTypeBinary)
+	if err != nil {
+		t.Fatalf("store failed: %v", err)
+	}
+
+	if files := vaultFiles(t, tmpDir); len(files) != 1 {
+		t.Fatalf("expected a single stored object, got: %v", files)
+	}
+	if !strings.HasSuffix(textRef, ".txt") || !strings.HasSuffix(binRef, ".bin") {
+		t.Errorf("expected refs to record the hinted types, got %s and %s", textRef, binRef)
+	}
+	for _, ref := range []string{textRef, binRef} {
+		data, err := vault.Retrieve(ref)
+		if err != nil || !bytes.Equal(data, content) {
+			t.Errorf("expected %s to resolve to the canonical object, got %q (%v)", ref, data, err)
+		}
+	}
+}
+
+func TestVaultRetrieveRange(t *testing.T) {
+	var sb strings.Builder
+	for i := 0; sb.Len() < 10000; i++ {
+		fmt.Fprintf(&sb, "%d,", i)
+	}
+	content := sb.String()
+
+	for name, opts := range map[string][]FilesystemOption{
+		"raw":  nil,
+		"gzip": {WithGzip(1024)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			vault, _ := NewFilesystemVault(t.TempDir(), opts...)
+			ref, err := vault.Store([]byte(content))
+			if err != nil {
+				t.Fatalf("store failed: %v", err)
+			}
+
+			data, err := vault.RetrieveRange(ref, 100, 50)
+			if err != nil {
+				t.Fatalf("range read failed: %v", err)
+			}
+			if string(data) != content[100:150] {
+				t.Errorf("expected %q, got %q", content[100:150], data)
+			}
+
+			// Ranges past the end are clipped.
+			data, _ = vault.RetrieveRange(ref, int64(len(content)-10), 100)
+			if string(data) != content[len(content)-10:] {
+				t.Errorf("expected clipped tail %q, got %q", content[len(content)-10:], data)
+			}
+			data, _ = vault.RetrieveRange(ref, int64(len(content)+10), 5)
+			if len(data) != 0 {
+				t.Errorf("expected empty read past the end, got %q", data)
+			}
+		})
+	}
+}
+
+func TestVaultSecondaryChecksum(t *testing.T) {
+	tmpDir := t.TempDir()
+	clock := func() time.Time { return time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC) }
+	vault, _ := NewFilesystemVault(tmpDir, WithSecondaryChecksum(), WithClock(clock))
+	content := []byte("Tell me about quantum computing")
+
+	ref, err := vault.Store(content)
+	if err != nil {
+		t.Fatalf("store failed: %v", err)
+	}
+	sha := sha256.Sum256(content)
+	b2 := blake2b.Sum256(content)
+	want := fmt.Sprintf("vault://2024/01/02/%x.txt#b2=%x", sha, b2)
+	if ref != want {
+		t.Fatalf("expected both checksums in the reference\n got: %s\nwant: %s", ref, want)
+	}
+	data, err := vault.Retrieve(ref)
+	if err != nil || !bytes.Equal(data, content) {
+		t.Fatalf("expected a verified round trip, got %q (%v)", data, err)
+	}
+	if !RefsEqual(ref, strings.TrimSuffix(ref, fmt.Sprintf("#b2=%x", b2))) {
+		t.Error("expected the secondary checksum to be ignored for content identity")
+	}
+
+	// A reference whose second checksum disagrees is rejected even though
+	// the SHA-256 still matches.
+	forged := fmt.Sprintf("vault://%x.txt#b2=%064x", sha, 0)
+	if _, err := vault.Retrieve(forged); err == nil || !strings.Contains(err.Error(), "BLAKE2b") {
+		t.Errorf("expected a BLAKE2b mismatch, got %v", err)
+	}
+
+	// Corrupted content fails verification.
+	files := vaultFiles(t, tmpDir)
+	if err := os.WriteFile(files[0], []byte("Tell me about quantum computinG"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vault.Retrieve(ref); err == nil {
+		t.Error("expected corrupted content to be detected")
+	}
+}
+
+func TestVaultCollisionCheck(t *testing.T) {
+	dir := t.TempDir()
+	vault, _ := NewFilesystemVault(dir, WithGzip(64), WithSecondaryChecksum(), WithCollisionCheck(4096))
+	set, reader := newTestTelemetry()
+	if _, err := newVaultProcessor(set, createDefaultConfig(), vault, new(consumertest.TracesSink)); err != nil {
+		t.Fatalf("failed to create processor: %v", err)
+	}
+
+	content := []byte(strings.Repeat("Tell me about quantum computing. ", 10))
+	ref, err := vault.Store(content)
+	if err != nil {
+		t.Fatalf("store failed: %v", err)
+	}
+
+	// Force a collision: other content sits under this content's name, as
+	// a sharding bug that truncated hashes would leave it.
+	files := vaultFiles(t, dir)
+	other, _ := gzipBytes([]byte("What is the capital of France?"))
+	if err := os.WriteFile(files[0], other, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	collided, err := vault.Store(content)
+	if err != nil {
+		t.Fatalf("store failed: %v", err)
+	}
+	if CanonicalRef(collided) == CanonicalRef(ref) || !strings.Contains(collided, "-1.txt#b2=") {
+		t.Errorf("expected a disambiguated reference, got %s", collided)
+	}
+	if got, err := vault.Retrieve(collided); err != nil || !bytes.Equal(got, content) {
+		t.Errorf("expected the disambiguated object to resolve, got %v", err)
+	}
+	if n := vault.Collisions(); n != 1 {
+		t.Errorf("expected 1 collision, got %d", n)
+	}
+	if n := counterValue(t, reader, "processor_promptvault_hash_collisions"); n != 1 {
+		t.Errorf("expected the collision metric at 1, got %d", n)
+	}
+
+	// Storing again deduplicates against the disambiguated object.
+	if again, _ := vault.Store(content); again != collided {
+		t.Errorf("expected %s again, got %s", collided, again)
+	}
+	if files := vaultFiles(t, dir); len(files) != 2 {
+		t.Errorf("expected two objects, got %v", files)
+	}
+
+	// Without the check the name is trusted.
+	trusting, _ := NewFilesystemVault(dir, WithGzip(64))
+	if got, _ := trusting.Store(content); CanonicalRef(got) != CanonicalRef(ref) || trusting.Collisions() != 0 {
+		t.Errorf("expected the name to be trusted without the check, got %s", got)
+	}
+}
+
+func TestVaultPredictRef(t *testing.T) {
+	vault, _ := NewFilesystemVault(t.TempDir(), WithGzip(16), WithSecondaryChecksum())
+	for _, content := range []string{"short", strings.Repeat("Tell me about quantum computing. ", 8), `{"role":"user"}`} {
+		predicted, err := vault.PredictRef([]byte(content), "")
+		if err != nil {
+			t.Fatalf("predict: %v", err)
+		}
+		ref, err := vault.Store([]byte(content))
+		if err != nil {
+			t.Fatalf("store: %v", err)
+		}
+		if predicted != ref {
+			t.Errorf("predicted %s, stored as %s", predicted, ref)
+		}
+	}
+
+	checked, _ := NewFilesystemVault(t.TempDir(), WithCollisionCheck(1024))
+	if _, err := checked.PredictRef([]byte("short"), ""); err == nil {
+		t.Error("expected prediction to fail with a collision check")
+	}
+}
+
+func TestFilesystemVaultList(t *testing.T) {
+	now := time.Date(2026, 5, 10, 12, 0, 0, 0, time.UTC)
+	clock := now
+	vault, _ := NewFilesystemVault(t.TempDir(), WithBasePaths(t.TempDir(), t.TempDir()), WithClock(func() time.Time { return clock }))
+	var hashes [][sha256.Size]byte
+	for i, age := range []int{5, 2, 0} {
+		clock = now.AddDate(0, 0, -age)
+		content := []byte(fmt.Sprintf("object %d stored %d days ago", i, age))
+		if _, err := vault.Store(content); err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, sha256.Sum256(content))
+	}
+
+	got, err := vault.List(now.AddDate(0, 0, -3), 0)
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if len(got) != 2 || got[0] != hashes[2] || got[1] != hashes[1] {
+		t.Errorf("expected the two objects of the last 3 days, newest first, got %x", got)
+	}
+	if got, _ := vault.List(time.Time{}, 1); len(got) != 1 || got[0] != hashes[2] {
+		t.Errorf("expected the limit to keep the newest object, got %x", got)
+	}
+	if got, _ := vault.List(time.Time{}, 0); len(got) != 3 {
+		t.Errorf("expected all 3 objects without a bound, got %d", len(got))
+	}
+}